@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+)
+
+// Agency is an agency uploaded in multi-agency mode, along with its bets file.
+type Agency struct {
+	ID           string
+	BetsFilePath string
+}
+
+// ParseAgencies parses the agencies setting, a comma-separated list of
+// id=path entries, e.g. "1=./agency-1.csv,2=./agency-2.csv".
+func ParseAgencies(s string) ([]Agency, error) {
+	var agencies []Agency
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("agency %q must be id=path", entry)
+		}
+		id, path := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if id == "" || path == "" {
+			return nil, fmt.Errorf("agency %q must be id=path", entry)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("agency %s is repeated", id)
+		}
+		seen[id] = true
+		agencies = append(agencies, Agency{ID: id, BetsFilePath: path})
+	}
+	if len(agencies) == 0 {
+		return nil, errors.New("no agencies")
+	}
+	return agencies, nil
+}
+
+// agencyPath derives the per agency file of a multi-agency run from a
+// configured path, inserting the agency ID before the extension, so the
+// agencies do not overwrite each other's files.
+func agencyPath(path string, id string) string {
+	if path == "" {
+		return ""
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + id + ext
+}
+
+// RunAgencies uploads the bets of every agency concurrently, each one with
+// its own Client (and thus its own connections) configured like config but
+// for the agency ID and bets file. The checkpoint, journal, rejects,
+// dead-letter, results and capture files, as well as the winners export of each
+// agency, get the agency ID inserted before their extension. The outcome of
+// every agency is logged once all of them finished; the error of the first
+// agency that failed is returned.
+func RunAgencies(ctx context.Context, config common.ClientConfig, agencies []Agency, winnersPath string, winnersFormat string) error {
+	clients := make([]*common.Client, len(agencies))
+	for i, agency := range agencies {
+		agencyConfig := config
+		agencyConfig.ID = agency.ID
+		agencyConfig.BetsFilePath = agency.BetsFilePath
+		agencyConfig.CheckpointPath = agencyPath(config.CheckpointPath, agency.ID)
+		agencyConfig.JournalPath = agencyPath(config.JournalPath, agency.ID)
+		agencyConfig.RejectsPath = agencyPath(config.RejectsPath, agency.ID)
+		agencyConfig.DeadLetterPath = agencyPath(config.DeadLetterPath, agency.ID)
+		agencyConfig.ResultsPath = agencyPath(config.ResultsPath, agency.ID)
+		agencyConfig.CapturePath = agencyPath(config.CapturePath, agency.ID)
+		client, err := common.NewClient(agencyConfig)
+		if err != nil {
+			return fmt.Errorf("%w: agency %s: %v", errConfig, agency.ID, err)
+		}
+		clients[i] = client
+		defer client.Close()
+	}
+
+	summaries := make([]common.RunSummary, len(agencies))
+	errs := make([]error, len(agencies))
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			summaries[i], errs[i] = clients[i].SendBets(ctx)
+		}(i)
+	}
+	wg.Wait()
+
+	var firstErr error
+	for i, client := range clients {
+		summary := summaries[i]
+		if errs[i] != nil {
+			log.Errorf("action: agencia | result: fail | client_id: %s | bets_sent: %d | error: %v",
+				agencies[i].ID, summary.BetsSent, errs[i])
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			continue
+		}
+		log.Infof("action: agencia | result: success | client_id: %s | bets_sent: %d | batches_sent: %d | cant_ganadores: %d",
+			agencies[i].ID, summary.BetsSent, summary.BatchesSent, len(summary.Winners))
+		ExportWinners(client, agencyPath(winnersPath, agencies[i].ID), winnersFormat)
+	}
+	return firstErr
+}
@@ -0,0 +1,734 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// configKeyAnnotation is the flag annotation naming the configuration key a
+// flag overrides, see bindFlag.
+const configKeyAnnotation = "config_key"
+
+// stdoutAnnotation marks the commands that write their output to stdout, so
+// their logs go to stderr instead.
+const stdoutAnnotation = "stdout"
+
+// bindFlag declares that the flag name overrides the configuration key: the
+// flag takes precedence over the environment and the config file, but only
+// when it is given on the command line.
+func bindFlag(flags *pflag.FlagSet, name string, key string) {
+	_ = flags.SetAnnotation(name, configKeyAnnotation, []string{key})
+}
+
+// bindFlags binds the flags of the command being run to their configuration
+// keys. Flags are bound once the command is known because several commands
+// declare flags for the same key.
+func bindFlags(v *viper.Viper, flags *pflag.FlagSet) error {
+	var err error
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if keys := flag.Annotations[configKeyAnnotation]; len(keys) == 1 && err == nil {
+			err = v.BindPFlag(keys[0], flag)
+		}
+	})
+	return err
+}
+
+// usageError logs a command line error and marks it as a configuration error.
+func usageError(err error) error {
+	log.Criticalf("action: config | result: fail | error: %v", err)
+	return fmt.Errorf("%w: %v", errConfig, err)
+}
+
+// checkArgs wraps a cobra argument validator so its errors are reported as
+// configuration errors.
+func checkArgs(validate cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := validate(cmd, args); err != nil {
+			return usageError(err)
+		}
+		return nil
+	}
+}
+
+// NewRootCommand builds the command line of the client, whose flags override
+// the configuration read into v. Without a subcommand the client runs send,
+// so existing deployments keep working unchanged:
+//   - send: uploads the bets and waits for the winners (the default).
+//   - winners: queries the winners of an earlier upload.
+//   - validate: parses and validates the bets without connecting.
+//   - generate: writes random valid bets as a CSV bets file.
+//   - decode: prints the frames of a captured protocol stream.
+//   - replay: sends the client frames of a capture to the server again.
+//   - tune: benchmarks several batch sizes against the server.
+//   - daemon: serves control commands on http.address, uploading the files
+//     of daemon.dir.
+//   - bundle verify: checks an audit bundle.
+//   - config print: prints the effective configuration.
+//
+// Failed commands return an error whose exit code is given by exitCode.
+func NewRootCommand(v *viper.Viper) *cobra.Command {
+	root := &cobra.Command{
+		Use:           "client",
+		Short:         "Lottery agency client",
+		Args:          checkArgs(cobra.NoArgs),
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		CompletionOptions: cobra.CompletionOptions{
+			DisableDefaultCmd: true,
+		},
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := bindFlags(v, cmd.Flags()); err != nil {
+				return usageError(err)
+			}
+			if err := ReadConfigFile(v, v.GetString("config")); err != nil {
+				return usageError(err)
+			}
+			var out io.Writer = os.Stdout
+			if _, ok := cmd.Annotations[stdoutAnnotation]; ok {
+				out = os.Stderr
+			}
+			if err := InitLogger(out, v.GetString("log.level"), v.GetString("log.format")); err != nil {
+				return usageError(err)
+			}
+			common.SetRedaction(v.GetBool("log.redact"))
+			ToggleDebugOnSignal(v.GetString("log.level"))
+			if address := v.GetString("pprof.address"); address != "" {
+				common.NewPprofListener(address).Start()
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSend(cmd, v)
+		},
+	}
+	root.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return usageError(err)
+	})
+	flags := root.PersistentFlags()
+	flags.String("config", "", "config file (default "+defaultConfigFile+")")
+	bindFlag(flags, "config", "config")
+	flags.String("id", "", "agency ID")
+	bindFlag(flags, "id", "id")
+	flags.String("server", "", "server address")
+	bindFlag(flags, "server", "server.address")
+	flags.String("log-level", "", "log level")
+	bindFlag(flags, "log-level", "log.level")
+	flags.String("log-format", "", "log format (text, json or logfmt)")
+	bindFlag(flags, "log-format", "log.format")
+	flags.Bool("log-redact", false, "mask the documents and names of the bettors in the logs")
+	bindFlag(flags, "log-redact", "log.redact")
+	flags.String("pprof", "", "address profiles are served on (empty = off)")
+	bindFlag(flags, "pprof", "pprof.address")
+	flags.String("capture", "", "file every frame sent and received is recorded to (empty = off)")
+	bindFlag(flags, "capture", "capture.path")
+	addSendFlags(root.Flags())
+
+	root.AddCommand(
+		newSendCommand(v),
+		newWinnersCommand(v),
+		newValidateCommand(v),
+		newGenerateCommand(v),
+		newDecodeCommand(v),
+		newReplayCommand(v),
+		newTuneCommand(v),
+		newDaemonCommand(v),
+		newBundleCommand(v),
+		newConfigCommand(v),
+	)
+	return root
+}
+
+// addSendFlags declares the flags of send, which are also the flags of the
+// root command.
+func addSendFlags(flags *pflag.FlagSet) {
+	flags.String("bets", "", "bets file path or http(s) URL")
+	bindFlag(flags, "bets", "bets.path")
+	flags.String("format", "", "bets input format")
+	bindFlag(flags, "format", "bets.format")
+	flags.Int32("batch", 0, "bets per batch")
+	bindFlag(flags, "batch", "batch.maxAmount")
+	flags.Bool("pipeline", false, "parse, encode and send the bets concurrently")
+	bindFlag(flags, "pipeline", "bets.pipeline")
+	flags.Int("start-line", 0, "first input line sent (0 = the first line)")
+	bindFlag(flags, "start-line", "shard.startLine")
+	flags.Int("end-line", 0, "last input line sent (0 = the last line)")
+	bindFlag(flags, "end-line", "shard.endLine")
+	flags.Int("shard-index", 0, "0-based shard of the records sent by this instance")
+	bindFlag(flags, "shard-index", "shard.index")
+	flags.Int("shard-count", 0, "instances the records are split between")
+	bindFlag(flags, "shard-count", "shard.count")
+	flags.Duration("max-duration", 0, "bound on the whole run, upload and winners wait (0 = no limit)")
+	bindFlag(flags, "max-duration", "run.maxDuration")
+	flags.Int64("memory-budget", 0, "bytes held for unacknowledged batches, the journal and the winners verification")
+	bindFlag(flags, "memory-budget", "run.memoryBudget")
+	flags.Int64("generate", 0, "upload this many random bets instead of the bets file")
+	bindFlag(flags, "generate", "bets.generate")
+	flags.String("checkpoint", "", "checkpoint file path")
+	bindFlag(flags, "checkpoint", "bets.checkpoint")
+	flags.String("agencies", "", "upload several agencies at once, as id=path,...")
+	bindFlag(flags, "agencies", "agencies")
+	flags.String("watch", "", "keep sending the CSVs dropped into this directory")
+	bindFlag(flags, "watch", "watch.dir")
+	addWinnersExportFlags(flags)
+}
+
+// addWinnersExportFlags declares the flags of the winners export.
+func addWinnersExportFlags(flags *pflag.FlagSet) {
+	flags.String("winners-out", "", "export the winners to this file")
+	bindFlag(flags, "winners-out", "winners.path")
+	flags.String("winners-format", "", "winners export format (json or csv)")
+	bindFlag(flags, "winners-format", "winners.format")
+}
+
+func newSendCommand(v *viper.Viper) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "send",
+		Short: "Upload the bets and wait for the winners",
+		Args:  checkArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSend(cmd, v)
+		},
+	}
+	addSendFlags(cmd.Flags())
+	return cmd
+}
+
+func newWinnersCommand(v *viper.Viper) *cobra.Command {
+	var refresh bool
+	cmd := &cobra.Command{
+		Use:   "winners",
+		Short: "Query the winners of an earlier upload",
+		Args:  checkArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			PrintConfig(v)
+			client, err := newClient(v, false)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+			if listener := startHTTPListener(v, client, nil); listener != nil {
+				defer listener.Close()
+			}
+			if err := QueryWinners(cmd.Context(), client, refresh); err != nil {
+				return err
+			}
+			ExportWinners(client, v.GetString("winners.path"), v.GetString("winners.format"))
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&refresh, "refresh", false, "bypass winners.cache")
+	cmd.Flags().String("draw", "", "draw ID of the winners cache")
+	bindFlag(cmd.Flags(), "draw", "winners.draw")
+	addWinnersExportFlags(cmd.Flags())
+	return cmd
+}
+
+func newValidateCommand(v *viper.Viper) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Parse and validate the bets without connecting to the server",
+		Args:  checkArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			v.Set("bets.dryRun", true)
+			client, err := newClient(v, true)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+			_, err = client.SendBets(cmd.Context())
+			if err != nil {
+				log.Errorf("action: validate | result: fail | error: %v", err)
+				return err
+			}
+			log.Infof("action: validate | result: success | bets: %s", v.GetString("bets.path"))
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("bets", "", "bets file path or http(s) URL")
+	bindFlag(flags, "bets", "bets.path")
+	flags.String("format", "", "bets input format")
+	bindFlag(flags, "format", "bets.format")
+	flags.Bool("gzip", false, "the bets file is gzip compressed")
+	bindFlag(flags, "gzip", "bets.gzip")
+	flags.Bool("tolerant", false, "keep validating past invalid records")
+	bindFlag(flags, "tolerant", "bets.tolerant")
+	flags.String("rejects", "", "write the invalid records to this file")
+	bindFlag(flags, "rejects", "bets.rejectsPath")
+	return cmd
+}
+
+func newGenerateCommand(v *viper.Viper) *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:         "generate",
+		Short:       "Write random valid bets as a CSV bets file",
+		Args:        checkArgs(cobra.NoArgs),
+		Annotations: map[string]string{stdoutAnnotation: ""},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			count := v.GetInt64("bets.generate")
+			if count <= 0 {
+				return usageError(fmt.Errorf("--count must be positive, got %d", count))
+			}
+			if output == "" || output == "-" {
+				return common.WriteGeneratedBets(os.Stdout, count)
+			}
+			file, err := os.Create(output)
+			if err != nil {
+				log.Errorf("action: generate | result: fail | error: %v", err)
+				return err
+			}
+			if err := common.WriteGeneratedBets(file, count); err != nil {
+				file.Close()
+				log.Errorf("action: generate | result: fail | error: %v", err)
+				return err
+			}
+			if err := file.Close(); err != nil {
+				log.Errorf("action: generate | result: fail | error: %v", err)
+				return err
+			}
+			log.Infof("action: generate | result: success | path: %s | bets: %d", output, count)
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.Int64("count", 0, "number of bets")
+	bindFlag(flags, "count", "bets.generate")
+	flags.StringVarP(&output, "output", "o", "-", "output file, - for stdout")
+	return cmd
+}
+
+func newDecodeCommand(v *viper.Viper) *cobra.Command {
+	var showBets bool
+	cmd := &cobra.Command{
+		Use:         "decode [file]",
+		Short:       "Print the frames of a captured protocol stream or capture file (stdin by default)",
+		Args:        checkArgs(cobra.MaximumNArgs(1)),
+		Annotations: map[string]string{stdoutAnnotation: ""},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			codec, err := protocol.CodecByName(v.GetString("protocol.codec"))
+			if err != nil {
+				return usageError(err)
+			}
+			var in io.Reader = os.Stdin
+			if len(args) == 1 && args[0] != "-" {
+				file, err := os.Open(args[0])
+				if err != nil {
+					log.Errorf("action: decode | result: fail | error: %v", err)
+					return fmt.Errorf("%w: %v", common.ErrInput, err)
+				}
+				defer file.Close()
+				in = file
+			}
+			reader := bufio.NewReader(in)
+			decode := DecodeFrames
+			if protocol.IsCapture(reader) {
+				decode = DecodeCapture
+			}
+			if err := decode(reader, os.Stdout, codec, showBets); err != nil {
+				log.Errorf("action: decode | result: fail | error: %v", err)
+				return err
+			}
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("codec", "", "body codec (binary or msgpack)")
+	bindFlag(flags, "codec", "protocol.codec")
+	flags.BoolVar(&showBets, "bets", false, "also print every bet")
+	return cmd
+}
+
+func newReplayCommand(v *viper.Viper) *cobra.Command {
+	var options ReplayOptions
+	cmd := &cobra.Command{
+		Use:   "replay [file]",
+		Short: "Send the client frames of a capture file or protocol stream to the server (stdin by default)",
+		Args:  checkArgs(cobra.MaximumNArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			codec, err := protocol.CodecByName(v.GetString("protocol.codec"))
+			if err != nil {
+				return usageError(err)
+			}
+			if options.Speed < 0 || options.Interval < 0 || options.Wait < 0 {
+				return usageError(fmt.Errorf("--speed, --interval and --wait cannot be negative"))
+			}
+			address := v.GetString("server.address")
+			if address == "" {
+				return usageError(fmt.Errorf("replay needs server.address"))
+			}
+			var in io.Reader = os.Stdin
+			if len(args) == 1 && args[0] != "-" {
+				file, err := os.Open(args[0])
+				if err != nil {
+					log.Errorf("action: replay | result: fail | error: %v", err)
+					return fmt.Errorf("%w: %v", common.ErrInput, err)
+				}
+				defer file.Close()
+				in = file
+			}
+			options.ConnectTimeout = v.GetDuration("server.connectTimeout")
+			return Replay(cmd.Context(), address, in, codec, options)
+		},
+	}
+	flags := cmd.Flags()
+	flags.Float64Var(&options.Speed, "speed", 1, "pace of the captured timing, e.g. 2 for twice as fast (0 = ignore it)")
+	flags.DurationVar(&options.Interval, "interval", 0, "gap between frames without captured timing")
+	flags.DurationVar(&options.Wait, "wait", 10*time.Second, "how long to read responses after the last frame")
+	flags.String("codec", "", "body codec (binary or msgpack)")
+	bindFlag(flags, "codec", "protocol.codec")
+	return cmd
+}
+
+func newTuneCommand(v *viper.Viper) *cobra.Command {
+	options := TuneOptions{Limits: []int32{10, 50, 100, 200, 500}}
+	cmd := &cobra.Command{
+		Use:         "tune",
+		Short:       "Upload synthetic bets to a test server at several batch sizes and recommend a batch.maxAmount",
+		Args:        checkArgs(cobra.NoArgs),
+		Annotations: map[string]string{stdoutAnnotation: ""},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			codec, err := protocol.CodecByName(v.GetString("protocol.codec"))
+			if err != nil {
+				return usageError(err)
+			}
+			if len(options.Limits) == 0 {
+				return usageError(fmt.Errorf("--limits needs at least one batch size"))
+			}
+			for _, limit := range options.Limits {
+				if limit <= 0 {
+					return usageError(fmt.Errorf("--limits must be positive, got %d", limit))
+				}
+			}
+			if options.Bets <= 0 {
+				return usageError(fmt.Errorf("--bets must be positive, got %d", options.Bets))
+			}
+			id, address := v.GetString("id"), v.GetString("server.address")
+			if id == "" || address == "" {
+				return usageError(fmt.Errorf("tune needs id and server.address"))
+			}
+			options.Codec = codec
+			options.MaxFrameSize = v.GetInt("protocol.maxFrameSize")
+			if options.MaxFrameSize == 0 {
+				options.MaxFrameSize = protocol.DefaultMaxFrameSize
+			}
+			if options.MaxFrameSize < protocol.MinMaxFrameSize {
+				return usageError(fmt.Errorf("max frame size must be at least %d bytes, got %d", protocol.MinMaxFrameSize, options.MaxFrameSize))
+			}
+			options.Window = v.GetInt("protocol.window")
+			options.ConnectTimeout = v.GetDuration("server.connectTimeout")
+			options.AckTimeout = v.GetDuration("protocol.ackTimeout")
+			results, err := Tune(cmd.Context(), id, address, options)
+			if err != nil {
+				log.Errorf("action: tune | result: fail | error: %v", err)
+				return err
+			}
+			if err := WriteTuneReport(os.Stdout, results); err != nil {
+				return err
+			}
+			limit, ok := RecommendBatchLimit(results)
+			if !ok {
+				log.Errorf("action: tune | result: fail | error: every trial failed")
+				return results[0].Err
+			}
+			log.Infof("action: tune | result: success | recommended: %d", limit)
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.Int32SliceVar(&options.Limits, "limits", options.Limits, "batch sizes tried")
+	flags.Int64Var(&options.Bets, "bets", 5000, "synthetic bets uploaded per batch size")
+	flags.String("codec", "", "body codec (binary or msgpack)")
+	bindFlag(flags, "codec", "protocol.codec")
+	return cmd
+}
+
+func newDaemonCommand(v *viper.Viper) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Serve control commands on http.address until shut down",
+		Args:  checkArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if v.GetString("http.address") == "" {
+				return usageError(fmt.Errorf("daemon mode needs http.address"))
+			}
+			if err := common.CheckControlAddress(v.GetString("http.address")); err != nil {
+				return usageError(fmt.Errorf("daemon mode needs a unix socket or loopback http.address: %v", err))
+			}
+			if v.GetString("daemon.dir") == "" {
+				return usageError(fmt.Errorf("daemon mode needs daemon.dir"))
+			}
+			PrintConfig(v)
+			client, err := newClient(v, false)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+			daemon, err := common.NewDaemon(cmd.Context(), client, v.GetString("daemon.dir"))
+			if err != nil {
+				return usageError(fmt.Errorf("invalid daemon.dir: %v", err))
+			}
+			stopReload := ReloadOnSighup(client, v.GetString("log.level"), v.GetString("config"))
+			defer stopReload()
+			listener := startHTTPListener(v, client, daemon)
+			defer listener.Close()
+			daemon.Run()
+			return nil
+		},
+	}
+	cmd.Flags().String("http", "", "control listener address, unix:<path> or loopback host:port")
+	bindFlag(cmd.Flags(), "http", "http.address")
+	cmd.Flags().String("dir", "", "directory of the bets files that may be submitted")
+	bindFlag(cmd.Flags(), "dir", "daemon.dir")
+	return cmd
+}
+
+func newBundleCommand(v *viper.Viper) *cobra.Command {
+	bundle := &cobra.Command{
+		Use:   "bundle",
+		Short: "Audit bundle commands",
+	}
+	verify := &cobra.Command{
+		Use:   "verify <path>",
+		Short: "Check an audit bundle",
+		Args:  checkArgs(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return VerifyBundle(args[0], v.GetString("bundle.key"))
+		},
+	}
+	verify.Flags().String("key", "", "bundle signing key")
+	bindFlag(verify.Flags(), "key", "bundle.key")
+	bundle.AddCommand(verify)
+	return bundle
+}
+
+func newConfigCommand(v *viper.Viper) *cobra.Command {
+	config := &cobra.Command{
+		Use:   "config",
+		Short: "Configuration commands",
+	}
+	printCommand := &cobra.Command{
+		Use:         "print",
+		Short:       "Print the effective configuration, as YAML",
+		Args:        checkArgs(cobra.NoArgs),
+		Annotations: map[string]string{stdoutAnnotation: ""},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out, err := yaml.Marshal(EffectiveConfig(v))
+			if err != nil {
+				return err
+			}
+			_, err = os.Stdout.Write(out)
+			return err
+		},
+	}
+	config.AddCommand(printCommand)
+	return config
+}
+
+// secretKeys are the configuration keys whose values EffectiveConfig hides.
+var secretKeys = []string{"bundle.key", "anonymize.salt"}
+
+// EffectiveConfig returns the configuration in effect once the config file,
+// the environment and the command line flags are merged, as nested sections.
+// Secrets are replaced by "***".
+func EffectiveConfig(v *viper.Viper) map[string]interface{} {
+	settings := v.AllSettings()
+	for _, key := range secretKeys {
+		if v.GetString(key) == "" {
+			continue
+		}
+		path := strings.Split(key, ".")
+		section := settings
+		for _, name := range path[:len(path)-1] {
+			next, ok := section[name].(map[string]interface{})
+			if !ok {
+				break
+			}
+			section = next
+		}
+		section[path[len(path)-1]] = "***"
+	}
+	return settings
+}
+
+// newClient builds the client configured by v, reporting configuration
+// errors as such. Clients that are given their bets files later (winners
+// queries, daemon and watch modes) are built without bets.path, so it is
+// not required to exist.
+func newClient(v *viper.Viper, readsBets bool) (*common.Client, error) {
+	config, err := BuildClientConfig(v)
+	if err != nil {
+		return nil, usageError(err)
+	}
+	if !readsBets {
+		config.BetsFilePath = ""
+	}
+	client, err := common.NewClient(config)
+	if err != nil {
+		return nil, usageError(err)
+	}
+	return client, nil
+}
+
+// startHTTPListener starts the HTTP listener on http.address, if set,
+// serving the result and protocol stats of client, its counters as expvar
+// variables, its health checks, the log level control and, if not nil, the control commands of
+// daemon. It returns nil if there is no listener.
+func startHTTPListener(v *viper.Viper, client *common.Client, daemon *common.Daemon) *common.HTTPListener {
+	address := v.GetString("http.address")
+	if address == "" {
+		return nil
+	}
+	listener := common.NewHTTPListener(address)
+	listener.HandleFunc("/result", client.ServeResult)
+	listener.HandleFunc("/stats", client.ServeProtocolStats)
+	listener.HandleFunc("/loglevel", common.ServeLogLevel)
+	listener.HandleFunc("/healthz", client.ServeHealthz)
+	listener.HandleFunc("/readyz", client.ServeReadyz)
+	client.PublishVars()
+	listener.HandleFunc("/debug/vars", common.ServeVars)
+	if daemon != nil {
+		daemon.Register(listener)
+	}
+	listener.Start()
+	return listener
+}
+
+// runSend uploads the bets: those of every agency in multi-agency mode, the
+// CSVs dropped into watch.dir in watch mode, or else the bets file once.
+func runSend(cmd *cobra.Command, v *viper.Viper) error {
+	ctx := cmd.Context()
+	PrintConfig(v)
+
+	// In multi-agency mode one process uploads several agencies at once
+	if list := v.GetString("agencies"); list != "" {
+		agencies, err := ParseAgencies(list)
+		if err != nil {
+			return usageError(err)
+		}
+		config, err := BuildClientConfig(v)
+		if err != nil {
+			return usageError(err)
+		}
+		if err := RunAgencies(ctx, config, agencies, v.GetString("winners.path"), v.GetString("winners.format")); err != nil {
+			log.Errorf("action: agencias | result: fail | error: %v", err)
+			return err
+		}
+		return nil
+	}
+
+	client, err := newClient(v, v.GetString("watch.dir") == "")
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	httpListener := startHTTPListener(v, client, nil)
+	if httpListener != nil {
+		defer httpListener.Close()
+	}
+
+	// In watch mode the client keeps sending the CSVs dropped into a directory
+	if dir := v.GetString("watch.dir"); dir != "" {
+		stopReload := ReloadOnSighup(client, v.GetString("log.level"), v.GetString("config"))
+		defer stopReload()
+		if err := client.WatchDir(ctx, dir, v.GetDuration("watch.settle")); err != nil {
+			log.Criticalf("action: watch_dir | result: fail | error: %v", err)
+			return err
+		}
+		return nil
+	}
+
+	summary, sendErr := client.SendBets(ctx)
+	if sendErr != nil {
+		log.Errorf("action: send_bets | result: fail | error: %v", sendErr)
+	}
+
+	if path := v.GetString("bundle.path"); path != "" {
+		if err := client.WriteAuditBundle(path, v.GetString("bundle.key")); err != nil {
+			log.Errorf("action: write_bundle | result: fail | error: %v", err)
+		} else {
+			log.Infof("action: write_bundle | result: success | path: %s", path)
+		}
+	}
+
+	if sendErr == nil {
+		ExportWinners(client, v.GetString("winners.path"), v.GetString("winners.format"))
+	}
+
+	if httpListener != nil && summary.Success {
+		ServeResultWindow(ctx, v.GetDuration("http.resultWindow"))
+	}
+	return sendErr
+}
+
+// DecodeFrames writes one line per frame read from in to out, with its
+// offset in the stream, opcode, body length and decoded message, followed
+// by its bets if showBets is set. Frames that cannot be decoded are
+// printed along with the error, as long as the stream stays aligned.
+func DecodeFrames(in io.Reader, out io.Writer, codec protocol.Codec, showBets bool) error {
+	offset := 0
+	for {
+		frame, err := protocol.ReadFrame(in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: offset %d: %v", common.ErrProtocol, offset, err)
+		}
+		msg, err := frame.Decode(codec)
+		if err != nil {
+			fmt.Fprintf(out, "%d\t%v\t%d\terror: %v\n", offset, frame.OpCode, len(frame.Body), err)
+		} else {
+			fmt.Fprintf(out, "%d\t%v\t%d\t%v\n", offset, frame.OpCode, len(frame.Body), msg)
+		}
+		if bets, ok := msg.(*protocol.BetsFrame); ok && showBets {
+			for _, bet := range bets.Bets {
+				fmt.Fprintf(out, "\t%s\n", protocol.FormatBet(bet))
+			}
+		}
+		offset += 1 + 4 + len(frame.Body)
+	}
+}
+
+// DecodeCapture prints every frame of a capture file read from in (see
+// the capture flag), one per line: its time, direction, opcode, body length
+// and decoded message, followed by its bets if showBets is set.
+func DecodeCapture(in io.Reader, out io.Writer, codec protocol.Codec, showBets bool) error {
+	capture, err := protocol.NewCaptureReader(in)
+	if err != nil {
+		return fmt.Errorf("%w: %v", common.ErrInput, err)
+	}
+	for n := 1; ; n++ {
+		frame, err := capture.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: record %d: %v", common.ErrProtocol, n, err)
+		}
+		when := frame.Time.Format("15:04:05.000000")
+		msg, err := frame.Decode(codec)
+		if err != nil {
+			fmt.Fprintf(out, "%s\t%v\t%v\t%d\terror: %v\n", when, frame.Direction, frame.OpCode, len(frame.Body), err)
+		} else {
+			fmt.Fprintf(out, "%s\t%v\t%v\t%d\t%v\n", when, frame.Direction, frame.OpCode, len(frame.Body), msg)
+		}
+		if bets, ok := msg.(*protocol.BetsFrame); ok && showBets {
+			for _, bet := range bets.Bets {
+				fmt.Fprintf(out, "\t%s\n", protocol.FormatBet(bet))
+			}
+		}
+	}
+}
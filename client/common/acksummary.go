@@ -0,0 +1,73 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// AckMode selects how the server confirms the batches.
+//   - AckBatch (default): the server acknowledges every batch.
+//   - AckSummary: the server is asked not to acknowledge batches (QUIET)
+//     and, along with FINISHED, for a single summary of the batches and
+//     bets it received, which is reconciled with what was sent. This saves
+//     a round trip per batch, at the cost of the features that rely on
+//     per-batch acks: Window, MaxReconnects, checkpoint progress (the
+//     checkpoint only clears on success), MaxRetransmits, the journal and
+//     several Connections. NewClient ignores MaxRetransmits, as no failure
+//     ack is received, and rejects the last two.
+type AckMode string
+
+const (
+	AckBatch   AckMode = "batch"
+	AckSummary AckMode = "summary"
+)
+
+func validateAckMode(config ClientConfig) error {
+	switch config.AckMode {
+	case AckBatch:
+		return nil
+	case AckSummary:
+		if config.JournalPath != "" || config.Connections > 1 {
+			return fmt.Errorf("ack mode %q cannot journal or use several connections", config.AckMode)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown ack mode %q", config.AckMode)
+	}
+}
+
+// quietLocked asks the server not to acknowledge the batches of the current
+// connection. Callers must hold connMu.
+func (c *Client) quietLocked() error {
+	var frame bytes.Buffer
+	if _, err := (&protocol.Quiet{Counters: &c.run.stats.protocol}).WriteTo(&frame); err != nil {
+		return err
+	}
+	return c.writeLocked(frame.Bytes())
+}
+
+// requestSummary asks the server for the summary of the batches it
+// received, answered before the winners.
+func (c *Client) requestSummary() error {
+	var frame bytes.Buffer
+	if _, err := (&protocol.SummaryRequest{Counters: &c.run.stats.protocol}).WriteTo(&frame); err != nil {
+		return err
+	}
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	return c.writeLocked(frame.Bytes())
+}
+
+// summaryReceived records the summary sent by the server as the acks of
+// the run, to be reconciled with what was sent (see reconcile).
+func (c *Client) summaryReceived(summary *protocol.Summary) {
+	c.ackSummary = summary
+	c.run.summaryReceived(int64(summary.Batches), int64(summary.Failed))
+	for i := int32(0); i < summary.Batches; i++ {
+		c.config.Hooks.ack(i >= summary.Failed)
+	}
+	log.Infof("action: resumen | result: success | client_id: %v | batches: %d | bets: %d | failed: %d",
+		c.config.ID, summary.Batches, summary.Bets, summary.Failed)
+}
@@ -0,0 +1,104 @@
+package common
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+func TestValidateAckMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  ClientConfig
+		wantErr bool
+	}{
+		{"batch", ClientConfig{AckMode: AckBatch}, false},
+		{"batch with journal", ClientConfig{AckMode: AckBatch, JournalPath: "journal"}, false},
+		{"summary", ClientConfig{AckMode: AckSummary}, false},
+		{"summary with one connection", ClientConfig{AckMode: AckSummary, Connections: 1}, false},
+		{"summary with journal", ClientConfig{AckMode: AckSummary, JournalPath: "journal"}, true},
+		{"summary with several connections", ClientConfig{AckMode: AckSummary, Connections: 2}, true},
+		{"unknown", ClientConfig{AckMode: "none"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateAckMode(tt.config); (err != nil) != tt.wantErr {
+				t.Fatalf("validateAckMode = %v; want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAckSummaryIgnoresRetransmits(t *testing.T) {
+	config := testConfig("127.0.0.1:1", writeTestBets(t, 1))
+	config.AckMode = AckSummary
+	config.MaxRetransmits = 3
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer client.Close()
+	if client.config.MaxRetransmits != 0 {
+		t.Fatalf("MaxRetransmits = %d; want 0", client.config.MaxRetransmits)
+	}
+}
+
+func TestAckSummaryRun(t *testing.T) {
+	tests := []struct {
+		name        string
+		summary     []byte // answer to SUMMARY_REQUEST, nil = the one of the fake server
+		wantErr     error
+		wantSuccess int64
+		wantFail    int64
+	}{
+		{"every bet stored", nil, nil, 3, 0},
+		{"bets missing", summaryFrame(3, 20, 0), ErrProtocol, 3, 0},
+		{"batch missing", summaryFrame(2, 25, 0), ErrProtocol, 2, 0},
+		{"batch failed", summaryFrame(3, 15, 1), ErrServerRejected, 2, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var received []string
+			server := newFakeServer(t, func(s *fakeSession, msg interface{}) bool {
+				mu.Lock()
+				defer mu.Unlock()
+				switch msg.(type) {
+				case *protocol.Quiet:
+					received = append(received, "quiet")
+				case *fakeBatch:
+					received = append(received, "batch")
+				case *protocol.SummaryRequest:
+					received = append(received, "summary_request")
+					if tt.summary != nil {
+						s.send(tt.summary)
+						return true
+					}
+				case *protocol.Finished:
+					received = append(received, "finished")
+				}
+				return false
+			})
+			config := testConfig(server.addr(), writeTestBets(t, 25))
+			config.AckMode = AckSummary
+			summary, err := runTestClient(t, config)
+			checkErrorKind(t, err, tt.wantErr)
+			if summary.AcksSuccess != tt.wantSuccess || summary.AcksFail != tt.wantFail {
+				t.Fatalf("AcksSuccess = %d, AcksFail = %d; want %d and %d",
+					summary.AcksSuccess, summary.AcksFail, tt.wantSuccess, tt.wantFail)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			want := []string{"quiet", "batch", "batch", "batch", "summary_request", "finished"}
+			if len(received) != len(want) {
+				t.Fatalf("server received %v; want %v", received, want)
+			}
+			for i := range want {
+				if received[i] != want[i] {
+					t.Fatalf("server received %v; want %v", received, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// Anonymizer rewrites the value of a bet field before it leaves the agency.
+type Anonymizer interface {
+	Anonymize(value string) string
+}
+
+// AnonymizerFunc adapts a plain function to the Anonymizer interface.
+type AnonymizerFunc func(value string) string
+
+func (f AnonymizerFunc) Anonymize(value string) string { return f(value) }
+
+// anonymizableFields are the bet fields Anonymizers may be set for; the
+// rest are needed by the draw.
+var anonymizableFields = []string{protocol.FirstNameKey, protocol.LastNameKey, protocol.DocumentKey}
+
+// HashAnonymizer replaces values with their salted SHA-256 digest, so equal
+// values stay equal. Values made only of digits (e.g. DOCUMENTO) are
+// replaced by the same number of digits; anything else by 12 hex characters.
+func HashAnonymizer(salt string) Anonymizer {
+	return AnonymizerFunc(func(value string) string {
+		sum := sha256.Sum256([]byte(salt + value))
+		if value == "" || !isDigits(value) {
+			return hex.EncodeToString(sum[:6])
+		}
+		digits := fmt.Sprintf("%020d", binary.BigEndian.Uint64(sum[:8]))
+		if len(value) <= len(digits) {
+			return digits[len(digits)-len(value):]
+		}
+		return strings.Repeat("0", len(value)-len(digits)) + digits
+	})
+}
+
+// MaskAnonymizer keeps the first keep characters of values and replaces the
+// rest with '*'.
+func MaskAnonymizer(keep int) Anonymizer {
+	return AnonymizerFunc(func(value string) string {
+		count := utf8.RuneCountInString(value)
+		if count <= keep {
+			return value
+		}
+		var masked strings.Builder
+		for i, r := range []rune(value) {
+			if i < keep {
+				masked.WriteRune(r)
+			} else {
+				masked.WriteByte('*')
+			}
+		}
+		return masked.String()
+	})
+}
+
+// AnonymizerByName returns the built-in Anonymizer called name: "hash"
+// (HashAnonymizer with salt) or "mask" (MaskAnonymizer keeping the first
+// character). "" and "none" return nil, which leaves the field unchanged.
+func AnonymizerByName(name string, salt string) (Anonymizer, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return nil, nil
+	case "hash":
+		return HashAnonymizer(salt), nil
+	case "mask":
+		return MaskAnonymizer(1), nil
+	default:
+		return nil, fmt.Errorf("unknown anonymizer %q", name)
+	}
+}
+
+// validateAnonymizers checks that anonymizers are only set for fields that
+// can be anonymized.
+func validateAnonymizers(anonymizers map[string]Anonymizer) error {
+	for field := range anonymizers {
+		allowed := false
+		for _, key := range anonymizableFields {
+			allowed = allowed || key == field
+		}
+		if !allowed {
+			return fmt.Errorf("field %s cannot be anonymized", field)
+		}
+	}
+	return nil
+}
+
+// anonymize applies the configured Anonymizers to bet. It runs after
+// validation and duplicate detection, which need the real values.
+func (c *Client) anonymize(bet *protocol.Bet) {
+	for field, anonymizer := range c.config.Anonymize {
+		if anonymizer == nil {
+			continue
+		}
+		switch field {
+		case protocol.FirstNameKey:
+			bet.FirstName = anonymizer.Anonymize(bet.FirstName)
+		case protocol.LastNameKey:
+			bet.LastName = anonymizer.Anonymize(bet.LastName)
+		case protocol.DocumentKey:
+			bet.Document = anonymizer.Anonymize(bet.Document)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package common
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+func TestHashAnonymizer(t *testing.T) {
+	hash := HashAnonymizer("salt")
+	tests := []struct {
+		value string
+		want  *regexp.Regexp
+	}{
+		{"30123412", regexp.MustCompile(`^[0-9]{8}$`)},
+		{"3012341", regexp.MustCompile(`^[0-9]{7}$`)},
+		{strings.Repeat("9", 25), regexp.MustCompile(`^00000[0-9]{20}$`)},
+		{"Gomez", regexp.MustCompile(`^[0-9a-f]{12}$`)},
+		{"", regexp.MustCompile(`^[0-9a-f]{12}$`)},
+	}
+	for _, tt := range tests {
+		got := hash.Anonymize(tt.value)
+		if !tt.want.MatchString(got) || got == tt.value {
+			t.Errorf("Anonymize(%q) = %q; want a value matching %v", tt.value, got, tt.want)
+		}
+		if again := hash.Anonymize(tt.value); again != got {
+			t.Errorf("Anonymize(%q) = %q, then %q; want the same value", tt.value, got, again)
+		}
+	}
+	if HashAnonymizer("other").Anonymize("Gomez") == hash.Anonymize("Gomez") {
+		t.Errorf("different salts give the same digest")
+	}
+}
+
+func TestMaskAnonymizer(t *testing.T) {
+	tests := []struct {
+		keep  int
+		value string
+		want  string
+	}{
+		{1, "Gomez", "G****"},
+		{2, "Núñez", "Nú***"},
+		{1, "A", "A"},
+		{0, "Ana", "***"},
+		{1, "", ""},
+	}
+	for _, tt := range tests {
+		if got := MaskAnonymizer(tt.keep).Anonymize(tt.value); got != tt.want {
+			t.Errorf("MaskAnonymizer(%d).Anonymize(%q) = %q; want %q", tt.keep, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestAnonymizerByName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantNil bool
+		wantErr bool
+	}{
+		{"", true, false},
+		{"none", true, false},
+		{"hash", false, false},
+		{"MASK", false, false},
+		{"rot13", true, true},
+	}
+	for _, tt := range tests {
+		anonymizer, err := AnonymizerByName(tt.name, "salt")
+		if (err != nil) != tt.wantErr || (anonymizer == nil) != tt.wantNil {
+			t.Errorf("AnonymizerByName(%q) = %v, %v; want nil %t, error %t", tt.name, anonymizer, err, tt.wantNil, tt.wantErr)
+		}
+	}
+}
+
+func TestAnonymizeUpload(t *testing.T) {
+	server := newFakeServer(t, nil)
+	bets := "Ana,Gomez,30123412,1990-05-17,7574\nAna,Gomez,30123412,1990-05-17,7574\n"
+	config := testConfig(server.addr(), writeTestFile(t, "bets.csv", []byte(bets)))
+	config.Duplicates = DuplicatesSkip
+	config.Anonymize = map[string]Anonymizer{
+		protocol.FirstNameKey: MaskAnonymizer(1),
+		protocol.DocumentKey:  HashAnonymizer("salt"),
+	}
+	summary, err := runTestClient(t, config)
+	if err != nil {
+		t.Fatalf("SendBets: %v", err)
+	}
+	// Duplicates are detected on the real values, before anonymizing.
+	if summary.BetsDuplicated != 1 {
+		t.Fatalf("BetsDuplicated = %d; want 1", summary.BetsDuplicated)
+	}
+	received := server.received()
+	if len(received) != 1 {
+		t.Fatalf("server received %d bets; want 1", len(received))
+	}
+	bet := received[0]
+	want := map[string]string{
+		protocol.FirstNameKey: "A**",
+		protocol.LastNameKey:  "Gomez",
+		protocol.DocumentKey:  HashAnonymizer("salt").Anonymize("30123412"),
+		protocol.NumberKey:    "7574",
+	}
+	for field, value := range want {
+		if bet[field] != value {
+			t.Errorf("%s = %q; want %q", field, bet[field], value)
+		}
+	}
+}
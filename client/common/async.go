@@ -0,0 +1,62 @@
+package common
+
+import "context"
+
+// progressBuffer is the number of progress reports a Run keeps for a
+// consumer that is not receiving them yet.
+const progressBuffer = 16
+
+// Result is the outcome of a run started with Start, as returned by
+// SendBets.
+type Result struct {
+	Summary RunSummary
+	Err     error
+}
+
+// Run is an upload started with Client.Start.
+type Run struct {
+	progress chan Progress
+	done     chan Result
+	cancel   context.CancelFunc
+}
+
+// Start runs SendBets in the background, returning right away so GUIs or
+// orchestrators can observe the run without blocking on it. The Run
+// reports its progress and outcome through channels, see Run.Progress and
+// Run.Done.
+func (c *Client) Start(ctx context.Context) *Run {
+	ctx, cancel := context.WithCancel(ctx)
+	run := &Run{
+		progress: make(chan Progress, progressBuffer),
+		done:     make(chan Result, 1),
+		cancel:   cancel,
+	}
+	go func() {
+		defer cancel()
+		summary, err := c.send(ctx, run.progress)
+		close(run.progress)
+		run.done <- Result{Summary: summary, Err: err}
+		close(run.done)
+	}()
+	return run
+}
+
+// Progress returns the channel receiving the progress reports of the run,
+// every ProgressPeriod (none if unset), closed once the run ends. Reports
+// are dropped rather than holding the run back while the consumer falls
+// behind.
+func (r *Run) Progress() <-chan Progress {
+	return r.progress
+}
+
+// Done returns the channel receiving the Result of the run once it ends,
+// after Progress is closed. It holds a single Result and is then closed.
+func (r *Run) Done() <-chan Result {
+	return r.done
+}
+
+// Cancel cancels the run, as described by CancelMode, without waiting for
+// it to end. Unlike Client.Cancel it leaves the other runs alone.
+func (r *Run) Cancel() {
+	r.cancel()
+}
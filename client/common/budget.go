@@ -0,0 +1,67 @@
+package common
+
+import "sync/atomic"
+
+// documentOverhead approximates the bytes a tracked document takes in
+// sentDocuments besides its own, for the map entry.
+const documentOverhead = 48
+
+// memoryBudget bounds the bytes a run holds in memory (see MemoryBudget):
+// the frames and records of the batches waiting for their ack, the batches
+// of the journal and the documents tracked to verify the winners. It is
+// shared by the workers of the run. A nil budget or a zero limit never
+// runs out.
+type memoryBudget struct {
+	limit int64
+	used  int64 // accessed atomically
+}
+
+func newMemoryBudget(limit int64) *memoryBudget {
+	return &memoryBudget{limit: limit}
+}
+
+// fits reports whether n more bytes can be held.
+func (b *memoryBudget) fits(n int64) bool {
+	return b == nil || b.limit <= 0 || atomic.LoadInt64(&b.used)+n <= b.limit
+}
+
+// hold accounts n bytes, whether they fit or not.
+func (b *memoryBudget) hold(n int64) {
+	if b != nil {
+		atomic.AddInt64(&b.used, n)
+	}
+}
+
+// reserve holds n bytes if they fit, reporting whether they did.
+func (b *memoryBudget) reserve(n int64) bool {
+	if b == nil || b.limit <= 0 {
+		b.hold(n)
+		return true
+	}
+	for {
+		used := atomic.LoadInt64(&b.used)
+		if used+n > b.limit {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&b.used, used, used+n) {
+			return true
+		}
+	}
+}
+
+// release gives back n bytes held.
+func (b *memoryBudget) release(n int64) {
+	b.hold(-n)
+}
+
+// size returns the bytes the batch holds until it is acknowledged: its
+// frames, if kept to be resent, and its records (see keepsRecords).
+func (e *unackedBatch) size() int64 {
+	size := int64(len(e.frames))
+	for _, record := range e.records {
+		for _, field := range record {
+			size += int64(len(field)) + 16
+		}
+	}
+	return size
+}
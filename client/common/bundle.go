@@ -0,0 +1,279 @@
+package common
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// bundleMagic prefixes every audit bundle file and identifies its format version.
+const bundleMagic = "TP0AUDIT1\n"
+
+// Names of the entries stored inside an audit bundle.
+const (
+	bundleManifestEntry  = "manifest.json"
+	bundleSignatureEntry = "manifest.sig"
+	bundleAuditEntry     = "audit.log"
+	bundleSummaryEntry   = "summary.json"
+	bundleWinnersEntry   = "winners.json"
+)
+
+// BundleManifest is the signed index of an audit bundle. Files maps each
+// archived entry to its SHA-256, so tampering with any entry is detected
+// even if the manifest signature is intact.
+type BundleManifest struct {
+	TraceID          string            `json:"trace_id"`
+	AgencyID         string            `json:"agency_id"`
+	CreatedAt        time.Time         `json:"created_at"`
+	InputPath        string            `json:"input_path"`
+	InputFingerprint string            `json:"input_sha256"`
+	FingerprintNote  string            `json:"input_sha256_note,omitempty"`
+	Files            map[string]string `json:"files"`
+}
+
+// bundleKeys derives independent encryption and signing keys from secret.
+func bundleKeys(secret string) (encKey []byte, signKey []byte) {
+	enc := sha256.Sum256([]byte("tp0-bundle-encrypt:" + secret))
+	sign := sha256.Sum256([]byte("tp0-bundle-sign:" + secret))
+	return enc[:], sign[:]
+}
+
+// fingerprintInput returns betsFile, hashing the bytes the run reads from
+// it if AuditTrail is set. The database of InputSQLite is not read through
+// betsFile, so it is not hashed.
+func (c *Client) fingerprintInput(betsFile io.Reader) io.Reader {
+	if !c.config.AuditTrail || c.config.InputFormat == InputSQLite {
+		return betsFile
+	}
+	c.inputHash = sha256.New()
+	return io.TeeReader(betsFile, c.inputHash)
+}
+
+// inputFingerprint returns the hex SHA-256 of the bytes the last run read
+// from BetsFilePath or, if there is none, why.
+func (c *Client) inputFingerprint() (string, string) {
+	switch {
+	case c.config.Generate > 0:
+		return "", "bets were generated, not read"
+	case c.config.InputFormat == InputSQLite:
+		return "", "bets were queried from a database"
+	case c.inputHash == nil:
+		return "", "bets file was not opened"
+	}
+	return hex.EncodeToString(c.inputHash.Sum(nil)), ""
+}
+
+// WriteAuditBundle assembles the audit bundle of the last run into path.
+// The bundle is a tar archive holding the audit log, the run summary, the
+// winners and a manifest signed with HMAC-SHA256; the archive is gzip
+// compressed and then encrypted with AES-256-GCM. Both keys are derived
+// from secret, which must not be empty. The manifest fingerprints the bytes
+// of the input the run actually read, or notes why it could not. The run
+// must have been made with AuditTrail set.
+func (c *Client) WriteAuditBundle(path string, secret string) error {
+	if secret == "" {
+		return errors.New("bundle key is empty")
+	}
+	if !c.config.AuditTrail {
+		return errors.New("audit trail is off")
+	}
+	summary, finished := c.run.snapshot()
+	if !finished {
+		return errors.New("run is still in progress")
+	}
+	fingerprint, note := c.inputFingerprint()
+	summaryJSON, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	winnersJSON, err := json.MarshalIndent(summary.Winners, "", "  ")
+	if err != nil {
+		return err
+	}
+	entries := map[string][]byte{
+		bundleAuditEntry:   []byte(strings.Join(c.run.auditLog(), "\n") + "\n"),
+		bundleSummaryEntry: summaryJSON,
+		bundleWinnersEntry: winnersJSON,
+	}
+
+	manifest := BundleManifest{
+		TraceID:          summary.TraceID,
+		AgencyID:         summary.AgencyID,
+		CreatedAt:        time.Now().UTC(),
+		InputPath:        c.config.BetsFilePath,
+		InputFingerprint: fingerprint,
+		FingerprintNote:  note,
+		Files:            map[string]string{},
+	}
+	for name, content := range entries {
+		sum := sha256.Sum256(content)
+		manifest.Files[name] = hex.EncodeToString(sum[:])
+	}
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	encKey, signKey := bundleKeys(secret)
+	mac := hmac.New(sha256.New, signKey)
+	mac.Write(manifestJSON)
+	entries[bundleManifestEntry] = manifestJSON
+	entries[bundleSignatureEntry] = []byte(hex.EncodeToString(mac.Sum(nil)))
+
+	var archive bytes.Buffer
+	if err := writeBundleArchive(&archive, entries); err != nil {
+		return err
+	}
+	sealed, err := sealBundle(archive.Bytes(), encKey)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, sealed, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// writeBundleArchive writes entries as a gzip-compressed tar archive, in
+// name order so the output is deterministic.
+func writeBundleArchive(out io.Writer, entries map[string][]byte) error {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	for _, name := range names {
+		content := entries[name]
+		header := &tar.Header{Name: name, Mode: 0o600, Size: int64(len(content))}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// sealBundle encrypts plaintext with AES-256-GCM: [magic][nonce][ciphertext].
+func sealBundle(plaintext []byte, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := append([]byte(bundleMagic), nonce...)
+	return aead.Seal(sealed, nonce, plaintext, []byte(bundleMagic)), nil
+}
+
+// openBundle reverses sealBundle, failing if the content was altered.
+func openBundle(sealed []byte, key []byte) ([]byte, error) {
+	if !bytes.HasPrefix(sealed, []byte(bundleMagic)) {
+		return nil, errors.New("not an audit bundle")
+	}
+	sealed = sealed[len(bundleMagic):]
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("truncated audit bundle")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(bundleMagic))
+	if err != nil {
+		return nil, errors.New("audit bundle was tampered with or the key is wrong")
+	}
+	return plaintext, nil
+}
+
+// VerifyAuditBundle decrypts the bundle at path, checks the manifest
+// signature and every archived entry against its recorded SHA-256, and
+// returns the manifest on success.
+func VerifyAuditBundle(path string, secret string) (*BundleManifest, error) {
+	if secret == "" {
+		return nil, errors.New("bundle key is empty")
+	}
+	sealed, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	encKey, signKey := bundleKeys(secret)
+	archive, err := openBundle(sealed, encKey)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	entries := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		entries[header.Name] = content
+	}
+
+	manifestJSON, signature := entries[bundleManifestEntry], entries[bundleSignatureEntry]
+	mac := hmac.New(sha256.New, signKey)
+	mac.Write(manifestJSON)
+	expected := []byte(hex.EncodeToString(mac.Sum(nil)))
+	if !hmac.Equal(expected, signature) {
+		return nil, errors.New("invalid manifest signature")
+	}
+	var manifest BundleManifest
+	if err := json.Unmarshal(manifestJSON, &manifest); err != nil {
+		return nil, err
+	}
+	for name, digest := range manifest.Files {
+		content, ok := entries[name]
+		if !ok {
+			return nil, fmt.Errorf("entry %s is missing", name)
+		}
+		sum := sha256.Sum256(content)
+		if hex.EncodeToString(sum[:]) != digest {
+			return nil, fmt.Errorf("entry %s does not match the manifest", name)
+		}
+	}
+	return &manifest, nil
+}
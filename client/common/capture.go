@@ -0,0 +1,77 @@
+package common
+
+import (
+	"io"
+	"net"
+	"os"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// captureFile is the file every frame sent or received by a Client is
+// recorded to, see CapturePath.
+type captureFile struct {
+	file *os.File
+	*protocol.CaptureWriter
+}
+
+// openCapture creates the capture file at path.
+func openCapture(path string) (*captureFile, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := protocol.NewCaptureWriter(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &captureFile{file: file, CaptureWriter: writer}, nil
+}
+
+// wrap returns conn with the frames written to and read from it recorded.
+func (f *captureFile) wrap(conn net.Conn) net.Conn {
+	return &captureConn{
+		Conn: conn,
+		out:  f.Stream(protocol.ClientToServer),
+		in:   f.Stream(protocol.ServerToClient),
+	}
+}
+
+// close closes the capture file, logging the first error recording it, if
+// any.
+func (f *captureFile) close() {
+	err := f.Err()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Errorf("action: capture | result: fail | path: %s | error: %v", f.file.Name(), err)
+		return
+	}
+	log.Infof("action: capture | result: success | path: %s", f.file.Name())
+}
+
+// captureConn tees the bytes written to and read from a connection into
+// the capture streams of each direction.
+type captureConn struct {
+	net.Conn
+	out io.Writer
+	in  io.Writer
+}
+
+func (c *captureConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		_, _ = c.in.Write(p[:n])
+	}
+	return n, err
+}
+
+func (c *captureConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		_, _ = c.out.Write(p[:n])
+	}
+	return n, err
+}
@@ -0,0 +1,93 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// windows1252High maps the 0x80-0x9F range of Windows-1252, where it
+// differs from Latin-1 (ISO-8859-1). Undefined positions keep the Latin-1
+// control character.
+var windows1252High = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8d, 'Ž', 0x8f,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9d, 'ž', 'Ÿ',
+}
+
+// charsetTables holds the single-byte encodings accepted as InputEncoding,
+// by every accepted name. UTF-8 needs no table.
+var charsetTables = map[string]*[256]rune{}
+
+func init() {
+	var latin1, windows1252 [256]rune
+	for b := range latin1 {
+		latin1[b] = rune(b)
+		windows1252[b] = rune(b)
+	}
+	copy(windows1252[0x80:0xa0], windows1252High[:])
+	for _, name := range []string{"latin1", "latin-1", "iso-8859-1"} {
+		charsetTables[name] = &latin1
+	}
+	for _, name := range []string{"windows-1252", "cp1252"} {
+		charsetTables[name] = &windows1252
+	}
+}
+
+// Encodings accepted as InputEncoding are UTF-8 ("utf-8", the default),
+// Latin-1 ("latin1", "iso-8859-1") and Windows-1252 ("windows-1252", "cp1252").
+
+// isUTF8Encoding reports whether name designates UTF-8 (the default).
+func isUTF8Encoding(name string) bool {
+	switch strings.ToLower(name) {
+	case "", "utf-8", "utf8":
+		return true
+	}
+	return false
+}
+
+// validateEncoding checks that name is a supported InputEncoding.
+func validateEncoding(name string) error {
+	if isUTF8Encoding(name) || charsetTables[strings.ToLower(name)] != nil {
+		return nil
+	}
+	return fmt.Errorf("unsupported input encoding %q", name)
+}
+
+// decodeInput converts input from the named encoding to UTF-8.
+func decodeInput(input io.Reader, name string) io.Reader {
+	if isUTF8Encoding(name) {
+		return input
+	}
+	return &charsetReader{src: input, table: charsetTables[strings.ToLower(name)]}
+}
+
+// charsetReader transcodes a single-byte encoding to UTF-8.
+type charsetReader struct {
+	src     io.Reader
+	table   *[256]rune
+	raw     []byte
+	pending []byte
+}
+
+func (r *charsetReader) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		if cap(r.raw) == 0 {
+			r.raw = make([]byte, 4096)
+		}
+		n, err := r.src.Read(r.raw)
+		out := r.pending[:0]
+		var buf [utf8.UTFMax]byte
+		for _, b := range r.raw[:n] {
+			size := utf8.EncodeRune(buf[:], r.table[b])
+			out = append(out, buf[:size]...)
+		}
+		r.pending = out
+		if n == 0 {
+			return 0, err
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
@@ -0,0 +1,158 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// checkpoint is the progress of an upload acknowledged by the server, as
+// stored in CheckpointPath. Line is the last input line of the last
+// acknowledged batch; a restarted client skips the records up to it.
+type checkpoint struct {
+	Input   string `json:"input"`
+	Size    int64  `json:"size"`
+	Agency  string `json:"agency"`
+	Line    int    `json:"line"`
+	Batches int64  `json:"batches"`
+	Bets    int64  `json:"bets"`
+}
+
+// sentBatch describes a batch written to the server: the input line of its
+// last bet and its number of bets, and whether it was replayed from the
+// journal.
+type sentBatch struct {
+	line      int
+	bets      int32
+	journaled bool
+}
+
+// checkpointWriter keeps CheckpointPath up to date as acks arrive. The
+// file is replaced atomically, so a killed client always leaves either the
+// previous or the new checkpoint behind.
+type checkpointWriter struct {
+	path    string
+	state   checkpoint
+	stalled bool // a batch was rejected: later acks must not skip past it
+}
+
+// loadCheckpoint opens the checkpoint of the current input, resuming the
+// progress stored by a previous run if it was made for the same agency and
+// input. It returns nil if checkpoints are disabled, as well as when the
+// bets are spread across several connections, whose acks do not follow the
+// input order.
+func (c *Client) loadCheckpoint() *checkpointWriter {
+	if c.config.CheckpointPath == "" || c.config.Generate > 0 || c.config.DryRun || c.config.Connections > 1 {
+		return nil
+	}
+	current := checkpoint{Input: c.config.BetsFilePath, Size: -1, Agency: c.config.ID}
+	if info, err := os.Stat(c.config.BetsFilePath); err == nil {
+		current.Size = info.Size()
+	}
+	writer := &checkpointWriter{path: c.config.CheckpointPath, state: current}
+
+	data, err := os.ReadFile(c.config.CheckpointPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return writer
+	}
+	var stored checkpoint
+	if err == nil {
+		err = json.Unmarshal(data, &stored)
+	}
+	if err != nil {
+		log.Warningf("action: checkpoint | result: fail | path: %s | error: %v", c.config.CheckpointPath, err)
+		return writer
+	}
+	if stored.Input != current.Input || stored.Size != current.Size || stored.Agency != current.Agency {
+		log.Warningf("action: checkpoint | result: ignored | path: %s | input: %s | agency: %s",
+			c.config.CheckpointPath, stored.Input, stored.Agency)
+		return writer
+	}
+	writer.state = stored
+	log.Infof("action: checkpoint | result: resume | path: %s | line: %d | bets: %d",
+		c.config.CheckpointPath, stored.Line, stored.Bets)
+	return writer
+}
+
+// acked records the ack of batch, the oldest one pending, and stores the
+// new progress. After a failed batch the checkpoint no longer advances, so
+// a restart sends it again. Callers must hold connMu.
+func (w *checkpointWriter) acked(batch sentBatch, success bool) {
+	if w == nil {
+		return
+	}
+	if !success {
+		w.stalled = true
+	}
+	if w.stalled {
+		return
+	}
+	w.state.Line = batch.line
+	w.state.Batches++
+	w.state.Bets += int64(batch.bets)
+	if err := w.store(); err != nil {
+		log.Errorf("action: checkpoint | result: fail | path: %s | error: %v", w.path, err)
+	}
+}
+
+// store writes the checkpoint to a temporary file and renames it over path.
+func (w *checkpointWriter) store() error {
+	data, err := json.Marshal(w.state)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(w.path), filepath.Base(w.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), w.path)
+}
+
+// clear removes the checkpoint once the upload completed.
+func (w *checkpointWriter) clear() {
+	if w == nil {
+		return
+	}
+	if err := os.Remove(w.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Errorf("action: checkpoint | result: fail | path: %s | error: %v", w.path, err)
+	}
+}
+
+// skipRecords wraps betsReader to skip the records already acknowledged
+// according to the checkpoint.
+func (w *checkpointWriter) skipRecords(betsReader RecordReader) RecordReader {
+	if w == nil || w.state.Line == 0 {
+		return betsReader
+	}
+	return &checkpointReader{RecordReader: betsReader, line: w.state.Line}
+}
+
+// checkpointReader skips the records up to line.
+type checkpointReader struct {
+	RecordReader
+	line int
+}
+
+func (r *checkpointReader) Read() ([]string, error) {
+	for {
+		record, err := r.RecordReader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if line, ok := recordLine(r.RecordReader, err); ok && line <= r.line {
+			continue
+		}
+		return record, err
+	}
+}
@@ -0,0 +1,89 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	tests := []struct {
+		name string
+		// failSeq is a batch of the first run acknowledged with a failure (0 = none).
+		failSeq int32
+		// between changes the configuration or the files before the second run.
+		between  func(t *testing.T, config *ClientConfig)
+		wantLine int
+		wantSent int
+	}{
+		{"resumed", 0, func(*testing.T, *ClientConfig) {}, 30, 20},
+		{"stalled on a failure ack", 2, func(*testing.T, *ClientConfig) {}, 10, 40},
+		{"input changed", 0, func(t *testing.T, config *ClientConfig) {
+			file, err := os.OpenFile(config.BetsFilePath, os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			if _, err := file.WriteString("Ana,Gomez,30123412,1990-05-17,7574\n"); err != nil {
+				t.Fatal(err)
+			}
+		}, 30, 51},
+		{"another agency", 0, func(_ *testing.T, config *ClientConfig) { config.ID = "6" }, 30, 50},
+		{"corrupt checkpoint", 0, func(t *testing.T, config *ClientConfig) {
+			if err := os.WriteFile(config.CheckpointPath, []byte("{"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}, 30, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The first run is interrupted when the connection drops on its
+			// fourth batch.
+			interrupted := newFakeServer(t, func(s *fakeSession, msg interface{}) bool {
+				batch, ok := msg.(*fakeBatch)
+				switch {
+				case ok && batch.Seq == tt.failSeq:
+					s.ack(batch, protocol.AckFail)
+					return true
+				case ok && batch.Seq == 4:
+					s.conn.Close()
+					return true
+				}
+				return false
+			})
+			config := testConfig(interrupted.addr(), writeTestBets(t, 50))
+			config.CheckpointPath = filepath.Join(t.TempDir(), "checkpoint.json")
+			if _, err := runTestClient(t, config); err == nil {
+				t.Fatal("interrupted SendBets succeeded")
+			}
+			data, err := os.ReadFile(config.CheckpointPath)
+			if err != nil {
+				t.Fatalf("checkpoint: %v", err)
+			}
+			var stored checkpoint
+			if err := json.Unmarshal(data, &stored); err != nil {
+				t.Fatalf("checkpoint %s: %v", data, err)
+			}
+			if stored.Line != tt.wantLine || stored.Bets != int64(tt.wantLine) || stored.Agency != "5" {
+				t.Fatalf("checkpoint = %+v; want line %d of agency 5", stored, tt.wantLine)
+			}
+
+			tt.between(t, &config)
+			server := newFakeServer(t, nil)
+			config.ServerAddress = server.addr()
+			if _, err := runTestClient(t, config); err != nil {
+				t.Fatalf("resumed SendBets: %v", err)
+			}
+			if got := len(server.received()); got != tt.wantSent {
+				t.Fatalf("resumed run sent %d bets; want %d", got, tt.wantSent)
+			}
+			if _, err := os.Stat(config.CheckpointPath); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("checkpoint left after a complete upload: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package common
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// chunkSize is the size of the reads of InputCSVChunked, and so the
+// longest line it accepts.
+const chunkSize = 1 << 20
+
+// errQuotedField reports a quote in a record of InputCSVChunked, which
+// does not parse quoted fields.
+var errQuotedField = errors.New("quoted fields need the csv format")
+
+// errLongLine reports a line of InputCSVChunked longer than chunkSize.
+var errLongLine = fmt.Errorf("line longer than %d bytes", chunkSize)
+
+// chunkedRecordReader reads the records of InputCSVChunked: the input is
+// read in chunkSize reads, and split into lines and fields on the
+// delimiter by hand, which is much cheaper than encoding/csv. Quoted fields
+// are not parsed, so records with quotes are rejected, unless
+// CSVOptions.LazyQuotes is set, in which case quotes are kept as they are.
+// Blank lines are skipped, and CSVOptions.FieldsPerRecord applies as in
+// encoding/csv.
+type chunkedRecordReader struct {
+	input           io.Reader
+	comma           string
+	fieldsPerRecord int
+	lazyQuotes      bool
+	buf             []byte
+	start, end      int
+	eof             bool
+	line            int
+	raw             string
+	record          []string
+}
+
+func newChunkedRecordReader(input io.Reader, options CSVOptions) *chunkedRecordReader {
+	return &chunkedRecordReader{
+		input:           input,
+		comma:           string(options.Comma),
+		fieldsPerRecord: options.FieldsPerRecord,
+		lazyQuotes:      options.LazyQuotes,
+		buf:             make([]byte, chunkSize),
+	}
+}
+
+func (r *chunkedRecordReader) Read() ([]string, error) {
+	for {
+		text, err := r.nextLine()
+		if err != nil {
+			return nil, err
+		}
+		r.line++
+		text = bytes.TrimSuffix(text, []byte{'\r'})
+		if len(text) == 0 {
+			continue
+		}
+		// One string per line, the fields being substrings of it
+		r.raw = string(text)
+		r.record = r.record[:0]
+		for rest := r.raw; ; {
+			i := strings.Index(rest, r.comma)
+			if i < 0 {
+				r.record = append(r.record, rest)
+				break
+			}
+			r.record = append(r.record, rest[:i])
+			rest = rest[i+len(r.comma):]
+		}
+		if !r.lazyQuotes && strings.IndexByte(r.raw, '"') >= 0 {
+			return nil, &RecordError{Line: r.line, Err: errQuotedField}
+		}
+		if r.fieldsPerRecord == 0 {
+			r.fieldsPerRecord = len(r.record)
+		} else if r.fieldsPerRecord > 0 && len(r.record) != r.fieldsPerRecord {
+			return nil, &RecordError{Line: r.line, Err: csv.ErrFieldCount}
+		}
+		if len(r.record) < betFieldsCount {
+			return nil, &RecordError{Line: r.line, Err: errShortRecord}
+		}
+		return r.record[:betFieldsCount], nil
+	}
+}
+
+// nextLine returns the next line of the input, without its newline. It
+// is only valid until the next call.
+func (r *chunkedRecordReader) nextLine() ([]byte, error) {
+	for {
+		if i := bytes.IndexByte(r.buf[r.start:r.end], '\n'); i >= 0 {
+			text := r.buf[r.start : r.start+i]
+			r.start += i + 1
+			return text, nil
+		}
+		if r.eof {
+			if r.start == r.end {
+				return nil, io.EOF
+			}
+			text := r.buf[r.start:r.end]
+			r.start = r.end
+			return text, nil
+		}
+		if r.start == 0 && r.end == len(r.buf) {
+			r.eof = true
+			r.start = r.end
+			return nil, &RecordError{Line: r.line + 1, Err: errLongLine}
+		}
+		// Move the partial line to the front and fill the rest of buf
+		r.end = copy(r.buf, r.buf[r.start:r.end])
+		r.start = 0
+		n, err := io.ReadFull(r.input, r.buf[r.end:])
+		r.end += n
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			r.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (r *chunkedRecordReader) Line() int { return r.line }
+
+func (r *chunkedRecordReader) Raw() string { return r.raw }
@@ -1,91 +1,403 @@
 package common
 
 import (
-	"bufio"
 	"bytes"
 	"context"
-	"encoding/csv"
 	"errors"
+	"fmt"
+	"hash"
 	"io"
+	"math/rand"
 	"net"
 	"os"
-	"os/signal"
 	"strconv"
-	"syscall"
+	"sync"
 	"time"
 
 	"github.com/op/go-logging"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
 )
 
 var log = logging.MustGetLogger("log")
 
 // ClientConfig holds the runtime configuration for a client instance.
 // - ID: agency identifier as a string.
-// - ServerAddress: TCP address of the server (host:port).
-// - BetsFilePath: CSV path with the agency bets.
-// - BatchLimit: maximum number of bets per batch (upper bound besides the 8 KiB framing limit).
+// - ServerAddress: TCP address of the server (host:port), or a comma-separated list of addresses failed over in order.
+// - WebSocketURL: ws or wss URL the frames are tunneled through instead of dialing ServerAddress, through the HTTP proxy of the environment if any; a comma-separated list is failed over like ServerAddress (empty = off).
+// - BetsFilePath: CSV path or http(s) URL with the agency bets.
+// - BatchLimit: maximum number of bets per logical batch (split into 8 KiB frames as needed).
+// - OpenRetryPeriod: how long to keep retrying to open BetsFilePath before failing (0 = no retries).
+// - GzipInput: decompress BetsFilePath even if its name does not end in .gz.
+// - InputFormat: format of BetsFilePath (empty = InputCSV).
+// - CSV: parser options used when InputFormat is InputCSV or InputCSVChunked.
+// - SQL: driver and query used when InputFormat is InputSQLite.
+// - InputEncoding: charset of BetsFilePath, converted to UTF-8 before parsing (empty = UTF-8).
+// - DryRun: parse, validate and batch the input without connecting, logging what would be sent.
+// - Tolerant: skip malformed or invalid records instead of aborting the upload.
+// - RejectsPath: where tolerant mode writes the skipped records (empty = BetsFilePath + ".rejects").
+// - MaxErrors: skipped records tolerated before the upload is aborted (zero = no limit).
+// - RateLimit: bets read per second, averaged over one second bursts (0 = no limit).
+// - Pipeline: parse, encode and send the bets in concurrent stages (see buildAndSendBatches); batches count as flushed once queued for sending.
+// - Connections: connections the bets are spread across, FINISHED being sent once all of them were acknowledged (0 = 1).
+// - BandwidthLimit: bytes written to the server per second, on every connection of the run (0 = no limit).
+// - Generate: send this many random valid bets instead of reading BetsFilePath (0 = off).
+// - Anonymize: Anonymizer applied to each bet field before sending, by protocol field key (NOMBRE, APELLIDO, DOCUMENTO).
+// - CheckpointPath: file tracking the acknowledged progress, so a restarted client skips the bets already delivered (empty = off).
+// - Shard: part of BetsFilePath sent by this instance (zero = the whole file).
+// - Duplicates: handling of repeated (DOCUMENTO, NUMERO) pairs (empty = DuplicatesAllow).
+// - Resync: recovery strategy after a malformed server frame (see ResyncMode).
+// - MaxReconnects: connections replaced per run when one drops mid-transfer, resending the unacknowledged batches (0 = abort on drop).
+// - Window: batches in flight without an ack before sending blocks (0 = no limit).
+// - AckTimeout: how long to wait for the next ack while batches are in flight before the server is considered stalled (0 = forever).
+// - WinnersTimeout: how long to wait for the winners once FINISHED was sent (0 = forever).
+// - WinnersDeadline: how long to keep reconnecting and resending FINISHED, with backoff, when the connection drops or WinnersTimeout elapses before the winners arrive (0 = no polling).
+// - WriteTimeout: how long a batch write may block before the connection is considered dropped (0 = forever).
+// - MaxRetransmits: times a batch is sent again after a failure ack before its bets go to the dead-letter file (0 = no retransmissions, ignored with AckSummary).
+// - DeadLetterPath: where the bets of batches that kept failing are written (empty = BetsFilePath + ".deadletter").
+// - ConnectRetries: dial attempts made after the first one fails (0 = no retries).
+// - ConnectBackoff: delay before the first dial retry, doubled on each attempt (0 = 200ms).
+// - ConnectTimeout: how long a single dial attempt may take (0 = the OS default).
+// - MaxDuration: bound on a whole SendBets run, upload and winners wait (0 = no limit).
+// - CancelMode: what a cancelled run does with the partial batch and the acks in flight (empty = CancelDrain).
+// - DrainTimeout: how long a draining run waits for the acks in flight (0 = 2s).
+// - MemoryBudget: bytes a run may hold for the batches waiting for their ack, the journal and the winners verification (0 = no limit), see memoryBudget.
+// - JournalPath: file the batches are written to while the server is unreachable, sent once it answers again (empty = off).
+// - JournalRetry: how often the server is dialed while the batches go to JournalPath (0 = 5s).
+// - TCP: socket options applied to every connection dialed.
+// - AckMode: whether the server acknowledges every batch or sends a single summary (empty = AckBatch).
+// - WinnersCache: file caching the winners received by QueryWinners, by agency and DrawID (empty = off).
+// - DrawID: draw the cached winners belong to, so the winners of a new draw are not answered from the cache.
+// - MaxFrameSize: largest physical frame written, header included (0 = DefaultMaxFrameSize).
+// - Codec: body encoding shared with the server (nil = protocol.BinaryCodec).
+// - ProgressPeriod: how often the progress of a run is logged and reported to Hooks.OnProgress (0 = off).
+// - RateLogPeriod: how often the throughput of a run over the last period is logged (0 = off).
+// - Hooks: callbacks invoked as the runs make progress.
+// - ResultsPath: file the summary of every SendBets run is written to as JSON (empty = off).
+// - TLS: TLS settings of the connections dialed to ServerAddress.
+// - CapturePath: file every frame sent and received is recorded to, with its time and direction (empty = off, see protocol.CaptureWriter).
+// - Telemetry: report the stats of every upload to the server before FINISHED (see protocol.Telemetry).
+// - StallTimeout: how long a run may go without writing a frame or receiving an ack before /healthz reports it stalled (0 = 1m).
+// - OTLPEndpoint: base http(s) URL of an OTLP/HTTP collector the spans of every run are exported to (empty = off).
+// - AuditTrail: record the events of every run and the SHA-256 of the bets read, for WriteAuditBundle.
 type ClientConfig struct {
-	ID            string
-	ServerAddress string
-	BetsFilePath  string
-	BatchLimit    int32
+	ID              string
+	ServerAddress   string
+	WebSocketURL    string
+	BetsFilePath    string
+	BatchLimit      int32
+	OpenRetryPeriod time.Duration
+	GzipInput       bool
+	InputFormat     InputFormat
+	CSV             CSVOptions
+	SQL             SQLOptions
+	InputEncoding   string
+	DryRun          bool
+	Tolerant        bool
+	RejectsPath     string
+	MaxErrors       ErrorThreshold
+	RateLimit       float64
+	Pipeline        bool
+	Connections     int
+	BandwidthLimit  int64
+	Generate        int64
+	Anonymize       map[string]Anonymizer
+	CheckpointPath  string
+	Shard           Shard
+	Duplicates      DuplicateMode
+	Resync          ResyncMode
+	MaxReconnects   int
+	Window          int
+	AckTimeout      time.Duration
+	WinnersTimeout  time.Duration
+	WriteTimeout    time.Duration
+	MaxRetransmits  int
+	DeadLetterPath  string
+	ConnectRetries  int
+	ConnectBackoff  time.Duration
+	ConnectTimeout  time.Duration
+	MaxDuration     time.Duration
+	CancelMode      CancelMode
+	DrainTimeout    time.Duration
+	MemoryBudget    int64
+	JournalPath     string
+	JournalRetry    time.Duration
+	TCP             TCPOptions
+	AckMode         AckMode
+	WinnersDeadline time.Duration
+	WinnersCache    string
+	DrawID          string
+	MaxFrameSize    int
+	Codec           protocol.Codec
+	Hooks           Hooks
+	ProgressPeriod  time.Duration
+	RateLogPeriod   time.Duration
+	ResultsPath     string
+	TLS             TLSOptions
+	OTLPEndpoint    string
+	AuditTrail      bool
+	StallTimeout    time.Duration
+	Telemetry       bool
+	CapturePath     string
+}
+
+// ResyncMode selects how the client recovers from a malformed server frame.
+//   - ResyncSkip (default): if the advertised length was plausible the frame
+//     is skipped and reading continues; otherwise the reader stops.
+//   - ResyncReconnect: like ResyncSkip, but an implausible length closes the
+//     connection and dials a new one while the upload is still in progress.
+//   - ResyncOff: any malformed frame stops the reader.
+type ResyncMode string
+
+const (
+	ResyncSkip      ResyncMode = "skip"
+	ResyncReconnect ResyncMode = "reconnect"
+	ResyncOff       ResyncMode = "off"
+)
+
+// validateResyncMode checks that mode is one of the ResyncMode constants.
+func validateResyncMode(mode ResyncMode) error {
+	switch mode {
+	case ResyncSkip, ResyncReconnect, ResyncOff:
+		return nil
+	default:
+		return fmt.Errorf("unknown resync mode %q", mode)
+	}
 }
 
 // Client encapsulates the client behavior, including configuration and
-// the currently open TCP connection (if any).
+// the TCP connection borrowed from pool for the current run (if any).
+// connMu guards conn so that a reconnection never splits an outbound frame
+// across two connections.
 type Client struct {
-	config ClientConfig
-	conn   net.Conn
+	config       ClientConfig
+	connMu       sync.Mutex
+	conn         net.Conn
+	finishedSent bool
+	finishedMsg  []byte          // FINISHED as sent, repeated on a new connection
+	connGen      uint64          // incremented each time conn is replaced
+	unacked      []*unackedBatch // batches sent but not acknowledged yet, oldest first
+	reconnects   int
+	batchLine    int        // input line of the last bet added to the current batch
+	batchRecords [][]string // bets of the current batch, see keepsRecords
+	batchStarted time.Time  // when the first bet of the current batch was added
+	checkpoint   *checkpointWriter
+	ackSignal    chan struct{} // signalled on every ack, see waitAcked
+	readDone     chan struct{} // closed once the reader of the run stopped
+	connSeq      int32         // batches written on the current connection
+	pool         *connPool     // owns the connections, reused across runs
+	workers      *workerPool   // connections bets are spread across, see Connections
+	sender       *batchSender  // sending stage of a pipelined run, see Pipeline
+	budget       *memoryBudget // of the run, see MemoryBudget
+	run          *runState
+	inputHash    hash.Hash // of the bets read by the run, see AuditTrail
+	readErr      error     // why the reader stopped, set before readDone is closed
+	rejects      *rejectsWriter
+	duplicates   *duplicateTracker
+	reloadMu     sync.Mutex
+	live         ReloadableConfig // settings set by Reload, guarded by reloadMu
+	reloading    int32            // set by Reload until applyReload runs
+	journal      *journal         // see JournalPath
+	offline      bool             // batches go to journal instead of conn
+	// ackSummary is received in AckSummary mode, before readDone is closed.
+	ackSummary *protocol.Summary
+	pollUntil  time.Time      // when polling for the winners gives up, see pollWinners
+	sent       *sentDocuments // see verifyWinners
+	progress   *progressTracker
+	events     chan<- Progress // progress reports of the run, see Start
+	tracer     *tracer         // spans of the run, see OTLPEndpoint
+	releases   []func()        // resources of the run, see releaseRun
+	lifeMu     sync.Mutex
+	cancels    map[int]context.CancelFunc // of the runs in progress, see Cancel
+	lastRun    int
+	closed     bool
+	closeOnce  sync.Once
+	runs       sync.WaitGroup // runs in progress, see beginRun
 }
 
 // NewClient constructs a Client with the provided configuration.
 // The TCP connection is not opened here; see createClientSocket / SendBets.
-func NewClient(config ClientConfig) *Client {
+// An invalid configuration is reported as a *ConfigError listing every
+// problem found: a non-numeric ID, a non-positive BatchLimit, a malformed
+// ServerAddress, WebSocketURL or OTLPEndpoint, an unreadable BetsFilePath, MaxFrameSize
+// below MinMaxFrameSize, unknown InputFormat, InputEncoding, Duplicates,
+// CancelMode or AckMode, invalid CSV or TCP options or Shard, TLS files
+// that cannot be loaded, or an Anonymizer set for a field the draw needs.
+// It also fails if the CapturePath file cannot be created.
+func NewClient(config ClientConfig) (*Client, error) {
+	if config.MaxFrameSize == 0 {
+		config.MaxFrameSize = protocol.DefaultMaxFrameSize
+	}
+	if config.Codec == nil {
+		config.Codec = protocol.BinaryCodec
+	}
+	if config.InputFormat == "" {
+		config.InputFormat = InputCSV
+	}
+	if config.CSV.Comma == 0 {
+		config.CSV.Comma = ','
+	}
+	if config.Duplicates == "" {
+		config.Duplicates = DuplicatesAllow
+	}
+	if config.CancelMode == "" {
+		config.CancelMode = CancelDrain
+	}
+	if config.AckMode == "" {
+		config.AckMode = AckBatch
+	}
+	if config.Resync == "" {
+		config.Resync = ResyncSkip
+	}
+	if config.AckMode == AckSummary && config.MaxRetransmits > 0 {
+		log.Warningf("action: max_retransmits | result: ignored | ack_mode: %s | max_retransmits: %d",
+			config.AckMode, config.MaxRetransmits)
+		config.MaxRetransmits = 0
+	}
+	problems := config.problems()
+	tlsConfig, err := config.TLS.load()
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) > 0 {
+		return nil, &ConfigError{Problems: problems}
+	}
+	var capture *captureFile
+	if config.CapturePath != "" {
+		if capture, err = openCapture(config.CapturePath); err != nil {
+			return nil, err
+		}
+	}
 	client := &Client{
-		config: config,
+		config:    config,
+		ackSignal: make(chan struct{}, 1),
+		run:       &runState{auditing: config.AuditTrail},
+		pool:      newConnPool(config, tlsConfig, capture),
+		live: ReloadableConfig{
+			BatchLimit:     config.BatchLimit,
+			RateLimit:      config.RateLimit,
+			BandwidthLimit: config.BandwidthLimit,
+		},
 	}
-	return client
+	return client, nil
 }
 
-// processNextBet reads a single CSV record from betsReader, converts it
-// to the protocol key/value map (including AGENCIA), and attempts to add
-// it to the current batch buffer via AddBetWithFlush. If adding this bet
-// would exceed either the 8 KiB framing limit or the configured BatchLimit,
-// the function triggers a flush of the current batch to c.conn and then
-// starts a new batch with this bet. The returned error is io.EOF when the
-// CSV is exhausted, or any I/O/serialization error encountered.
-func (c *Client) processNextBet(betsReader *csv.Reader, batchBuff *bytes.Buffer, betsCounter *int32) error {
+// processNextBet reads a single record from betsReader, builds and
+// validates the protocol bet (including AGENCIA) with protocol.NewBet and
+// validateBet, skips it if it is a duplicate (see DuplicateMode),
+// anonymizes it (see ClientConfig.Anonymize) and attempts to add it to the current batch buffer via AddBetWithFlush. If
+// adding this bet would exceed the configured BatchLimit, the function
+// triggers a flush of the current batch to c.conn and then starts a new
+// batch with this bet. The returned error is io.EOF when the input is
+// exhausted, a validation error naming the input line (nil in tolerant
+// mode, see rejectRecord), or any I/O/serialization error encountered.
+func (c *Client) processNextBet(betsReader RecordReader, batchBuff *bytes.Buffer, betsCounter *int32) error {
 	betFields, err := betsReader.Read()
-	if err != nil {
+	if errors.Is(err, io.EOF) {
 		return err
 	}
-	bet := map[string]string{
-		"AGENCIA":    c.config.ID,
-		"NOMBRE":     betFields[0],
-		"APELLIDO":   betFields[1],
-		"DOCUMENTO":  betFields[2],
-		"NACIMIENTO": betFields[3],
-		"NUMERO":     betFields[4],
+	c.rejects.read++
+	c.progress.recordRead()
+	c.run.stats.betRead()
+	if err != nil {
+		return c.rejectRecord(betsReader, err)
+	}
+	bet, err := protocol.NewBet(c.config.ID, betFields[0], betFields[1], betFields[2], betFields[3], betFields[4])
+	if err == nil {
+		err = validateBet(bet, time.Now())
+	}
+	if err != nil {
+		return c.rejectRecord(betsReader, fmt.Errorf("line %d: %w", betsReader.Line(), err))
+	}
+	if c.checkDuplicate(bet, betsReader.Line()) {
+		return nil
 	}
-	if err := AddBetWithFlush(bet, batchBuff, c.conn, betsCounter, c.config.BatchLimit); err != nil {
+	c.anonymize(bet)
+	c.documentSent(bet.Document)
+	if c.workers != nil {
+		return c.workers.dispatch(bet, betsReader.Line())
+	}
+	return c.addBet(bet, betsReader.Line(), batchBuff, betsCounter)
+}
+
+// addBet adds bet, read from the given input line, to the current batch,
+// flushing the batch first if it is full.
+func (c *Client) addBet(bet *protocol.Bet, line int, batchBuff *bytes.Buffer, betsCounter *int32) error {
+	prevCounter := *betsCounter
+	fields := protocol.GetFields(bet)
+	defer protocol.PutFields(fields)
+	err := c.writeBatchLocked(sentBatch{line: c.batchLine, bets: prevCounter}, func(out io.Writer) error {
+		return protocol.AddBetWithFlush(fields, batchBuff, out, betsCounter, c.config.BatchLimit, c.config.MaxFrameSize, c.config.Codec, &c.run.stats.protocol)
+	})
+	if err != nil {
 		return err
 	}
+	if prevCounter > 0 && *betsCounter == 1 {
+		c.run.batchFlushed(prevCounter)
+		c.config.Hooks.batchFlushed(prevCounter)
+	}
+	if *betsCounter == 1 {
+		c.batchStarted = time.Now()
+	}
+	c.batchLine = line
+	c.batchRecord(bet)
 	return nil
 }
 
-// buildAndSendBatches streams the CSV, incrementally building NewBets
+// flushLocked sends the accumulated batch through the current connection.
+func (c *Client) flushLocked(batchBuff *bytes.Buffer, betsCounter int32) error {
+	err := c.writeBatchLocked(sentBatch{line: c.batchLine, bets: betsCounter}, func(out io.Writer) error {
+		return protocol.FlushBatch(batchBuff, out, betsCounter, c.config.MaxFrameSize, c.config.Codec, &c.run.stats.protocol)
+	})
+	if err == nil {
+		c.run.batchFlushed(betsCounter)
+		c.config.Hooks.batchFlushed(betsCounter)
+	}
+	return err
+}
+
+// buildAndSendBatches streams the bets input, incrementally building NewBets
 // bodies into batchBuff and flushing to c.conn as limits are reached.
-// On context cancellation, it flushes any partial batch and returns the
-// context error. On clean EOF, it flushes a final partial batch (if any)
-// and returns nil. Any serialization or socket error is returned.
-func (c *Client) buildAndSendBatches(ctx context.Context, betsReader *csv.Reader) error {
+// On context cancellation, it flushes any partial batch (unless CancelMode
+// is CancelAbort) and returns the context error. On clean EOF, it flushes a
+// final partial batch (if any) and returns nil. Any serialization or socket
+// error is returned. Records are read at most RateLimit per second.
+//
+// With Pipeline, the records are parsed ahead by a goroutine (see
+// prefetchRecords) and, on a single connection, the batches are sent by
+// another one (see startSender), which is waited for before returning.
+// Bets are still validated and batched in input order, and batches sent in
+// the order they were built.
+func (c *Client) buildAndSendBatches(ctx context.Context, betsReader RecordReader) (err error) {
 	var batchBuff bytes.Buffer
 	var betsCounter int32 = 0
+	started := time.Now()
+	defer func() {
+		c.tracer.record("read_bets", otlpKindInternal, started, nil, attr("bets_read", c.Stats().BetsRead))
+	}()
+	records := betsReader
+	if c.config.Pipeline {
+		prefetched := c.prefetchRecords(betsReader)
+		defer prefetched.close()
+		records = prefetched
+		if c.workers == nil {
+			c.sender = c.startSender()
+			defer func() {
+				sendErr := c.sender.stop()
+				c.sender = nil
+				if sendErr != nil && (err == nil || stopped(ctx, err)) {
+					err = sendErr
+				}
+			}()
+		}
+	}
+	limiter := newRateLimiter(c.config.RateLimit)
 	for {
+		c.applyReload(limiter)
 		select {
 		case <-ctx.Done():
-			if betsCounter > 0 {
-				if err := FlushBatch(&batchBuff, c.conn, betsCounter); err != nil {
+			if betsCounter > 0 && c.config.CancelMode != CancelAbort {
+				if err := c.flushLocked(&batchBuff, betsCounter); err != nil {
 					return err
 				}
 				betsCounter = 0
@@ -93,13 +405,26 @@ func (c *Client) buildAndSendBatches(ctx context.Context, betsReader *csv.Reader
 			return ctx.Err()
 		default:
 		}
-		if err := c.processNextBet(betsReader, &batchBuff, &betsCounter); err != nil {
+		waited, err := limiter.wait(ctx, 1)
+		c.run.throttled(waited)
+		if err != nil {
+			continue
+		}
+		if err := c.processNextBet(records, &batchBuff, &betsCounter); err != nil {
 			if errors.Is(err, io.EOF) {
+				if err := c.checkErrorThreshold(true); err != nil {
+					return err
+				}
 				if betsCounter > 0 {
-					if err := FlushBatch(&batchBuff, c.conn, betsCounter); err != nil {
+					if err := c.flushLocked(&batchBuff, betsCounter); err != nil {
 						return err
 					}
 				}
+				c.logShard(betsReader)
+				if rate, _ := limiter.limit(); rate > 0 {
+					log.Infof("action: rate_limit | result: success | client_id: %v | rate: %v | throttled: %v",
+						c.config.ID, rate, c.Summary().Throttled.Round(time.Millisecond))
+				}
 				break
 			}
 			return err
@@ -108,23 +433,97 @@ func (c *Client) buildAndSendBatches(ctx context.Context, betsReader *csv.Reader
 	return nil
 }
 
-// createClientSocket dials the configured ServerAddress and assigns the
-// resulting connection to c.conn. On failure it logs a critical message
-// and returns the dial error; on success it returns nil.
-func (c *Client) createClientSocket() error {
-	conn, err := net.Dial("tcp", c.config.ServerAddress)
+// openBetsFile opens BetsFilePath, retrying with exponential backoff while the
+// file does not exist yet and OpenRetryPeriod has not elapsed. This covers the
+// case where the dataset volume is mounted slightly after the container starts.
+// Each failed attempt is logged; the last error is returned once the period
+// expires or ctx is cancelled. http(s) URLs are streamed, retrying transient
+// fetch errors for the same period (see openRemoteBets).
+func (c *Client) openBetsFile(ctx context.Context) (io.ReadCloser, error) {
+	if isRemoteBets(c.config.BetsFilePath) {
+		remote, err := c.openRemoteBets(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return remote, nil
+	}
+	deadline := time.Now().Add(c.config.OpenRetryPeriod)
+	backoff := 100 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		betsFile, err := os.Open(c.config.BetsFilePath)
+		if err == nil {
+			return betsFile, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) || time.Now().Add(backoff).After(deadline) {
+			return nil, err
+		}
+		log.Warningf("action: open_bets_file | result: retry | attempt: %d | backoff: %v | error: %v",
+			attempt, backoff, err)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > 2*time.Second {
+			backoff = 2 * time.Second
+		}
+	}
+}
+
+// defaultConnectBackoff is the first retry delay when ConnectBackoff is
+// unset; maxConnectBackoff caps the exponential growth.
+const (
+	defaultConnectBackoff = 200 * time.Millisecond
+	maxConnectBackoff     = 5 * time.Second
+)
+
+// jitterRand is seeded per process so clients started together do not
+// retry in lockstep.
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// jitter returns a random delay between half of backoff and backoff.
+func jitter(backoff time.Duration) time.Duration {
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return backoff/2 + time.Duration(jitterRand.Int63n(int64(backoff/2)+1))
+}
+
+// createClientSocket assigns to c.conn a connection from the pool, reusing
+// an idle one if possible and dialing otherwise (see connPool.dial). The
+// batch count of the connection carries over to connSeq (see useConn).
+func (c *Client) createClientSocket(ctx context.Context) error {
+	conn, seq, err := c.pool.get(ctx)
 	if err != nil {
-		log.Criticalf(
-			"action: connect | result: fail | client_id: %v | error: %v",
-			c.config.ID,
-			err,
-		)
 		return err
 	}
-	c.conn = conn
+	c.useConn(conn, seq)
 	return nil
 }
 
+// useConn makes conn, on which seq batches were written, the current
+// connection and reports its address as the run endpoint.
+func (c *Client) useConn(conn net.Conn, seq int32) {
+	c.conn = conn
+	c.connSeq = seq
+	address := endpoint(conn)
+	if c.run.connected(address) && len(c.pool.addresses) > 1 {
+		log.Infof("action: connect | result: success | client_id: %v | address: %s", c.config.ID, address)
+	}
+	c.config.Hooks.connect(address)
+}
+
+// reconnect closes the current connection and dials a new one, holding
+// connMu so no frame is written in between, and resends the unacknowledged
+// batches (and FINISHED, if already sent) on it.
+func (c *Client) reconnect() error {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	return c.redialLocked()
+}
+
 // SendBets is the high-level entry point. It:
 //  1. Opens the CSV and connects to the server.
 //  2. Starts a reader goroutine (readResponse) to consume server replies.
@@ -132,84 +531,421 @@ func (c *Client) createClientSocket() error {
 //  4. On success, sends FINISHED.
 //  5. Waits for either context cancellation or the reader goroutine to finish.
 //
-// It guarantees connection closure on exit and uses deadlines to unblock
-// the reader goroutine on cancellation.
-func (c *Client) SendBets() {
-	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
-	defer stop()
+// It guarantees connection closure on exit. If the server cannot be reached
+// and JournalPath is set, the batches are journaled instead and sent once
+// the server answers (see goOffline). Cancelling ctx cancels the run as
+// described by CancelMode, and so do Cancel, Close and MaxDuration, which bounds
+// the whole run, once it elapses.
+//
+// It returns the RunSummary of the run (bets and batches sent, acks,
+// winners), whatever its outcome, along with the run error: an *Error
+// categorized as ErrInput, ErrConnection, ErrProtocol, ErrServerRejected,
+// ErrCancelled or ErrTimeout (see errors.go), nil only if every batch was
+// acknowledged and the winners were received. The winners are then checked
+// against the documents sent, see verifyWinners and
+// RunSummary.UnknownWinners, and the summary is logged (see reportRun).
+func (c *Client) SendBets(ctx context.Context) (RunSummary, error) {
+	return c.send(ctx, nil)
+}
 
-	betsFile, err := os.Open(c.config.BetsFilePath)
+// sendFile runs SendBets on the bets at path, already validated, in place
+// of BetsFilePath, which is restored once the run ends. Like applyReload,
+// it only changes the configuration from the goroutine of the run.
+func (c *Client) sendFile(ctx context.Context, path string) (RunSummary, error) {
+	configured := c.config.BetsFilePath
+	c.config.BetsFilePath = path
+	defer func() { c.config.BetsFilePath = configured }()
+	return c.SendBets(ctx)
+}
+
+// send runs SendBets, also sending its progress reports to events if not
+// nil (see Start).
+func (c *Client) send(ctx context.Context, events chan<- Progress) (RunSummary, error) {
+	ctx, endRun, err := c.beginRun(ctx)
 	if err != nil {
-		log.Criticalf("action: read_bets | result: fail | error: %v", err)
-		return
+		return c.Summary(), newError(ErrCancelled, "send_bets", err)
+	}
+	defer endRun()
+	ctx, cancel := c.runContext(ctx)
+	defer cancel()
+	c.events = events
+	err = c.sendBets(ctx)
+	c.events = nil
+	if err == nil {
+		c.verifyWinners()
 	}
-	defer betsFile.Close()
+	c.config.Hooks.error(err)
+	summary := c.Summary()
+	if errors.Is(err, ErrTimeout) {
+		log.Errorf("action: send_bets | result: timeout | client_id: %v | max_duration: %v | bets_sent: %d | acks_success: %d",
+			c.config.ID, c.config.MaxDuration, summary.BetsSent, summary.AcksSuccess)
+	}
+	c.reportRun(summary, err)
+	return summary, err
+}
 
-	betsReader := csv.NewReader(betsFile)
-	betsReader.Comma = ','
-	betsReader.FieldsPerRecord = 5
+// runContext returns the context of a run started with ctx, bounded by
+// MaxDuration if set.
+func (c *Client) runContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.config.MaxDuration <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.config.MaxDuration)
+}
 
-	if err := c.createClientSocket(); err != nil {
-		return
+// sendBets runs SendBets until ctx is done.
+func (c *Client) sendBets(ctx context.Context) (err error) {
+	c.run.start(c.config.ID)
+	defer c.run.finish()
+	log.Infof("action: start | result: success | client_id: %v | trace_id: %s", c.config.ID, c.Summary().TraceID)
+	c.startTrace()
+	defer func() { c.endTrace("send_bets", err) }()
+	defer c.releaseRun()
+	betsReader, err := c.openRun(ctx)
+	if err != nil {
+		return err
+	}
+
+	if c.config.DryRun {
+		return c.dryRun(ctx, betsReader)
+	}
+	if c.config.Connections > 1 {
+		return c.sendParallel(ctx, betsReader)
+	}
+	return c.sendSequential(ctx, betsReader)
+}
+
+// sendSequential sends the bets of betsReader on a single connection, the
+// journal first if the server is back, then FINISHED, and waits for the
+// winners.
+func (c *Client) sendSequential(ctx context.Context, betsReader RecordReader) error {
+	journaled := false
+	if err := c.createClientSocket(ctx); err != nil {
+		if ctx.Err() != nil {
+			return classify("connect", ctx.Err())
+		}
+		if c.journal == nil {
+			return newError(ErrConnection, "connect", err)
+		}
+		if err := c.goOffline(ctx, betsReader, err); err != nil {
+			return err
+		}
+		journaled = true
 	}
-	defer c.conn.Close()
+	c.onRelease(func() {
+		c.connMu.Lock()
+		c.pool.discard(c.conn)
+		c.connMu.Unlock()
+	})
+
+	if c.config.AckMode == AckSummary {
+		c.connMu.Lock()
+		err := c.quietLocked()
+		c.connMu.Unlock()
+		if err != nil {
+			return newError(ErrConnection, "quiet", err)
+		}
+	}
+
+	readCtx, cancelRead := context.WithCancel(context.Background())
+	defer cancelRead()
+	readDone := make(chan struct{})
+	c.readDone = readDone
+	c.readResponse(readCtx, readDone)
 
 	writeDone := make(chan error, 1)
 	go func() {
+		if err := c.replayJournal(); err != nil || journaled {
+			writeDone <- err
+			return
+		}
 		writeDone <- c.buildAndSendBatches(ctx, betsReader)
 	}()
 
-	conn := c.conn
-	readDone := make(chan struct{})
-	readResponse(conn, readDone)
-
-	if err = <-writeDone; err != nil && !errors.Is(err, context.Canceled) {
-		log.Errorf("action: send_bets | result: fail | error: %v", err)
-		return
+	err := <-writeDone
+	if err != nil && !stopped(ctx, err) {
+		return classify("send_bets", err)
 	}
 
 	if err == nil {
-		c.sendFinished()
+		if c.config.MaxRetransmits > 0 {
+			// Failed batches can only be retransmitted before FINISHED.
+			c.waitAcked(readDone, nil)
+		}
+		if c.config.AckMode == AckSummary {
+			if err := c.requestSummary(); err != nil {
+				return classify("summary", err)
+			}
+		}
+		if err := c.sendTelemetry(); err != nil {
+			return classify("send_telemetry", err)
+		}
+		finishedAt := time.Now()
+		if err := c.sendFinished(); err != nil {
+			return classify("send_finished", err)
+		}
+		defer func() {
+			c.tracer.record("query_winners", otlpKindClient, finishedAt, c.readErr,
+				attr("winners", len(c.Summary().Winners)))
+		}()
 	}
 	select {
 	case <-ctx.Done():
-		_ = c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		c.drain(readDone)
+		cancelRead()
 		<-readDone
-		return
+		return classify("send_bets", ctx.Err())
 	case <-readDone:
-		if tcp, ok := c.conn.(*net.TCPConn); ok {
-			_ = tcp.CloseWrite()
+		c.connMu.Lock()
+		if cw, ok := c.conn.(interface{ CloseWrite() error }); ok {
+			_ = cw.CloseWrite()
 		}
+		c.connMu.Unlock()
 	}
+	err = c.runError()
+	if err == nil {
+		c.checkpoint.clear()
+	}
+	return err
 }
 
-// readResponse consumes server responses from conn in a dedicated goroutine.
+// openRun resets the state of the Client for a new run and opens its
+// resources, registered to be released by releaseRun: the progress and
+// throughput reporters, the input, the checkpoint, the rejects file, the
+// duplicate tracker and the journal. It returns the reader of the bets to send.
+func (c *Client) openRun(ctx context.Context) (RecordReader, error) {
+	c.applyReload(nil)
+	c.finishedSent = false
+	c.readErr = nil
+	c.ackSummary = nil
+	c.unacked = nil
+	c.connSeq = 0
+	c.batchRecords = nil
+	c.reconnects = 0
+	c.pollUntil = time.Time{}
+	c.budget = newMemoryBudget(c.config.MemoryBudget)
+	c.inputHash = nil
+
+	progress, stopProgress := c.reportProgress(ctx, c.inputSize())
+	c.onRelease(stopProgress)
+	c.progress = progress
+	c.onRelease(c.reportThroughput(ctx))
+
+	var betsReader RecordReader
+	if c.config.Generate > 0 {
+		betsReader = c.newGeneratedRecords()
+	} else {
+		opened := time.Now()
+		betsFile, err := c.openBetsFile(ctx)
+		c.tracer.record("open_bets", otlpKindInternal, opened, err, attr("path", c.config.BetsFilePath))
+		if err != nil {
+			log.Criticalf("action: read_bets | result: fail | error: %v", err)
+			return nil, newError(ErrInput, "read_bets", err)
+		}
+		c.onRelease(func() { betsFile.Close() })
+		records, input, err := c.openRecords(ctx, c.progress.count(c.fingerprintInput(betsFile)))
+		if err != nil {
+			log.Criticalf("action: read_bets | result: fail | error: %v", err)
+			return nil, newError(ErrInput, "read_bets", err)
+		}
+		c.onRelease(func() { input.Close() })
+		betsReader = records
+	}
+	betsReader = c.shardRecords(betsReader)
+	c.batchLine = 0
+	c.checkpoint = c.loadCheckpoint()
+	betsReader = c.checkpoint.skipRecords(betsReader)
+	c.rejects = &rejectsWriter{path: c.rejectsPath()}
+	c.onRelease(c.closeRejects)
+	c.duplicates = newDuplicateTracker()
+	c.onRelease(c.logDuplicates)
+	journal, err := c.openJournal()
+	if err != nil {
+		log.Criticalf("action: journal | result: fail | error: %v", err)
+		return nil, newError(ErrInput, "journal", err)
+	}
+	c.journal = journal
+	c.trackDocuments()
+	return betsReader, nil
+}
+
+// runError reports the outcome of a run whose reader already stopped:
+// rejected batches take precedence, then batches the server never
+// acknowledged, then the reason the reader stopped before receiving the
+// winners.
+func (c *Client) runError() error {
+	summary := c.Summary()
+	if summary.AcksFail > 0 {
+		return newError(ErrServerRejected, "send_bets",
+			fmt.Errorf("%d of %d batches rejected", summary.AcksFail, summary.BatchesSent))
+	}
+	if summary.Success {
+		return c.reconcile(summary)
+	}
+	if c.readErr == nil || errors.Is(c.readErr, io.EOF) {
+		return newError(ErrConnection, "consulta_ganadores", io.ErrUnexpectedEOF)
+	}
+	return classify("leer_respuesta", c.readErr)
+}
+
+// reconcile checks that the server acknowledged every batch sent before
+// answering with the winners, and in AckSummary mode that it stored every
+// bet sent. A server that silently dropped a batch would otherwise go
+// unnoticed.
+func (c *Client) reconcile(summary RunSummary) error {
+	if c.ackSummary != nil && int64(c.ackSummary.Bets) != summary.BetsSent {
+		log.Errorf("action: reconcile | result: fail | client_id: %v | bets_sent: %d | bets_stored: %d",
+			c.config.ID, summary.BetsSent, c.ackSummary.Bets)
+		return newError(ErrProtocol, "reconcile",
+			fmt.Errorf("%d bets sent but %d stored", summary.BetsSent, c.ackSummary.Bets))
+	}
+	if summary.AcksSuccess == summary.BatchesSent {
+		return nil
+	}
+	log.Errorf("action: reconcile | result: fail | client_id: %v | batches_sent: %d | acks_success: %d",
+		c.config.ID, summary.BatchesSent, summary.AcksSuccess)
+	return newError(ErrProtocol, "reconcile",
+		fmt.Errorf("%d batches sent but %d acknowledged", summary.BatchesSent, summary.AcksSuccess))
+}
+
+// readResponse consumes server responses in a dedicated goroutine.
 // It logs per-message results and terminates when:
-//   - an I/O error occurs (EOF included), or
+//   - an I/O error occurs (EOF included),
+//   - a malformed frame cannot be recovered according to config.Resync, or
 //   - a Winners message is received (explicit break to stop reading).
 //
-// The function closes readDone when the goroutine exits.
-func readResponse(conn net.Conn, readDone chan struct{}) {
-	reader := bufio.NewReader(conn)
+// Reads are bound to ctx, so cancelling it stops the goroutine, and to the
+// read timeouts (see readTimeoutLocked); a timeout counts as a dropped
+// connection, unless the server owed nothing, in which case reading goes
+// on, or a frame was cut, which is handled like a malformed one. Once
+// FINISHED was sent, a dropped connection may be replaced
+// until the winners arrive (see pollWinners). The function closes readDone
+// when the goroutine exits.
+func (c *Client) readResponse(ctx context.Context, readDone chan struct{}) {
+	c.connMu.Lock()
+	reader := protocol.NewConnReaderCodec(c.conn, c.config.Codec, &c.run.stats.protocol)
+	gen := c.connGen
+	c.connMu.Unlock()
 	go func() {
+		// Batches acknowledged on the current connection. Legacy acks carry no
+		// correlation ID, so they are matched to batches by arrival order.
+		var acked int32
 	readLoop:
 		for {
-			msg, err := ReadMessage(reader)
+			c.connMu.Lock()
+			if c.connGen != gen {
+				// The connection was replaced: whatever was left unread on the
+				// old one refers to batches that were resent.
+				reader = protocol.NewConnReaderCodec(c.conn, c.config.Codec, &c.run.stats.protocol)
+				gen = c.connGen
+				acked = 0
+			}
+			timeout, waiting := c.readTimeoutLocked()
+			c.connMu.Unlock()
+			readCtx, cancelTimeout := ctx, context.CancelFunc(func() {})
+			if timeout > 0 {
+				readCtx, cancelTimeout = context.WithTimeout(ctx, timeout)
+			}
+			msg, err := protocol.ReadMessageContext(readCtx, reader)
+			cancelTimeout()
+			if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+				switch {
+				case waiting != "":
+					err = &timeoutError{waiting: waiting, after: timeout}
+				case reader.Aligned():
+					continue
+				default:
+					// The poll timed out in the middle of a frame, whose
+					// start is lost.
+					err = &protocol.ProtocolError{Msg: "frame interrupted by the read timeout"}
+				}
+			}
+			c.connMu.Lock()
+			stale := c.connGen != gen
+			retransmitted := false
+			var batch int64
+			if !stale && err == nil {
+				if isAck, success, seq := batchAck(msg); isAck {
+					batch, retransmitted = c.ackReceivedLocked(success, seq)
+				}
+			}
+			c.connMu.Unlock()
+			if stale {
+				continue
+			}
+			if retransmitted {
+				acked++
+				log.Warningf("action: bets_enviadas | result: retry | batch: %d | seq: %d", batch, acked)
+				continue
+			}
 			if err != nil {
-				if !errors.Is(err, io.EOF) {
+				var protoErr *protocol.ProtocolError
+				if errors.As(err, &protoErr) && c.config.Resync != ResyncOff {
+					if protoErr.Resynced {
+						log.Warningf("action: leer_respuesta | result: skip | err: %v", err)
+						continue
+					}
+					if c.config.Resync == ResyncReconnect {
+						log.Warningf("action: leer_respuesta | result: reconnect | err: %v", err)
+						err := c.reconnect()
+						if err == nil {
+							continue
+						}
+						log.Errorf("action: reconnect | result: fail | err: %v", err)
+						c.readErr = err
+						break
+					}
+				}
+				c.connMu.Lock()
+				polls := c.pollsWinnersLocked()
+				c.connMu.Unlock()
+				if polls && !errors.As(err, &protoErr) && ctx.Err() == nil {
+					if err = c.pollWinners(ctx, gen, err); err == nil {
+						continue
+					}
+				} else if !errors.As(err, &protoErr) && ctx.Err() == nil && c.config.MaxReconnects > 0 {
+					if err := c.resume(gen, err); err == nil {
+						continue
+					}
+				}
+				if !errors.Is(err, io.EOF) && !errors.Is(err, context.Canceled) {
 					log.Errorf("action: leer_respuesta | result: fail | err: %v", err)
 				}
+				c.readErr = err
 				break
 			}
+			log.Debugf("action: receive_message | result: success | opcode: %v | length: %d | acked: %d | msg: %v",
+				msg.GetOpCode(), msg.GetLength(), acked, msg)
 			switch msg.GetOpCode() {
-			case BetsRecvSuccessOpCode:
-				log.Info("action: bets_enviadas | result: success")
-			case BetsRecvFailOpCode:
-				log.Error("action: bets_enviadas | result: fail")
-			case WinnersOpCode:
+			case protocol.BetsRecvSuccessOpCode:
+				acked++
+				c.run.ackReceived(true)
+				c.config.Hooks.ack(true)
+				log.Infof("action: bets_enviadas | result: success | batch: %d | seq: %d", batch, acked)
+			case protocol.BetsRecvFailOpCode:
+				acked++
+				c.run.ackReceived(false)
+				c.config.Hooks.ack(false)
+				log.Errorf("action: bets_enviadas | result: fail | batch: %d | seq: %d", batch, acked)
+			case protocol.AckOpCode:
+				ack := msg.(*protocol.Ack)
+				acked++
+				c.run.ackReceived(ack.Success())
+				c.config.Hooks.ack(ack.Success())
+				if ack.Success() {
+					log.Infof("action: bets_enviadas | result: success | batch: %d | seq: %d", batch, ack.CorrelationID)
+				} else {
+					log.Errorf("action: bets_enviadas | result: fail | batch: %d | seq: %d | detail: %v", batch, ack.CorrelationID, ack.Detail)
+				}
+			case protocol.SummaryOpCode:
+				c.summaryReceived(msg.(*protocol.Summary))
+			case protocol.WinnersOpCode:
 				{
+					winners := msg.(*protocol.Winners).List
+					c.run.winnersReceived(winners)
+					c.config.Hooks.winners(winners)
 					log.Infof("action: consulta_ganadores | result: success | cant_ganadores: %d",
-						len(msg.(*Winners).List))
+						len(winners))
 					break readLoop
 				}
 			}
@@ -218,20 +954,38 @@ func readResponse(conn net.Conn, readDone chan struct{}) {
 	}()
 }
 
-// sendFinishedAndAskForWinners sends FINISHED (with the numeric agency ID).
-// It logs success or failure for each write. On any serialization/I/O error it logs and returns.
-func (c *Client) sendFinished() {
+// Summary returns a snapshot of the current (or last) run summary.
+func (c *Client) Summary() RunSummary {
+	summary, _ := c.run.snapshot()
+	return summary
+}
+
+// sendFinished sends FINISHED (with the numeric agency ID).
+// It logs success or failure for each write and returns any
+// serialization/I/O error.
+func (c *Client) sendFinished() error {
 	agencyId, err := strconv.Atoi(c.config.ID)
 	if err != nil {
 		log.Errorf("action: send_finished | result: fail | error: %v", err)
-		return
+		return newError(ErrInput, "send_finished", err)
 	}
 
-	finishedMsg := Finished{int32(agencyId)}
-	if _, err := finishedMsg.WriteTo(c.conn); err != nil {
+	finishedMsg := protocol.Finished{AgencyId: int32(agencyId), Codec: c.config.Codec, Counters: &c.run.stats.protocol}
+	var frame bytes.Buffer
+	if _, err = finishedMsg.WriteTo(&frame); err == nil {
+		c.connMu.Lock()
+		c.finishedMsg = frame.Bytes()
+		err = c.sendLocked(c.finishedMsg, false)
+		c.finishedSent = err == nil
+		c.connMu.Unlock()
+	}
+	if err != nil {
 		log.Errorf("action: send_finished | result: fail | error: %v", err)
-		return
+		return err
 	}
 
+	c.run.finishedSent()
+	c.config.Hooks.finishedSent()
 	log.Infof("action: send_finished | result: success | agencyId: %d", int32(agencyId))
+	return nil
 }
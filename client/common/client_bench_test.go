@@ -0,0 +1,51 @@
+package common
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/op/go-logging"
+)
+
+// BenchmarkSendBetsDryRun measures the whole upload pipeline, from reading
+// the CSV to writing the frames, on 10000 bets. The dry run connection is
+// the in-memory sink, so the server plays no part in the timing.
+func BenchmarkSendBetsDryRun(b *testing.B) {
+	const bets = 10000
+	path := filepath.Join(b.TempDir(), "bets.csv")
+	file, err := os.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := WriteGeneratedBets(file, bets); err != nil {
+		b.Fatal(err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		b.Fatal(err)
+	}
+	file.Close()
+
+	level := logging.GetLevel("log")
+	logging.SetLevel(logging.ERROR, "log")
+	defer logging.SetLevel(level, "log")
+
+	b.ReportAllocs()
+	b.SetBytes(info.Size())
+	for i := 0; i < b.N; i++ {
+		client, err := NewClient(ClientConfig{ID: "5", BetsFilePath: path, BatchLimit: 100, DryRun: true})
+		if err != nil {
+			b.Fatal(err)
+		}
+		summary, err := client.SendBets(context.Background())
+		client.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if summary.BetsSent != bets {
+			b.Fatalf("sent %d bets, want %d", summary.BetsSent, bets)
+		}
+	}
+}
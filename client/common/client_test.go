@@ -0,0 +1,177 @@
+package common
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+func TestIdleReadTimeoutInFrame(t *testing.T) {
+	tests := []struct {
+		name      string
+		resync    ResyncMode
+		wantErr   error
+		wantConns int
+	}{
+		{"skip", ResyncSkip, ErrProtocol, 1},
+		{"reconnect", ResyncReconnect, nil, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The first 100 bets fill the bucket of the rate limit, the
+			// last batch waits 100ms for its tokens: meanwhile the server
+			// owes nothing and the reader polls. The server starts a frame
+			// there and never completes it.
+			server := newFakeServer(t, func(s *fakeSession, msg interface{}) bool {
+				if batch, ok := msg.(*fakeBatch); ok && s.index == 1 && batch.Seq == 10 {
+					s.ack(batch, protocol.AckSuccess)
+					s.send(ackFrame(11, protocol.AckSuccess)[:2])
+					return true
+				}
+				return false
+			})
+			config := testConfig(server.addr(), writeTestBets(t, 110))
+			config.RateLimit = 100
+			config.AckTimeout = 20 * time.Millisecond
+			config.Resync = tt.resync
+			summary, err := runTestClient(t, config)
+			checkErrorKind(t, err, tt.wantErr)
+			if server.connections() != tt.wantConns {
+				t.Fatalf("%d connections; want %d", server.connections(), tt.wantConns)
+			}
+			if tt.wantErr == nil && summary.BetsSent != 110 {
+				t.Fatalf("BetsSent = %d; want 110", summary.BetsSent)
+			}
+		})
+	}
+}
+
+func TestResync(t *testing.T) {
+	junk := frameBytes(protocol.OpCode(99), []byte("junk"))
+	implausible := []byte{byte(protocol.AckOpCode), 0xff, 0xff, 0xff, 0x7f}
+	tests := []struct {
+		name      string
+		resync    ResyncMode
+		frame     []byte
+		wantErr   error
+		wantConns int
+	}{
+		{"skip an unknown frame", ResyncSkip, junk, nil, 1},
+		{"skip with an implausible length", ResyncSkip, implausible, ErrProtocol, 1},
+		{"reconnect on an unknown frame", ResyncReconnect, junk, nil, 1},
+		{"reconnect on an implausible length", ResyncReconnect, implausible, nil, 2},
+		{"off", ResyncOff, junk, ErrProtocol, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, func(s *fakeSession, msg interface{}) bool {
+				if batch, ok := msg.(*fakeBatch); ok && s.index == 1 && batch.Seq == 3 {
+					s.send(tt.frame)
+					s.ack(batch, protocol.AckSuccess)
+					return true
+				}
+				return false
+			})
+			config := testConfig(server.addr(), writeTestBets(t, 50))
+			config.Resync = tt.resync
+			summary, err := runTestClient(t, config)
+			checkErrorKind(t, err, tt.wantErr)
+			if server.connections() != tt.wantConns {
+				t.Fatalf("%d connections; want %d", server.connections(), tt.wantConns)
+			}
+			if tt.wantErr == nil && summary.BetsSent != 50 {
+				t.Fatalf("BetsSent = %d; want 50", summary.BetsSent)
+			}
+		})
+	}
+}
+
+func TestOpenBetsFileRetry(t *testing.T) {
+	tests := []struct {
+		name        string
+		retryPeriod time.Duration
+		appearAfter time.Duration // 0 = never
+		wantErr     error
+	}{
+		{"file appears while retrying", 2 * time.Second, 150 * time.Millisecond, nil},
+		{"file appears too late", 250 * time.Millisecond, 0, ErrInput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, nil)
+			bets := writeTestBets(t, 25)
+			path := bets + ".later"
+			if tt.appearAfter > 0 {
+				timer := time.AfterFunc(tt.appearAfter, func() { os.Rename(bets, path) })
+				defer timer.Stop()
+			}
+			config := testConfig(server.addr(), path)
+			config.OpenRetryPeriod = tt.retryPeriod
+			started := time.Now()
+			summary, err := runTestClient(t, config)
+			checkErrorKind(t, err, tt.wantErr)
+			if tt.wantErr == nil && summary.BetsSent != 25 {
+				t.Fatalf("BetsSent = %d; want 25", summary.BetsSent)
+			}
+			if elapsed := time.Since(started); tt.wantErr != nil && elapsed > tt.retryPeriod+time.Second {
+				t.Fatalf("gave up after %v; want about %v", elapsed, tt.retryPeriod)
+			}
+		})
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	tests := []struct {
+		name       string
+		summary    RunSummary
+		ackSummary *protocol.Summary
+		wantErr    error
+	}{
+		{"every batch acknowledged", RunSummary{BatchesSent: 5, AcksSuccess: 5, BetsSent: 50}, nil, nil},
+		{"ack missing", RunSummary{BatchesSent: 5, AcksSuccess: 4, BetsSent: 50}, nil, ErrProtocol},
+		{"every bet stored", RunSummary{BatchesSent: 5, AcksSuccess: 5, BetsSent: 50}, &protocol.Summary{Batches: 5, Bets: 50}, nil},
+		{"bets missing from the summary", RunSummary{BatchesSent: 5, AcksSuccess: 5, BetsSent: 50}, &protocol.Summary{Batches: 5, Bets: 40}, ErrProtocol},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{ackSummary: tt.ackSummary}
+			checkErrorKind(t, c.reconcile(tt.summary), tt.wantErr)
+		})
+	}
+}
+
+func TestReconcileRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		answer  func(s *fakeSession, batch *fakeBatch)
+		wantErr error
+	}{
+		{"acks", func(s *fakeSession, batch *fakeBatch) { s.ack(batch, protocol.AckSuccess) }, nil},
+		{"legacy acks", func(s *fakeSession, batch *fakeBatch) {
+			s.send(frameBytes(protocol.BetsRecvSuccessOpCode, nil))
+		}, nil},
+		{"ack dropped", func(s *fakeSession, batch *fakeBatch) {
+			if batch.Seq != 3 {
+				s.ack(batch, protocol.AckSuccess)
+			}
+		}, ErrProtocol},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, func(s *fakeSession, msg interface{}) bool {
+				if batch, ok := msg.(*fakeBatch); ok {
+					tt.answer(s, batch)
+					return true
+				}
+				return false
+			})
+			summary, err := runTestClient(t, testConfig(server.addr(), writeTestBets(t, 50)))
+			checkErrorKind(t, err, tt.wantErr)
+			if summary.BatchesSent != 5 {
+				t.Fatalf("BatchesSent = %d; want 5", summary.BatchesSent)
+			}
+		})
+	}
+}
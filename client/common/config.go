@@ -0,0 +1,118 @@
+package common
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// ConfigError is returned by NewClient for an invalid ClientConfig. It
+// lists every problem found, so they can all be fixed at once.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	if len(e.Problems) == 1 {
+		return "invalid client config: " + e.Problems[0]
+	}
+	return fmt.Sprintf("invalid client config (%d problems): %s", len(e.Problems), strings.Join(e.Problems, "; "))
+}
+
+// problems checks a ClientConfig whose defaults were already applied,
+// returning a description of each invalid setting.
+func (config ClientConfig) problems() []string {
+	var problems []string
+	check := func(err error) {
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if _, err := strconv.ParseInt(config.ID, 10, 32); err != nil {
+		check(fmt.Errorf("agency ID %q is not a number", config.ID))
+	}
+	if config.BatchLimit <= 0 {
+		check(fmt.Errorf("batch limit must be positive, got %d", config.BatchLimit))
+	}
+	if config.WebSocketURL == "" && !config.DryRun {
+		check(validateServerAddresses(config.ServerAddress))
+	}
+	check(validateWebSocketURLs(config.WebSocketURL))
+	check(validateOTLPEndpoint(config.OTLPEndpoint))
+	check(config.validateBetsFile())
+	switch config.InputFormat {
+	case InputCSV, InputCSVChunked, InputJSONLines, InputSQLite:
+	default:
+		check(fmt.Errorf("unknown input format %q", config.InputFormat))
+	}
+	if config.InputFormat == InputSQLite {
+		check(config.SQL.validate())
+	}
+	check(config.CSV.validate())
+	check(validateEncoding(config.InputEncoding))
+	check(config.TCP.validate())
+	check(config.Shard.validate())
+	check(validateAnonymizers(config.Anonymize))
+	check(validateDuplicateMode(config.Duplicates))
+	check(validateCancelMode(config.CancelMode))
+	check(validateResyncMode(config.Resync))
+	if config.MemoryBudget < 0 {
+		check(fmt.Errorf("memory budget must not be negative, got %d", config.MemoryBudget))
+	}
+	if config.Connections < 0 {
+		check(fmt.Errorf("invalid number of connections %d", config.Connections))
+	}
+	check(validateAckMode(config))
+	if config.JournalPath != "" && config.Connections > 1 {
+		check(fmt.Errorf("journal cannot be used with %d connections", config.Connections))
+	}
+	if config.MaxFrameSize < protocol.MinMaxFrameSize {
+		check(fmt.Errorf("max frame size must be at least %d bytes, got %d", protocol.MinMaxFrameSize, config.MaxFrameSize))
+	}
+	return problems
+}
+
+// validateServerAddresses checks that every entry of a ServerAddress list
+// is a host:port pair with a valid port.
+func validateServerAddresses(list string) error {
+	if strings.TrimSpace(list) == "" {
+		return fmt.Errorf("server address is empty")
+	}
+	for _, address := range splitAddresses(list) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil || host == "" {
+			return fmt.Errorf("server address %q is not host:port", address)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("server address %q has an invalid port", address)
+		}
+	}
+	return nil
+}
+
+// validateBetsFile checks that a local BetsFilePath can be read. It is not
+// checked when the bets are generated, fetched from a URL or waited for
+// (see OpenRetryPeriod), nor when it is empty because the files are given
+// later, as in WatchDir.
+func (config ClientConfig) validateBetsFile() error {
+	path := config.BetsFilePath
+	if config.InputFormat == InputSQLite && isRemoteBets(path) {
+		return fmt.Errorf("sqlite input cannot be read from a URL")
+	}
+	if path == "" || config.Generate > 0 || isRemoteBets(path) || config.OpenRetryPeriod > 0 {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("bets file is not readable: %w", err)
+	}
+	defer file.Close()
+	if info, err := file.Stat(); err == nil && info.IsDir() {
+		return fmt.Errorf("bets file %s is a directory", path)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package common
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// testDriver is a database/sql driver that cannot open databases, enough
+// for the configuration to accept it.
+type testDriver struct{}
+
+func (testDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("test driver")
+}
+
+func init() {
+	sql.Register("common-test", testDriver{})
+}
+
+func TestConfigProblems(t *testing.T) {
+	tests := []struct {
+		name string
+		// change breaks an otherwise valid configuration.
+		change      func(config *ClientConfig)
+		wantProblem string
+	}{
+		{"valid", func(config *ClientConfig) {}, ""},
+		{"missing bets file", func(config *ClientConfig) { config.BetsFilePath += ".missing" }, "bets file is not readable"},
+		{"missing bets file retried", func(config *ClientConfig) {
+			config.BetsFilePath += ".missing"
+			config.OpenRetryPeriod = time.Second
+		}, ""},
+		{"unknown duplicates mode", func(config *ClientConfig) { config.Duplicates = "drop" }, `unknown duplicates mode "drop"`},
+		{"anonymized number", func(config *ClientConfig) {
+			config.Anonymize = map[string]Anonymizer{protocol.NumberKey: MaskAnonymizer(1)}
+		}, "field NUMERO cannot be anonymized"},
+		{"unknown resync mode", func(config *ClientConfig) { config.Resync = "reconect" }, `unknown resync mode "reconect"`},
+		{"sqlite without the driver", func(config *ClientConfig) { config.InputFormat = InputSQLite }, `sql driver "sqlite3" is not linked into the client (build it with -tags sqlite`},
+		{"sql driver linked", func(config *ClientConfig) {
+			config.InputFormat = InputSQLite
+			config.SQL.Driver = "common-test"
+		}, ""},
+		{"unknown sql driver", func(config *ClientConfig) {
+			config.InputFormat = InputSQLite
+			config.SQL.Driver = "postgres"
+		}, `sql driver "postgres" is not linked`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := ClientConfig{
+				ID:            "5",
+				ServerAddress: "127.0.0.1:12345",
+				BetsFilePath:  writeTestBets(t, 1),
+				BatchLimit:    10,
+			}
+			tt.change(&config)
+			client, err := NewClient(config)
+			if client != nil {
+				defer client.Close()
+			}
+			if tt.wantProblem == "" {
+				if err != nil {
+					t.Fatalf("NewClient: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantProblem) {
+				t.Fatalf("NewClient error = %v; want %q", err, tt.wantProblem)
+			}
+		})
+	}
+}
+
+func TestConfigDefaultResync(t *testing.T) {
+	client, err := NewClient(ClientConfig{ID: "5", ServerAddress: "127.0.0.1:12345", BatchLimit: 10})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer client.Close()
+	if client.config.Resync != ResyncSkip {
+		t.Fatalf("Resync = %q; want %q", client.config.Resync, ResyncSkip)
+	}
+}
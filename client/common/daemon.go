@@ -0,0 +1,255 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Daemon operates a Client as a long-running service controlled through
+// the HTTP listener, which must only be reachable from the host (see
+// CheckControlAddress):
+//   - POST /submit?path=<file>: uploads the bets file in the background.
+//     The file must be in the directory of the daemon; relative paths are
+//     resolved against it.
+//   - GET /status: whether a run is in progress, the last file submitted,
+//     its RunSummary and error.
+//   - POST /winners[?refresh=1]: queries the winners (see QueryWinners).
+//   - POST /cancel: cancels the run in progress (see Client.Cancel).
+//   - POST /shutdown: stops the daemon once the run in progress ends.
+//
+// Runs are serialized: a request made while another run is in progress is
+// answered with 409 Conflict.
+type Daemon struct {
+	ctx      context.Context
+	client   *Client
+	dir      string // absolute, without symlinks
+	mu       sync.Mutex
+	running  bool
+	file     string
+	lastErr  error
+	runs     sync.WaitGroup
+	shutdown chan struct{}
+	stopOnce sync.Once
+}
+
+// daemonStatus is the body of the /status response.
+type daemonStatus struct {
+	State   string     `json:"state"`
+	File    string     `json:"file"`
+	Summary RunSummary `json:"summary"`
+	Error   string     `json:"error,omitempty"`
+}
+
+// NewDaemon returns a Daemon operating client until ctx is cancelled, which
+// uploads the files of dir. The runs of the daemon are cancelled along
+// with ctx. It fails if dir is not a directory.
+func NewDaemon(ctx context.Context, client *Client, dir string) (*Daemon, error) {
+	dir, err := filepath.Abs(dir)
+	if err == nil {
+		dir, err = filepath.EvalSymlinks(dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &Daemon{ctx: ctx, client: client, dir: dir, shutdown: make(chan struct{})}, nil
+}
+
+// CheckControlAddress fails unless address, as given to NewHTTPListener,
+// is a unix socket or a loopback host: the control commands of a Daemon
+// are not authenticated, so they must only be reachable from the host.
+func CheckControlAddress(address string) error {
+	if strings.HasPrefix(address, unixPrefix) {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return nil
+	}
+	return fmt.Errorf("%q is neither a unix socket nor a loopback address", address)
+}
+
+// resolve returns the file a submitted path names, relative paths being
+// resolved against the directory of the daemon. It fails if the file does
+// not exist or is out of that directory once symlinks are followed.
+func (d *Daemon) resolve(name string) (string, error) {
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(d.dir, path)
+	}
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(d.dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is out of %s", name, d.dir)
+	}
+	return path, nil
+}
+
+// Register adds the control handlers to listener, before it is started.
+func (d *Daemon) Register(listener *HTTPListener) {
+	listener.HandleFunc("/submit", d.serveSubmit)
+	listener.HandleFunc("/status", d.serveStatus)
+	listener.HandleFunc("/winners", d.serveWinners)
+	listener.HandleFunc("/cancel", d.serveCancel)
+	listener.HandleFunc("/shutdown", d.serveShutdown)
+}
+
+// Run blocks until /shutdown is requested or the context of the daemon is
+// cancelled, which also cancels the run in progress, and then waits for
+// that run to end.
+func (d *Daemon) Run() {
+	log.Infof("action: daemon | result: in_progress | client_id: %v", d.client.config.ID)
+	select {
+	case <-d.ctx.Done():
+	case <-d.shutdown:
+	}
+	d.runs.Wait()
+	log.Infof("action: daemon | result: success | client_id: %v", d.client.config.ID)
+}
+
+// begin marks a run as in progress, answering 409 if there already is one
+// and 503 once shutting down. It reports whether the run may start.
+func (d *Daemon) begin(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "POST required"})
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	select {
+	case <-d.shutdown:
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "shutting down"})
+		return false
+	default:
+	}
+	if d.running {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "a run is in progress"})
+		return false
+	}
+	d.running = true
+	d.runs.Add(1)
+	return true
+}
+
+// abort releases the run started by begin before it ran, keeping the
+// outcome of the previous one.
+func (d *Daemon) abort() {
+	d.mu.Lock()
+	d.running = false
+	d.mu.Unlock()
+	d.runs.Done()
+}
+
+// end records the outcome of the run started by begin.
+func (d *Daemon) end(err error) {
+	d.mu.Lock()
+	d.running = false
+	d.lastErr = err
+	d.mu.Unlock()
+	d.runs.Done()
+}
+
+func (d *Daemon) serveSubmit(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("path")
+	if name == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "path required"})
+		return
+	}
+	if !d.begin(w, r) {
+		return
+	}
+	path, err := d.resolve(name)
+	// No run is in progress, so the configuration can be copied.
+	config := d.client.config
+	config.BetsFilePath = path
+	if err == nil {
+		err = config.validateBetsFile()
+	}
+	if err != nil {
+		d.abort()
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	d.mu.Lock()
+	d.file = path
+	d.mu.Unlock()
+	log.Infof("action: submit_file | result: in_progress | file: %s", path)
+	go func() {
+		_, err := d.client.sendFile(d.ctx, config.BetsFilePath)
+		if err != nil {
+			log.Errorf("action: submit_file | result: fail | file: %s | error: %v", path, err)
+		} else {
+			log.Infof("action: submit_file | result: success | file: %s", path)
+		}
+		d.end(err)
+	}()
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "running", "file": path})
+}
+
+func (d *Daemon) serveStatus(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	status := daemonStatus{State: "idle", File: d.file}
+	if d.running {
+		status.State = "running"
+	}
+	if d.lastErr != nil {
+		status.Error = d.lastErr.Error()
+	}
+	d.mu.Unlock()
+	status.Summary = d.client.Summary()
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (d *Daemon) serveWinners(w http.ResponseWriter, r *http.Request) {
+	if !d.begin(w, r) {
+		return
+	}
+	winners, err := d.client.QueryWinners(d.ctx, r.URL.Query().Get("refresh") == "1")
+	d.end(err)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"count": len(winners), "documents": winners})
+}
+
+func (d *Daemon) serveCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "POST required"})
+		return
+	}
+	d.mu.Lock()
+	running := d.running
+	d.mu.Unlock()
+	if !running {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "no run in progress"})
+		return
+	}
+	d.client.Cancel()
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "cancelling"})
+}
+
+func (d *Daemon) serveShutdown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "POST required"})
+		return
+	}
+	d.mu.Lock()
+	d.stopOnce.Do(func() { close(d.shutdown) })
+	d.mu.Unlock()
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "shutting down"})
+}
@@ -0,0 +1,109 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckControlAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		wantErr bool
+	}{
+		{"unix:/run/client/ctl.sock", false},
+		{"127.0.0.1:8080", false},
+		{"[::1]:8080", false},
+		{"localhost:8080", false},
+		{":8080", true},
+		{"0.0.0.0:8080", true},
+		{"192.168.0.10:8080", true},
+		{"client:8080", true},
+		{"8080", true},
+	}
+	for _, tt := range tests {
+		if err := CheckControlAddress(tt.address); (err != nil) != tt.wantErr {
+			t.Errorf("CheckControlAddress(%q) = %v; want error %t", tt.address, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDaemonSubmit(t *testing.T) {
+	dir := t.TempDir()
+	bets := writeTestBets(t, 25)
+	if err := os.Rename(bets, filepath.Join(dir, "agency-5.csv")); err != nil {
+		t.Fatal(err)
+	}
+	outside := writeTestBets(t, 25)
+	if err := os.Symlink(outside, filepath.Join(dir, "link.csv")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{"relative path", "agency-5.csv", http.StatusAccepted},
+		{"absolute path", filepath.Join(dir, "agency-5.csv"), http.StatusAccepted},
+		{"path through a subdirectory", "sub/../agency-5.csv", http.StatusAccepted},
+		{"no path", "", http.StatusBadRequest},
+		{"missing file", "agency-6.csv", http.StatusBadRequest},
+		{"directory", "sub", http.StatusBadRequest},
+		{"parent directory", "..", http.StatusBadRequest},
+		{"file out of the directory", outside, http.StatusBadRequest},
+		{"relative path out of the directory", "../" + filepath.Base(filepath.Dir(outside)) + "/bets.csv", http.StatusBadRequest},
+		{"symlink out of the directory", "link.csv", http.StatusBadRequest},
+		{"url", "http://127.0.0.1:1/bets.csv", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, nil)
+			config := testConfig(server.addr(), "")
+			client, err := NewClient(config)
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+			defer client.Close()
+			daemon, err := NewDaemon(context.Background(), client, dir)
+			if err != nil {
+				t.Fatalf("NewDaemon: %v", err)
+			}
+			request := httptest.NewRequest(http.MethodPost, "/submit?path="+url.QueryEscape(tt.path), nil)
+			response := httptest.NewRecorder()
+			daemon.serveSubmit(response, request)
+			daemon.runs.Wait()
+			if response.Code != tt.wantStatus {
+				t.Fatalf("status = %d (%s); want %d", response.Code, response.Body, tt.wantStatus)
+			}
+			wantBets := 0
+			if tt.wantStatus == http.StatusAccepted {
+				wantBets = 25
+			}
+			if got := len(server.received()); got != wantBets {
+				t.Fatalf("server received %d bets; want %d", got, wantBets)
+			}
+		})
+	}
+}
+
+func TestNewDaemonRejectsFiles(t *testing.T) {
+	server := newFakeServer(t, nil)
+	client, err := NewClient(testConfig(server.addr(), ""))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer client.Close()
+	for _, dir := range []string{writeTestBets(t, 1), filepath.Join(t.TempDir(), "missing")} {
+		if _, err := NewDaemon(context.Background(), client, dir); err == nil {
+			t.Errorf("NewDaemon(%s) succeeded; want an error", dir)
+		}
+	}
+}
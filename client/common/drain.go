@@ -0,0 +1,69 @@
+package common
+
+import (
+	"fmt"
+	"time"
+)
+
+// CancelMode selects what a run does when SIGTERM or SIGINT arrives, or
+// MaxDuration elapses.
+//   - CancelDrain (default): the record being read is added to the batch,
+//     the partial batch is flushed and the acks in flight are awaited for up
+//     to DrainTimeout before closing the connection.
+//   - CancelAbort: the partial batch is dropped and the connection is
+//     closed without waiting for acks.
+type CancelMode string
+
+const (
+	CancelDrain CancelMode = "drain"
+	CancelAbort CancelMode = "abort"
+)
+
+// defaultDrainTimeout is used when DrainTimeout is unset.
+const defaultDrainTimeout = 2 * time.Second
+
+func validateCancelMode(mode CancelMode) error {
+	switch mode {
+	case CancelDrain, CancelAbort:
+		return nil
+	default:
+		return fmt.Errorf("unknown cancel mode %q", mode)
+	}
+}
+
+// drainTimeout returns how long a cancelled run waits for the acks in
+// flight: DrainTimeout when draining, nothing when aborting.
+func (c *Client) drainTimeout() time.Duration {
+	if c.config.CancelMode == CancelAbort {
+		return 0
+	}
+	if c.config.DrainTimeout <= 0 {
+		return defaultDrainTimeout
+	}
+	return c.config.DrainTimeout
+}
+
+// drain waits, after the run was cancelled, for the acks of the batches in
+// flight (see drainTimeout), then logs how many were left unacknowledged.
+func (c *Client) drain(readDone <-chan struct{}) {
+	giveUp := make(chan struct{})
+	timer := time.AfterFunc(c.drainTimeout(), func() { close(giveUp) })
+	defer timer.Stop()
+	c.waitAcked(readDone, giveUp)
+
+	c.connMu.Lock()
+	pending := len(c.unacked)
+	c.connMu.Unlock()
+	c.logDrain(pending)
+}
+
+// logDrain logs the outcome of a cancelled run, pending being the batches
+// left unacknowledged.
+func (c *Client) logDrain(pending int) {
+	result := "success"
+	if pending > 0 {
+		result = "incomplete"
+	}
+	log.Infof("action: drain | result: %s | client_id: %v | mode: %s | unacked: %d",
+		result, c.config.ID, c.config.CancelMode, pending)
+}
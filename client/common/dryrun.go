@@ -0,0 +1,49 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// dryRunConn stands in for the server connection in dry runs, counting the
+// bytes that would have been written.
+type dryRunConn struct {
+	net.Conn
+	written int64
+}
+
+func (d *dryRunConn) Write(p []byte) (int, error) {
+	d.written += int64(len(p))
+	return len(p), nil
+}
+
+func (d *dryRunConn) SetWriteDeadline(time.Time) error { return nil }
+
+func (d *dryRunConn) Close() error { return nil }
+
+// dryRun parses, validates and batches the whole input without connecting
+// to the server, then logs what would have been sent. Every invalid record
+// is logged instead of stopping at the first one. The dry run fails if the
+// real upload would: on any invalid record outside tolerant mode, or above
+// the error threshold.
+func (c *Client) dryRun(ctx context.Context, betsReader RecordReader) error {
+	meter := &dryRunConn{}
+	c.connMu.Lock()
+	c.conn = meter
+	c.connMu.Unlock()
+
+	err := c.buildAndSendBatches(ctx, betsReader)
+	if err == nil && !c.config.Tolerant && c.rejects.count > 0 {
+		err = newError(ErrInput, "dry_run", fmt.Errorf("%d invalid records", c.rejects.count))
+	}
+	result := "success"
+	if err != nil {
+		result = "fail"
+	}
+	summary := c.Summary()
+	log.Infof("action: dry_run | result: %s | client_id: %v | bets: %d | batches: %d | bytes: %d | invalid: %d | duplicated: %d",
+		result, c.config.ID, summary.BetsSent, summary.BatchesSent, meter.written, summary.BetsRejected, summary.BetsDuplicated)
+	return classify("dry_run", err)
+}
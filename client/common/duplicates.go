@@ -0,0 +1,73 @@
+package common
+
+import (
+	"fmt"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// DuplicateMode selects what happens to a bet whose (DOCUMENTO, NUMERO)
+// pair was already sent in the same run.
+//   - DuplicatesAllow (default): duplicates are not tracked.
+//   - DuplicatesFlag: duplicates are logged and counted, but still sent.
+//   - DuplicatesSkip: duplicates are logged, counted and not sent.
+type DuplicateMode string
+
+const (
+	DuplicatesAllow DuplicateMode = "allow"
+	DuplicatesFlag  DuplicateMode = "flag"
+	DuplicatesSkip  DuplicateMode = "skip"
+)
+
+// betKey identifies a bet for duplicate detection.
+type betKey struct {
+	document string
+	number   string
+}
+
+// duplicateTracker remembers the input line where each bet of the run was
+// first seen.
+type duplicateTracker struct {
+	seen  map[betKey]int
+	count int64
+}
+
+func newDuplicateTracker() *duplicateTracker {
+	return &duplicateTracker{seen: make(map[betKey]int)}
+}
+
+// checkDuplicate records bet, read at line, and reports whether it must be
+// skipped according to the configured DuplicateMode.
+func (c *Client) checkDuplicate(bet *protocol.Bet, line int) bool {
+	if c.config.Duplicates == DuplicatesAllow {
+		return false
+	}
+	key := betKey{document: bet.Document, number: bet.Number}
+	first, ok := c.duplicates.seen[key]
+	if !ok {
+		c.duplicates.seen[key] = line
+		return false
+	}
+	c.duplicates.count++
+	c.run.betDuplicated(line, first)
+	log.Warningf("action: duplicate_bet | result: %s | line: %d | first_line: %d | dni: %s | numero: %s",
+		c.config.Duplicates, line, first, PII(bet.Document), bet.Number)
+	return c.config.Duplicates == DuplicatesSkip
+}
+
+// logDuplicates reports how many duplicates the run found.
+func (c *Client) logDuplicates() {
+	if c.duplicates.count > 0 {
+		log.Warningf("action: duplicate_bets | result: %s | duplicates: %d", c.config.Duplicates, c.duplicates.count)
+	}
+}
+
+// validateDuplicateMode checks that mode is a known DuplicateMode.
+func validateDuplicateMode(mode DuplicateMode) error {
+	switch mode {
+	case DuplicatesAllow, DuplicatesFlag, DuplicatesSkip:
+		return nil
+	default:
+		return fmt.Errorf("unknown duplicates mode %q", mode)
+	}
+}
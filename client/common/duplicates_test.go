@@ -0,0 +1,39 @@
+package common
+
+import "testing"
+
+func TestDuplicates(t *testing.T) {
+	// Lines 3 and 5 repeat the bet of line 1; line 4 has the document of
+	// line 1 but another number.
+	bets := "Ana,Gomez,30123412,1990-05-17,7574\n" +
+		"Juan,Perez,28999111,1985-01-02,12\n" +
+		"Ana,Gomez,30123412,1990-05-17,7574\n" +
+		"Ana,Gomez,30123412,1990-05-17,7575\n" +
+		"Ana M.,Gomez,30123412,1991-01-01,7574\n"
+	tests := []struct {
+		mode           DuplicateMode
+		wantSent       int64
+		wantDuplicated int64
+	}{
+		{DuplicatesAllow, 5, 0},
+		{DuplicatesFlag, 5, 2},
+		{DuplicatesSkip, 3, 2},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			server := newFakeServer(t, nil)
+			config := testConfig(server.addr(), writeTestFile(t, "bets.csv", []byte(bets)))
+			config.Duplicates = tt.mode
+			summary, err := runTestClient(t, config)
+			if err != nil {
+				t.Fatalf("SendBets: %v", err)
+			}
+			if summary.BetsSent != tt.wantSent || int64(len(server.received())) != tt.wantSent {
+				t.Fatalf("BetsSent = %d, server received %d; want %d", summary.BetsSent, len(server.received()), tt.wantSent)
+			}
+			if summary.BetsDuplicated != tt.wantDuplicated {
+				t.Fatalf("BetsDuplicated = %d; want %d", summary.BetsDuplicated, tt.wantDuplicated)
+			}
+		})
+	}
+}
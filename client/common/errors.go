@@ -0,0 +1,93 @@
+package common
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// Error categories returned by the client. Every error returned by SendBets
+// is an *Error whose Kind is one of these sentinels, so embedders can branch
+// with errors.Is(err, ErrConnection) and still reach the cause with
+// errors.As / errors.Unwrap.
+var (
+	ErrInput          = errors.New("invalid input")
+	ErrConnection     = errors.New("connection error")
+	ErrProtocol       = errors.New("protocol error")
+	ErrServerRejected = errors.New("server rejected")
+	ErrCancelled      = errors.New("cancelled")
+	ErrTimeout        = errors.New("max duration exceeded")
+)
+
+// Error is a categorized client error.
+// - Kind: one of the Err* sentinels.
+// - Op: the client action that failed, using the log action names.
+// - Err: the underlying cause.
+type Error struct {
+	Kind error
+	Op   string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v: %v", e.Op, e.Kind, e.Err)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Is reports whether target is the category of e.
+func (e *Error) Is(target error) bool { return target == e.Kind }
+
+func newError(kind error, op string, err error) *Error {
+	return &Error{Kind: kind, Op: op, Err: err}
+}
+
+// classify wraps err in an *Error, inferring its category from the cause:
+// network timeouts are ErrConnection, even those matching
+// context.DeadlineExceeded such as a dial timeout, other context errors are
+// ErrCancelled (ErrTimeout once MaxDuration elapsed),
+// record, gzip and bet validation errors are
+// ErrInput, malformed frames are ErrProtocol and anything else is treated
+// as an I/O failure of the connection. Already classified errors are kept.
+func classify(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var clientErr *Error
+	var protoErr *protocol.ProtocolError
+	var validationErr *protocol.ValidationError
+	var csvErr *csv.ParseError
+	var recordErr *RecordError
+	var flateErr flate.CorruptInputError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &clientErr):
+		return err
+	case errors.As(err, &netErr) && netErr.Timeout() && netErr != context.DeadlineExceeded:
+		// context.DeadlineExceeded is a net.Error too.
+		return newError(ErrConnection, op, err)
+	case errors.Is(err, context.Canceled):
+		return newError(ErrCancelled, op, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return newError(ErrTimeout, op, err)
+	case errors.As(err, &csvErr), errors.As(err, &recordErr), errors.As(err, &validationErr), errors.As(err, &flateErr),
+		errors.Is(err, gzip.ErrChecksum), errors.Is(err, gzip.ErrHeader):
+		return newError(ErrInput, op, err)
+	case errors.As(err, &protoErr):
+		return newError(ErrProtocol, op, err)
+	default:
+		return newError(ErrConnection, op, err)
+	}
+}
+
+// stopped reports whether err comes from ctx, the run context, being done:
+// on SIGTERM, SIGINT or once MaxDuration elapsed.
+func stopped(ctx context.Context, err error) bool {
+	return ctx.Err() != nil && errors.Is(err, ctx.Err())
+}
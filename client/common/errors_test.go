@@ -0,0 +1,88 @@
+package common
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// dialTimeout returns the error of a dial that timed out.
+func dialTimeout(t *testing.T) error {
+	t.Helper()
+	dialer := net.Dialer{Deadline: time.Now().Add(-time.Second)}
+	_, err := dialer.Dial("tcp", "127.0.0.1:1")
+	if err == nil {
+		t.Fatal("dial with a past deadline succeeded")
+	}
+	return err
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"cancelled", context.Canceled, ErrCancelled},
+		{"max duration", fmt.Errorf("waiting: %w", context.DeadlineExceeded), ErrTimeout},
+		{"dial timeout", dialTimeout(t), ErrConnection},
+		{"read deadline", &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, ErrConnection},
+		{"ack timeout", &timeoutError{waiting: "ack", after: time.Second}, ErrConnection},
+		{"closed connection", io.EOF, ErrConnection},
+		{"malformed frame", &protocol.ProtocolError{Msg: "invalid opcode"}, ErrProtocol},
+		{"invalid bet", &protocol.ValidationError{Field: "DOCUMENTO", Reason: "not numeric"}, ErrInput},
+		{"malformed csv", &csv.ParseError{Line: 3, Err: csv.ErrFieldCount}, ErrInput},
+		{"already classified", newError(ErrServerRejected, "bets_enviadas", io.EOF), ErrServerRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classify("send_bets", tt.err)
+			var clientErr *Error
+			if !errors.As(err, &clientErr) || clientErr.Kind != tt.want {
+				t.Fatalf("classify(%v) = %v; want kind %v", tt.err, err, tt.want)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("classify(%v) = %v, which does not wrap the cause", tt.err, err)
+			}
+		})
+	}
+	if err := classify("send_bets", nil); err != nil {
+		t.Fatalf("classify(nil) = %v; want nil", err)
+	}
+}
+
+func TestStopped(t *testing.T) {
+	live := context.Background()
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithTimeout(context.Background(), -time.Second)
+	defer cancelExpired()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want bool
+	}{
+		{"cancelled run", cancelled, fmt.Errorf("reading bets: %w", context.Canceled), true},
+		{"expired run", expired, context.DeadlineExceeded, true},
+		{"dial timeout during the run", live, dialTimeout(t), false},
+		{"deadline of another context", live, context.DeadlineExceeded, false},
+		{"other error of a cancelled run", cancelled, io.EOF, false},
+		{"no error", cancelled, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stopped(tt.ctx, tt.err); got != tt.want {
+				t.Fatalf("stopped = %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package common
+
+import (
+	"expvar"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	publishOnce sync.Once
+	published   atomic.Value // *Client whose Stats are published
+)
+
+// PublishVars publishes the Stats and the ProtocolStats of c as the
+// expvar variables "client" and "protocol", next to the memstats and
+// cmdline ones of the runtime. expvar names are process-wide, so a later
+// call publishes the Stats of its client instead.
+func (c *Client) PublishVars() {
+	published.Store(c)
+	publishOnce.Do(func() {
+		expvar.Publish("client", expvar.Func(func() interface{} {
+			return published.Load().(*Client).Stats()
+		}))
+		expvar.Publish("protocol", expvar.Func(func() interface{} {
+			return published.Load().(*Client).ProtocolStats()
+		}))
+	})
+}
+
+// ServeVars is the handler for /debug/vars: every expvar variable as JSON.
+func ServeVars(w http.ResponseWriter, r *http.Request) {
+	expvar.Handler().ServeHTTP(w, r)
+}
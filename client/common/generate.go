@@ -0,0 +1,81 @@
+package common
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// Pools the generated bettor names are drawn from.
+var (
+	generatedFirstNames = []string{"Santiago", "Valentina", "Mateo", "Sofia", "Benjamin", "Martina", "Joaquin", "Catalina", "Tomas", "Lucia"}
+	generatedLastNames  = []string{"Gonzalez", "Rodriguez", "Fernandez", "Lopez", "Martinez", "Garcia", "Perez", "Romero", "Sosa", "Alvarez"}
+)
+
+// generatedRecordReader yields Generate random valid bets instead of
+// reading BetsFilePath, to load test the server without fixture files.
+// Line is the number of the bet, starting at 1.
+type generatedRecordReader struct {
+	rng   *rand.Rand
+	total int64
+	line  int
+	last  []string
+}
+
+func (c *Client) newGeneratedRecords() *generatedRecordReader {
+	log.Infof("action: generate_bets | result: in_progress | client_id: %v | bets: %d", c.config.ID, c.config.Generate)
+	return newGeneratedRecordReader(c.config.Generate)
+}
+
+func newGeneratedRecordReader(total int64) *generatedRecordReader {
+	return &generatedRecordReader{
+		rng:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		total: total,
+	}
+}
+
+// WriteGeneratedBets writes n random valid bets to w as the records of a
+// CSV bets file, like the ones uploaded with Generate.
+func WriteGeneratedBets(w io.Writer, n int64) error {
+	records := newGeneratedRecordReader(n)
+	out := csv.NewWriter(w)
+	for {
+		record, err := records.Read()
+		if err == io.EOF {
+			break
+		}
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func (r *generatedRecordReader) Read() ([]string, error) {
+	if int64(r.line) >= r.total {
+		return nil, io.EOF
+	}
+	r.line++
+	birthdate := time.Date(1940, time.January, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, r.rng.Intn(65*365))
+	r.last = []string{
+		generatedFirstNames[r.rng.Intn(len(generatedFirstNames))],
+		generatedLastNames[r.rng.Intn(len(generatedLastNames))],
+		strconv.Itoa(10000000 + r.rng.Intn(90000000)),
+		birthdate.Format(birthdateLayout),
+		strconv.Itoa(r.rng.Intn(10000)),
+	}
+	return r.last, nil
+}
+
+func (r *generatedRecordReader) Line() int { return r.line }
+
+func (r *generatedRecordReader) Raw() string {
+	if r.last == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s,%s,%s,%s,%s", r.last[0], r.last[1], r.last[2], r.last[3], r.last[4])
+}
@@ -0,0 +1,73 @@
+package common
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// defaultStallTimeout is the StallTimeout used when it is not set.
+const defaultStallTimeout = time.Minute
+
+// Health is the state of a Client reported by its health endpoints, see
+// ServeHealthz and ServeReadyz.
+// - Running: a run is in progress.
+// - Connections: connections to the server open, idle ones included.
+// - InFlight: batches written and not acknowledged yet.
+// - Idle: seconds since the run in progress last wrote a frame or received an ack (0 = no run).
+// - Stalled: the run in progress was idle for longer than ClientConfig.StallTimeout.
+type Health struct {
+	Running     bool    `json:"running"`
+	Closed      bool    `json:"closed"`
+	Connections int64   `json:"connections"`
+	InFlight    int64   `json:"in_flight"`
+	Idle        float64 `json:"idle_seconds"`
+	Stalled     bool    `json:"stalled"`
+}
+
+// Health returns the connection state and liveness of the Client. It is
+// safe to call from any goroutine.
+func (c *Client) Health() Health {
+	c.lifeMu.Lock()
+	health := Health{Running: len(c.cancels) > 0, Closed: c.closed}
+	c.lifeMu.Unlock()
+	health.Connections = atomic.LoadInt64(&c.pool.open)
+	if !health.Running {
+		return health
+	}
+	summary := c.Summary()
+	health.InFlight = summary.BatchesSent - summary.AcksSuccess - summary.AcksFail
+	idle := time.Since(time.Unix(0, atomic.LoadInt64(&c.run.stats.lastActivity)))
+	health.Idle = idle.Seconds()
+	timeout := c.config.StallTimeout
+	if timeout <= 0 {
+		timeout = defaultStallTimeout
+	}
+	health.Stalled = idle > timeout
+	return health
+}
+
+// ServeHealthz is the handler for /healthz, the liveness check: it answers
+// 503 while the run in progress is stalled (see StallTimeout) and 200
+// otherwise, along with the Health of the Client.
+func (c *Client) ServeHealthz(w http.ResponseWriter, r *http.Request) {
+	health := c.Health()
+	status := http.StatusOK
+	if health.Stalled {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, health)
+}
+
+// ServeReadyz is the handler for /readyz, the readiness check: it answers
+// 503 once the Client is closed, and while a run is in progress without a
+// connection to the server (dialing it, or journaling while it is
+// unreachable); 200 otherwise, along with the Health of the Client.
+func (c *Client) ServeReadyz(w http.ResponseWriter, r *http.Request) {
+	health := c.Health()
+	status := http.StatusOK
+	if health.Closed || (health.Running && health.Connections == 0) {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, health)
+}
@@ -0,0 +1,65 @@
+package common
+
+// Hooks are optional callbacks invoked by the client as a run makes
+// progress, e.g. to feed custom metrics or progress bars. Any of them may
+// be nil. They are called from the goroutines of the run, possibly
+// concurrently, so they must be safe for concurrent use and should return
+// quickly, as the run waits for them.
+// - OnConnect: a connection to the server at address is put to use.
+// - OnBatchFlushed: a batch of bets was written to the server.
+// - OnAck: the server acknowledged a batch, or rejected it if !success.
+// - OnFinishedSent: FINISHED was sent.
+// - OnWinners: the winners of the agency were received.
+// - OnError: a run (SendBets or QueryWinners) failed with err.
+// - OnProgress: the progress of a run, every ClientConfig.ProgressPeriod.
+type Hooks struct {
+	OnConnect      func(address string)
+	OnBatchFlushed func(bets int32)
+	OnAck          func(success bool)
+	OnFinishedSent func()
+	OnWinners      func(winners []string)
+	OnError        func(err error)
+	OnProgress     func(progress Progress)
+}
+
+func (h Hooks) connect(address string) {
+	if h.OnConnect != nil {
+		h.OnConnect(address)
+	}
+}
+
+func (h Hooks) batchFlushed(bets int32) {
+	if h.OnBatchFlushed != nil {
+		h.OnBatchFlushed(bets)
+	}
+}
+
+func (h Hooks) ack(success bool) {
+	if h.OnAck != nil {
+		h.OnAck(success)
+	}
+}
+
+func (h Hooks) finishedSent() {
+	if h.OnFinishedSent != nil {
+		h.OnFinishedSent()
+	}
+}
+
+func (h Hooks) winners(winners []string) {
+	if h.OnWinners != nil {
+		h.OnWinners(append([]string(nil), winners...))
+	}
+}
+
+func (h Hooks) error(err error) {
+	if h.OnError != nil && err != nil {
+		h.OnError(err)
+	}
+}
+
+func (h Hooks) progress(progress Progress) {
+	if h.OnProgress != nil {
+		h.OnProgress(progress)
+	}
+}
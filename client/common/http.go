@@ -0,0 +1,102 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// HTTPListener is the optional HTTP endpoint of the client. It is only
+// started when an address is configured; features register their handlers
+// on it before Start is called.
+type HTTPListener struct {
+	mux    *http.ServeMux
+	server *http.Server
+}
+
+// NewHTTPListener builds a listener bound to address (host:port), or to
+// a local unix socket, that only its owner may use, for a "unix:<path>"
+// address. The socket is not opened until Start.
+func NewHTTPListener(address string) *HTTPListener {
+	mux := http.NewServeMux()
+	return &HTTPListener{
+		mux:    mux,
+		server: &http.Server{Addr: address, Handler: mux},
+	}
+}
+
+// unixPrefix marks an HTTPListener address as a unix socket path.
+const unixPrefix = "unix:"
+
+// HandleFunc registers handler for pattern on the listener.
+func (l *HTTPListener) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	l.mux.HandleFunc(pattern, handler)
+}
+
+// Start serves HTTP requests in a background goroutine. Serve errors other
+// than the listener being closed are logged.
+func (l *HTTPListener) Start() {
+	go func() {
+		log.Infof("action: http_listen | result: in_progress | address: %s", l.server.Addr)
+		var err error
+		if path := strings.TrimPrefix(l.server.Addr, unixPrefix); path != l.server.Addr {
+			// A socket left behind by an earlier process would make Listen fail.
+			_ = os.Remove(path)
+			var listener net.Listener
+			if listener, err = net.Listen("unix", path); err == nil {
+				// Only the user running the client may connect.
+				if err = os.Chmod(path, 0o600); err != nil {
+					listener.Close()
+				} else {
+					err = l.server.Serve(listener)
+				}
+			}
+		} else {
+			err = l.server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("action: http_listen | result: fail | error: %v", err)
+		}
+	}()
+}
+
+// Close gracefully shuts the listener down, waiting at most 2 seconds for
+// in-flight requests.
+func (l *HTTPListener) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := l.server.Shutdown(ctx); err != nil {
+		log.Errorf("action: http_close | result: fail | error: %v", err)
+		return
+	}
+	log.Infof("action: http_close | result: success")
+}
+
+// ServeResult is the handler for /result. It answers 503 while the run is in
+// progress and the RunSummary (winners included) once it finished.
+func (c *Client) ServeResult(w http.ResponseWriter, r *http.Request) {
+	summary, finished := c.run.snapshot()
+	if !finished {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "running"})
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+// ServeProtocolStats is the handler for /stats: the protocol counters of
+// the current (or last) run as JSON.
+func (c *Client) ServeProtocolStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, c.ProtocolStats())
+}
+
+// writeJSON encodes body as the JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
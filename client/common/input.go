@@ -0,0 +1,248 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// gzipSuffix marks bets files that are decompressed automatically.
+const gzipSuffix = ".gz"
+
+// InputFormat selects how the bets file is parsed.
+//   - InputCSV (default): one bet per line, NOMBRE,APELLIDO,DOCUMENTO,NACIMIENTO,NUMERO.
+//   - InputCSVChunked: the same records without quoted fields, read in
+//     large chunks and split by hand, for maximum-throughput runs.
+//   - InputJSONLines: one JSON object per line keyed by the protocol field
+//     names (NOMBRE, APELLIDO, DOCUMENTO, NACIMIENTO, NUMERO).
+//   - InputSQLite: the rows of a query run on the database at BetsFilePath
+//     (see SQLOptions).
+type InputFormat string
+
+const (
+	InputCSV        InputFormat = "csv"
+	InputCSVChunked InputFormat = "csv-chunked"
+	InputJSONLines  InputFormat = "jsonl"
+	InputSQLite     InputFormat = "sqlite"
+)
+
+// betFieldsCount is the number of bet columns of a record (AGENCIA aside).
+const betFieldsCount = 5
+
+// CSVOptions tunes the CSV parser for exports from other tools.
+// - Comma: field delimiter (0 = ',').
+// - LazyQuotes: accept quotes inside unquoted fields and bare quotes in quoted fields.
+// - FieldsPerRecord: fields per record, as in encoding/csv (0 = count of the first record, < 0 = any).
+//
+// Records must always hold at least the 5 bet fields; extra trailing
+// columns are ignored.
+type CSVOptions struct {
+	Comma           rune
+	LazyQuotes      bool
+	FieldsPerRecord int
+}
+
+// validate checks that the options can parse bets.
+func (o CSVOptions) validate() error {
+	if o.Comma == '"' || o.Comma == '\r' || o.Comma == '\n' || o.Comma == utf8.RuneError || !utf8.ValidRune(o.Comma) {
+		return fmt.Errorf("invalid CSV delimiter %q", o.Comma)
+	}
+	if o.FieldsPerRecord > 0 && o.FieldsPerRecord < betFieldsCount {
+		return fmt.Errorf("CSV records need at least %d fields, got %d", betFieldsCount, o.FieldsPerRecord)
+	}
+	return nil
+}
+
+// RecordReader yields the bets of an input file, whatever its format, as
+// records in the CSV column order so all formats share the batching code.
+type RecordReader interface {
+	// Read returns the next record, or io.EOF once the input is exhausted.
+	// The record slice may be reused by the next Read, so it must not be
+	// kept; its strings may.
+	Read() ([]string, error)
+	// Line returns the input line of the last record read.
+	Line() int
+	// Raw returns the source text of the last record read or rejected, or
+	// "" if it is not available.
+	Raw() string
+}
+
+// RecordError reports a record that could not be parsed.
+type RecordError struct {
+	Line int
+	Err  error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("record on line %d: %v", e.Line, e.Err)
+}
+
+func (e *RecordError) Unwrap() error { return e.Err }
+
+// betsInput returns the reader of the bets stored in betsFile. Files whose
+// name ends in .gz, or any file if GzipInput is set, are decompressed on the
+// fly. Closing the returned reader does not close betsFile.
+func (c *Client) betsInput(betsFile io.Reader) (io.ReadCloser, error) {
+	if !c.config.GzipInput && !strings.HasSuffix(c.betsFileName(), gzipSuffix) {
+		return io.NopCloser(betsFile), nil
+	}
+	return gzip.NewReader(betsFile)
+}
+
+// openRecords returns the RecordReader of the bets stored in betsFile and
+// the closer that releases it. betsFile itself is left open.
+func (c *Client) openRecords(ctx context.Context, betsFile io.Reader) (RecordReader, io.Closer, error) {
+	if c.config.InputFormat == InputSQLite {
+		reader, err := c.newSQLRecordReader(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		return reader, reader, nil
+	}
+	input, err := c.betsInput(betsFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	betsReader, err := c.newRecordReader(input)
+	if err != nil {
+		input.Close()
+		return nil, nil, err
+	}
+	return betsReader, input, nil
+}
+
+// newRecordReader returns the RecordReader for the configured InputFormat,
+// reading input converted from InputEncoding to UTF-8.
+func (c *Client) newRecordReader(input io.Reader) (RecordReader, error) {
+	input = decodeInput(input, c.config.InputEncoding)
+	switch c.config.InputFormat {
+	case InputCSV:
+		reader := csv.NewReader(input)
+		reader.Comma = c.config.CSV.Comma
+		reader.LazyQuotes = c.config.CSV.LazyQuotes
+		reader.FieldsPerRecord = c.config.CSV.FieldsPerRecord
+		reader.ReuseRecord = true
+		return &csvRecordReader{reader: reader, comma: c.config.CSV.Comma}, nil
+	case InputCSVChunked:
+		return newChunkedRecordReader(input, c.config.CSV), nil
+	case InputJSONLines:
+		scanner := bufio.NewScanner(input)
+		scanner.Buffer(nil, maxJSONLineSize)
+		return &jsonRecordReader{scanner: scanner}, nil
+	default:
+		return nil, fmt.Errorf("unknown input format %q", c.config.InputFormat)
+	}
+}
+
+type csvRecordReader struct {
+	reader *csv.Reader
+	comma  rune
+	last   []string
+}
+
+// errShortRecord reports a record without all the bet fields.
+var errShortRecord = errors.New("record has fewer than 5 fields")
+
+func (r *csvRecordReader) Read() ([]string, error) {
+	// On ErrFieldCount the record is returned along with the error.
+	record, err := r.reader.Read()
+	r.last = record
+	if err != nil {
+		return nil, err
+	}
+	if len(record) < betFieldsCount {
+		return nil, &RecordError{Line: r.Line(), Err: errShortRecord}
+	}
+	return record[:betFieldsCount], nil
+}
+
+func (r *csvRecordReader) Line() int {
+	line, _ := r.reader.FieldPos(0)
+	return line
+}
+
+// Raw re-encodes the last record with the configured delimiter; records
+// that failed to parse are not available.
+func (r *csvRecordReader) Raw() string {
+	if r.last == nil {
+		return ""
+	}
+	var raw strings.Builder
+	writer := csv.NewWriter(&raw)
+	writer.Comma = r.comma
+	_ = writer.Write(r.last)
+	writer.Flush()
+	return strings.TrimSuffix(raw.String(), "\n")
+}
+
+// maxJSONLineSize bounds the length of a JSON Lines record.
+const maxJSONLineSize = 64 * 1024
+
+// jsonFields are the object keys read from each JSON line, in CSV order.
+var jsonFields = []string{
+	protocol.FirstNameKey,
+	protocol.LastNameKey,
+	protocol.DocumentKey,
+	protocol.BirthdateKey,
+	protocol.NumberKey,
+}
+
+// jsonRecordReader reads one JSON object per line. Blank lines are skipped
+// and values may be strings or numbers; missing fields are left empty so
+// bet validation reports them.
+type jsonRecordReader struct {
+	scanner *bufio.Scanner
+	line    int
+	raw     string
+}
+
+func (r *jsonRecordReader) Read() ([]string, error) {
+	for r.scanner.Scan() {
+		r.line++
+		text := bytes.TrimSpace(r.scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		r.raw = string(text)
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(text, &object); err != nil {
+			return nil, &RecordError{Line: r.line, Err: err}
+		}
+		record := make([]string, len(jsonFields))
+		for i, key := range jsonFields {
+			value, ok := object[key]
+			if !ok {
+				continue
+			}
+			var s string
+			if err := json.Unmarshal(value, &s); err == nil {
+				record[i] = s
+				continue
+			}
+			var n json.Number
+			if err := json.Unmarshal(value, &n); err != nil {
+				return nil, &RecordError{Line: r.line, Err: fmt.Errorf("field %s must be a string or a number", key)}
+			}
+			record[i] = n.String()
+		}
+		return record, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, &RecordError{Line: r.line + 1, Err: err}
+	}
+	return nil, io.EOF
+}
+
+func (r *jsonRecordReader) Line() int { return r.line }
+
+func (r *jsonRecordReader) Raw() string { return r.raw }
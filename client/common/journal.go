@@ -0,0 +1,244 @@
+package common
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// defaultJournalRetry is used when JournalRetry is unset.
+const defaultJournalRetry = 5 * time.Second
+
+// journalRecord is a batch stored in the journal: its input line, bet count
+// and NEW_BETS frames, ready to be written to the server. Frames that did
+// not fit in the MemoryBudget are left in the file, at offset.
+type journalRecord struct {
+	batch  sentBatch
+	frames []byte
+	offset int64
+	length int
+}
+
+// journal stores the batches serialized while the server was unreachable,
+// so they are sent once it is back, even by a later run. Each record is
+// the input line, the bet count and the frames length as big-endian
+// uint32s, followed by the frames.
+type journal struct {
+	path     string
+	records  []journalRecord
+	previous int // records left by earlier runs
+	pending  int // replayed records waiting for their ack
+	budget   *memoryBudget
+	held     int64 // bytes of frames kept in memory
+}
+
+// keep stores frames in record if they fit in the budget, which they are
+// copied from, or leaves them in the file otherwise.
+func (j *journal) keep(record *journalRecord, frames []byte) {
+	record.length = len(frames)
+	if j.budget.reserve(int64(len(frames))) {
+		record.frames = append([]byte(nil), frames...)
+		j.held += int64(len(frames))
+	}
+}
+
+// frames returns the frames of record, reading them from the file if they
+// were left there.
+func (j *journal) frames(record journalRecord) ([]byte, error) {
+	if record.frames != nil || record.length == 0 {
+		return record.frames, nil
+	}
+	file, err := os.Open(j.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	frames := make([]byte, record.length)
+	if _, err := file.ReadAt(frames, record.offset); err != nil {
+		return nil, err
+	}
+	return frames, nil
+}
+
+// openJournal loads the batches left in JournalPath by earlier runs. It
+// returns nil if the journal is disabled. A record cut short by a crash
+// while it was appended is dropped.
+func (c *Client) openJournal() (*journal, error) {
+	if c.config.JournalPath == "" || c.config.DryRun {
+		return nil, nil
+	}
+	j := &journal{path: c.config.JournalPath, budget: c.budget}
+	file, err := os.Open(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	var offset int64
+	var frames []byte
+	for {
+		var header [12]byte
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Warningf("action: journal | result: truncated | path: %s | records: %d", j.path, len(j.records))
+			}
+			break
+		}
+		record := journalRecord{batch: sentBatch{
+			line:      int(binary.BigEndian.Uint32(header[0:4])),
+			bets:      int32(binary.BigEndian.Uint32(header[4:8])),
+			journaled: true,
+		}, offset: offset + int64(len(header))}
+		length := int(binary.BigEndian.Uint32(header[8:12]))
+		if cap(frames) < length {
+			frames = make([]byte, length)
+		}
+		if _, err := io.ReadFull(reader, frames[:length]); err != nil {
+			log.Warningf("action: journal | result: truncated | path: %s | records: %d", j.path, len(j.records))
+			break
+		}
+		j.keep(&record, frames[:length])
+		j.records = append(j.records, record)
+		offset = record.offset + int64(length)
+	}
+	j.previous = len(j.records)
+	if j.previous > 0 {
+		log.Infof("action: journal | result: resume | path: %s | batches: %d", j.path, j.previous)
+	}
+	return j, nil
+}
+
+// append stores a batch in the journal and syncs it to disk.
+func (j *journal) append(batch sentBatch, frames []byte) error {
+	file, err := os.OpenFile(j.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	var header [12]byte
+	binary.BigEndian.PutUint32(header[0:4], uint32(batch.line))
+	binary.BigEndian.PutUint32(header[4:8], uint32(batch.bets))
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(frames)))
+	_, err = file.Write(append(header[:], frames...))
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	batch.journaled = true
+	record := journalRecord{batch: batch, offset: info.Size() + int64(len(header))}
+	j.keep(&record, frames)
+	j.records = append(j.records, record)
+	return nil
+}
+
+// acked records the ack of a replayed batch, removing the journal once
+// every replayed batch was acknowledged. Callers must hold connMu.
+func (j *journal) acked() {
+	if j == nil {
+		return
+	}
+	if j.pending--; j.pending > 0 {
+		return
+	}
+	j.records = nil
+	j.previous = 0
+	j.budget.release(j.held)
+	j.held = 0
+	if err := os.Remove(j.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Errorf("action: journal | result: fail | path: %s | error: %v", j.path, err)
+		return
+	}
+	log.Infof("action: journal | result: delivered | path: %s", j.path)
+}
+
+// goOffline handles a server that could not be reached: the bets of
+// betsReader are serialized into the journal, then the server is dialed
+// every JournalRetry until it answers, which leaves c.conn connected.
+func (c *Client) goOffline(ctx context.Context, betsReader RecordReader, cause error) error {
+	log.Warningf("action: journal | result: offline | client_id: %v | path: %s | error: %v",
+		c.config.ID, c.journal.path, cause)
+	c.offline = true
+	err := c.buildAndSendBatches(ctx, betsReader)
+	c.offline = false
+	if err != nil {
+		return classify("journal", err)
+	}
+	log.Infof("action: journal | result: success | client_id: %v | path: %s | batches: %d",
+		c.config.ID, c.journal.path, len(c.journal.records))
+
+	retry := c.config.JournalRetry
+	if retry <= 0 {
+		retry = defaultJournalRetry
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return classify("connect", ctx.Err())
+		case <-time.After(retry):
+		}
+		if err := c.createClientSocket(ctx); err == nil {
+			log.Infof("action: journal | result: online | client_id: %v | batches: %d", c.config.ID, len(c.journal.records))
+			return nil
+		}
+	}
+}
+
+// journalLocked stores a batch serialized while offline in the journal. The
+// checkpoint advances past it, since the journal now owns it. Callers must
+// hold connMu.
+func (c *Client) journalLocked(batch sentBatch, frames []byte) error {
+	if err := c.journal.append(batch, frames); err != nil {
+		return fmt.Errorf("journal %s: %w", c.journal.path, err)
+	}
+	c.checkpoint.acked(batch, true)
+	return nil
+}
+
+// replayJournal sends the batches of the journal, the ones left by earlier
+// runs first. Those are counted in the run summary now; the ones journaled
+// by this run already were.
+func (c *Client) replayJournal() error {
+	if c.journal == nil || len(c.journal.records) == 0 {
+		return nil
+	}
+	c.connMu.Lock()
+	records, previous := c.journal.records, c.journal.previous
+	c.journal.pending = len(records)
+	c.connMu.Unlock()
+	log.Infof("action: journal | result: replay | client_id: %v | batches: %d", c.config.ID, len(records))
+	for i, record := range records {
+		frames, err := c.journal.frames(record)
+		if err != nil {
+			return fmt.Errorf("journal %s: %w", c.journal.path, err)
+		}
+		err = c.writeBatchLocked(record.batch, func(out io.Writer) error {
+			_, err := out.Write(frames)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if i < previous {
+			c.run.batchFlushed(record.batch.bets)
+			c.config.Hooks.batchFlushed(record.batch.bets)
+		}
+	}
+	return nil
+}
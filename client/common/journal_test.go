@@ -0,0 +1,146 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenJournal(t *testing.T) {
+	frames := [][]byte{[]byte("first batch"), []byte("second batch")}
+	tests := []struct {
+		name        string
+		write       bool
+		cut         int    // bytes cut from the end of the file
+		extra       []byte // bytes appended to the file
+		budget      int64
+		wantRecords int
+	}{
+		{"missing file", false, 0, nil, 0, 0},
+		{"complete", true, 0, nil, 0, 2},
+		{"frames left in the file", true, 0, nil, 1, 2},
+		{"truncated header", true, 0, []byte{0, 0, 0, 7, 0}, 0, 2},
+		{"truncated frames", true, 3, nil, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "journal")
+			if tt.write {
+				j := &journal{path: path}
+				for i, f := range frames {
+					if err := j.append(sentBatch{line: 10 * (i + 1), bets: int32(i + 1)}, f); err != nil {
+						t.Fatalf("append: %v", err)
+					}
+				}
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data = append(data[:len(data)-tt.cut], tt.extra...)
+				if err := os.WriteFile(path, data, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			client := &Client{
+				config: ClientConfig{JournalPath: path},
+				budget: &memoryBudget{limit: tt.budget},
+			}
+			j, err := client.openJournal()
+			if err != nil {
+				t.Fatalf("openJournal: %v", err)
+			}
+			if len(j.records) != tt.wantRecords || j.previous != tt.wantRecords {
+				t.Fatalf("journal has %d records, %d previous; want %d", len(j.records), j.previous, tt.wantRecords)
+			}
+			for i, record := range j.records {
+				if record.batch.line != 10*(i+1) || record.batch.bets != int32(i+1) || !record.batch.journaled {
+					t.Fatalf("record %d = %+v", i, record.batch)
+				}
+				got, err := j.frames(record)
+				if err != nil {
+					t.Fatalf("frames of record %d: %v", i, err)
+				}
+				if !bytes.Equal(got, frames[i]) {
+					t.Fatalf("frames of record %d = %q; want %q", i, got, frames[i])
+				}
+			}
+		})
+	}
+}
+
+func TestJournalDisabled(t *testing.T) {
+	for _, config := range []ClientConfig{{}, {JournalPath: filepath.Join(t.TempDir(), "journal"), DryRun: true}} {
+		if j, err := (&Client{config: config}).openJournal(); j != nil || err != nil {
+			t.Errorf("openJournal with %+v = %v, %v; want no journal", config, j, err)
+		}
+	}
+}
+
+func TestJournalOffline(t *testing.T) {
+	tests := []struct {
+		name         string
+		earlierRun   bool // an earlier run journals the bets and is stopped
+		memoryBudget int64
+	}{
+		{"server back during the run", false, 0},
+		{"frames left in the file", false, 1},
+		{"journal left by an earlier run", true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address := unusedAddress(t)
+			config := testConfig(address, writeTestBets(t, 25))
+			dir := t.TempDir()
+			config.JournalPath = filepath.Join(dir, "journal")
+			config.CheckpointPath = filepath.Join(dir, "checkpoint.json")
+			config.JournalRetry = 50 * time.Millisecond
+			config.MemoryBudget = tt.memoryBudget
+
+			if tt.earlierRun {
+				client, err := NewClient(config)
+				if err != nil {
+					t.Fatalf("NewClient: %v", err)
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+				_, err = client.SendBets(ctx)
+				cancel()
+				client.Close()
+				if err == nil {
+					t.Fatal("run without a server succeeded")
+				}
+				if _, err := os.Stat(config.JournalPath); err != nil {
+					t.Fatalf("journal not written: %v", err)
+				}
+			}
+
+			started := make(chan *fakeServer, 1)
+			timer := time.AfterFunc(150*time.Millisecond, func() {
+				listener, err := net.Listen("tcp", address)
+				if err != nil {
+					t.Errorf("listen: %v", err)
+					close(started)
+					return
+				}
+				started <- serveFake(t, listener, nil)
+			})
+			defer timer.Stop()
+			summary, err := runTestClient(t, config)
+			if err != nil {
+				t.Fatalf("SendBets: %v", err)
+			}
+			server := <-started
+			if summary.BatchesSent != 3 || summary.AcksSuccess != 3 || len(distinctBets(server.received())) != 25 {
+				t.Fatalf("BatchesSent = %d, AcksSuccess = %d, server received %d distinct bets; want 3, 3 and 25",
+					summary.BatchesSent, summary.AcksSuccess, len(distinctBets(server.received())))
+			}
+			if _, err := os.Stat(config.JournalPath); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("journal left after the batches were delivered: %v", err)
+			}
+		})
+	}
+}
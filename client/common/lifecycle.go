@@ -0,0 +1,90 @@
+package common
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrClosed is the cause of the ErrCancelled error returned by the methods
+// of a Client that was closed.
+var ErrClosed = errors.New("client closed")
+
+// beginRun registers a run (SendBets, QueryWinners or WatchDir) started
+// with ctx, returning its context, also cancelled by Cancel and Close, and
+// the function ending it, to be called once the run returns. It fails with
+// ErrClosed once Close was called.
+func (c *Client) beginRun(ctx context.Context) (context.Context, func(), error) {
+	c.lifeMu.Lock()
+	defer c.lifeMu.Unlock()
+	if c.closed {
+		return nil, nil, ErrClosed
+	}
+	if c.cancels == nil {
+		c.cancels = make(map[int]context.CancelFunc)
+	}
+	c.runs.Add(1)
+	c.lastRun++
+	id := c.lastRun
+	ctx, cancel := context.WithCancel(ctx)
+	c.cancels[id] = cancel
+	return ctx, func() {
+		c.lifeMu.Lock()
+		delete(c.cancels, id)
+		c.lifeMu.Unlock()
+		cancel()
+		c.runs.Done()
+	}, nil
+}
+
+// Cancel aborts the runs in progress from any goroutine, as cancelling
+// their context would: the partial batch is flushed and the acks in flight
+// awaited, or both dropped, according to CancelMode, and the runs return
+// an ErrCancelled error. The protocol has no message to tell the server
+// that an upload was cancelled, so it only sees the connection close. It
+// does not wait for the runs to return, and later runs are not affected.
+func (c *Client) Cancel() {
+	c.lifeMu.Lock()
+	defer c.lifeMu.Unlock()
+	if len(c.cancels) == 0 {
+		return
+	}
+	log.Infof("action: cancel | result: in_progress | client_id: %v | runs: %d", c.config.ID, len(c.cancels))
+	for _, cancel := range c.cancels {
+		cancel()
+	}
+}
+
+// onRelease registers a resource of the current run to be released by
+// releaseRun, in reverse registration order.
+func (c *Client) onRelease(release func()) {
+	c.releases = append(c.releases, release)
+}
+
+// releaseRun releases the resources of the run: its input, rejects file,
+// progress reporter and connection.
+func (c *Client) releaseRun() {
+	for i := len(c.releases) - 1; i >= 0; i-- {
+		c.releases[i]()
+	}
+	c.releases = nil
+}
+
+// Close cancels the runs in progress (see Cancel), waits for them to
+// return and closes the idle connections. Journals, checkpoints and rejects
+// files are synced or closed by the runs themselves as they return, so
+// nothing is left to flush afterwards. Once closed, every run fails with
+// ErrClosed. Close is safe to call more than
+// once and from any goroutine: later calls wait for the first one to
+// complete. It always returns nil.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		c.lifeMu.Lock()
+		c.closed = true
+		c.lifeMu.Unlock()
+		c.Cancel()
+		c.runs.Wait()
+		c.pool.close()
+		log.Infof("action: close | result: success | client_id: %v", c.config.ID)
+	})
+	return nil
+}
@@ -0,0 +1,57 @@
+package common
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/op/go-logging"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// SetLogLevel changes the level of the logs of the process, e.g. to DEBUG
+// while a run is being diagnosed, and logs the change.
+func SetLogLevel(name string) error {
+	level, err := logging.LogLevel(name)
+	if err != nil {
+		return err
+	}
+	previous := logging.GetLevel("")
+	logging.SetLevel(level, "")
+	log.Infof("action: log_level | result: success | log_level: %v -> %v", previous, level)
+	return nil
+}
+
+// ServeLogLevel is the handler for /loglevel: GET answers the log level of
+// the process and POST ?level=<level> changes it (see SetLogLevel).
+func ServeLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := SetLogLevel(r.URL.Query().Get("level")); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "GET or POST required"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"level": logging.GetLevel("").String()})
+}
+
+// describeFrames renders the opcode and size of every frame in frames, for
+// the debug logs.
+func describeFrames(frames []byte) string {
+	var parts []string
+	for len(frames) >= 5 {
+		size := 5 + int(binary.LittleEndian.Uint32(frames[1:5]))
+		parts = append(parts, fmt.Sprintf("%v/%d", protocol.OpCode(frames[0]), size))
+		if size > len(frames) {
+			break
+		}
+		frames = frames[size:]
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,246 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// dispatchedBet is a bet handed to a worker, along with its input line.
+type dispatchedBet struct {
+	bet  *protocol.Bet
+	line int
+}
+
+// workerPool spreads the bets of a run across Connections connections, each
+// owned by a worker Client that batches and sends the bets it is given and
+// tracks their acks. Workers share the run state of the Client that
+// started them, so the summary adds up the acks of every connection.
+type workerPool struct {
+	workers     []*Client
+	bets        []chan dispatchedBet
+	cancelReads []context.CancelFunc
+	next        int // worker receiving the current chunk of bets
+	dispatched  int32
+	chunk       int32
+	failed      chan struct{} // closed when the first worker fails
+	failOnce    sync.Once
+	giveUp      chan struct{} // closed when workers must stop waiting for acks
+	giveUpOnce  sync.Once
+	err         error
+	wg          sync.WaitGroup
+}
+
+// newWorker returns a Client sending on its own connection on behalf of c.
+func (c *Client) newWorker() *Client {
+	return &Client{
+		config:    c.config,
+		ackSignal: make(chan struct{}, 1),
+		run:       c.run,
+		budget:    c.budget,
+		pool:      c.pool,
+		tracer:    c.tracer,
+	}
+}
+
+// startWorkers dials Connections connections and starts, on each of them,
+// a reader of server responses and a worker sending the bets dispatched to
+// it. If a dial fails the connections already opened are closed.
+func (c *Client) startWorkers(ctx context.Context) (*workerPool, error) {
+	chunk := c.config.BatchLimit
+	if chunk <= 0 {
+		chunk = 1
+	}
+	pool := &workerPool{chunk: chunk, failed: make(chan struct{}), giveUp: make(chan struct{})}
+	for i := 0; i < c.config.Connections; i++ {
+		worker := c.newWorker()
+		if err := worker.createClientSocket(ctx); err != nil {
+			pool.close()
+			return nil, err
+		}
+		readCtx, cancelRead := context.WithCancel(context.Background())
+		readDone := make(chan struct{})
+		worker.readDone = readDone
+		worker.readResponse(readCtx, readDone)
+		pool.workers = append(pool.workers, worker)
+		pool.cancelReads = append(pool.cancelReads, cancelRead)
+		pool.bets = append(pool.bets, make(chan dispatchedBet, chunk))
+	}
+	for i, worker := range pool.workers {
+		pool.wg.Add(1)
+		go func(worker *Client, bets <-chan dispatchedBet) {
+			defer pool.wg.Done()
+			if err := worker.sendShard(bets, pool.giveUp); err != nil {
+				pool.fail(err)
+			}
+		}(worker, pool.bets[i])
+	}
+	log.Infof("action: connect | result: success | client_id: %v | connections: %d", c.config.ID, len(pool.workers))
+	return pool, nil
+}
+
+// dispatch hands bet to a worker. Bets are dealt in chunks of BatchLimit so
+// that each batch holds consecutive bets of the input. It fails with the
+// error of the first worker that stopped.
+func (p *workerPool) dispatch(bet *protocol.Bet, line int) error {
+	if p.dispatched == p.chunk {
+		p.dispatched = 0
+		p.next = (p.next + 1) % len(p.workers)
+	}
+	p.dispatched++
+	select {
+	case p.bets[p.next] <- dispatchedBet{bet: bet, line: line}:
+		return nil
+	case <-p.failed:
+		return p.err
+	}
+}
+
+func (p *workerPool) fail(err error) {
+	p.failOnce.Do(func() {
+		p.err = err
+		close(p.failed)
+	})
+}
+
+// stopWaiting makes the workers stop waiting for acks and, if they did not
+// flush their partial batch yet, drop it.
+func (p *workerPool) stopWaiting() {
+	p.giveUpOnce.Do(func() { close(p.giveUp) })
+}
+
+// unacked returns the number of batches waiting for their ack.
+func (p *workerPool) unacked() int {
+	pending := 0
+	for _, worker := range p.workers {
+		worker.connMu.Lock()
+		pending += len(worker.unacked)
+		worker.connMu.Unlock()
+	}
+	return pending
+}
+
+// finish tells the workers that every bet was dispatched and waits until
+// they sent their last batch and got it acknowledged. It returns the error
+// of the first worker that failed.
+func (p *workerPool) finish() error {
+	for _, bets := range p.bets {
+		close(bets)
+	}
+	p.wg.Wait()
+	select {
+	case <-p.failed:
+		return p.err
+	default:
+		return nil
+	}
+}
+
+// close stops the readers of every worker and hands their connections back
+// to the pool if every batch written on them was acknowledged. The
+// connection FINISHED was sent on is closed by the server, so it is
+// discarded, and so are all of them once a worker failed, since a write
+// may have been cut midway. Callers must call finish first, if workers
+// were started.
+func (p *workerPool) close() {
+	for i, worker := range p.workers {
+		p.cancelReads[i]()
+		<-worker.readDone
+		worker.connMu.Lock()
+		readStopped := worker.readErr == nil || errors.Is(worker.readErr, context.Canceled)
+		if p.err == nil && readStopped && len(worker.unacked) == 0 && !worker.finishedSent {
+			worker.pool.put(worker.conn, worker.connSeq)
+		} else {
+			worker.pool.discard(worker.conn)
+		}
+		worker.connMu.Unlock()
+	}
+}
+
+// sendShard batches and sends the bets received from bets until it is
+// closed, then waits for the acks of every batch sent, unless giveUp is
+// closed.
+func (c *Client) sendShard(bets <-chan dispatchedBet, giveUp <-chan struct{}) error {
+	var batchBuff bytes.Buffer
+	var betsCounter int32 = 0
+	for dispatched := range bets {
+		select {
+		case <-giveUp:
+			return nil
+		default:
+		}
+		if err := c.addBet(dispatched.bet, dispatched.line, &batchBuff, &betsCounter); err != nil {
+			return err
+		}
+	}
+	select {
+	case <-giveUp:
+		// The run was aborted: the partial batch is dropped.
+		return nil
+	default:
+	}
+	if betsCounter > 0 {
+		if err := c.flushLocked(&batchBuff, betsCounter); err != nil {
+			return err
+		}
+	}
+	c.waitAcked(c.readDone, giveUp)
+	return nil
+}
+
+// sendParallel sends the bets read from betsReader across Connections
+// connections. FINISHED is sent once every connection got the acks of its
+// batches, and only on the first connection, since the server expects one
+// per agency; the winners are read from that same connection.
+func (c *Client) sendParallel(ctx context.Context, betsReader RecordReader) error {
+	pool, err := c.startWorkers(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return classify("connect", ctx.Err())
+		}
+		return newError(ErrConnection, "connect", err)
+	}
+	defer pool.close()
+	c.workers = pool
+	defer func() { c.workers = nil }()
+
+	err = c.buildAndSendBatches(ctx, betsReader)
+	if ctx.Err() != nil {
+		// Cancelled: workers wait for their acks as long as CancelMode allows.
+		if timeout := c.drainTimeout(); timeout > 0 {
+			defer time.AfterFunc(timeout, pool.stopWaiting).Stop()
+		} else {
+			pool.stopWaiting()
+		}
+	}
+	workerErr := pool.finish()
+	if workerErr != nil && (err == nil || stopped(ctx, err)) {
+		err = workerErr
+	}
+	if err != nil && !stopped(ctx, err) {
+		return classify("send_bets", err)
+	}
+	if ctx.Err() != nil {
+		c.logDrain(pool.unacked())
+		return classify("send_bets", ctx.Err())
+	}
+
+	first := pool.workers[0]
+	if err := first.sendTelemetry(); err != nil {
+		return classify("send_telemetry", err)
+	}
+	if err := first.sendFinished(); err != nil {
+		return classify("send_finished", err)
+	}
+	select {
+	case <-ctx.Done():
+		return classify("send_bets", ctx.Err())
+	case <-first.readDone:
+	}
+	c.readErr = first.readErr
+	return c.runError()
+}
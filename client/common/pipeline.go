@@ -0,0 +1,189 @@
+package common
+
+import (
+	"errors"
+	"io"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// pipelineDepth is how far, in chunks of records or in batches, a stage of
+// a pipelined run may get ahead of the next one.
+const pipelineDepth = 4
+
+// prefetchChunk is the number of records the parsing stage hands over at
+// once.
+const prefetchChunk = 256
+
+// prefetchedRecord is a record read by the parsing stage, along with what
+// the RecordReader reported about it.
+type prefetchedRecord struct {
+	fields [betFieldsCount]string
+	n      int // fields read
+	line   int
+	raw    string
+	err    error
+}
+
+// prefetchReader is the parsing stage of a pipelined run (see
+// ClientConfig.Pipeline): a goroutine reads the records of a RecordReader
+// ahead of the consumer, handing them over in chunks through a bounded
+// channel, so parsing overlaps the validation and encoding of the bets.
+// Chunks are recycled once consumed. The reading stops after the first
+// error that is not a record error, as the upload fails on it.
+type prefetchReader struct {
+	chunks  chan []prefetchedRecord
+	free    chan []prefetchedRecord
+	stop    chan struct{}
+	stopped chan struct{} // closed once the goroutine returned
+	chunk   []prefetchedRecord
+	next    int
+	current *prefetchedRecord
+}
+
+// prefetchRecords starts reading betsReader in the background. Raw is only
+// kept for the records that failed to parse, unless the rejects file may
+// need it (see Tolerant).
+func (c *Client) prefetchRecords(betsReader RecordReader) *prefetchReader {
+	r := &prefetchReader{
+		chunks:  make(chan []prefetchedRecord, pipelineDepth),
+		free:    make(chan []prefetchedRecord, pipelineDepth+2),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	keepRaw := c.config.Tolerant
+	go func() {
+		defer close(r.stopped)
+		defer close(r.chunks)
+		for done := false; !done; {
+			var chunk []prefetchedRecord
+			select {
+			case chunk = <-r.free:
+			default:
+				chunk = make([]prefetchedRecord, 0, prefetchChunk)
+			}
+			for len(chunk) < prefetchChunk && !done {
+				fields, err := betsReader.Read()
+				if errors.Is(err, io.EOF) {
+					done = true
+					break
+				}
+				record := prefetchedRecord{line: betsReader.Line(), err: err}
+				record.n = copy(record.fields[:], fields)
+				if err != nil || keepRaw {
+					record.raw = betsReader.Raw()
+				}
+				chunk = append(chunk, record)
+				done = err != nil && !isRecordError(err)
+			}
+			if len(chunk) == 0 {
+				continue
+			}
+			select {
+			case r.chunks <- chunk:
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+	return r
+}
+
+func (r *prefetchReader) Read() ([]string, error) {
+	for r.next == len(r.chunk) {
+		if r.chunk != nil {
+			select {
+			case r.free <- r.chunk[:0]:
+			default:
+			}
+			r.chunk, r.current = nil, nil
+		}
+		chunk, ok := <-r.chunks
+		if !ok {
+			return nil, io.EOF
+		}
+		r.chunk, r.next = chunk, 0
+	}
+	r.current = &r.chunk[r.next]
+	r.next++
+	if r.current.err != nil {
+		return nil, r.current.err
+	}
+	return r.current.fields[:r.current.n], nil
+}
+
+func (r *prefetchReader) Line() int {
+	if r.current == nil {
+		return 0
+	}
+	return r.current.line
+}
+
+func (r *prefetchReader) Raw() string {
+	if r.current == nil {
+		return ""
+	}
+	return r.current.raw
+}
+
+// close stops the parsing stage and waits until it no longer reads the
+// underlying RecordReader.
+func (r *prefetchReader) close() {
+	close(r.stop)
+	<-r.stopped
+}
+
+// batchSender is the sending stage of a pipelined run: a goroutine sends,
+// in order, the batches serialized by writeBatchLocked, so encoding
+// overlaps the writes and the waits for acks (see Window). The batches are
+// queued in a bounded channel; being sent by a single goroutine, they get
+// their sequence numbers in the order they were built.
+type batchSender struct {
+	batches chan builtBatch
+	done    chan struct{} // closed once the goroutine returned
+	err     error         // why the sending stopped, set before done is closed
+}
+
+// startSender starts sending the batches queued with send.
+func (c *Client) startSender() *batchSender {
+	s := &batchSender{
+		batches: make(chan builtBatch, pipelineDepth),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		for batch := range s.batches {
+			if err := c.sendBatch(batch); err != nil {
+				s.err = err
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// send queues batch, blocking while the queue is full. It fails with the
+// error that stopped the sender, if it did.
+func (s *batchSender) send(batch builtBatch) error {
+	select {
+	case <-s.done:
+		protocol.PutBuffer(batch.frames)
+		return s.err
+	default:
+	}
+	select {
+	case s.batches <- batch:
+		return nil
+	case <-s.done:
+		protocol.PutBuffer(batch.frames)
+		return s.err
+	}
+}
+
+// stop waits until the queued batches were sent and returns the error that
+// stopped the sender, if any.
+func (s *batchSender) stop() error {
+	close(s.batches)
+	<-s.done
+	return s.err
+}
@@ -0,0 +1,269 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connPool owns the connections to the server. Connections are borrowed
+// with get and handed back with put once every batch written on them was
+// acknowledged, so later uploads (parallel workers, watch mode, winner
+// queries) reuse them instead of dialing again; discard closes those left
+// in an unknown state. New connections go to the current entry of
+// ServerAddress, which moves to the next one when it fails (see failover).
+// Host names are resolved again on every dial, so a server redeployed
+// under a new IP is reached by the next connection. It is safe for concurrent use.
+type connPool struct {
+	open      int64 // connections dialed and not closed yet, see Health
+	config    ClientConfig
+	bandwidth *rateLimiter // shared by every connection, see BandwidthLimit
+	tls       *tls.Config  // nil unless TLS is enabled, see TLSOptions
+	capture   *captureFile // nil unless CapturePath is set
+	addresses []string     // ServerAddress entries, in failover order
+	mu        sync.Mutex
+	idle      []idleConn
+	current   int               // index in addresses of the server dialed
+	resolved  map[string]string // IP each address resolved to on its last dial
+}
+
+// idleConn is a connection waiting in the pool, along with the number of
+// batches written on it: the server numbers the acks per connection.
+type idleConn struct {
+	conn net.Conn
+	seq  int32
+}
+
+// serverConn is a connection dialed by the pool, along with the
+// ServerAddress (or WebSocketURL) entry it was dialed to.
+type serverConn struct {
+	*throttledConn
+	address   string
+	pool      *connPool
+	closeOnce sync.Once
+}
+
+// Close closes the connection, counting it out of the open connections of
+// the pool the first time.
+func (c *serverConn) Close() error {
+	c.closeOnce.Do(func() { atomic.AddInt64(&c.pool.open, -1) })
+	return c.throttledConn.Close()
+}
+
+func newConnPool(config ClientConfig, tlsConfig *tls.Config, capture *captureFile) *connPool {
+	return &connPool{
+		config:    config,
+		bandwidth: newRateLimiter(float64(config.BandwidthLimit)),
+		tls:       tlsConfig,
+		capture:   capture,
+		addresses: splitAddresses(serverAddress(config)),
+		resolved:  make(map[string]string),
+	}
+}
+
+// serverAddress returns the addresses the server is reached at:
+// WebSocketURL if set, ServerAddress otherwise.
+func serverAddress(config ClientConfig) string {
+	if config.WebSocketURL != "" {
+		return config.WebSocketURL
+	}
+	return config.ServerAddress
+}
+
+// splitAddresses returns the entries of a comma-separated address list.
+func splitAddresses(list string) []string {
+	var addresses []string
+	for _, address := range strings.Split(list, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	if len(addresses) == 0 {
+		return []string{list}
+	}
+	return addresses
+}
+
+// endpoint returns the server address conn was dialed to.
+func endpoint(conn net.Conn) string {
+	if server, ok := conn.(*serverConn); ok {
+		return server.address
+	}
+	return conn.RemoteAddr().String()
+}
+
+// address returns the ServerAddress entry new connections are dialed to.
+func (p *connPool) address() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.addresses[p.current]
+}
+
+// failover moves on to the next ServerAddress entry after cause revealed
+// that from, the one in use, failed. It does nothing if another connection
+// already moved on, or if there is a single address.
+func (p *connPool) failover(from string, cause error) {
+	if len(p.addresses) < 2 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.addresses[p.current] != from {
+		return
+	}
+	p.current = (p.current + 1) % len(p.addresses)
+	log.Warningf("action: failover | result: in_progress | client_id: %v | from: %s | to: %s | error: %v",
+		p.config.ID, from, p.addresses[p.current], cause)
+}
+
+// get returns an idle connection that is still open, along with its batch
+// count, or dials a new one (see dial).
+func (p *connPool) get(ctx context.Context) (net.Conn, int32, error) {
+	for {
+		p.mu.Lock()
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		idle := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+		if healthy(idle.conn) {
+			log.Debugf("action: connect | result: reuse | client_id: %v", p.config.ID)
+			return idle.conn, idle.seq, nil
+		}
+		_ = idle.conn.Close()
+	}
+	conn, err := p.dial(ctx)
+	return conn, 0, err
+}
+
+// put hands back a connection that has no batch waiting for its ack, seq
+// being the number of batches written on it.
+func (p *connPool) put(conn net.Conn, seq int32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.idle = append(p.idle, idleConn{conn: conn, seq: seq})
+}
+
+// discard closes a connection that cannot be reused.
+func (p *connPool) discard(conn net.Conn) {
+	_ = conn.Close()
+}
+
+// close closes the idle connections and the capture file.
+func (p *connPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, idle := range p.idle {
+		_ = idle.conn.Close()
+	}
+	p.idle = nil
+	if p.capture != nil {
+		p.capture.close()
+	}
+}
+
+// healthy reports whether an idle connection is still open. The server
+// sends nothing unsolicited, so anything but a read timeout (EOF, a reset
+// or stray bytes) makes the connection unusable.
+func healthy(conn net.Conn) bool {
+	if err := conn.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+		return false
+	}
+	var probe [1]byte
+	_, err := conn.Read(probe[:])
+	_ = conn.SetReadDeadline(time.Time{})
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// dialed records the IP address resolved to when conn was dialed, logging
+// when it changed since the previous dial, e.g. because the server was
+// redeployed.
+func (p *connPool) dialed(address string, conn net.Conn) {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return
+	}
+	p.mu.Lock()
+	previous, seen := p.resolved[address]
+	p.resolved[address] = host
+	p.mu.Unlock()
+	if seen && previous != host {
+		log.Infof("action: resolve | result: changed | client_id: %v | address: %s | from: %s | to: %s",
+			p.config.ID, address, previous, host)
+	}
+}
+
+// dial connects to ServerAddress, over TLS if enabled (or through
+// WebSocketURL, see dialWebSocket), each attempt bounded by ConnectTimeout
+// and aborted as soon as ctx is cancelled. An attempt tries every address
+// once, starting from the current one and failing over to the next. Failed
+// attempts are retried up to ConnectRetries times with exponential backoff
+// and jitter, logging each attempt, which lets the client start before the
+// server does. Once the retries are exhausted or ctx is cancelled it logs a
+// critical message and returns the last dial error.
+func (p *connPool) dial(ctx context.Context) (net.Conn, error) {
+	backoff := p.config.ConnectBackoff
+	if backoff <= 0 {
+		backoff = defaultConnectBackoff
+	}
+	dialer := net.Dialer{Timeout: p.config.ConnectTimeout, KeepAlive: p.config.TCP.KeepAlive}
+	dialTCP := func(ctx context.Context, address string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err == nil {
+			if err := p.config.TCP.apply(conn); err != nil {
+				log.Warningf("action: tcp_options | result: fail | client_id: %v | error: %v", p.config.ID, err)
+			}
+		}
+		return conn, err
+	}
+	for attempt := 1; ; attempt++ {
+		var err error
+		for range p.addresses {
+			address := p.address()
+			var conn net.Conn
+			if p.config.WebSocketURL != "" {
+				conn, err = dialWebSocket(ctx, address, dialTCP)
+			} else if conn, err = dialTCP(ctx, address); err == nil && p.tls != nil {
+				conn, err = dialTLS(ctx, conn, address, p.tls)
+			}
+			if err == nil {
+				p.dialed(address, conn)
+				if p.capture != nil {
+					conn = p.capture.wrap(conn)
+				}
+				atomic.AddInt64(&p.open, 1)
+				return &serverConn{throttledConn: &throttledConn{Conn: conn, limiter: p.bandwidth}, address: address, pool: p}, nil
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			p.failover(address, err)
+		}
+		if attempt > p.config.ConnectRetries || ctx.Err() != nil {
+			log.Criticalf(
+				"action: connect | result: fail | client_id: %v | error: %v",
+				p.config.ID,
+				err,
+			)
+			return nil, err
+		}
+		delay := jitter(backoff)
+		log.Warningf("action: connect | result: retry | client_id: %v | attempt: %d | backoff: %v | error: %v",
+			p.config.ID, attempt, delay, err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+		if backoff *= 2; backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
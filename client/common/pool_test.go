@@ -0,0 +1,80 @@
+package common
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// unusedAddress returns a loopback address nothing listens on.
+func unusedAddress(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func TestDialRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		retries    int
+		startAfter time.Duration // 0 = the server never starts
+		wantErr    error
+	}{
+		{"server starts while retrying", 10, 150 * time.Millisecond, nil},
+		{"no retries", 0, 0, ErrConnection},
+		{"retries exhausted", 3, 0, ErrConnection},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address := unusedAddress(t)
+			started := make(chan *fakeServer, 1)
+			if tt.startAfter > 0 {
+				timer := time.AfterFunc(tt.startAfter, func() {
+					listener, err := net.Listen("tcp", address)
+					if err != nil {
+						t.Errorf("listen: %v", err)
+						close(started)
+						return
+					}
+					started <- serveFake(t, listener, nil)
+				})
+				defer timer.Stop()
+			}
+			config := testConfig(address, writeTestBets(t, 25))
+			config.ConnectRetries = tt.retries
+			config.ConnectBackoff = 20 * time.Millisecond
+			begin := time.Now()
+			summary, err := runTestClient(t, config)
+			checkErrorKind(t, err, tt.wantErr)
+			if tt.wantErr != nil {
+				// Each retry waits at least half of its backoff.
+				minimum := time.Duration(0)
+				for retry, backoff := 0, config.ConnectBackoff; retry < tt.retries; retry, backoff = retry+1, 2*backoff {
+					minimum += backoff / 2
+				}
+				if elapsed := time.Since(begin); elapsed < minimum {
+					t.Fatalf("gave up after %v; want at least %v of backoff", elapsed, minimum)
+				}
+				return
+			}
+			server := <-started
+			if summary.BetsSent != 25 || len(server.received()) != 25 {
+				t.Fatalf("BetsSent = %d, server received %d; want 25", summary.BetsSent, len(server.received()))
+			}
+		})
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for _, backoff := range []time.Duration{time.Millisecond, 200 * time.Millisecond, maxConnectBackoff} {
+		for i := 0; i < 100; i++ {
+			if delay := jitter(backoff); delay < backoff/2 || delay > backoff {
+				t.Fatalf("jitter(%v) = %v; want between %v and %v", backoff, delay, backoff/2, backoff)
+			}
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package common
+
+import (
+	"net/http/pprof"
+)
+
+// NewPprofListener builds a listener serving the runtime profiles of the
+// process under /debug/pprof/ (CPU, heap, goroutines...), to be read with
+// go tool pprof during large uploads. It is kept apart from the listener of
+// NewHTTPListener, so profiling is opt-in and can be bound to a loopback
+// address only.
+func NewPprofListener(address string) *HTTPListener {
+	listener := NewHTTPListener(address)
+	listener.HandleFunc("/debug/pprof/", pprof.Index)
+	listener.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	listener.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	listener.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	listener.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return listener
+}
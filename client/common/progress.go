@@ -0,0 +1,149 @@
+package common
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Progress is a snapshot of a run in progress, see ProgressPeriod.
+// - Records: input records read so far.
+// - BatchesSent, Acks: batches written to the server and acknowledged so far.
+// - Bytes: bytes of the bets file read so far (compressed ones for gzip input).
+// - Total: size of the bets file, or the number of bets to generate (-1 = unknown).
+// - Rate: records read per second since the run started.
+// - ETA: estimated time until the input is exhausted (0 = exhausted or unknown Total).
+type Progress struct {
+	Records     int64
+	BatchesSent int64
+	Acks        int64
+	Bytes       int64
+	Total       int64
+	Rate        float64
+	ETA         time.Duration
+}
+
+// progressTracker counts the input read by a run for its progress reports.
+// The counters are updated by the writer goroutine and read atomically by
+// the reporter.
+type progressTracker struct {
+	bytes   int64
+	records int64
+	total   int64 // see Progress.Total
+	started time.Time
+}
+
+// countingReader counts the bytes read through it into a progressTracker.
+type countingReader struct {
+	io.Reader
+	tracker *progressTracker
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	atomic.AddInt64(&r.tracker.bytes, int64(n))
+	return n, err
+}
+
+// count wraps betsFile so the bytes read from it are counted. A nil tracker
+// counts nothing.
+func (t *progressTracker) count(betsFile io.Reader) io.Reader {
+	if t == nil {
+		return betsFile
+	}
+	return &countingReader{Reader: betsFile, tracker: t}
+}
+
+// recordRead counts a record read from the input.
+func (t *progressTracker) recordRead() {
+	if t != nil {
+		atomic.AddInt64(&t.records, 1)
+	}
+}
+
+// reportProgress starts a progress tracker for the run, reporting it every
+// ProgressPeriod (to the log, Hooks.OnProgress and the Run of Start) until
+// the returned function is called. total is the size
+// of the input (see Progress.Total). It returns a nil tracker if progress
+// reports are disabled.
+func (c *Client) reportProgress(ctx context.Context, total int64) (*progressTracker, func()) {
+	if c.config.ProgressPeriod <= 0 {
+		return nil, func() {}
+	}
+	tracker := &progressTracker{total: total, started: time.Now()}
+	events := c.events
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(c.config.ProgressPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			progress := tracker.snapshot(c.Summary())
+			eta := "unknown"
+			if progress.Total > 0 {
+				eta = progress.ETA.Round(100 * time.Millisecond).String()
+			}
+			log.Infof("action: progress | result: in_progress | client_id: %v | records: %d | batches_sent: %d | acks: %d | bytes: %d | total: %d | rate: %.1f | eta: %s",
+				c.config.ID, progress.Records, progress.BatchesSent, progress.Acks, progress.Bytes, progress.Total, progress.Rate, eta)
+			c.config.Hooks.progress(progress)
+			select {
+			case events <- progress:
+			default:
+				// The consumer is late: it gets a newer report next time.
+			}
+		}
+	}()
+	return tracker, func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// inputSize returns the Progress.Total of the run.
+func (c *Client) inputSize() int64 {
+	if c.config.Generate > 0 {
+		return c.config.Generate
+	}
+	if c.config.InputFormat == InputSQLite || isRemoteBets(c.config.BetsFilePath) {
+		return -1
+	}
+	info, err := os.Stat(c.config.BetsFilePath)
+	if err != nil {
+		return -1
+	}
+	return info.Size()
+}
+
+// snapshot returns the progress of the run whose current summary is summary.
+func (t *progressTracker) snapshot(summary RunSummary) Progress {
+	progress := Progress{
+		Records:     atomic.LoadInt64(&t.records),
+		BatchesSent: summary.BatchesSent,
+		Acks:        summary.AcksSuccess + summary.AcksFail,
+		Bytes:       atomic.LoadInt64(&t.bytes),
+		Total:       t.total,
+	}
+	elapsed := time.Since(t.started)
+	if elapsed > 0 {
+		progress.Rate = float64(progress.Records) / elapsed.Seconds()
+	}
+	// Generated bets have no file: their progress is measured in records.
+	done := progress.Bytes
+	if progress.Bytes == 0 {
+		done = progress.Records
+	}
+	if progress.Total > 0 && done > 0 && done < progress.Total {
+		progress.ETA = time.Duration(float64(elapsed) * float64(progress.Total-done) / float64(done))
+	}
+	return progress
+}
@@ -0,0 +1,133 @@
+package common
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket refilled at rate tokens per second that
+// holds at most one second worth of tokens, so short bursts are allowed but
+// the average never exceeds the rate. A rate that is not positive disables
+// limiting. It is safe for concurrent use.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter for rate tokens per second.
+func newRateLimiter(rate float64) *rateLimiter {
+	l := &rateLimiter{}
+	l.setRate(rate)
+	return l
+}
+
+// setRate changes the rate of the limiter, which starts over with a full
+// bucket if it was disabled.
+func (l *rateLimiter) setRate(rate float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		l.tokens = rate
+		l.last = time.Now()
+	}
+	l.rate = rate
+	l.burst = rate
+	if l.burst < 1 {
+		l.burst = 1
+	}
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// limit returns the rate and the bucket size, the rate being 0 if limiting
+// is disabled.
+func (l *rateLimiter) limit() (rate float64, burst float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return 0, 0
+	}
+	return l.rate, l.burst
+}
+
+// wait takes n tokens, blocking until the bucket holds them or ctx is
+// cancelled. It returns how long it blocked. Requests larger than the bucket
+// are allowed and leave it in debt, which later calls pay off.
+func (l *rateLimiter) wait(ctx context.Context, n float64) (time.Duration, error) {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return 0, nil
+	}
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= n
+	deficit := -l.tokens
+	delay := time.Duration(deficit / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if deficit <= 0 {
+		return 0, nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		// Give back the tokens that were not used.
+		l.mu.Lock()
+		l.tokens += n
+		l.mu.Unlock()
+		return time.Since(now), ctx.Err()
+	case <-timer.C:
+		return time.Since(now), nil
+	}
+}
+
+// throttledConn caps the bytes written to the wrapped connection per
+// second, as long as its limiter has a rate. Writes are split so that no chunk exceeds the bucket, keeping
+// the outgoing traffic smooth rather than bursting a whole frame.
+type throttledConn struct {
+	net.Conn
+	limiter *rateLimiter
+}
+
+func (t *throttledConn) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		chunk := len(p) - written
+		rate, burst := t.limiter.limit()
+		if rate <= 0 {
+			n, err := t.Conn.Write(p[written:])
+			return written + n, err
+		}
+		if chunk > int(burst) {
+			chunk = int(burst)
+		}
+		if _, err := t.limiter.wait(context.Background(), float64(chunk)); err != nil {
+			return written, err
+		}
+		n, err := t.Conn.Write(p[written : written+chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// CloseWrite half-closes the wrapped connection if it supports it.
+func (t *throttledConn) CloseWrite() error {
+	if cw, ok := t.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package common
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// redactPII is set while the documents and names of the bettors are masked
+// in the log output, see SetRedaction.
+var redactPII int32
+
+// SetRedaction enables or disables the masking of the documents and names
+// of the bettors in the log output of the process, for deployments whose
+// logs must not hold personal data.
+func SetRedaction(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&redactPII, value)
+}
+
+// PII is a document or name of a bettor. While redaction is enabled (see
+// SetRedaction) it is formatted with its middle masked, keeping its first
+// and last two characters so log lines can still be correlated: 30***12
+// for 30123412. Values of up to four characters keep only the first one.
+type PII string
+
+func (p PII) String() string {
+	if atomic.LoadInt32(&redactPII) == 0 {
+		return string(p)
+	}
+	value := []rune(string(p))
+	if len(value) <= 4 {
+		if len(value) == 0 {
+			return ""
+		}
+		return string(value[0]) + "***"
+	}
+	return string(value[:2]) + "***" + string(value[len(value)-2:])
+}
+
+// PIIList formats values as a comma-separated list of PII.
+func PIIList(values []string) string {
+	masked := make([]string, len(values))
+	for i, value := range values {
+		masked[i] = PII(value).String()
+	}
+	return strings.Join(masked, ",")
+}
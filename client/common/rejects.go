@@ -0,0 +1,155 @@
+package common
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// rejectsSuffix is appended to BetsFilePath to name the default rejects file.
+const rejectsSuffix = ".rejects"
+
+// thresholdMinRecords is the number of records read before a percentage
+// ErrorThreshold is enforced, so a bad first row does not abort the upload.
+// At the end of the input the percentage is always enforced.
+const thresholdMinRecords = 100
+
+// ErrorThreshold bounds the records tolerant mode may skip before the
+// upload is aborted: more than Count records (0 = no limit) or more than
+// Percent percent of the records read (0 = no limit).
+type ErrorThreshold struct {
+	Count   int64
+	Percent float64
+}
+
+// ParseErrorThreshold parses an absolute ("25") or percentage ("5%")
+// threshold. An empty string means no limit.
+func ParseErrorThreshold(s string) (ErrorThreshold, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ErrorThreshold{}, nil
+	}
+	if percent := strings.TrimSuffix(s, "%"); percent != s {
+		value, err := strconv.ParseFloat(strings.TrimSpace(percent), 64)
+		if err != nil || value < 0 || value > 100 {
+			return ErrorThreshold{}, fmt.Errorf("invalid error threshold %q", s)
+		}
+		return ErrorThreshold{Percent: value}, nil
+	}
+	value, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || value < 0 {
+		return ErrorThreshold{}, fmt.Errorf("invalid error threshold %q", s)
+	}
+	return ErrorThreshold{Count: value}, nil
+}
+
+// exceeded reports whether rejected out of read records crosses the
+// threshold. final is set once the whole input was read.
+func (t ErrorThreshold) exceeded(rejected int64, read int64, final bool) bool {
+	if t.Count > 0 && rejected > t.Count {
+		return true
+	}
+	if t.Percent > 0 && read > 0 && (final || read >= thresholdMinRecords) {
+		return float64(rejected)*100 > t.Percent*float64(read)
+	}
+	return false
+}
+
+// rejectsWriter stores the source text of the records rejected in a run.
+// The file is created on the first reject, so clean runs leave no file.
+// read counts every record of the run, rejected or not.
+type rejectsWriter struct {
+	path  string
+	file  *os.File
+	count int64
+	read  int64
+}
+
+func (w *rejectsWriter) write(raw string) error {
+	w.count++
+	if raw == "" {
+		return nil
+	}
+	if w.file == nil {
+		file, err := os.Create(w.path)
+		if err != nil {
+			return err
+		}
+		w.file = file
+	}
+	_, err := fmt.Fprintln(w.file, raw)
+	return err
+}
+
+func (w *rejectsWriter) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	return w.file.Close()
+}
+
+// rejectsPath returns RejectsPath, or the BetsFilePath based default.
+func (c *Client) rejectsPath() string {
+	if c.config.RejectsPath != "" {
+		return c.config.RejectsPath
+	}
+	return c.betsFileName() + rejectsSuffix
+}
+
+// isRecordError reports whether err is about a single input record, as
+// opposed to an I/O error that makes the rest of the input unreadable.
+func isRecordError(err error) bool {
+	var csvErr *csv.ParseError
+	var recordErr *RecordError
+	var validationErr *protocol.ValidationError
+	return errors.As(err, &csvErr) || errors.As(err, &recordErr) || errors.As(err, &validationErr)
+}
+
+// closeRejects closes the rejects file of the run and reports how many
+// records were skipped.
+func (c *Client) closeRejects() {
+	if err := c.rejects.Close(); err != nil {
+		log.Errorf("action: write_rejects | result: fail | file: %s | error: %v", c.rejects.path, err)
+	}
+	if c.rejects.count > 0 && c.config.Tolerant {
+		log.Warningf("action: reject_bets | result: success | skipped: %d | rejects: %s", c.rejects.count, c.rejects.path)
+	}
+}
+
+// rejectRecord handles a record that could not be turned into a bet. In
+// tolerant mode record errors are logged, the record is written to the
+// rejects file and nil is returned so the upload continues, unless
+// MaxErrors is exceeded; otherwise, or for any other error, err is returned.
+// Dry runs also go on past record errors, only counting them.
+func (c *Client) rejectRecord(betsReader RecordReader, err error) error {
+	if !(c.config.Tolerant || c.config.DryRun) || !isRecordError(err) {
+		return err
+	}
+	log.Warningf("action: reject_bet | result: success | error: %v", err)
+	c.run.betRejected(err)
+	if !c.config.Tolerant {
+		// Dry runs list every invalid record but keep no rejects file.
+		c.rejects.count++
+		return nil
+	}
+	if err := c.rejects.write(betsReader.Raw()); err != nil {
+		return err
+	}
+	return c.checkErrorThreshold(false)
+}
+
+// checkErrorThreshold fails with ErrInput once the rejected records exceed
+// MaxErrors. final is set once the whole input was read.
+func (c *Client) checkErrorThreshold(final bool) error {
+	if !c.config.Tolerant || !c.config.MaxErrors.exceeded(c.rejects.count, c.rejects.read, final) {
+		return nil
+	}
+	log.Errorf("action: read_bets | result: fail | rejected: %d | read: %d", c.rejects.count, c.rejects.read)
+	return newError(ErrInput, "read_bets",
+		fmt.Errorf("%d of %d records rejected, above the error threshold", c.rejects.count, c.rejects.read))
+}
@@ -0,0 +1,110 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// writeTestCSV writes n bets to a CSV file of the test, every tenth one with
+// an invalid document, and returns its path.
+func writeTestCSV(t *testing.T, n int) string {
+	t.Helper()
+	var csv strings.Builder
+	for i := 1; i <= n; i++ {
+		document := fmt.Sprint(30000000 + i)
+		if i%10 == 0 {
+			document = "invalid"
+		}
+		fmt.Fprintf(&csv, "Ana,Gomez,%s,1990-05-17,%d\n", document, i)
+	}
+	return writeTestFile(t, "bets.csv", []byte(csv.String()))
+}
+
+func TestParseErrorThreshold(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    ErrorThreshold
+		wantErr bool
+	}{
+		{"", ErrorThreshold{}, false},
+		{"25", ErrorThreshold{Count: 25}, false},
+		{"5%", ErrorThreshold{Percent: 5}, false},
+		{" 2.5 % ", ErrorThreshold{Percent: 2.5}, false},
+		{"-1", ErrorThreshold{}, true},
+		{"101%", ErrorThreshold{}, true},
+		{"ten", ErrorThreshold{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseErrorThreshold(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseErrorThreshold(%q) = %+v, %v; want %+v, error %t", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestErrorThresholdExceeded(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold ErrorThreshold
+		rejected  int64
+		read      int64
+		final     bool
+		want      bool
+	}{
+		{"no limit", ErrorThreshold{}, 50, 50, true, false},
+		{"count reached", ErrorThreshold{Count: 3}, 3, 10, false, false},
+		{"count exceeded", ErrorThreshold{Count: 3}, 4, 10, false, true},
+		{"percent before enough records", ErrorThreshold{Percent: 5}, 2, 10, false, false},
+		{"percent at the end of the input", ErrorThreshold{Percent: 5}, 2, 10, true, true},
+		{"percent after enough records", ErrorThreshold{Percent: 5}, 6, thresholdMinRecords, false, true},
+		{"percent reached", ErrorThreshold{Percent: 5}, 5, thresholdMinRecords, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.threshold.exceeded(tt.rejected, tt.read, tt.final); got != tt.want {
+				t.Fatalf("exceeded(%d, %d, %t) = %t; want %t", tt.rejected, tt.read, tt.final, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTolerantUpload(t *testing.T) {
+	tests := []struct {
+		name      string
+		tolerant  bool
+		maxErrors ErrorThreshold
+		wantErr   error
+	}{
+		{"strict", false, ErrorThreshold{}, ErrInput},
+		{"tolerant", true, ErrorThreshold{}, nil},
+		{"count reached", true, ErrorThreshold{Count: 20}, nil},
+		{"count exceeded", true, ErrorThreshold{Count: 19}, ErrInput},
+		{"percent exceeded", true, ErrorThreshold{Percent: 5}, ErrInput},
+		{"percent not exceeded", true, ErrorThreshold{Percent: 15}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, nil)
+			config := testConfig(server.addr(), writeTestCSV(t, 200))
+			config.Tolerant = tt.tolerant
+			config.MaxErrors = tt.maxErrors
+			summary, err := runTestClient(t, config)
+			checkErrorKind(t, err, tt.wantErr)
+			if tt.wantErr != nil {
+				return
+			}
+			if summary.BetsSent != 180 || len(server.received()) != 180 {
+				t.Fatalf("BetsSent = %d, server received %d; want 180", summary.BetsSent, len(server.received()))
+			}
+			rejects, err := os.ReadFile(config.BetsFilePath + rejectsSuffix)
+			if err != nil {
+				t.Fatalf("rejects file: %v", err)
+			}
+			if lines := strings.Count(string(rejects), ",invalid,"); lines != 20 {
+				t.Fatalf("rejects file has %d invalid bets; want 20:\n%s", lines, rejects)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package common
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// ReloadableConfig holds the ClientConfig settings that Reload can change
+// while the client is running.
+type ReloadableConfig struct {
+	BatchLimit     int32
+	RateLimit      float64
+	BandwidthLimit int64
+}
+
+func (r ReloadableConfig) validate() error {
+	if r.BatchLimit <= 0 {
+		return fmt.Errorf("batch limit must be positive, got %d", r.BatchLimit)
+	}
+	return nil
+}
+
+// Reload replaces the reloadable settings of the client, logging the old
+// and new values, without touching its connections. The bandwidth cap
+// applies at once; the rate and batch limits from the next record read, or
+// from the next run if none is in progress. The workers of a parallel run
+// keep their batch limit until the run ends.
+func (c *Client) Reload(update ReloadableConfig) error {
+	if err := update.validate(); err != nil {
+		log.Errorf("action: reload | result: fail | client_id: %v | error: %v", c.config.ID, err)
+		return err
+	}
+	c.reloadMu.Lock()
+	old := c.live
+	c.live = update
+	atomic.StoreInt32(&c.reloading, 1)
+	c.reloadMu.Unlock()
+
+	c.pool.bandwidth.setRate(float64(update.BandwidthLimit))
+	log.Infof("action: reload | result: success | client_id: %v | batch_limit: %d -> %d | rate_limit: %v -> %v | bandwidth_limit: %d -> %d",
+		c.config.ID, old.BatchLimit, update.BatchLimit, old.RateLimit, update.RateLimit, old.BandwidthLimit, update.BandwidthLimit)
+	return nil
+}
+
+// applyReload copies into the config the settings changed by Reload since
+// the last call, and the new rate into limiter (if any). It must be called
+// from the goroutine reading the records.
+func (c *Client) applyReload(limiter *rateLimiter) {
+	if atomic.LoadInt32(&c.reloading) == 0 {
+		return
+	}
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
+	atomic.StoreInt32(&c.reloading, 0)
+	c.config.BatchLimit = c.live.BatchLimit
+	c.config.RateLimit = c.live.RateLimit
+	c.config.BandwidthLimit = c.live.BandwidthLimit
+	if limiter != nil {
+		limiter.setRate(c.live.RateLimit)
+	}
+}
@@ -0,0 +1,82 @@
+package common
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	tests := []struct {
+		name          string
+		update        ReloadableConfig
+		wantErr       bool
+		wantBatches   int64
+		wantThrottled bool
+	}{
+		{"batch limit", ReloadableConfig{BatchLimit: 5}, false, 5, false},
+		{"rate limit", ReloadableConfig{BatchLimit: 10, RateLimit: 20}, false, 3, true},
+		{"bandwidth limit", ReloadableConfig{BatchLimit: 10, BandwidthLimit: 1 << 20}, false, 3, false},
+		{"zero batch limit", ReloadableConfig{BatchLimit: 0, RateLimit: 20}, true, 3, false},
+		{"negative batch limit", ReloadableConfig{BatchLimit: -1}, true, 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, nil)
+			client, err := NewClient(testConfig(server.addr(), writeTestBets(t, 25)))
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+			defer client.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if summary, err := client.SendBets(ctx); err != nil || summary.BatchesSent != 3 {
+				t.Fatalf("first run: BatchesSent = %d, error = %v; want 3 and none", summary.BatchesSent, err)
+			}
+
+			if err := client.Reload(tt.update); (err != nil) != tt.wantErr {
+				t.Fatalf("Reload(%+v) = %v; want error %t", tt.update, err, tt.wantErr)
+			}
+			summary, err := client.SendBets(ctx)
+			if err != nil {
+				t.Fatalf("second run: %v", err)
+			}
+			if summary.BatchesSent != tt.wantBatches {
+				t.Fatalf("BatchesSent = %d; want %d", summary.BatchesSent, tt.wantBatches)
+			}
+			if throttled := summary.Throttled > 0; throttled != tt.wantThrottled {
+				t.Fatalf("Throttled = %v; want throttling %t", summary.Throttled, tt.wantThrottled)
+			}
+			if rate, _ := client.pool.bandwidth.limit(); !tt.wantErr && rate != float64(tt.update.BandwidthLimit) {
+				t.Fatalf("bandwidth rate = %v; want %d", rate, tt.update.BandwidthLimit)
+			}
+		})
+	}
+}
+
+func TestRateLimiterSetRate(t *testing.T) {
+	tests := []struct {
+		name      string
+		from, to  float64
+		wantRate  float64
+		wantBurst float64
+	}{
+		{"enabled", 0, 50, 50, 50},
+		{"disabled", 50, 0, 0, 0},
+		{"raised", 10, 50, 50, 50},
+		{"lowered", 50, 10, 10, 10},
+		{"below one per second", 0, 0.5, 0.5, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newRateLimiter(tt.from)
+			limiter.setRate(tt.to)
+			if rate, burst := limiter.limit(); rate != tt.wantRate || burst != tt.wantBurst {
+				t.Fatalf("limit() = %v, %v; want %v, %v", rate, burst, tt.wantRate, tt.wantBurst)
+			}
+			if limiter.tokens > limiter.burst {
+				t.Fatalf("bucket holds %v tokens, more than its size %v", limiter.tokens, limiter.burst)
+			}
+		})
+	}
+}
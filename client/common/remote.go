@@ -0,0 +1,153 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// isRemoteBets reports whether name is an http(s) URL rather than a path.
+func isRemoteBets(name string) bool {
+	return strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://")
+}
+
+// betsFileName returns the file name part of BetsFilePath, without the
+// query string of URLs (e.g. the signature of presigned URLs).
+func (c *Client) betsFileName() string {
+	if !isRemoteBets(c.config.BetsFilePath) {
+		return c.config.BetsFilePath
+	}
+	u, err := url.Parse(c.config.BetsFilePath)
+	if err != nil {
+		return c.config.BetsFilePath
+	}
+	return path.Base(u.Path)
+}
+
+// transientFetchError marks fetch failures worth retrying: network errors,
+// 5xx and 429 responses.
+type transientFetchError struct{ err error }
+
+func (e *transientFetchError) Error() string { return e.err.Error() }
+
+func (e *transientFetchError) Unwrap() error { return e.err }
+
+// remoteBody streams the bets file at a URL. If the transfer breaks midway
+// and the server accepts ranges, it is resumed from the last byte received
+// instead of failing the run.
+type remoteBody struct {
+	ctx         context.Context
+	client      *http.Client
+	url         string
+	retryPeriod time.Duration
+	body        io.ReadCloser
+	offset      int64
+	ranges      bool
+	err         error // sticky error of a failed resume
+}
+
+// openRemoteBets fetches BetsFilePath, retrying transient errors with
+// exponential backoff until OpenRetryPeriod elapses.
+func (c *Client) openRemoteBets(ctx context.Context) (*remoteBody, error) {
+	remote := &remoteBody{
+		ctx:         ctx,
+		client:      http.DefaultClient,
+		url:         c.config.BetsFilePath,
+		retryPeriod: c.config.OpenRetryPeriod,
+	}
+	if err := remote.fetch(); err != nil {
+		return nil, err
+	}
+	return remote, nil
+}
+
+// fetch (re)issues the GET request from the current offset.
+func (r *remoteBody) fetch() error {
+	deadline := time.Now().Add(r.retryPeriod)
+	backoff := 100 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := r.get()
+		var transient *transientFetchError
+		if err == nil || !errors.As(err, &transient) || time.Now().Add(backoff).After(deadline) {
+			return err
+		}
+		log.Warningf("action: fetch_bets | result: retry | attempt: %d | offset: %d | backoff: %v | error: %v",
+			attempt, r.offset, backoff, err)
+		select {
+		case <-r.ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > 2*time.Second {
+			backoff = 2 * time.Second
+		}
+	}
+}
+
+func (r *remoteBody) get() error {
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return err
+	}
+	if r.offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", r.offset))
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		if r.ctx.Err() != nil {
+			return r.ctx.Err()
+		}
+		return &transientFetchError{err}
+	}
+	switch {
+	case r.offset > 0 && resp.StatusCode == http.StatusPartialContent,
+		r.offset == 0 && resp.StatusCode == http.StatusOK:
+	default:
+		resp.Body.Close()
+		err := fmt.Errorf("fetch %s: unexpected status %s", r.betsURL(), resp.Status)
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return &transientFetchError{err}
+		}
+		return err
+	}
+	r.body = resp.Body
+	r.ranges = resp.Header.Get("Accept-Ranges") == "bytes" || resp.StatusCode == http.StatusPartialContent
+	return nil
+}
+
+// betsURL returns the URL without its query string, which may hold
+// credentials.
+func (r *remoteBody) betsURL() string {
+	if i := strings.IndexByte(r.url, '?'); i >= 0 {
+		return r.url[:i]
+	}
+	return r.url
+}
+
+func (r *remoteBody) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == nil || errors.Is(err, io.EOF) || !r.ranges || r.ctx.Err() != nil {
+		return n, err
+	}
+	log.Warningf("action: fetch_bets | result: resume | offset: %d | error: %v", r.offset, err)
+	r.body.Close()
+	if err := r.fetch(); err != nil {
+		r.err = err
+		return n, err
+	}
+	return n, nil
+}
+
+func (r *remoteBody) Close() error {
+	return r.body.Close()
+}
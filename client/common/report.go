@@ -0,0 +1,42 @@
+package common
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// runReport is the end-of-run summary written to ResultsPath.
+type runReport struct {
+	RunSummary
+	Duration float64 `json:"duration_seconds"`
+	Error    string  `json:"error"`
+}
+
+// reportRun logs the outcome of a finished run, summary being its
+// RunSummary and err its error, in a single line and, if ResultsPath is
+// set, writes it there as JSON.
+func (c *Client) reportRun(summary RunSummary, err error) {
+	report := runReport{RunSummary: summary, Duration: summary.FinishedAt.Sub(summary.StartedAt).Seconds()}
+	result := "success"
+	if err != nil {
+		result = "fail"
+		report.Error = err.Error()
+	}
+	log.Infof("action: resumen_run | result: %s | client_id: %v | trace_id: %s | duration: %v | bets_sent: %d | batches_sent: %d | retries: %d | acks_fail: %d | bets_rejected: %d | cant_ganadores: %d",
+		result, c.config.ID, summary.TraceID, summary.FinishedAt.Sub(summary.StartedAt).Round(time.Millisecond),
+		summary.BetsSent, summary.BatchesSent, summary.Retries, summary.AcksFail, summary.BetsRejected, len(summary.Winners))
+	if c.config.ResultsPath == "" {
+		return
+	}
+	data, writeErr := json.MarshalIndent(report, "", "  ")
+	if writeErr == nil {
+		tmpPath := c.config.ResultsPath + ".tmp"
+		if writeErr = os.WriteFile(tmpPath, append(data, '\n'), 0o644); writeErr == nil {
+			writeErr = os.Rename(tmpPath, c.config.ResultsPath)
+		}
+	}
+	if writeErr != nil {
+		log.Errorf("action: write_results | result: fail | path: %s | error: %v", c.config.ResultsPath, writeErr)
+	}
+}
@@ -0,0 +1,286 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// tracksUnacked reports whether the frames of sent batches are kept until
+// acknowledged, to be resent on a replacement connection or after a
+// failure ack.
+func (c *Client) tracksUnacked() bool {
+	return c.config.MaxReconnects > 0 || c.config.Resync == ResyncReconnect || c.config.MaxRetransmits > 0
+}
+
+// builtBatch is a batch serialized by writeBatchLocked, waiting to be sent
+// by sendBatch.
+type builtBatch struct {
+	sentBatch
+	frames  *bytes.Buffer // from protocol.GetBuffer, put back once sent
+	records [][]string    // see keepsRecords
+}
+
+// writeBatchLocked serializes with write the frames of at most one batch,
+// described by batch, along with the records gathered for it, and sends
+// them with sendBatch: it is the flush point of every batch. In a pipelined
+// run the batch is handed to the sender stage instead (see batchSender).
+func (c *Client) writeBatchLocked(batch sentBatch, write func(out io.Writer) error) error {
+	frames := protocol.GetBuffer()
+	if err := write(frames); err != nil || frames.Len() == 0 {
+		protocol.PutBuffer(frames)
+		return err
+	}
+	if !c.batchStarted.IsZero() {
+		c.tracer.record("build_batch", otlpKindInternal, c.batchStarted, nil, attr("bets", batch.bets))
+		c.batchStarted = time.Time{}
+	}
+	built := builtBatch{sentBatch: batch, frames: frames, records: c.batchRecords}
+	c.batchRecords = nil
+	if c.sender != nil {
+		return c.sender.send(built)
+	}
+	return c.sendBatch(built)
+}
+
+// sendBatch sends the frames of batch on the current connection while
+// holding connMu, in a single write. The batch is queued until
+// acknowledged, along with its frames (see tracksUnacked) and records (see
+// keepsRecords), unless the server sends no acks (see AckSummary). Sent
+// batches are logged at debug level with their ID (see runStats.nextBatch)
+// and sequence number.
+func (c *Client) sendBatch(batch builtBatch) (err error) {
+	frames := batch.frames
+	defer protocol.PutBuffer(frames)
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.config.DryRun {
+		return c.sendLocked(frames.Bytes(), false)
+	}
+	if c.offline {
+		return c.journalLocked(batch.sentBatch, frames.Bytes())
+	}
+	id := c.run.stats.nextBatch()
+	flushed := time.Now()
+	defer func() {
+		c.tracer.record("flush", otlpKindClient, flushed, err,
+			attr("batch", id), attr("seq", c.connSeq), attr("bets", batch.bets), attr("bytes", frames.Len()))
+		if err == nil {
+			log.Debugf("action: flush_batch | result: success | client_id: %v | batch: %d | seq: %d | bets: %d | line: %d",
+				c.config.ID, id, c.connSeq, batch.bets, batch.line)
+		}
+	}()
+	if c.config.AckMode == AckSummary {
+		c.connSeq++
+		return c.sendLocked(frames.Bytes(), false)
+	}
+	entry := &unackedBatch{sentBatch: batch.sentBatch, id: id, records: batch.records}
+	track := c.tracksUnacked()
+	if track {
+		entry.frames = append([]byte(nil), frames.Bytes()...)
+	}
+	if err := c.waitWindowLocked(entry.size()); err != nil {
+		return err
+	}
+	c.connSeq++
+	entry.seq = c.connSeq
+	entry.sentAt = time.Now()
+	c.budget.hold(entry.size())
+	c.unacked = append(c.unacked, entry)
+	return c.sendLocked(frames.Bytes(), track)
+}
+
+// sendLocked writes frames to the current connection. If the write fails
+// the connection is replaced (see resumeLocked) and frames are written again,
+// unless tracked, since then they were resent along with the rest of the
+// unacknowledged batches. Callers must hold connMu.
+func (c *Client) sendLocked(frames []byte, tracked bool) error {
+	err := c.writeLocked(frames)
+	if err == nil {
+		return nil
+	}
+	if err := c.resumeLocked(err); err != nil {
+		return err
+	}
+	if tracked {
+		return nil
+	}
+	return c.writeLocked(frames)
+}
+
+// ackReceivedLocked dequeues the oldest unacknowledged batch, acks arriving
+// in batch order on each connection; seq is the correlation ID of the ack,
+// or 0 for legacy acks. It returns the ID of the batch (0 if none was
+// waiting). A failed batch is retransmitted if attempts remain, in which
+// case retransmitted is true; otherwise the ack is recorded in the
+// checkpoint (or the journal, for replayed batches) and failed batches go to
+// the dead-letter file. Callers must hold connMu.
+func (c *Client) ackReceivedLocked(success bool, seq int32) (batch int64, retransmitted bool) {
+	if len(c.unacked) == 0 {
+		log.Warningf("action: bets_enviadas | result: unexpected | seq: %d", seq)
+		return 0, false
+	}
+	entry := c.unacked[0]
+	if seq != 0 && seq != entry.seq {
+		log.Warningf("action: bets_enviadas | result: mismatch | batch: %d | expected: %d | seq: %d", entry.id, entry.seq, seq)
+	}
+	c.run.stats.acked(time.Since(entry.sentAt))
+	var rejected error
+	if !success {
+		rejected = errors.New("batch rejected")
+	}
+	c.tracer.record("ack_wait", otlpKindClient, entry.sentAt, rejected,
+		attr("batch", entry.id), attr("seq", entry.seq), attr("success", success))
+	c.unacked[0] = nil
+	c.unacked = c.unacked[1:]
+	select {
+	case c.ackSignal <- struct{}{}:
+	default:
+	}
+	if !success && c.retransmitLocked(entry) {
+		return entry.id, true
+	}
+	c.budget.release(entry.size())
+	if entry.journaled {
+		// The checkpoint advanced when the batch was journaled.
+		c.journal.acked()
+	} else {
+		c.checkpoint.acked(entry.sentBatch, success)
+	}
+	if !success {
+		c.deadLetterLocked(entry)
+	}
+	return entry.id, false
+}
+
+// waitWindowLocked blocks while Window batches are waiting for their ack,
+// or while the ones waiting leave no room in MemoryBudget for a batch
+// holding size bytes, releasing connMu meanwhile. This holds back the
+// batching of more bets, and so the reading of the input. A server that
+// stalls is detected by the reader (see AckTimeout), which either replaces
+// the connection or stops. Callers must hold connMu.
+func (c *Client) waitWindowLocked(size int64) error {
+	for (c.config.Window > 0 && len(c.unacked) >= c.config.Window) ||
+		(len(c.unacked) > 0 && !c.budget.fits(size)) {
+		c.connMu.Unlock()
+		select {
+		case <-c.ackSignal:
+			c.connMu.Lock()
+		case <-c.readDone:
+			c.connMu.Lock()
+			if c.readErr != nil {
+				return c.readErr
+			}
+			return errors.New("reader stopped before every batch was acknowledged")
+		}
+	}
+	return nil
+}
+
+// waitAcked blocks until every batch sent was acknowledged, the reader
+// stopped or giveUp is closed (nil = never).
+func (c *Client) waitAcked(readDone <-chan struct{}, giveUp <-chan struct{}) {
+	for {
+		c.connMu.Lock()
+		pending := len(c.unacked)
+		c.connMu.Unlock()
+		if pending == 0 {
+			return
+		}
+		select {
+		case <-readDone:
+			return
+		case <-giveUp:
+			return
+		case <-c.ackSignal:
+		}
+	}
+}
+
+// resume replaces the connection of generation gen after cause revealed
+// that it dropped. It does nothing if the connection was already replaced.
+func (c *Client) resume(gen uint64, cause error) error {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.connGen != gen {
+		return nil
+	}
+	return c.resumeLocked(cause)
+}
+
+// resumeLocked replaces a dropped connection, cause being the error that
+// revealed the drop, and resends the unacknowledged batches on the new one,
+// so the upload continues from the first batch the server did not
+// acknowledge. The new connection goes to the next ServerAddress entry, if
+// there are several. Batches whose ack was lost are sent twice. At most
+// MaxReconnects connections are replaced per run. Callers must hold connMu.
+func (c *Client) resumeLocked(cause error) error {
+	for {
+		if c.reconnects >= c.config.MaxReconnects || c.config.AckMode == AckSummary {
+			// Without acks there is no telling which batches the server got.
+			return cause
+		}
+		c.reconnects++
+		c.run.retried("reconnect")
+		c.pool.failover(endpoint(c.conn), cause)
+		log.Warningf("action: reconnect | result: in_progress | client_id: %v | attempt: %d/%d | unacked: %d | error: %v",
+			c.config.ID, c.reconnects, c.config.MaxReconnects, len(c.unacked), cause)
+		if err := c.redialLocked(); err != nil {
+			cause = err
+			continue
+		}
+		return nil
+	}
+}
+
+// redialLocked closes the current connection, dials a new one and resends
+// the unacknowledged batches on it, followed by FINISHED if it was already
+// sent: the server answers a repeated FINISHED with the winners as well.
+// The idle connections of the pool are skipped, as they may lead to the
+// server that went away, and the server host name is resolved again.
+// Callers must hold connMu.
+func (c *Client) redialLocked() error {
+	c.pool.discard(c.conn)
+	conn, err := c.pool.dial(context.Background())
+	if err != nil {
+		return err
+	}
+	c.useConn(conn, 0)
+	c.connGen++
+	for _, entry := range c.unacked {
+		c.connSeq++
+		entry.seq = c.connSeq
+		if err := c.writeLocked(entry.frames); err != nil {
+			return err
+		}
+		log.Debugf("action: resend_batch | result: success | client_id: %v | batch: %d | seq: %d", c.config.ID, entry.id, entry.seq)
+	}
+	if c.finishedSent {
+		if err := c.writeLocked(c.finishedMsg); err != nil {
+			return err
+		}
+	}
+	log.Infof("action: reconnect | result: success | client_id: %v | resent: %d | finished: %t",
+		c.config.ID, len(c.unacked), c.finishedSent)
+	return nil
+}
+
+// batchAck reports whether msg acknowledges a batch, in any ack format,
+// whether the batch was stored and the correlation ID of the ack (0 for
+// legacy acks).
+func batchAck(msg protocol.Readable) (isAck bool, success bool, id int32) {
+	switch msg.GetOpCode() {
+	case protocol.BetsRecvSuccessOpCode:
+		return true, true, 0
+	case protocol.BetsRecvFailOpCode:
+		return true, false, 0
+	case protocol.AckOpCode:
+		ack := msg.(*protocol.Ack)
+		return true, ack.Success(), ack.CorrelationID
+	}
+	return false, false, 0
+}
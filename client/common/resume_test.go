@@ -0,0 +1,65 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// distinctBets returns the bets in sorted order without repetitions.
+func distinctBets(bets []map[string]string) []string {
+	seen := map[string]bool{}
+	var distinct []string
+	for _, bet := range bets {
+		key := fmt.Sprint(bet)
+		if !seen[key] {
+			seen[key] = true
+			distinct = append(distinct, key)
+		}
+	}
+	sort.Strings(distinct)
+	return distinct
+}
+
+func TestReconnect(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxReconnects int
+		drops         int // connections dropped on their third batch
+		wantErr       error
+		wantConns     int
+	}{
+		{"no reconnects", 0, 1, ErrConnection, 1},
+		{"reconnect once", 1, 1, nil, 2},
+		{"reconnect twice", 2, 2, nil, 3},
+		{"reconnects exhausted", 1, 2, ErrConnection, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, func(s *fakeSession, msg interface{}) bool {
+				if batch, ok := msg.(*fakeBatch); ok && s.index <= tt.drops && batch.Seq == 3 {
+					s.conn.Close()
+					return true
+				}
+				return false
+			})
+			config := testConfig(server.addr(), writeTestBets(t, 50))
+			config.MaxReconnects = tt.maxReconnects
+			summary, err := runTestClient(t, config)
+			checkErrorKind(t, err, tt.wantErr)
+			if server.connections() != tt.wantConns {
+				t.Fatalf("%d connections; want %d", server.connections(), tt.wantConns)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if summary.BetsSent != 50 || summary.AcksSuccess != 5 {
+				t.Fatalf("BetsSent = %d, AcksSuccess = %d; want 50 and 5", summary.BetsSent, summary.AcksSuccess)
+			}
+			// The batch in flight when the connection dropped is resent.
+			if got := len(distinctBets(server.received())); got != 50 {
+				t.Fatalf("server received %d distinct bets; want 50", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package common
+
+import (
+	"encoding/csv"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// deadLetterSuffix is appended to BetsFilePath to name the default
+// dead-letter file.
+const deadLetterSuffix = ".deadletter"
+
+// deadLetterMu serializes appends to the dead-letter file, which the
+// workers of a parallel upload share.
+var deadLetterMu sync.Mutex
+
+// unackedBatch is a batch written to the server and not acknowledged yet.
+type unackedBatch struct {
+	sentBatch
+	id       int64      // logged as batch, see runStats.nextBatch
+	seq      int32      // NEW_BETS sequence number on the current connection
+	sentAt   time.Time  // when the batch was last written, see Stats.LastAckLatency
+	frames   []byte     // to resend the batch, see tracksUnacked
+	records  [][]string // bets of the batch, see keepsRecords
+	attempts int        // retransmissions after failure acks
+}
+
+// keepsRecords reports whether the bets of each batch are kept until it is
+// acknowledged, to write them to the dead-letter file if it keeps failing.
+func (c *Client) keepsRecords() bool {
+	return c.config.MaxRetransmits > 0 && !c.config.DryRun
+}
+
+// batchRecord adds bet to the records of the batch being built.
+func (c *Client) batchRecord(bet *protocol.Bet) {
+	if c.keepsRecords() {
+		c.batchRecords = append(c.batchRecords, []string{bet.FirstName, bet.LastName, bet.Document, bet.Birthdate, bet.Number})
+	}
+}
+
+// retransmitLocked sends entry again after the server failed to store it,
+// queueing it as the newest unacknowledged batch. It reports false once the
+// batch used its MaxRetransmits attempts. Callers must hold connMu.
+func (c *Client) retransmitLocked(entry *unackedBatch) bool {
+	if entry.frames == nil || entry.attempts >= c.config.MaxRetransmits {
+		return false
+	}
+	entry.attempts++
+	c.run.retried("retransmit")
+	c.connSeq++
+	entry.seq = c.connSeq
+	entry.sentAt = time.Now()
+	log.Warningf("action: retransmit_batch | result: in_progress | client_id: %v | batch: %d | seq: %d | attempt: %d/%d | bets: %d",
+		c.config.ID, entry.id, entry.seq, entry.attempts, c.config.MaxRetransmits, entry.bets)
+	c.unacked = append(c.unacked, entry)
+	if err := c.writeLocked(entry.frames); err != nil {
+		// The reader notices the broken connection and resumes it, resending
+		// entry along with the rest of the queue.
+		log.Errorf("action: retransmit_batch | result: fail | client_id: %v | batch: %d | error: %v", c.config.ID, entry.id, err)
+	}
+	return true
+}
+
+// deadLetterPath returns DeadLetterPath, or the BetsFilePath based default.
+func (c *Client) deadLetterPath() string {
+	if c.config.DeadLetterPath != "" {
+		return c.config.DeadLetterPath
+	}
+	return c.betsFileName() + deadLetterSuffix
+}
+
+// deadLetterLocked appends the bets of a batch the server kept failing to
+// the dead-letter file, as CSV that can be sent again later. Callers must
+// hold connMu.
+func (c *Client) deadLetterLocked(entry *unackedBatch) {
+	if entry.records == nil {
+		return
+	}
+	path := c.deadLetterPath()
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err == nil {
+		writer := csv.NewWriter(file)
+		err = writer.WriteAll(entry.records)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		log.Errorf("action: dead_letter | result: fail | file: %s | batch: %d | bets: %d | error: %v", path, entry.id, len(entry.records), err)
+		return
+	}
+	log.Warningf("action: dead_letter | result: success | file: %s | batch: %d | bets: %d | attempts: %d", path, entry.id, len(entry.records), entry.attempts+1)
+}
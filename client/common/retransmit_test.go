@@ -0,0 +1,78 @@
+package common
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+func TestRetransmit(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxRetransmits int
+		failures       int // failure acks of the second batch
+		wantErr        error
+		wantRetries    int64
+		wantDeadLetter int // bets in the dead-letter file
+	}{
+		{"no retransmissions", 0, 1, ErrServerRejected, 0, 0},
+		{"retransmitted", 2, 1, nil, 1, 0},
+		{"retransmitted twice", 2, 2, nil, 2, 0},
+		{"dead-lettered", 2, 3, ErrServerRejected, 2, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bets := writeTestBets(t, 50)
+			var mu sync.Mutex
+			var secondBatch string
+			failures := 0
+			server := newFakeServer(t, func(s *fakeSession, msg interface{}) bool {
+				batch, ok := msg.(*fakeBatch)
+				if !ok {
+					return false
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if batch.Seq == 2 {
+					secondBatch = batch.Bets[0][protocol.DocumentKey]
+				}
+				if batch.Bets[0][protocol.DocumentKey] == secondBatch && failures < tt.failures {
+					failures++
+					s.ack(batch, protocol.AckFail)
+					return true
+				}
+				return false
+			})
+			config := testConfig(server.addr(), bets)
+			config.MaxRetransmits = tt.maxRetransmits
+			summary, err := runTestClient(t, config)
+			checkErrorKind(t, err, tt.wantErr)
+			if summary.Retries != tt.wantRetries {
+				t.Fatalf("Retries = %d; want %d", summary.Retries, tt.wantRetries)
+			}
+			if tt.wantErr == nil && (summary.AcksSuccess != 5 || len(distinctBets(server.received())) != 50) {
+				t.Fatalf("AcksSuccess = %d, server received %d distinct bets; want 5 and 50",
+					summary.AcksSuccess, len(distinctBets(server.received())))
+			}
+			deadLetter, err := os.ReadFile(bets + deadLetterSuffix)
+			if tt.wantDeadLetter == 0 {
+				if err == nil {
+					t.Fatalf("dead-letter file written:\n%s", deadLetter)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("dead-letter file: %v", err)
+			}
+			if lines := strings.Count(string(deadLetter), "\n"); lines != tt.wantDeadLetter {
+				t.Fatalf("dead-letter file has %d bets; want %d", lines, tt.wantDeadLetter)
+			}
+			if !strings.Contains(string(deadLetter), secondBatch) {
+				t.Fatalf("dead-letter file does not hold the second batch:\n%s", deadLetter)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+	"github.com/op/go-logging"
+)
+
+func TestMain(m *testing.M) {
+	logging.SetLevel(logging.CRITICAL, "log")
+	os.Exit(m.Run())
+}
+
+// fakeServer is an in-process lottery server for the tests. It answers
+// every complete batch with an Ack (until the connection sends QUIET),
+// FINISHED with the winners and SUMMARY_REQUEST with a Summary, unless
+// answer handles the message first.
+type fakeServer struct {
+	listener net.Listener
+	codec    protocol.Codec
+	winners  []string
+	// answer, if set, sees every message received: a *fakeBatch once a
+	// batch is complete, or the decoded frame for the other opcodes. It
+	// returns true if it answered the message itself.
+	answer func(s *fakeSession, msg interface{}) bool
+
+	mu       sync.Mutex
+	batches  []*fakeBatch // complete batches received, in order
+	finished int          // FINISHED received
+	conns    int          // connections accepted
+	sessions sync.WaitGroup
+}
+
+// fakeBatch is a logical NewBets batch received by a fakeServer, with Seq
+// its 1-based sequence number on the connection.
+type fakeBatch struct {
+	Seq  int32
+	Bets []map[string]string
+}
+
+// fakeSession is a connection accepted by a fakeServer.
+type fakeSession struct {
+	server  *fakeServer
+	conn    net.Conn
+	index   int   // of the connection on the server, from 1
+	seq     int32 // batches received on the connection
+	batches int32 // batches acknowledged with success, for the Summary
+	bets    int32
+	failed  int32
+	pending *fakeBatch // batch waiting for its Continuation frames
+	total   int32
+	quiet   bool // QUIET received: batches are counted but not acknowledged
+}
+
+// newFakeServer starts a fakeServer on a loopback port, stopped when the
+// test ends.
+func newFakeServer(t *testing.T, answer func(s *fakeSession, msg interface{}) bool) *fakeServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	return serveFake(t, listener, answer)
+}
+
+// serveFake starts a fakeServer accepting connections from listener,
+// stopped when the test ends.
+func serveFake(t *testing.T, listener net.Listener, answer func(s *fakeSession, msg interface{}) bool) *fakeServer {
+	s := &fakeServer{listener: listener, codec: protocol.BinaryCodec, answer: answer}
+	go s.accept()
+	t.Cleanup(func() {
+		listener.Close()
+		s.sessions.Wait()
+	})
+	return s
+}
+
+func (s *fakeServer) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeServer) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns++
+		session := &fakeSession{server: s, conn: conn, index: s.conns}
+		s.mu.Unlock()
+		s.sessions.Add(1)
+		go func() {
+			defer s.sessions.Done()
+			defer conn.Close()
+			session.serve()
+		}()
+	}
+}
+
+// connections returns the connections accepted so far.
+func (s *fakeServer) connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+// received returns the bets of the complete batches received so far.
+func (s *fakeServer) received() []map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var bets []map[string]string
+	for _, batch := range s.batches {
+		bets = append(bets, batch.Bets...)
+	}
+	return bets
+}
+
+// serve reads the frames of the connection until it is closed, answering
+// them. The connection is closed if a frame cannot be decoded.
+func (s *fakeSession) serve() {
+	for {
+		frame, err := protocol.ReadFrame(s.conn)
+		if err != nil {
+			return
+		}
+		decoded, err := frame.Decode(s.server.codec)
+		if err != nil {
+			return
+		}
+		var msg interface{} = decoded
+		if betsFrame, ok := decoded.(*protocol.BetsFrame); ok {
+			if !betsFrame.Continuation {
+				s.pending = &fakeBatch{}
+				s.total = betsFrame.Total
+			}
+			if s.pending == nil {
+				return
+			}
+			s.pending.Bets = append(s.pending.Bets, betsFrame.Bets...)
+			if int32(len(s.pending.Bets)) < s.total {
+				continue
+			}
+			s.seq++
+			s.pending.Seq = s.seq
+			msg = s.pending
+			s.server.mu.Lock()
+			s.server.batches = append(s.server.batches, s.pending)
+			s.server.mu.Unlock()
+			s.pending = nil
+		}
+		if _, ok := msg.(*protocol.Finished); ok {
+			s.server.mu.Lock()
+			s.server.finished++
+			s.server.mu.Unlock()
+		}
+		if s.server.answer != nil && s.server.answer(s, msg) {
+			continue
+		}
+		switch msg := msg.(type) {
+		case *fakeBatch:
+			if s.quiet {
+				s.count(msg, protocol.AckSuccess)
+			} else {
+				s.ack(msg, protocol.AckSuccess)
+			}
+		case *protocol.Quiet:
+			s.quiet = true
+		case *protocol.Finished:
+			s.send(winnersFrame(s.server.winners))
+		case *protocol.SummaryRequest:
+			s.send(summaryFrame(s.batches+s.failed, s.bets, s.failed))
+		}
+	}
+}
+
+// ack acknowledges batch with status.
+func (s *fakeSession) ack(batch *fakeBatch, status protocol.AckStatus) {
+	s.count(batch, status)
+	s.send(ackFrame(batch.Seq, status))
+}
+
+// count adds batch, stored with status, to the Summary of the connection.
+func (s *fakeSession) count(batch *fakeBatch, status protocol.AckStatus) {
+	if status == protocol.AckSuccess {
+		s.batches++
+		s.bets += int32(len(batch.Bets))
+	} else {
+		s.failed++
+	}
+}
+
+// send writes frames to the connection, ignoring errors: a client that
+// went away notices by itself.
+func (s *fakeSession) send(frames []byte) {
+	_, _ = s.conn.Write(frames)
+}
+
+func frameBytes(opcode protocol.OpCode, body []byte) []byte {
+	return (&protocol.Frame{OpCode: opcode, Body: body}).Bytes()
+}
+
+func appendInt32(b []byte, v int32) []byte {
+	var encoded [4]byte
+	binary.LittleEndian.PutUint32(encoded[:], uint32(v))
+	return append(b, encoded[:]...)
+}
+
+// ackFrame encodes an Ack with an empty detail map, in BinaryCodec.
+func ackFrame(seq int32, status protocol.AckStatus) []byte {
+	body := append(appendInt32(nil, seq), byte(status))
+	return frameBytes(protocol.AckOpCode, appendInt32(body, 0))
+}
+
+// winnersFrame encodes a Winners message in BinaryCodec.
+func winnersFrame(documents []string) []byte {
+	body := appendInt32(nil, int32(len(documents)))
+	for _, document := range documents {
+		body = append(appendInt32(body, int32(len(document))), document...)
+	}
+	return frameBytes(protocol.WinnersOpCode, body)
+}
+
+func summaryFrame(batches, bets, failed int32) []byte {
+	body := appendInt32(appendInt32(appendInt32(nil, batches), bets), failed)
+	return frameBytes(protocol.SummaryOpCode, body)
+}
+
+// writeTestBets writes n valid generated bets to a CSV file of the test and
+// returns its path.
+func writeTestBets(t *testing.T, n int64) string {
+	t.Helper()
+	var bets bytes.Buffer
+	if err := WriteGeneratedBets(&bets, n); err != nil {
+		t.Fatal(err)
+	}
+	return writeTestFile(t, "bets.csv", bets.Bytes())
+}
+
+// writeTestFile writes data to a file named name in a directory of the test
+// and returns its path.
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testConfig returns the configuration of a client of agency 5 uploading
+// the bets at path to the server at address in batches of 10, with short
+// timeouts.
+func testConfig(address string, path string) ClientConfig {
+	return ClientConfig{
+		ID:             "5",
+		ServerAddress:  address,
+		BetsFilePath:   path,
+		BatchLimit:     10,
+		AckTimeout:     2 * time.Second,
+		WinnersTimeout: 2 * time.Second,
+		ConnectTimeout: time.Second,
+	}
+}
+
+// runTestClient runs SendBets with config, failing the test if the client
+// cannot be created or the run does not end within 10 seconds.
+func runTestClient(t *testing.T, config ClientConfig) (RunSummary, error) {
+	t.Helper()
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	summary, err := client.SendBets(ctx)
+	if ctx.Err() != nil {
+		t.Fatalf("SendBets did not end: %v", err)
+	}
+	return summary, err
+}
+
+// checkErrorKind fails the test unless err is nil if kind is nil, or of
+// category kind.
+func checkErrorKind(t *testing.T, err error, kind error) {
+	t.Helper()
+	if kind == nil && err != nil {
+		t.Fatalf("error = %v; want none", err)
+	}
+	if kind != nil && !errors.Is(err, kind) {
+		t.Fatalf("error = %v; want %v", err, kind)
+	}
+}
@@ -0,0 +1,122 @@
+package common
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Shard restricts a run to part of the bets file, so several client
+// instances can split one huge file between them.
+// - StartLine, EndLine: inclusive range of input lines to send (0 = from the first / up to the last line).
+// - Index, Count: with Count > 1, only every Count-th record of the range is sent, starting at the Index-th (0-based).
+//
+// Records outside the shard are read but neither validated nor sent.
+type Shard struct {
+	StartLine int
+	EndLine   int
+	Index     int
+	Count     int
+}
+
+// enabled reports whether the shard restricts the input at all.
+func (s Shard) enabled() bool {
+	return s.StartLine > 0 || s.EndLine > 0 || s.Count > 1
+}
+
+// validate checks that the shard selects a well-formed range.
+func (s Shard) validate() error {
+	if s.StartLine < 0 || s.EndLine < 0 {
+		return fmt.Errorf("shard lines must not be negative, got %d-%d", s.StartLine, s.EndLine)
+	}
+	if s.EndLine > 0 && s.EndLine < s.StartLine {
+		return fmt.Errorf("shard end line %d is before start line %d", s.EndLine, s.StartLine)
+	}
+	if s.Count < 0 || (s.Count > 0 && (s.Index < 0 || s.Index >= s.Count)) {
+		return fmt.Errorf("invalid shard %d/%d", s.Index, s.Count)
+	}
+	return nil
+}
+
+// shardReader yields only the records of inner selected by shard. Reading
+// stops as soon as a record past EndLine is found.
+type shardReader struct {
+	RecordReader
+	shard     Shard
+	position  int // records of the line range seen so far
+	firstLine int
+	lastLine  int
+}
+
+// shardRecords wraps betsReader with the configured Shard, if any.
+func (c *Client) shardRecords(betsReader RecordReader) RecordReader {
+	if !c.config.Shard.enabled() {
+		return betsReader
+	}
+	return &shardReader{RecordReader: betsReader, shard: c.config.Shard}
+}
+
+func (r *shardReader) Read() ([]string, error) {
+	for {
+		record, err := r.RecordReader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		line, ok := recordLine(r.RecordReader, err)
+		if !ok {
+			return record, err
+		}
+		if r.shard.EndLine > 0 && line > r.shard.EndLine {
+			return nil, io.EOF
+		}
+		if line < r.shard.StartLine {
+			continue
+		}
+		r.position++
+		if r.shard.Count > 1 && (r.position-1)%r.shard.Count != r.shard.Index {
+			continue
+		}
+		if r.firstLine == 0 {
+			r.firstLine = line
+		}
+		r.lastLine = line
+		return record, err
+	}
+}
+
+// recordLine returns the input line of the record just read from
+// betsReader, which failed with err if not nil. Errors that do not name a
+// line report false.
+func recordLine(betsReader RecordReader, err error) (int, bool) {
+	if err == nil {
+		return betsReader.Line(), true
+	}
+	var csvErr *csv.ParseError
+	var recordErr *RecordError
+	switch {
+	case errors.As(err, &recordErr):
+		return recordErr.Line, true
+	case errors.As(err, &csvErr):
+		return csvErr.StartLine, true
+	default:
+		return 0, false
+	}
+}
+
+// logShard logs the lines covered by the shard and the bets sent from them.
+func (c *Client) logShard(betsReader RecordReader) {
+	if resumed, ok := betsReader.(*checkpointReader); ok {
+		betsReader = resumed.RecordReader
+	}
+	shard, ok := betsReader.(*shardReader)
+	if !ok {
+		return
+	}
+	count := shard.shard.Count
+	if count == 0 {
+		count = 1
+	}
+	log.Infof("action: shard | result: success | client_id: %v | shard: %d/%d | first_line: %d | last_line: %d | bets_sent: %d",
+		c.config.ID, shard.shard.Index, count, shard.firstLine, shard.lastLine, c.Summary().BetsSent)
+}
@@ -0,0 +1,73 @@
+package common
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+func TestShard(t *testing.T) {
+	// The bet of line i has number i; lines 1 and 10 are invalid, so only
+	// shards that skip them succeed.
+	var bets strings.Builder
+	for line := 1; line <= 10; line++ {
+		document := fmt.Sprint(30000000 + line)
+		if line == 1 || line == 10 {
+			document = "invalid"
+		}
+		fmt.Fprintf(&bets, "Ana,Gomez,%s,1990-05-17,%d\n", document, line)
+	}
+	tests := []struct {
+		name      string
+		shard     Shard
+		wantLines []string
+	}{
+		{"line range", Shard{StartLine: 3, EndLine: 5}, []string{"3", "4", "5"}},
+		{"range around the invalid lines", Shard{StartLine: 2, EndLine: 9}, []string{"2", "3", "4", "5", "6", "7", "8", "9"}},
+		{"first shard of three", Shard{StartLine: 2, EndLine: 9, Index: 0, Count: 3}, []string{"2", "5", "8"}},
+		{"last shard of three", Shard{StartLine: 2, EndLine: 9, Index: 2, Count: 3}, []string{"4", "7"}},
+		{"shard of two", Shard{StartLine: 4, EndLine: 8, Index: 1, Count: 2}, []string{"5", "7"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, nil)
+			config := testConfig(server.addr(), writeTestFile(t, "bets.csv", []byte(bets.String())))
+			config.Shard = tt.shard
+			if _, err := runTestClient(t, config); err != nil {
+				t.Fatalf("SendBets: %v", err)
+			}
+			var lines []string
+			for _, bet := range server.received() {
+				lines = append(lines, bet[protocol.NumberKey])
+			}
+			if !reflect.DeepEqual(lines, tt.wantLines) {
+				t.Fatalf("sent the bets of lines %v; want %v", lines, tt.wantLines)
+			}
+		})
+	}
+}
+
+func TestShardValidate(t *testing.T) {
+	tests := []struct {
+		shard   Shard
+		wantErr bool
+	}{
+		{Shard{}, false},
+		{Shard{StartLine: 5, EndLine: 5}, false},
+		{Shard{StartLine: 5}, false},
+		{Shard{Index: 2, Count: 3}, false},
+		{Shard{StartLine: -1}, true},
+		{Shard{StartLine: 6, EndLine: 5}, true},
+		{Shard{Index: 3, Count: 3}, true},
+		{Shard{Index: -1, Count: 3}, true},
+		{Shard{Count: -2}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.shard.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%+v.validate() = %v; want error %t", tt.shard, err, tt.wantErr)
+		}
+	}
+}
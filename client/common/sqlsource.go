@@ -0,0 +1,125 @@
+package common
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Defaults of SQLOptions.
+const (
+	defaultSQLDriver = "sqlite3"
+	defaultSQLQuery  = "SELECT nombre, apellido, documento, nacimiento, numero FROM apuestas"
+)
+
+// SQLOptions configures the InputSQLite bet source.
+// - Driver: database/sql driver name (empty = "sqlite3"); it must be linked into the binary.
+// - Query: statement returning one bet per row, in the CSV column order (empty = all the rows of the apuestas table).
+type SQLOptions struct {
+	Driver string
+	Query  string
+}
+
+// validate checks that the driver is linked into the binary: the default
+// build has none (see the sqlite build tag of package main).
+func (o SQLOptions) validate() error {
+	driver := o.Driver
+	if driver == "" {
+		driver = defaultSQLDriver
+	}
+	for _, linked := range sql.Drivers() {
+		if linked == driver {
+			return nil
+		}
+	}
+	return fmt.Errorf("sql driver %q is not linked into the client (build it with -tags sqlite for %q)", driver, defaultSQLDriver)
+}
+
+// sqlRecordReader yields the rows of a query as records. Line is the row
+// number, starting at 1.
+type sqlRecordReader struct {
+	db     *sql.DB
+	rows   *sql.Rows
+	line   int
+	last   []string
+	values []sql.NullString
+}
+
+// newSQLRecordReader opens the database at BetsFilePath and runs the
+// configured query. The query is cancelled along with ctx.
+func (c *Client) newSQLRecordReader(ctx context.Context) (*sqlRecordReader, error) {
+	driver, query := c.config.SQL.Driver, c.config.SQL.Query
+	if driver == "" {
+		driver = defaultSQLDriver
+	}
+	if query == "" {
+		query = defaultSQLQuery
+	}
+	db, err := sql.Open(driver, c.config.BetsFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("open %s database: %w", driver, err)
+	}
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("query bets: %w", err)
+	}
+	columns, err := rows.Columns()
+	if err == nil && len(columns) < betFieldsCount {
+		err = fmt.Errorf("query returns %d columns, bets need %d", len(columns), betFieldsCount)
+	}
+	if err != nil {
+		rows.Close()
+		db.Close()
+		return nil, err
+	}
+	return &sqlRecordReader{db: db, rows: rows, values: make([]sql.NullString, len(columns))}, nil
+}
+
+func (r *sqlRecordReader) Read() ([]string, error) {
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	r.line++
+	r.last = nil
+	dest := make([]interface{}, len(r.values))
+	for i := range r.values {
+		dest[i] = &r.values[i]
+	}
+	if err := r.rows.Scan(dest...); err != nil {
+		return nil, &RecordError{Line: r.line, Err: err}
+	}
+	record := make([]string, len(r.values))
+	for i, value := range r.values {
+		record[i] = value.String
+	}
+	r.last = record
+	return record[:betFieldsCount], nil
+}
+
+func (r *sqlRecordReader) Line() int { return r.line }
+
+// Raw renders the last row as a CSV line, so rejected rows can be fixed and
+// sent from a CSV file.
+func (r *sqlRecordReader) Raw() string {
+	if r.last == nil {
+		return ""
+	}
+	var raw strings.Builder
+	writer := csv.NewWriter(&raw)
+	_ = writer.Write(r.last)
+	writer.Flush()
+	return strings.TrimSuffix(raw.String(), "\n")
+}
+
+// Close releases the query rows and the database handle.
+func (r *sqlRecordReader) Close() error {
+	r.rows.Close()
+	return r.db.Close()
+}
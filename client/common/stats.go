@@ -0,0 +1,148 @@
+package common
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// Stats is a snapshot of the counters and timings of the current (or last)
+// run, see Client.Stats.
+// - BetsRead: input records read, rejected ones included.
+// - BytesWritten: bytes of the frames written to the server, retransmissions included.
+// - LastAckLatency: time between the write of the last acknowledged batch and its ack (0 = none yet, or AckSummary mode).
+// - AckLatency: histogram of the time between the write of each batch and its ack.
+// - Elapsed: time since the run started, or its duration once finished.
+type Stats struct {
+	BetsRead       int64            `json:"bets_read"`
+	BatchesSent    int64            `json:"batches_sent"`
+	BytesWritten   int64            `json:"bytes_written"`
+	AcksSuccess    int64            `json:"acks_success"`
+	AcksFail       int64            `json:"acks_fail"`
+	Retries        int64            `json:"retries"`
+	LastAckLatency time.Duration    `json:"last_ack_latency"`
+	AckLatency     LatencyHistogram `json:"ack_latency"`
+	Elapsed        time.Duration    `json:"elapsed"`
+}
+
+// ackLatencyBounds are the upper bounds of the buckets of the AckLatency
+// histogram.
+var ackLatencyBounds = [...]time.Duration{
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond, 10 * time.Millisecond,
+	25 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond,
+	500 * time.Millisecond, time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// LatencyHistogram counts latencies by bucket. Counts[i] is the number of
+// latencies above Bounds[i-1] and up to Bounds[i]; the last count, one more
+// than the bounds, is that of the latencies above every bound.
+// - Count, Sum: number and total of the latencies counted, for their mean.
+type LatencyHistogram struct {
+	Bounds []time.Duration `json:"bounds"`
+	Counts []int64         `json:"counts"`
+	Count  int64           `json:"count"`
+	Sum    time.Duration   `json:"sum"`
+}
+
+// runStats holds the counters of a run updated too often to take the
+// runState lock. Being the first field of runState keeps them 64-bit
+// aligned for the atomic operations.
+type runStats struct {
+	betsRead       int64
+	bytesWritten   int64
+	lastAckLatency int64
+	batches        int64 // batch IDs handed out, see nextBatch
+	lastActivity   int64 // when a frame was last written or an ack received, see Health
+	ackSum         int64
+	ackCounts      [len(ackLatencyBounds) + 1]int64
+	protocol       protocol.Counters // frames of the run, see Client.ProtocolStats
+}
+
+func (s *runStats) reset() {
+	atomic.StoreInt64(&s.betsRead, 0)
+	atomic.StoreInt64(&s.bytesWritten, 0)
+	atomic.StoreInt64(&s.lastAckLatency, 0)
+	atomic.StoreInt64(&s.batches, 0)
+	atomic.StoreInt64(&s.lastActivity, time.Now().UnixNano())
+	atomic.StoreInt64(&s.ackSum, 0)
+	for i := range s.ackCounts {
+		atomic.StoreInt64(&s.ackCounts[i], 0)
+	}
+	s.protocol.Reset()
+}
+
+// nextBatch returns the ID of a new batch of the run, numbered from 1 in
+// write order across every connection. Unlike the sequence number the
+// server acknowledges, which restarts on each connection, it is kept when
+// the batch is resent, so it follows the batch through the logs.
+func (s *runStats) nextBatch() int64 {
+	return atomic.AddInt64(&s.batches, 1)
+}
+
+func (s *runStats) betRead() {
+	atomic.AddInt64(&s.betsRead, 1)
+}
+
+func (s *runStats) written(n int) {
+	atomic.AddInt64(&s.bytesWritten, int64(n))
+	atomic.StoreInt64(&s.lastActivity, time.Now().UnixNano())
+}
+
+// acked records the latency of an ack, from the write of its batch.
+func (s *runStats) acked(latency time.Duration) {
+	atomic.StoreInt64(&s.lastAckLatency, int64(latency))
+	atomic.StoreInt64(&s.lastActivity, time.Now().UnixNano())
+	atomic.AddInt64(&s.ackSum, int64(latency))
+	bucket := len(ackLatencyBounds)
+	for i, bound := range ackLatencyBounds {
+		if latency <= bound {
+			bucket = i
+			break
+		}
+	}
+	atomic.AddInt64(&s.ackCounts[bucket], 1)
+}
+
+// ackLatency returns a snapshot of the histogram of the ack latencies.
+func (s *runStats) ackLatency() LatencyHistogram {
+	histogram := LatencyHistogram{
+		Bounds: append([]time.Duration(nil), ackLatencyBounds[:]...),
+		Counts: make([]int64, len(s.ackCounts)),
+		Sum:    time.Duration(atomic.LoadInt64(&s.ackSum)),
+	}
+	for i := range s.ackCounts {
+		histogram.Counts[i] = atomic.LoadInt64(&s.ackCounts[i])
+		histogram.Count += histogram.Counts[i]
+	}
+	return histogram
+}
+
+// Stats returns the counters and timings of the current (or last) run. It
+// is safe to call from any goroutine while SendBets is running, e.g. by
+// embedders or a health endpoint.
+func (c *Client) Stats() Stats {
+	summary, finished := c.run.snapshot()
+	stats := Stats{
+		BetsRead:       atomic.LoadInt64(&c.run.stats.betsRead),
+		BatchesSent:    summary.BatchesSent,
+		BytesWritten:   atomic.LoadInt64(&c.run.stats.bytesWritten),
+		AcksSuccess:    summary.AcksSuccess,
+		AcksFail:       summary.AcksFail,
+		Retries:        summary.Retries,
+		LastAckLatency: time.Duration(atomic.LoadInt64(&c.run.stats.lastAckLatency)),
+		AckLatency:     c.run.stats.ackLatency(),
+	}
+	if finished {
+		stats.Elapsed = summary.FinishedAt.Sub(summary.StartedAt)
+	} else if !summary.StartedAt.IsZero() {
+		stats.Elapsed = time.Since(summary.StartedAt)
+	}
+	return stats
+}
+
+// ProtocolStats returns the protocol counters of the current (or last) run:
+// the frames written and read by this client only.
+func (c *Client) ProtocolStats() protocol.ProtocolStats {
+	return c.run.stats.protocol.Stats()
+}
@@ -0,0 +1,56 @@
+package common
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+func TestProtocolStatsPerRun(t *testing.T) {
+	server := newFakeServer(t, nil)
+	tests := []struct {
+		bets        int64
+		wantBatches uint64
+	}{
+		{25, 3},
+		{50, 5},
+		{95, 10},
+	}
+	clients := make([]*Client, len(tests))
+	for i, tt := range tests {
+		client, err := NewClient(testConfig(server.addr(), writeTestBets(t, tt.bets)))
+		if err != nil {
+			t.Fatalf("NewClient: %v", err)
+		}
+		defer client.Close()
+		clients[i] = client
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// Every client runs twice, the runs of different clients concurrently.
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			for run := 0; run < 2; run++ {
+				if _, err := client.SendBets(ctx); err != nil {
+					t.Errorf("SendBets: %v", err)
+				}
+			}
+		}(client)
+	}
+	wg.Wait()
+	for i, tt := range tests {
+		stats := clients[i].ProtocolStats()
+		if stats.BatchesFlushed != tt.wantBatches || stats.AcksReceived != tt.wantBatches {
+			t.Errorf("client of %d bets: %d batches flushed, %d acks; want %d", tt.bets, stats.BatchesFlushed, stats.AcksReceived, tt.wantBatches)
+		}
+		if stats.PerOpcode[protocol.FinishedOpCode].FramesSent != 1 || stats.PerOpcode[protocol.WinnersOpCode].FramesReceived != 1 {
+			t.Errorf("client of %d bets: opcodes %+v; want one FINISHED and one WINNERS", tt.bets, stats.PerOpcode)
+		}
+	}
+}
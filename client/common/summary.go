@@ -0,0 +1,199 @@
+package common
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RunSummary describes the outcome of a SendBets run.
+// - TraceID: random identifier of the run, logged at start and archived in audit bundles.
+// - Success: the upload completed and the winners were received.
+// - Winners: documents of the agency winners, as reported by the server.
+// - BetsRejected: records skipped in tolerant mode.
+// - BetsDuplicated: bets whose (DOCUMENTO, NUMERO) pair was already read in the run.
+// - Throttled: time the upload was held back by ClientConfig.RateLimit.
+// - Endpoint: ClientConfig.ServerAddress (or WebSocketURL) entry of the last connection of the run.
+// - UnknownWinners: winners whose document the client never sent, see Client.SendBets.
+// - Retries: connections replaced, batches retransmitted and winners polls made.
+type RunSummary struct {
+	TraceID        string        `json:"trace_id"`
+	AgencyID       string        `json:"agency_id"`
+	StartedAt      time.Time     `json:"started_at"`
+	FinishedAt     time.Time     `json:"finished_at"`
+	BetsSent       int64         `json:"bets_sent"`
+	BetsRejected   int64         `json:"bets_rejected"`
+	BetsDuplicated int64         `json:"bets_duplicated"`
+	BatchesSent    int64         `json:"batches_sent"`
+	AcksSuccess    int64         `json:"acks_success"`
+	AcksFail       int64         `json:"acks_fail"`
+	Throttled      time.Duration `json:"throttled"`
+	Success        bool          `json:"success"`
+	Winners        []string      `json:"winners"`
+	Endpoint       string        `json:"endpoint"`
+	UnknownWinners []string      `json:"unknown_winners"`
+	Retries        int64         `json:"retries"`
+}
+
+// runState accumulates the RunSummary while the writer and reader
+// goroutines make progress, along with an audit trail of the run events if
+// auditing (see ClientConfig.AuditTrail). All methods are safe for
+// concurrent use.
+type runState struct {
+	stats    runStats // updated atomically, see Client.Stats
+	mu       sync.Mutex
+	summary  RunSummary
+	auditing bool
+	audit    []string
+	finished bool
+}
+
+// newTraceID returns a random 128-bit identifier as hex.
+func newTraceID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// record appends an audit entry, unless the audit trail is off. Callers
+// must hold s.mu.
+func (s *runState) record(format string, args ...interface{}) {
+	if !s.auditing {
+		return
+	}
+	entry := time.Now().UTC().Format(time.RFC3339Nano) + " " + fmt.Sprintf(format, args...)
+	s.audit = append(s.audit, entry)
+}
+
+func (s *runState) start(agencyID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.summary = RunSummary{TraceID: newTraceID(), AgencyID: agencyID, StartedAt: time.Now()}
+	s.stats.reset()
+	s.audit = nil
+	s.finished = false
+	s.record("start | trace_id: %s | agency: %s", s.summary.TraceID, agencyID)
+}
+
+func (s *runState) batchFlushed(bets int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.summary.BatchesSent++
+	s.summary.BetsSent += int64(bets)
+	s.record("batch_flushed | batch: %d | bets: %d", s.summary.BatchesSent, bets)
+}
+
+func (s *runState) throttled(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.summary.Throttled += d
+}
+
+// connected records the server address of a new connection, reporting
+// whether it differs from the previous one.
+func (s *runState) connected(address string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.summary.Endpoint == address {
+		return false
+	}
+	s.summary.Endpoint = address
+	s.record("connect | address: %s", address)
+	return true
+}
+
+// retried records a retry of the given kind: reconnect, retransmit or poll.
+func (s *runState) retried(kind string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.summary.Retries++
+	s.record("retry | kind: %s", kind)
+}
+
+func (s *runState) betRejected(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.summary.BetsRejected++
+	s.record("bet_rejected | error: %v", err)
+}
+
+func (s *runState) betDuplicated(line int, firstLine int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.summary.BetsDuplicated++
+	s.record("bet_duplicated | line: %d | first_line: %d", line, firstLine)
+}
+
+func (s *runState) ackReceived(success bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if success {
+		s.summary.AcksSuccess++
+	} else {
+		s.summary.AcksFail++
+	}
+	s.record("ack | success: %t", success)
+}
+
+// summaryReceived records the acks of a run in AckSummary mode, reported
+// all at once by the server.
+func (s *runState) summaryReceived(batches int64, failed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.summary.AcksSuccess += batches - failed
+	s.summary.AcksFail += failed
+	s.record("summary | batches: %d | failed: %d", batches, failed)
+}
+
+func (s *runState) finishedSent() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record("finished_sent")
+}
+
+func (s *runState) winnersReceived(winners []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.summary.Winners = winners
+	s.summary.Success = true
+	s.record("winners | count: %d", len(winners))
+}
+
+// winnersUnknown records the winners the client never sent a bet for.
+func (s *runState) winnersUnknown(documents []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.summary.UnknownWinners = documents
+}
+
+func (s *runState) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.summary.FinishedAt = time.Now()
+	s.finished = true
+	s.record("finish | success: %t", s.summary.Success)
+}
+
+// auditLog returns a copy of the audit entries recorded so far.
+func (s *runState) auditLog() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.audit...)
+}
+
+// snapshot returns a copy of the current summary and whether the run ended.
+func (s *runState) snapshot() (RunSummary, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summary := s.summary
+	summary.Winners = append([]string{}, s.summary.Winners...)
+	summary.UnknownWinners = append([]string(nil), s.summary.UnknownWinners...)
+	return summary, s.finished
+}
@@ -0,0 +1,53 @@
+package common
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// TCPOptions tunes the sockets dialed to the server, e.g. for batch
+// uploads over high-latency links.
+// - KeepAlive: interval between keep-alive probes (0 = the Go default of 15s, < 0 = off).
+// - Delay: clear TCP_NODELAY, letting the kernel coalesce small writes (false = TCP_NODELAY set, as Go does by default).
+// - ReadBuffer: size of the socket receive buffer in bytes (0 = the OS default).
+// - WriteBuffer: size of the socket send buffer in bytes (0 = the OS default).
+type TCPOptions struct {
+	KeepAlive   time.Duration
+	Delay       bool
+	ReadBuffer  int
+	WriteBuffer int
+}
+
+// validate checks that the buffer sizes are not negative.
+func (o TCPOptions) validate() error {
+	if o.ReadBuffer < 0 || o.WriteBuffer < 0 {
+		return fmt.Errorf("invalid TCP buffer sizes %d/%d", o.ReadBuffer, o.WriteBuffer)
+	}
+	return nil
+}
+
+// apply sets the options on a connection just dialed; KeepAlive is set by
+// the dialer. Connections other than TCP are left untouched.
+func (o TCPOptions) apply(conn net.Conn) error {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if o.Delay {
+		if err := tcp.SetNoDelay(false); err != nil {
+			return err
+		}
+	}
+	if o.ReadBuffer > 0 {
+		if err := tcp.SetReadBuffer(o.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if o.WriteBuffer > 0 {
+		if err := tcp.SetWriteBuffer(o.WriteBuffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
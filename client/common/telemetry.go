@@ -0,0 +1,44 @@
+package common
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// Version is the version of the client reported in its telemetry, set at
+// build time with -ldflags "-X .../client/common.Version=...".
+var Version = "dev"
+
+// sendTelemetry reports the stats of the upload to the server, right
+// before FINISHED, if ClientConfig.Telemetry is set.
+func (c *Client) sendTelemetry() error {
+	if !c.config.Telemetry {
+		return nil
+	}
+	summary := c.Summary()
+	msg := protocol.Telemetry{Stats: map[string]string{
+		"agency":        c.config.ID,
+		"version":       Version,
+		"bets_sent":     strconv.FormatInt(summary.BetsSent, 10),
+		"batches_sent":  strconv.FormatInt(summary.BatchesSent, 10),
+		"acks_fail":     strconv.FormatInt(summary.AcksFail, 10),
+		"bets_rejected": strconv.FormatInt(summary.BetsRejected, 10),
+		"retries":       strconv.FormatInt(summary.Retries, 10),
+		"duration_ms":   strconv.FormatInt(time.Since(summary.StartedAt).Milliseconds(), 10),
+	}, Counters: &c.run.stats.protocol}
+	var frame bytes.Buffer
+	if _, err := msg.WriteTo(&frame); err != nil {
+		return err
+	}
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if err := c.sendLocked(frame.Bytes(), false); err != nil {
+		log.Errorf("action: send_telemetry | result: fail | client_id: %v | error: %v", c.config.ID, err)
+		return err
+	}
+	log.Debugf("action: send_telemetry | result: success | client_id: %v | msg: %v", c.config.ID, &msg)
+	return nil
+}
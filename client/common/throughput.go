@@ -0,0 +1,56 @@
+package common
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// reportThroughput logs the throughput of the run every RateLogPeriod
+// until the returned function is called: the bets read and the KB written
+// per second over the last period, and the batches in flight. The result is
+// stalled if batches were in flight but none was acknowledged during the
+// period, and idle if nothing was read or written, e.g. while the winners
+// are awaited.
+func (c *Client) reportThroughput(ctx context.Context) func() {
+	if c.config.RateLogPeriod <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(c.config.RateLogPeriod)
+		defer ticker.Stop()
+		var bets, bytes, acks int64
+		last := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			case now := <-ticker.C:
+				summary := c.Summary()
+				nowBets := atomic.LoadInt64(&c.run.stats.betsRead)
+				nowBytes := atomic.LoadInt64(&c.run.stats.bytesWritten)
+				nowAcks := summary.AcksSuccess + summary.AcksFail
+				inFlight := summary.BatchesSent - nowAcks
+				seconds := now.Sub(last).Seconds()
+				result := "in_progress"
+				if inFlight > 0 && nowAcks == acks {
+					result = "stalled"
+				} else if nowBets == bets && nowBytes == bytes {
+					result = "idle"
+				}
+				log.Infof("action: throughput | result: %s | client_id: %v | bets_per_sec: %.1f | kb_per_sec: %.1f | in_flight: %d",
+					result, c.config.ID, float64(nowBets-bets)/seconds, float64(nowBytes-bytes)/1024/seconds, inFlight)
+				bets, bytes, acks, last = nowBets, nowBytes, nowAcks, now
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
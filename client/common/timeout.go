@@ -0,0 +1,63 @@
+package common
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+// timeoutError reports that the server did not answer within the timeout
+// configured for what it owed: acks (AckTimeout) or winners
+// (WinnersTimeout).
+type timeoutError struct {
+	waiting string
+	after   time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("no %s received for %v", e.waiting, e.after)
+}
+
+// Timeout makes timeoutError satisfy net.Error.
+func (e *timeoutError) Timeout() bool { return true }
+
+func (e *timeoutError) Temporary() bool { return false }
+
+// readTimeoutLocked returns how long the reader waits for the next server
+// message and what it is waiting for: an ack while batches are in flight
+// (AckTimeout), the winners once FINISHED was sent (WinnersTimeout). When
+// the server owes nothing, waiting is empty and the shortest of both
+// timeouts is used to poll, so that they apply soon after the next write.
+// Callers must hold connMu.
+func (c *Client) readTimeoutLocked() (timeout time.Duration, waiting string) {
+	switch {
+	case len(c.unacked) > 0:
+		return c.config.AckTimeout, "ack"
+	case c.finishedSent:
+		return c.config.WinnersTimeout, "winners"
+	}
+	timeout = c.config.AckTimeout
+	if timeout <= 0 || (c.config.WinnersTimeout > 0 && c.config.WinnersTimeout < timeout) {
+		timeout = c.config.WinnersTimeout
+	}
+	return timeout, ""
+}
+
+// writeLocked writes frames to the current connection, failing if the write
+// does not complete within WriteTimeout, and logs their sizes at debug level
+// along with the sequence number of the last batch. Callers must hold connMu.
+func (c *Client) writeLocked(frames []byte) error {
+	if c.config.WriteTimeout > 0 {
+		if err := c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout)); err != nil {
+			return err
+		}
+	}
+	n, err := c.conn.Write(frames)
+	c.run.stats.written(n)
+	if err == nil && log.IsEnabledFor(logging.DEBUG) {
+		log.Debugf("action: write_frames | result: success | client_id: %v | seq: %d | frames: %s",
+			c.config.ID, c.connSeq, describeFrames(frames))
+	}
+	return err
+}
@@ -0,0 +1,79 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+)
+
+// TLSOptions secures the connections dialed to ServerAddress, e.g. when the
+// server is reached through a TLS terminating proxy. WebSocketURL entries
+// use TLS according to their scheme instead.
+// - Enabled: wrap every connection in TLS (false = plain TCP).
+// - CAFile: PEM file with the CAs the server certificate is checked against (empty = the system roots).
+// - CertFile, KeyFile: PEM client certificate and key, for servers requiring mutual TLS (empty = none).
+// - ServerName: name the server certificate is checked against (empty = the host of the address dialed).
+// - InsecureSkipVerify: accept any server certificate, for tests only.
+type TLSOptions struct {
+	Enabled            bool
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// load builds the TLS configuration of the options, reading the CA and
+// client certificate files. It returns nil if TLS is disabled.
+func (o TLSOptions) load() (*tls.Config, error) {
+	if !o.Enabled {
+		return nil, nil
+	}
+	config := &tls.Config{
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls ca: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls ca: no certificates in %s", o.CAFile)
+		}
+	}
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return nil, fmt.Errorf("tls client certificate needs both a cert and a key file")
+	}
+	if o.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// dialTLS performs the TLS handshake on conn, just dialed to address,
+// closing it if the handshake fails.
+func dialTLS(ctx context.Context, conn net.Conn, address string, config *tls.Config) (net.Conn, error) {
+	config = config.Clone()
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		config.ServerName = host
+	}
+	secure := tls.Client(conn, config)
+	if err := secure.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("tls handshake: %w", err)
+	}
+	return secure, nil
+}
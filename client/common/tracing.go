@@ -0,0 +1,256 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// otlpTimeout bounds the export of the spans of a run.
+const otlpTimeout = 5 * time.Second
+
+// Status codes and span kinds of the OTLP trace model.
+const (
+	otlpStatusError  = 2
+	otlpKindInternal = 1
+	otlpKindClient   = 3
+)
+
+// tracer records the spans of a run while ClientConfig.OTLPEndpoint is set,
+// to be exported once the run ends (see endTrace). The trace ID is the
+// TraceID of the run summary, so a trace can be found from the start line
+// of the run log. Every span is a child of the span of the whole run. A nil
+// tracer records nothing. Its methods are safe for concurrent use, workers
+// sharing the tracer of the Client that started them.
+type tracer struct {
+	mu      sync.Mutex
+	traceID string
+	rootID  string
+	started time.Time
+	spans   []otlpSpan
+}
+
+// spanAttr is an attribute of a span; values are strings, integers or
+// booleans.
+type spanAttr struct {
+	key   string
+	value interface{}
+}
+
+func attr(key string, value interface{}) spanAttr {
+	return spanAttr{key: key, value: value}
+}
+
+// newSpanID returns a random 64-bit span identifier as hex.
+func newSpanID() string {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// validateOTLPEndpoint checks that OTLPEndpoint, if set, is an http or
+// https URL.
+func validateOTLPEndpoint(endpoint string) error {
+	if endpoint == "" {
+		return nil
+	}
+	target, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid otlp endpoint %q: %w", endpoint, err)
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return fmt.Errorf("otlp endpoint %q must use http or https", endpoint)
+	}
+	return nil
+}
+
+// startTrace starts recording the spans of the run that just started, if
+// OTLPEndpoint is set.
+func (c *Client) startTrace() {
+	c.tracer = nil
+	if c.config.OTLPEndpoint == "" {
+		return
+	}
+	c.tracer = &tracer{traceID: c.Summary().TraceID, rootID: newSpanID(), started: time.Now()}
+}
+
+// record adds a span named name, that started at start and ends now. A
+// non-nil err marks the span as failed.
+func (t *tracer) record(name string, kind int, start time.Time, err error, attrs ...spanAttr) {
+	if t == nil {
+		return
+	}
+	span := newOTLPSpan(name, kind, start, time.Now(), err, attrs)
+	span.TraceID = t.traceID
+	span.SpanID = newSpanID()
+	span.ParentSpanID = t.rootID
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	t.mu.Unlock()
+}
+
+// endTrace ends the span of the run, named name, and exports it along with
+// the spans recorded meanwhile. Export failures are logged: they do not fail
+// the run.
+func (c *Client) endTrace(name string, err error) {
+	t := c.tracer
+	c.tracer = nil
+	if t == nil {
+		return
+	}
+	root := newOTLPSpan(name, otlpKindInternal, t.started, time.Now(), err, nil)
+	root.TraceID = t.traceID
+	root.SpanID = t.rootID
+	t.mu.Lock()
+	spans := append(t.spans, root)
+	t.mu.Unlock()
+	if err := c.exportSpans(spans); err != nil {
+		log.Warningf("action: export_trace | result: fail | client_id: %v | trace_id: %s | error: %v",
+			c.config.ID, t.traceID, err)
+		return
+	}
+	log.Debugf("action: export_trace | result: success | client_id: %v | trace_id: %s | spans: %d",
+		c.config.ID, t.traceID, len(spans))
+}
+
+// exportSpans posts spans to the OTLP/HTTP trace endpoint of OTLPEndpoint,
+// in the JSON encoding of the protocol.
+func (c *Client) exportSpans(spans []otlpSpan) error {
+	request := otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: otlpAttrs([]spanAttr{
+			attr("service.name", "lottery-client"),
+			attr("agency.id", c.config.ID),
+		})},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"},
+			Spans: spans,
+		}},
+	}}}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	endpoint := strings.TrimSuffix(c.config.OTLPEndpoint, "/")
+	if !strings.HasSuffix(endpoint, "/v1/traces") {
+		endpoint += "/v1/traces"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), otlpTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", endpoint, resp.Status)
+	}
+	return nil
+}
+
+// The types below are the subset of the OTLP/HTTP JSON trace request the
+// client sends. Identifiers are hex encoded and timestamps are nanoseconds
+// since the epoch, as strings.
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID      string         `json:"traceId"`
+	SpanID       string         `json:"spanId"`
+	ParentSpanID string         `json:"parentSpanId,omitempty"`
+	Name         string         `json:"name"`
+	Kind         int            `json:"kind"`
+	Start        string         `json:"startTimeUnixNano"`
+	End          string         `json:"endTimeUnixNano"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+	Status       *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	String *string `json:"stringValue,omitempty"`
+	Int    *string `json:"intValue,omitempty"`
+	Bool   *bool   `json:"boolValue,omitempty"`
+}
+
+func newOTLPSpan(name string, kind int, start, end time.Time, err error, attrs []spanAttr) otlpSpan {
+	span := otlpSpan{
+		Name:       name,
+		Kind:       kind,
+		Start:      strconv.FormatInt(start.UnixNano(), 10),
+		End:        strconv.FormatInt(end.UnixNano(), 10),
+		Attributes: otlpAttrs(attrs),
+	}
+	if err != nil {
+		span.Status = &otlpStatus{Code: otlpStatusError, Message: err.Error()}
+	}
+	return span
+}
+
+func otlpAttrs(attrs []spanAttr) []otlpKeyValue {
+	values := make([]otlpKeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		var value otlpValue
+		switch v := a.value.(type) {
+		case bool:
+			value.Bool = &v
+		case int:
+			s := strconv.Itoa(v)
+			value.Int = &s
+		case int32:
+			s := strconv.FormatInt(int64(v), 10)
+			value.Int = &s
+		case int64:
+			s := strconv.FormatInt(v, 10)
+			value.Int = &s
+		default:
+			s := fmt.Sprint(v)
+			value.String = &s
+		}
+		values = append(values, otlpKeyValue{Key: a.key, Value: value})
+	}
+	return values
+}
@@ -0,0 +1,63 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// Domain limits checked before a bet is serialized, mirroring what the
+// server accepts when it stores the bet.
+const (
+	minDocumentDigits = 7
+	maxDocumentDigits = 8
+	birthdateLayout   = "2006-01-02"
+	minBetNumber      = 0
+	maxBetNumber      = 9999
+)
+
+// validateBet checks the bet fields the server parses: the document must
+// be a DNI of 7 or 8 digits, the birthdate a YYYY-MM-DD date not after
+// now, and the number an integer in [0, 9999]. The first offending field
+// is reported as a *protocol.ValidationError.
+func validateBet(bet *protocol.Bet, now time.Time) error {
+	if n := len(bet.Document); n < minDocumentDigits || n > maxDocumentDigits || !isDigits(bet.Document) {
+		return &protocol.ValidationError{
+			Field:  protocol.DocumentKey,
+			Reason: fmt.Sprintf("%q is not a DNI of %d to %d digits", PII(bet.Document), minDocumentDigits, maxDocumentDigits),
+		}
+	}
+	birthdate, err := time.Parse(birthdateLayout, bet.Birthdate)
+	if err != nil {
+		return &protocol.ValidationError{
+			Field:  protocol.BirthdateKey,
+			Reason: fmt.Sprintf("%q is not a YYYY-MM-DD date", bet.Birthdate),
+		}
+	}
+	if birthdate.After(now) {
+		return &protocol.ValidationError{
+			Field:  protocol.BirthdateKey,
+			Reason: fmt.Sprintf("%s is in the future", bet.Birthdate),
+		}
+	}
+	number, err := strconv.Atoi(bet.Number)
+	if err != nil || number < minBetNumber || number > maxBetNumber {
+		return &protocol.ValidationError{
+			Field:  protocol.NumberKey,
+			Reason: fmt.Sprintf("%q is not a number between %d and %d", bet.Number, minBetNumber, maxBetNumber),
+		}
+	}
+	return nil
+}
+
+// isDigits reports whether s only holds ASCII digits.
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package common
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+func TestValidateBet(t *testing.T) {
+	now := time.Date(2024, time.March, 10, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		document  string
+		birthdate string
+		number    string
+		wantField string // "" = valid
+	}{
+		{"valid", "30123412", "1990-05-17", "7574", ""},
+		{"seven digit document", "3012341", "1990-05-17", "0", ""},
+		{"born today", "30123412", "2024-03-10", "9999", ""},
+		{"short document", "301234", "1990-05-17", "7574", protocol.DocumentKey},
+		{"long document", "301234123", "1990-05-17", "7574", protocol.DocumentKey},
+		{"document with letters", "30I23412", "1990-05-17", "7574", protocol.DocumentKey},
+		{"birthdate format", "30123412", "17/05/1990", "7574", protocol.BirthdateKey},
+		{"impossible birthdate", "30123412", "1990-02-30", "7574", protocol.BirthdateKey},
+		{"future birthdate", "30123412", "2024-03-11", "7574", protocol.BirthdateKey},
+		{"number above the range", "30123412", "1990-05-17", "10000", protocol.NumberKey},
+		{"negative number", "30123412", "1990-05-17", "-1", protocol.NumberKey},
+		{"number not numeric", "30123412", "1990-05-17", "7a", protocol.NumberKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bet, err := protocol.NewBet("5", "Ana", "Gomez", tt.document, tt.birthdate, tt.number)
+			if err != nil {
+				t.Fatalf("NewBet: %v", err)
+			}
+			err = validateBet(bet, now)
+			var validationErr *protocol.ValidationError
+			switch {
+			case tt.wantField == "" && err != nil:
+				t.Fatalf("validateBet: %v", err)
+			case tt.wantField != "" && (!errors.As(err, &validationErr) || validationErr.Field != tt.wantField):
+				t.Fatalf("validateBet error = %v; want a ValidationError of %s", err, tt.wantField)
+			}
+		})
+	}
+}
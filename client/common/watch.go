@@ -0,0 +1,137 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Suffixes appended to a watched file once its run ended.
+const (
+	watchDoneSuffix   = ".done"
+	watchFailedSuffix = ".failed"
+)
+
+// defaultWatchSettle is used when WatchDir gets a non-positive settle.
+const defaultWatchSettle = time.Second
+
+// WatchDir turns the client into a continuous ingester: it sends every bets
+// file already in dir and every one dropped into it afterwards (.csv or
+// .jsonl according to InputFormat, optionally .gz), one SendBets run
+// per file, until ctx is cancelled, Cancel is called or the client is closed.
+//
+// A file is sent once no write was observed on it for settle, so files still
+// being copied are not picked up early. After its run the file is renamed
+// with the .done suffix, or .failed if the run failed. Files whose run was
+// cancelled keep their name, so they are sent again on the next start.
+func (c *Client) WatchDir(ctx context.Context, dir string, settle time.Duration) error {
+	if settle <= 0 {
+		settle = defaultWatchSettle
+	}
+	ctx, endRun, err := c.beginRun(ctx)
+	if err != nil {
+		return newError(ErrCancelled, "watch_dir", err)
+	}
+	defer endRun()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err := watcher.Add(dir); err != nil {
+		return err
+	}
+
+	// Last activity seen on each bets file waiting to be sent.
+	pending := map[string]time.Time{}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && c.isBetsFile(entry.Name()) {
+			pending[filepath.Join(dir, entry.Name())] = time.Time{}
+		}
+	}
+	log.Infof("action: watch_dir | result: in_progress | dir: %s | pending: %d", dir, len(pending))
+
+	ticker := time.NewTicker(settle)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Infof("action: watch_dir | result: success | dir: %s", dir)
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !c.isBetsFile(event.Name) {
+				continue
+			}
+			if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				delete(pending, event.Name)
+			} else if event.Op&(fsnotify.Create|fsnotify.Write) != 0 {
+				pending[event.Name] = time.Now()
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Warningf("action: watch_dir | result: fail | error: %v", err)
+		case now := <-ticker.C:
+			var ready []string
+			for path, last := range pending {
+				if now.Sub(last) >= settle {
+					ready = append(ready, path)
+				}
+			}
+			sort.Strings(ready)
+			for _, path := range ready {
+				delete(pending, path)
+				if err := c.sendWatchedFile(ctx, path); errors.Is(err, ErrCancelled) {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// sendWatchedFile runs SendBets on path and marks the file as processed.
+// It returns the run error.
+func (c *Client) sendWatchedFile(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	log.Infof("action: watch_file | result: in_progress | file: %s", path)
+	c.config.BetsFilePath = path
+	_, runErr := c.SendBets(ctx)
+	if errors.Is(runErr, ErrCancelled) {
+		return runErr
+	}
+	suffix := watchDoneSuffix
+	if runErr != nil {
+		suffix = watchFailedSuffix
+		log.Errorf("action: watch_file | result: fail | file: %s | error: %v", path, runErr)
+	}
+	if err := os.Rename(path, path+suffix); err != nil {
+		log.Errorf("action: mark_file | result: fail | file: %s | error: %v", path, err)
+		return runErr
+	}
+	log.Infof("action: mark_file | result: success | file: %s | marked: %s", path, path+suffix)
+	return runErr
+}
+
+// isBetsFile reports whether name looks like a bets file of the configured
+// InputFormat, possibly gzipped.
+func (c *Client) isBetsFile(name string) bool {
+	ext := "." + string(c.config.InputFormat)
+	return strings.HasSuffix(name, ext) || strings.HasSuffix(name, ext+gzipSuffix)
+}
@@ -0,0 +1,360 @@
+package common
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// WebSocket opcodes (RFC 6455, section 5.2).
+const (
+	wsContinuation = 0x0
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// wsAcceptGUID is appended to the handshake key to compute the accept key.
+const wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsMaxControlPayload is the largest payload of a control frame (RFC 6455,
+// section 5.5).
+const wsMaxControlPayload = 125
+
+// validateWebSocketURLs checks that every entry of a comma-separated
+// WebSocketURL list is a ws or wss URL.
+func validateWebSocketURLs(list string) error {
+	if list == "" {
+		return nil
+	}
+	for _, rawURL := range splitAddresses(list) {
+		target, err := url.Parse(rawURL)
+		if err != nil {
+			return fmt.Errorf("invalid websocket URL %q: %w", rawURL, err)
+		}
+		if target.Scheme != "ws" && target.Scheme != "wss" {
+			return fmt.Errorf("websocket URL %q must use ws or wss", rawURL)
+		}
+	}
+	return nil
+}
+
+// wsConn tunnels the protocol frames over a WebSocket: each Write goes out
+// as one binary message and Read returns the payload of the messages
+// received, so the protocol framing is reused unchanged. Pings are
+// answered and a close message ends the stream.
+//
+// A read error between frames, such as the timeout of an idle poll, leaves
+// the stream aligned and is returned as is. Inside a frame, the bytes
+// already consumed are lost, so the error is fatal: every later Read
+// returns it, and it is no longer a timeout for the callers to retry.
+type wsConn struct {
+	net.Conn
+	reader    *bufio.Reader
+	remaining int64 // payload bytes of the current frame not read yet
+	readErr   error // fatal error of a read inside a frame
+	writeMu   sync.Mutex
+	closeSent bool // guarded by writeMu
+}
+
+// dialWebSocket connects to rawURL with dial, through the HTTP proxy of the
+// environment (HTTPS_PROXY, HTTP_PROXY, NO_PROXY) if there is one, and
+// performs the WebSocket handshake, wrapped in TLS for wss URLs.
+func dialWebSocket(ctx context.Context, rawURL string, dial func(ctx context.Context, address string) (net.Conn, error)) (net.Conn, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	host := target.Host
+	if target.Port() == "" {
+		port := "80"
+		if target.Scheme == "wss" {
+			port = "443"
+		}
+		host = net.JoinHostPort(target.Hostname(), port)
+	}
+	proxy, err := websocketProxy(target)
+	if err != nil {
+		return nil, err
+	}
+	address := host
+	if proxy != nil {
+		address = proxy.Host
+	}
+	conn, err := dial(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	// Cancelling ctx interrupts the handshake.
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	ws, err := upgrade(ctx, conn, target, host, proxy)
+	close(done)
+	<-stopped
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		_ = conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("websocket %s: %w", rawURL, err)
+	}
+	return ws, nil
+}
+
+// websocketProxy returns the HTTP proxy to reach target through, or nil.
+func websocketProxy(target *url.URL) (*url.URL, error) {
+	scheme := "http"
+	if target.Scheme == "wss" {
+		scheme = "https"
+	}
+	return http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: scheme, Host: target.Host}})
+}
+
+// upgrade tunnels conn through proxy (if any) to host, adds TLS for wss
+// and turns the connection into a WebSocket.
+func upgrade(ctx context.Context, conn net.Conn, target *url.URL, host string, proxy *url.URL) (*wsConn, error) {
+	if proxy != nil {
+		connect := &http.Request{
+			Method: http.MethodConnect,
+			URL:    &url.URL{Opaque: host},
+			Host:   host,
+			Header: make(http.Header),
+		}
+		if proxy.User != nil {
+			password, _ := proxy.User.Password()
+			credentials := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+			connect.Header.Set("Proxy-Authorization", "Basic "+credentials)
+		}
+		if err := connect.Write(conn); err != nil {
+			return nil, err
+		}
+		// The proxy sends nothing after its response until the tunnel is
+		// used, so the reader cannot buffer bytes of the tunnel.
+		response, err := http.ReadResponse(bufio.NewReader(conn), connect)
+		if err != nil {
+			return nil, err
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("proxy %s: %s", proxy.Host, response.Status)
+		}
+	}
+	if target.Scheme == "wss" {
+		secure := tls.Client(conn, &tls.Config{ServerName: target.Hostname()})
+		if err := secure.HandshakeContext(ctx); err != nil {
+			return nil, err
+		}
+		conn = secure
+	}
+
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	request := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Path: target.Path, RawQuery: target.RawQuery},
+		Host:   target.Host,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+		},
+	}
+	if request.URL.Path == "" {
+		request.URL.Path = "/"
+	}
+	if err := request.Write(conn); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("handshake: %s", response.Status)
+	}
+	accept := sha1.Sum([]byte(key + wsAcceptGUID))
+	if response.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(accept[:]) {
+		return nil, errors.New("handshake: invalid Sec-WebSocket-Accept")
+	}
+	return &wsConn{Conn: conn, reader: reader}, nil
+}
+
+func (w *wsConn) Read(p []byte) (int, error) {
+	if w.readErr != nil {
+		return 0, w.readErr
+	}
+	for w.remaining == 0 {
+		if _, err := w.reader.Peek(1); err != nil {
+			// No byte of the next frame was consumed yet.
+			return 0, err
+		}
+		opcode, length, err := w.readHeader()
+		if err != nil {
+			return 0, w.fail(err)
+		}
+		switch opcode {
+		case wsBinary, wsContinuation:
+			w.remaining = length
+		case wsPing, wsPong, wsClose:
+			payload := make([]byte, length) // bounded by readHeader
+			if _, err := io.ReadFull(w.reader, payload); err != nil {
+				return 0, w.fail(interruptedFrame(err))
+			}
+			if opcode == wsPing {
+				if err := w.writeFrame(wsPong, payload); err != nil {
+					return 0, err
+				}
+			}
+			if opcode == wsClose {
+				_ = w.writeFrame(wsClose, payload)
+				return 0, w.fail(io.EOF)
+			}
+		default:
+			return 0, w.fail(fmt.Errorf("websocket: unexpected opcode %#x", opcode))
+		}
+	}
+	if int64(len(p)) > w.remaining {
+		p = p[:w.remaining]
+	}
+	n, err := w.reader.Read(p)
+	w.remaining -= int64(n)
+	if err != nil {
+		return n, w.fail(interruptedFrame(err))
+	}
+	return n, nil
+}
+
+// fail makes err the fatal error of every later Read.
+func (w *wsConn) fail(err error) error {
+	w.readErr = err
+	return err
+}
+
+// interruptedFrame reports the I/O error of a read inside a frame, dropping
+// its type so a timeout is not mistaken for an idle one.
+func interruptedFrame(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("websocket: frame interrupted: %v", err)
+}
+
+// readHeader reads the header of the next frame, returning its opcode and
+// payload length. Server frames must not be masked, and control frames
+// must neither be fragmented nor carry more than wsMaxControlPayload bytes.
+func (w *wsConn) readHeader() (opcode byte, length int64, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(w.reader, header[:]); err != nil {
+		return 0, 0, interruptedFrame(err)
+	}
+	if header[1]&0x80 != 0 {
+		return 0, 0, errors.New("websocket: masked server frame")
+	}
+	opcode = header[0] & 0x0F
+	length = int64(header[1] & 0x7F)
+	if opcode&0x8 != 0 {
+		if header[0]&0x80 == 0 {
+			return 0, 0, fmt.Errorf("websocket: fragmented control frame %#x", opcode)
+		}
+		if length > wsMaxControlPayload {
+			return 0, 0, fmt.Errorf("websocket: control frame %#x of more than %d bytes", opcode, wsMaxControlPayload)
+		}
+	}
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(w.reader, extended[:]); err != nil {
+			return 0, 0, interruptedFrame(err)
+		}
+		length = int64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(w.reader, extended[:]); err != nil {
+			return 0, 0, interruptedFrame(err)
+		}
+		if extended[0]&0x80 != 0 {
+			return 0, 0, errors.New("websocket: frame length overflows 63 bits")
+		}
+		length = int64(binary.BigEndian.Uint64(extended[:]))
+	}
+	return opcode, length, nil
+}
+
+func (w *wsConn) Write(p []byte) (int, error) {
+	if err := w.writeFrame(wsBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeFrame writes payload as a single masked frame, as clients must. A
+// close frame is only sent once, whether it starts the closing handshake
+// or answers the one of the server.
+func (w *wsConn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode, 0}
+	switch {
+	case len(payload) < 126:
+		header[1] = byte(len(payload))
+	case len(payload) <= 0xFFFF:
+		header[1] = 126
+		header = append(header, byte(len(payload)>>8), byte(len(payload)))
+	default:
+		header[1] = 127
+		var extended [8]byte
+		binary.BigEndian.PutUint64(extended[:], uint64(len(payload)))
+		header = append(header, extended[:]...)
+	}
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	header[1] |= 0x80
+	frame := append(header, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	if opcode == wsClose {
+		if w.closeSent {
+			return nil
+		}
+		w.closeSent = true
+	}
+	_, err := w.Conn.Write(frame)
+	return err
+}
+
+// CloseWrite sends a close message, the WebSocket counterpart of a TCP
+// half-close: the server still delivers what it owes before closing.
+func (w *wsConn) CloseWrite() error {
+	return w.writeFrame(wsClose, []byte{0x03, 0xE8}) // 1000: normal closure
+}
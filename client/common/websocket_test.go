@@ -0,0 +1,276 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+)
+
+// scriptedConn is a net.Conn whose reads follow a script: each step hands
+// over its bytes, possibly across several reads, and then fails with its
+// error, if any. Writes are recorded.
+type scriptedConn struct {
+	net.Conn
+	steps   []scriptStep
+	written bytes.Buffer
+}
+
+type scriptStep struct {
+	data []byte
+	err  error
+}
+
+func (c *scriptedConn) Read(p []byte) (int, error) {
+	for len(c.steps) > 0 {
+		step := &c.steps[0]
+		if len(step.data) > 0 {
+			n := copy(p, step.data)
+			step.data = step.data[n:]
+			return n, nil
+		}
+		c.steps = c.steps[1:]
+		if step.err != nil {
+			return 0, step.err
+		}
+	}
+	return 0, io.EOF
+}
+
+func (c *scriptedConn) Write(p []byte) (int, error) {
+	return c.written.Write(p)
+}
+
+// newScriptedWS returns a wsConn reading the script.
+func newScriptedWS(steps ...scriptStep) (*wsConn, *scriptedConn) {
+	conn := &scriptedConn{steps: steps}
+	return &wsConn{Conn: conn, reader: bufio.NewReader(conn)}, conn
+}
+
+// serverFrame encodes an unmasked frame with the shortest length encoding.
+func serverFrame(fin bool, opcode byte, payload []byte) []byte {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 126, byte(len(payload)>>8), byte(len(payload)))
+	default:
+		var extended [8]byte
+		binary.BigEndian.PutUint64(extended[:], uint64(len(payload)))
+		frame = append(append(frame, 127), extended[:]...)
+	}
+	return append(frame, payload...)
+}
+
+// readClientFrame decodes the next masked frame written by the client.
+func readClientFrame(t *testing.T, r io.Reader) (opcode byte, lengthMarker byte, payload []byte) {
+	t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		t.Fatalf("reading frame header: %v", err)
+	}
+	if header[0]&0x80 == 0 {
+		t.Fatalf("client frame without FIN")
+	}
+	if header[1]&0x80 == 0 {
+		t.Fatalf("client frame not masked")
+	}
+	lengthMarker = header[1] & 0x7F
+	length := uint64(lengthMarker)
+	switch lengthMarker {
+	case 126:
+		var extended [2]byte
+		io.ReadFull(r, extended[:])
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		io.ReadFull(r, extended[:])
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(r, mask[:]); err != nil {
+		t.Fatalf("reading mask: %v", err)
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatalf("reading payload: %v", err)
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return header[0] & 0x0F, lengthMarker, payload
+}
+
+func testPayload(n int) []byte {
+	payload := make([]byte, n)
+	for i := range payload {
+		payload[i] = byte(i * 7)
+	}
+	return payload
+}
+
+func TestWebSocketReadLengths(t *testing.T) {
+	tests := []struct {
+		name   string
+		length int
+	}{
+		{"empty", 0},
+		{"7-bit max", 125},
+		{"16-bit min", 126},
+		{"16-bit 127", 127},
+		{"16-bit max", 0xFFFF},
+		{"64-bit", 0x10000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := testPayload(tt.length)
+			ws, _ := newScriptedWS(scriptStep{data: append(serverFrame(true, wsBinary, payload), serverFrame(true, wsBinary, []byte("next"))...)})
+			got := make([]byte, tt.length+len("next"))
+			if _, err := io.ReadFull(ws, got); err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			if !bytes.Equal(got[:tt.length], payload) || string(got[tt.length:]) != "next" {
+				t.Fatalf("payload mismatch")
+			}
+		})
+	}
+}
+
+func TestWebSocketWriteMasksPayload(t *testing.T) {
+	tests := []struct {
+		length int
+		marker byte
+	}{
+		{0, 0},
+		{125, 125},
+		{126, 126},
+		{127, 126},
+		{0xFFFF, 126},
+		{0x10000, 127},
+	}
+	for _, tt := range tests {
+		ws, conn := newScriptedWS()
+		payload := testPayload(tt.length)
+		if n, err := ws.Write(payload); err != nil || n != tt.length {
+			t.Fatalf("Write(%d bytes) = %d, %v", tt.length, n, err)
+		}
+		opcode, marker, got := readClientFrame(t, &conn.written)
+		if opcode != wsBinary || marker != tt.marker || !bytes.Equal(got, payload) {
+			t.Errorf("%d bytes: opcode %#x, length marker %d, payload equal %t; want %#x, %d, true",
+				tt.length, opcode, marker, bytes.Equal(got, payload), wsBinary, tt.marker)
+		}
+	}
+}
+
+func TestWebSocketAnswersPing(t *testing.T) {
+	ws, conn := newScriptedWS(scriptStep{data: append(serverFrame(true, wsPing, []byte("hello")), serverFrame(true, wsBinary, []byte("data"))...)})
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(ws, got); err != nil || string(got) != "data" {
+		t.Fatalf("Read = %q, %v; want data", got, err)
+	}
+	opcode, _, payload := readClientFrame(t, &conn.written)
+	if opcode != wsPong || string(payload) != "hello" {
+		t.Fatalf("answer = %#x %q; want pong hello", opcode, payload)
+	}
+}
+
+func TestWebSocketClose(t *testing.T) {
+	status := []byte{0x03, 0xE8}
+	t.Run("server first", func(t *testing.T) {
+		ws, conn := newScriptedWS(scriptStep{data: serverFrame(true, wsClose, status)})
+		if _, err := ws.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("Read error = %v; want EOF", err)
+		}
+		if _, err := ws.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("Read after close = %v; want EOF", err)
+		}
+		if err := ws.CloseWrite(); err != nil {
+			t.Fatalf("CloseWrite: %v", err)
+		}
+		opcode, _, payload := readClientFrame(t, &conn.written)
+		if opcode != wsClose || !bytes.Equal(payload, status) {
+			t.Fatalf("answer = %#x %v; want close %v", opcode, payload, status)
+		}
+		if conn.written.Len() != 0 {
+			t.Fatalf("%d bytes written after the close frame", conn.written.Len())
+		}
+	})
+	t.Run("client first", func(t *testing.T) {
+		ws, conn := newScriptedWS(scriptStep{data: serverFrame(true, wsClose, status)})
+		if err := ws.CloseWrite(); err != nil {
+			t.Fatalf("CloseWrite: %v", err)
+		}
+		if _, err := ws.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("Read error = %v; want EOF", err)
+		}
+		if opcode, _, _ := readClientFrame(t, &conn.written); opcode != wsClose {
+			t.Fatalf("opcode = %#x; want close", opcode)
+		}
+		if conn.written.Len() != 0 {
+			t.Fatalf("close frame sent twice")
+		}
+	})
+}
+
+func TestWebSocketRejectsInvalidFrames(t *testing.T) {
+	hugePing := []byte{0x80 | wsPing, 127, 0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+	tests := []struct {
+		name  string
+		frame []byte
+	}{
+		{"ping of 126 bytes", serverFrame(true, wsPing, testPayload(126))},
+		{"ping of 2^63-1 bytes", hugePing},
+		{"fragmented close", serverFrame(false, wsClose, nil)},
+		{"masked frame", []byte{0x80 | wsBinary, 0x80 | 1, 0, 0, 0, 0, 'x'}},
+		{"64-bit length with the high bit set", append([]byte{0x80 | wsBinary, 127, 0x80}, make([]byte, 7)...)},
+		{"unknown opcode", serverFrame(true, 0x3, nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws, _ := newScriptedWS(scriptStep{data: tt.frame})
+			_, err := ws.Read(make([]byte, 16))
+			if err == nil || err == io.EOF {
+				t.Fatalf("Read error = %v; want a protocol error", err)
+			}
+			if _, again := ws.Read(make([]byte, 16)); again != err {
+				t.Fatalf("second Read error = %v; want %v", again, err)
+			}
+		})
+	}
+}
+
+func TestWebSocketTimeouts(t *testing.T) {
+	frame := serverFrame(true, wsBinary, []byte("payload"))
+	t.Run("between frames", func(t *testing.T) {
+		ws, _ := newScriptedWS(scriptStep{err: os.ErrDeadlineExceeded}, scriptStep{data: frame})
+		if _, err := ws.Read(make([]byte, 16)); !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("Read error = %v; want the timeout", err)
+		}
+		got := make([]byte, 7)
+		if _, err := io.ReadFull(ws, got); err != nil || string(got) != "payload" {
+			t.Fatalf("Read after timeout = %q, %v; want payload", got, err)
+		}
+	})
+	for _, cut := range []int{1, 2, 5} {
+		ws, _ := newScriptedWS(scriptStep{data: frame[:cut], err: os.ErrDeadlineExceeded}, scriptStep{data: frame[cut:]})
+		var err error
+		for err == nil {
+			_, err = ws.Read(make([]byte, 16))
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("cut at %d: Read error %v is still a timeout", cut, err)
+		}
+		if _, again := ws.Read(make([]byte, 16)); again != err {
+			t.Fatalf("cut at %d: Read after the error = %v; want %v", cut, again, err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package common
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+func TestWindow(t *testing.T) {
+	tests := []struct {
+		window       int
+		wantInFlight int
+	}{
+		{1, 1},
+		{3, 3},
+		{0, 5}, // no window: every batch is sent before the first ack
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("window %d", tt.window), func(t *testing.T) {
+			var mu sync.Mutex
+			inFlight, maxInFlight := 0, 0
+			// Acks are delayed, so the client has the chance to fill the
+			// window before any arrives. Answers keep their order, as the
+			// winners must follow the acks.
+			type answer struct {
+				at    time.Time
+				frame []byte
+				ack   bool
+			}
+			answers := make(chan answer, 16)
+			// Registered before the server, so it runs once its sessions ended.
+			t.Cleanup(func() { close(answers) })
+			server := newFakeServer(t, func(s *fakeSession, msg interface{}) bool {
+				switch msg := msg.(type) {
+				case *fakeBatch:
+					mu.Lock()
+					if inFlight++; inFlight > maxInFlight {
+						maxInFlight = inFlight
+					}
+					mu.Unlock()
+					if msg.Seq == 1 {
+						go func() {
+							for answer := range answers {
+								time.Sleep(time.Until(answer.at))
+								if answer.ack {
+									mu.Lock()
+									inFlight--
+									mu.Unlock()
+								}
+								s.send(answer.frame)
+							}
+						}()
+					}
+					answers <- answer{time.Now().Add(20 * time.Millisecond), ackFrame(msg.Seq, protocol.AckSuccess), true}
+					return true
+				case *protocol.Finished:
+					answers <- answer{frame: winnersFrame(nil)}
+					return true
+				}
+				return false
+			})
+			config := testConfig(server.addr(), writeTestBets(t, 50))
+			config.Window = tt.window
+			summary, err := runTestClient(t, config)
+			if err != nil {
+				t.Fatalf("SendBets: %v", err)
+			}
+			if summary.AcksSuccess != 5 {
+				t.Fatalf("AcksSuccess = %d; want 5", summary.AcksSuccess)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if maxInFlight != tt.wantInFlight {
+				t.Fatalf("%d batches in flight at most; want %d", maxInFlight, tt.wantInFlight)
+			}
+		})
+	}
+}
+
+func TestStalledServer(t *testing.T) {
+	tests := []struct {
+		name    string
+		window  int
+		wantErr error
+	}{
+		{"stalled with a window", 2, ErrConnection},
+		{"stalled without a window", 0, ErrConnection},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The server stops answering after the first batch.
+			server := newFakeServer(t, func(s *fakeSession, msg interface{}) bool {
+				batch, ok := msg.(*fakeBatch)
+				return !ok || batch.Seq > 1
+			})
+			config := testConfig(server.addr(), writeTestBets(t, 50))
+			config.Window = tt.window
+			config.AckTimeout = 50 * time.Millisecond
+			started := time.Now()
+			_, err := runTestClient(t, config)
+			checkErrorKind(t, err, tt.wantErr)
+			if elapsed := time.Since(started); elapsed > time.Second {
+				t.Fatalf("stall detected after %v; want about %v", elapsed, config.AckTimeout)
+			}
+		})
+	}
+}
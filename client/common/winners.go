@@ -0,0 +1,224 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// pollsWinnersLocked reports whether the winners are polled for after the
+// connection dropped or WinnersTimeout elapsed, see WinnersDeadline. In
+// AckSummary mode the summary must have arrived, since a new connection
+// has none. Callers must hold connMu.
+func (c *Client) pollsWinnersLocked() bool {
+	return c.config.WinnersDeadline > 0 && c.finishedSent &&
+		(c.config.AckMode != AckSummary || c.ackSummary != nil)
+}
+
+// pollWinners replaces the connection of generation gen, which failed with
+// cause while the winners were awaited, resending FINISHED on the new one
+// (see redialLocked): the server answers it once the draw is done. Dials
+// are retried with exponential backoff until WinnersDeadline elapses since
+// the first poll of the run, in which case the last error is returned.
+func (c *Client) pollWinners(ctx context.Context, gen uint64, cause error) error {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.connGen != gen {
+		return nil
+	}
+	if c.pollUntil.IsZero() {
+		c.pollUntil = time.Now().Add(c.config.WinnersDeadline)
+	}
+	backoff := c.config.ConnectBackoff
+	if backoff <= 0 {
+		backoff = defaultConnectBackoff
+	}
+	for attempt := 1; ; attempt++ {
+		delay := jitter(backoff)
+		if time.Now().Add(delay).After(c.pollUntil) {
+			log.Errorf("action: consulta_ganadores | result: fail | client_id: %v | deadline: %v | error: %v",
+				c.config.ID, c.config.WinnersDeadline, cause)
+			return cause
+		}
+		log.Warningf("action: consulta_ganadores | result: retry | client_id: %v | attempt: %d | backoff: %v | error: %v",
+			c.config.ID, attempt, delay, cause)
+		c.connMu.Unlock()
+		select {
+		case <-ctx.Done():
+			c.connMu.Lock()
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		c.connMu.Lock()
+		c.run.retried("poll")
+		if cause = c.redialLocked(); cause == nil {
+			return nil
+		}
+		if backoff *= 2; backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
+// sentDocuments remembers the documents of the bets the client sent, across
+// its runs, to verify the winners (see verifyWinners). It is partial once a
+// run sent only part of its input, as the server reports every winner of
+// the agency, or once the documents exceeded the MemoryBudget, in which
+// case they are dropped.
+type sentDocuments struct {
+	documents map[string]struct{}
+	partial   bool
+	size      int64 // bytes held by documents, see memoryBudget
+}
+
+// trackDocuments starts tracking the documents of a run, noting whether
+// bets of the input were sent by other runs or instances: the ones before
+// a checkpoint, left in the journal or outside Shard.
+func (c *Client) trackDocuments() {
+	if c.sent == nil {
+		c.sent = &sentDocuments{documents: make(map[string]struct{})}
+	}
+	if (c.checkpoint != nil && c.checkpoint.state.Line > 0) ||
+		(c.journal != nil && c.journal.previous > 0) || c.config.Shard.enabled() {
+		c.sent.partial = true
+	}
+	c.budget.hold(c.sent.size)
+}
+
+// documentSent records the document of a bet sent by the run. Once the
+// documents do not fit in the MemoryBudget, they are dropped and the
+// winners are no longer verified.
+func (c *Client) documentSent(document string) {
+	if c.sent == nil || c.sent.documents == nil {
+		return
+	}
+	if _, ok := c.sent.documents[document]; ok {
+		return
+	}
+	size := int64(len(document)) + documentOverhead
+	if !c.budget.reserve(size) {
+		log.Warningf("action: verificar_ganadores | result: skip | client_id: %v | documents: %d | memory_budget: %d",
+			c.config.ID, len(c.sent.documents), c.config.MemoryBudget)
+		c.budget.release(c.sent.size)
+		c.sent.documents = nil
+		c.sent.partial = true
+		c.sent.size = 0
+		return
+	}
+	c.sent.documents[document] = struct{}{}
+	c.sent.size += size
+}
+
+// verifyWinners checks every winner of the run against the documents sent
+// by the client, flagging the unknown ones, which reveal that the server
+// mixed up agencies. It is skipped if some bets of the agency were sent by
+// other runs.
+func (c *Client) verifyWinners() {
+	if c.sent == nil || c.sent.partial {
+		log.Debugf("action: verificar_ganadores | result: skip | client_id: %v", c.config.ID)
+		return
+	}
+	var unknown []string
+	for _, document := range c.Summary().Winners {
+		if _, ok := c.sent.documents[document]; !ok {
+			unknown = append(unknown, document)
+			log.Warningf("action: verificar_ganadores | result: fail | client_id: %v | dni: %s", c.config.ID, PII(document))
+		}
+	}
+	c.run.winnersUnknown(unknown)
+	if len(unknown) == 0 {
+		log.Infof("action: verificar_ganadores | result: success | client_id: %v", c.config.ID)
+		return
+	}
+	log.Errorf("action: verificar_ganadores | result: fail | client_id: %v | desconocidos: %d", c.config.ID, len(unknown))
+}
+
+// QueryWinners asks the server for the winners of the agency without
+// uploading any bet, for when the upload happened in a previous run: it
+// only sends FINISHED, which the server answers with the winners once the
+// draw is done. Like SendBets it is cancelled along with ctx, by Cancel and
+// Close and by MaxDuration, and the winners are also reported by Summary. The returned
+// error is categorized as in SendBets.
+//
+// If WinnersCache is set, the winners received are cached there and
+// later queries for the same agency and DrawID are answered from the cache,
+// unless refresh is set.
+func (c *Client) QueryWinners(ctx context.Context, refresh bool) ([]string, error) {
+	if c.config.WinnersCache != "" && !refresh {
+		if winners, ok := c.cachedWinners(); ok {
+			c.run.start(c.config.ID)
+			c.run.winnersReceived(winners)
+			c.run.finish()
+			c.config.Hooks.winners(winners)
+			return c.Summary().Winners, nil
+		}
+	}
+	ctx, endRun, err := c.beginRun(ctx)
+	if err != nil {
+		return nil, newError(ErrCancelled, "consulta_ganadores", err)
+	}
+	defer endRun()
+	ctx, cancel := c.runContext(ctx)
+	defer cancel()
+	if err := c.queryWinners(ctx); err != nil {
+		c.config.Hooks.error(err)
+		if errors.Is(err, ErrTimeout) {
+			log.Errorf("action: consulta_ganadores | result: timeout | client_id: %v | max_duration: %v",
+				c.config.ID, c.config.MaxDuration)
+		}
+		return nil, err
+	}
+	winners := c.Summary().Winners
+	if c.config.WinnersCache != "" {
+		c.cacheWinners(winners)
+	}
+	return winners, nil
+}
+
+// queryWinners runs QueryWinners until ctx is done.
+func (c *Client) queryWinners(ctx context.Context) (err error) {
+	c.finishedSent = false
+	c.readErr = nil
+	c.ackSummary = nil
+	c.unacked = nil
+	c.connSeq = 0
+	c.reconnects = 0
+	c.pollUntil = time.Time{}
+	c.journal = nil
+	c.run.start(c.config.ID)
+	defer c.run.finish()
+	log.Infof("action: consulta_ganadores | result: in_progress | client_id: %v | trace_id: %s",
+		c.config.ID, c.Summary().TraceID)
+	c.startTrace()
+	defer func() { c.endTrace("query_winners", err) }()
+
+	if err := c.createClientSocket(ctx); err != nil {
+		if ctx.Err() != nil {
+			return classify("connect", ctx.Err())
+		}
+		return newError(ErrConnection, "connect", err)
+	}
+	defer func() {
+		c.connMu.Lock()
+		c.pool.discard(c.conn)
+		c.connMu.Unlock()
+	}()
+
+	readCtx, cancelRead := context.WithCancel(context.Background())
+	defer cancelRead()
+	readDone := make(chan struct{})
+	c.readDone = readDone
+	c.readResponse(readCtx, readDone)
+
+	if err := c.sendFinished(); err != nil {
+		return classify("send_finished", err)
+	}
+	select {
+	case <-ctx.Done():
+		cancelRead()
+		<-readDone
+		return classify("consulta_ganadores", ctx.Err())
+	case <-readDone:
+	}
+	return c.runError()
+}
@@ -0,0 +1,75 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+)
+
+// cachedWinners is an entry of the winners cache, see WinnersCache.
+type cachedWinners struct {
+	Documents []string  `json:"documents"`
+	CachedAt  time.Time `json:"cached_at"`
+}
+
+// winnersCacheKey identifies the winners of the agency in the configured
+// draw.
+func (c *Client) winnersCacheKey() string {
+	return c.config.ID + "/" + c.config.DrawID
+}
+
+// loadWinnersCache reads the entries of WinnersCache, by agency and
+// draw. A missing file is an empty cache.
+func (c *Client) loadWinnersCache() (map[string]cachedWinners, error) {
+	cache := make(map[string]cachedWinners)
+	data, err := os.ReadFile(c.config.WinnersCache)
+	if errors.Is(err, os.ErrNotExist) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// cachedWinners returns the winners of the agency stored in
+// WinnersCache, if any.
+func (c *Client) cachedWinners() ([]string, bool) {
+	cache, err := c.loadWinnersCache()
+	if err != nil {
+		log.Warningf("action: winners_cache | result: fail | path: %s | error: %v", c.config.WinnersCache, err)
+		return nil, false
+	}
+	entry, ok := cache[c.winnersCacheKey()]
+	if !ok {
+		return nil, false
+	}
+	log.Infof("action: winners_cache | result: hit | client_id: %v | draw: %s | cached_at: %s",
+		c.config.ID, c.config.DrawID, entry.CachedAt.Format(time.RFC3339))
+	return entry.Documents, true
+}
+
+// cacheWinners stores the winners of the agency in WinnersCache, which
+// is replaced atomically. Failures are only logged.
+func (c *Client) cacheWinners(winners []string) {
+	cache, err := c.loadWinnersCache()
+	if err != nil {
+		log.Warningf("action: winners_cache | result: fail | path: %s | error: %v", c.config.WinnersCache, err)
+		cache = make(map[string]cachedWinners)
+	}
+	cache[c.winnersCacheKey()] = cachedWinners{Documents: winners, CachedAt: time.Now().UTC()}
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err == nil {
+		tmpPath := c.config.WinnersCache + ".tmp"
+		if err = os.WriteFile(tmpPath, data, 0o644); err == nil {
+			err = os.Rename(tmpPath, c.config.WinnersCache)
+		}
+	}
+	if err != nil {
+		log.Errorf("action: winners_cache | result: fail | path: %s | error: %v", c.config.WinnersCache, err)
+	}
+}
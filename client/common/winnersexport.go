@@ -0,0 +1,78 @@
+package common
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WinnersExport is the winners of a run as written by ExportWinners.
+type WinnersExport struct {
+	AgencyID  string    `json:"agency_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Count     int       `json:"count"`
+	Documents []string  `json:"documents"`
+}
+
+// ExportWinners writes the winners received by the last run to path, as
+// JSON or CSV according to format ("json" or "csv"; empty picks JSON for a
+// .json path and CSV otherwise). The CSV has a header and a row per winner
+// with the agency, the timestamp, the count and the document. The file is
+// replaced atomically. It fails if the run is in progress or did not
+// receive the winners.
+func (c *Client) ExportWinners(path string, format string) error {
+	summary, finished := c.run.snapshot()
+	if !finished {
+		return errors.New("run is still in progress")
+	}
+	if !summary.Success {
+		return errors.New("the winners were not received")
+	}
+	if format == "" {
+		format = "csv"
+		if strings.EqualFold(filepath.Ext(path), ".json") {
+			format = "json"
+		}
+	}
+	export := WinnersExport{
+		AgencyID:  summary.AgencyID,
+		Timestamp: summary.FinishedAt.UTC().Truncate(time.Second),
+		Count:     len(summary.Winners),
+		Documents: summary.Winners,
+	}
+
+	var content bytes.Buffer
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(&content)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(export); err != nil {
+			return err
+		}
+	case "csv":
+		writer := csv.NewWriter(&content)
+		_ = writer.Write([]string{"agency_id", "timestamp", "count", "document"})
+		timestamp, count := export.Timestamp.Format(time.RFC3339), strconv.Itoa(export.Count)
+		for _, document := range export.Documents {
+			_ = writer.Write([]string{export.AgencyID, timestamp, count, document})
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown winners format %q", format)
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, content.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+// Log formats accepted by the log.format setting.
+const (
+	logFormatText   = "text"
+	logFormatJSON   = "json"
+	logFormatLogfmt = "logfmt"
+)
+
+// jsonBackend writes every log record as a JSON object on its own line, for
+// log pipelines such as ELK or Loki. The "key: value" segments of the
+// pipe-delimited messages (action, result, client_id, error...) become
+// fields of the object, after its time and level; a segment that is not a
+// "key: value" pair goes to the msg field.
+type jsonBackend struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (b *jsonBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	var line bytes.Buffer
+	line.WriteByte('{')
+	writeJSONField(&line, "time", rec.Time.Format(time.RFC3339Nano))
+	writeJSONField(&line, "level", level.String())
+	var msg []string
+	for _, segment := range strings.Split(rec.Message(), " | ") {
+		parts := strings.SplitN(segment, ": ", 2)
+		if len(parts) != 2 || parts[0] == "" || strings.ContainsAny(parts[0], " \t") {
+			msg = append(msg, segment)
+			continue
+		}
+		writeJSONField(&line, parts[0], parts[1])
+	}
+	if len(msg) > 0 {
+		writeJSONField(&line, "msg", strings.Join(msg, " | "))
+	}
+	line.WriteString("}\n")
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, err := b.out.Write(line.Bytes())
+	return err
+}
+
+// writeJSONField appends "key":"value" to the object being written to line.
+func writeJSONField(line *bytes.Buffer, key string, value string) {
+	if line.Len() > 1 {
+		line.WriteByte(',')
+	}
+	k, _ := json.Marshal(key)
+	v, _ := json.Marshal(value)
+	line.Write(k)
+	line.WriteByte(':')
+	line.Write(v)
+}
+
+// logfmtEvents maps the actions logged under a Spanish name to the stable
+// event names of the logfmt format. Other actions keep their name.
+var logfmtEvents = map[string]string{
+	"agencia":             "agency",
+	"agencias":            "agencies",
+	"bets_enviadas":       "bets_sent",
+	"consulta_ganadores":  "query_winners",
+	"export_ganadores":    "export_winners",
+	"ganadores":           "winners",
+	"leer_respuesta":      "read_response",
+	"resumen":             "summary",
+	"resumen_run":         "run_summary",
+	"verificar_ganadores": "verify_winners",
+}
+
+// logfmtKeys maps the keys logged under a Spanish or camel case name to the
+// stable keys of the logfmt format. Other keys keep their name.
+var logfmtKeys = map[string]string{
+	"agencyId":       "agency_id",
+	"cant_ganadores": "winners",
+	"desconocidos":   "unknown",
+	"documentos":     "documents",
+	"numero":         "number",
+}
+
+// logfmtBackend writes every log record as a logfmt line of key=value
+// pairs, for test harnesses asserting on fields rather than on the text
+// of the messages. Every line starts with time, level and event, the action
+// of the message under a stable English name; the "key: value" segments
+// follow, with their keys renamed likewise, and the segments that are not
+// "key: value" pairs go to a trailing msg field.
+type logfmtBackend struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (b *logfmtBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	var line bytes.Buffer
+	writeLogfmtField(&line, "time", rec.Time.Format(time.RFC3339Nano))
+	writeLogfmtField(&line, "level", level.String())
+	var msg []string
+	for _, segment := range strings.Split(rec.Message(), " | ") {
+		parts := strings.SplitN(segment, ": ", 2)
+		if len(parts) != 2 || parts[0] == "" || strings.ContainsAny(parts[0], " \t=\"") {
+			msg = append(msg, segment)
+			continue
+		}
+		key, value := parts[0], parts[1]
+		if key == "action" {
+			key = "event"
+			if event, ok := logfmtEvents[value]; ok {
+				value = event
+			}
+		} else if stable, ok := logfmtKeys[key]; ok {
+			key = stable
+		}
+		writeLogfmtField(&line, key, value)
+	}
+	if len(msg) > 0 {
+		writeLogfmtField(&line, "msg", strings.Join(msg, " | "))
+	}
+	line.WriteByte('\n')
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, err := b.out.Write(line.Bytes())
+	return err
+}
+
+// writeLogfmtField appends key=value to line, quoting the value if it is
+// empty or holds spaces, quotes, equal signs or control characters.
+func writeLogfmtField(line *bytes.Buffer, key string, value string) {
+	if line.Len() > 0 {
+		line.WriteByte(' ')
+	}
+	line.WriteString(key)
+	line.WriteByte('=')
+	if value == "" || strings.IndexFunc(value, func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == 0x7f
+	}) >= 0 {
+		value = strconv.Quote(value)
+	}
+	line.WriteString(value)
+}
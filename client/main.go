@@ -1,23 +1,31 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
 
 	"github.com/op/go-logging"
 	"github.com/spf13/viper"
 
 	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
 )
 
 var log = logging.MustGetLogger("log")
 
 // InitConfig Function that uses viper library to parse configuration parameters.
-// Viper is configured to read variables from both environment variables and the
-// config file ./config.yaml. Environment variables takes precedence over parameters
-// defined in the configuration file. If some of the variables cannot be parsed,
-// an error is returned
+// Viper is configured to read variables from environment variables, and from the
+// config file once ReadConfigFile is called. Environment variables takes precedence
+// over parameters defined in the configuration file. If some of the variables cannot
+// be parsed, an error is returned
 func InitConfig() (*viper.Viper, error) {
 	v := viper.New()
 
@@ -28,35 +36,143 @@ func InitConfig() (*viper.Viper, error) {
 	// use nested configurations in the config file and at the same time define
 	// env variables for the nested configurations
 	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.SetDefault("log.level", "INFO")
 
 	// Add env variables supported
+	v.BindEnv("config")
 	v.BindEnv("id")
-	v.BindEnv("server", "address")
-	v.BindEnv("log", "level")
-
-	// Try to read configuration from config file. If config file
-	// does not exists then ReadInConfig will fail but configuration
-	// can be loaded from the environment variables so we shouldn't
-	// return an error in that case
-	v.SetConfigFile("./config.yaml")
+	v.BindEnv("log.progress")
+	v.BindEnv("log.rate")
+	v.BindEnv("results.path")
+	v.BindEnv("otlp.endpoint")
+	v.BindEnv("capture.path")
+	v.BindEnv("pprof.address")
+	v.BindEnv("agencies")
+	v.BindEnv("server.address")
+	v.BindEnv("server.websocket")
+	v.BindEnv("server.connectRetries")
+	v.BindEnv("server.maxReconnects")
+	v.BindEnv("server.connectBackoff")
+	v.BindEnv("server.connectTimeout")
+	v.BindEnv("server.bandwidthLimit")
+	v.BindEnv("server.connections")
+	v.BindEnv("log.level")
+	v.BindEnv("log.format")
+	v.BindEnv("log.redact")
+	v.BindEnv("batch.maxAmount")
+	v.BindEnv("bets.path")
+	v.BindEnv("bets.openRetryPeriod")
+	v.BindEnv("bets.gzip")
+	v.BindEnv("bets.format")
+	v.BindEnv("bets.encoding")
+	v.BindEnv("bets.dryRun")
+	v.BindEnv("bets.tolerant")
+	v.BindEnv("bets.rejectsPath")
+	v.BindEnv("bets.maxErrors")
+	v.BindEnv("bets.rateLimit")
+	v.BindEnv("bets.pipeline")
+	v.BindEnv("bets.duplicates")
+	v.BindEnv("bets.generate")
+	v.BindEnv("bets.checkpoint")
+	v.BindEnv("bets.maxRetransmits")
+	v.BindEnv("protocol.window")
+	v.BindEnv("protocol.ackMode")
+	v.BindEnv("protocol.telemetry")
+	v.BindEnv("protocol.winnersDeadline")
+	v.BindEnv("protocol.ackTimeout")
+	v.BindEnv("protocol.winnersTimeout")
+	v.BindEnv("protocol.writeTimeout")
+	v.BindEnv("bets.deadLetterPath")
+	v.BindEnv("sql.driver")
+	v.BindEnv("sql.query")
+	v.BindEnv("anonymize.nombre")
+	v.BindEnv("anonymize.apellido")
+	v.BindEnv("anonymize.documento")
+	v.BindEnv("anonymize.salt")
+	v.BindEnv("shard.startLine")
+	v.BindEnv("shard.endLine")
+	v.BindEnv("shard.index")
+	v.BindEnv("shard.count")
+	v.BindEnv("csv.comma")
+	v.BindEnv("csv.lazyQuotes")
+	v.BindEnv("csv.fieldsPerRecord")
+	v.BindEnv("protocol.resync")
+	v.BindEnv("protocol.maxFrameSize")
+	v.BindEnv("protocol.codec")
+	v.BindEnv("http.address")
+	v.BindEnv("http.stallTimeout")
+	v.BindEnv("http.resultWindow")
+	v.BindEnv("bundle.path")
+	v.BindEnv("bundle.key")
+	v.BindEnv("watch.dir")
+	v.BindEnv("daemon.dir")
+	v.BindEnv("watch.settle")
+	v.BindEnv("run.maxDuration")
+	v.BindEnv("run.cancelMode")
+	v.BindEnv("run.drainTimeout")
+	v.BindEnv("run.memoryBudget")
+	v.BindEnv("journal.path")
+	v.BindEnv("journal.retry")
+	v.BindEnv("winners.path")
+	v.BindEnv("winners.format")
+	v.BindEnv("winners.cache")
+	v.BindEnv("winners.draw")
+	v.BindEnv("tcp.keepAlive")
+	v.BindEnv("tcp.noDelay")
+	v.BindEnv("tcp.readBuffer")
+	v.BindEnv("tcp.writeBuffer")
+	v.BindEnv("tls.enabled")
+	v.BindEnv("tls.ca")
+	v.BindEnv("tls.cert")
+	v.BindEnv("tls.key")
+	v.BindEnv("tls.serverName")
+	v.BindEnv("tls.insecureSkipVerify")
+
+	return v, nil
+}
+
+// defaultConfigFile is the config file read unless another one is given with
+// --config or CLI_CONFIG.
+const defaultConfigFile = "./config.yaml"
+
+// ReadConfigFile reads the config file at path into v, or ./config.yaml if
+// path is empty. If ./config.yaml does not exist the configuration can still
+// be loaded from the environment variables, so that is not an error; a file
+// that was asked for explicitly must be readable.
+func ReadConfigFile(v *viper.Viper, path string) error {
+	if path != "" {
+		v.SetConfigFile(path)
+		return v.ReadInConfig()
+	}
+	v.SetConfigFile(defaultConfigFile)
 	if err := v.ReadInConfig(); err != nil {
 		fmt.Printf("Configuration could not be read from config file. Using env variables instead")
 	}
-
-	return v, nil
+	return nil
 }
 
-// InitLogger Receives the log level to be set in go-logging as a string. This method
-// parses the string and set the level to the logger. If the level string is not
-// valid an error is returned
-func InitLogger(logLevel string) error {
-	baseBackend := logging.NewLogBackend(os.Stdout, "", 0)
-	format := logging.MustStringFormatter(
-		`%{time:2006-01-02 15:04:05} %{level:.5s}     %{message}`,
-	)
-	backendFormatter := logging.NewBackendFormatter(baseBackend, format)
+// InitLogger Receives the log level to be set in go-logging as a string, the
+// writer the logs go to and their format, text (the default), json or logfmt. This
+// method parses the string and set the level to the logger. If the level string
+// or the format are not valid an error is returned
+func InitLogger(out io.Writer, logLevel string, logFormat string) error {
+	var backend logging.Backend
+	switch logFormat {
+	case "", logFormatText:
+		baseBackend := logging.NewLogBackend(out, "", 0)
+		format := logging.MustStringFormatter(
+			`%{time:2006-01-02 15:04:05} %{level:.5s}     %{message}`,
+		)
+		backend = logging.NewBackendFormatter(baseBackend, format)
+	case logFormatJSON:
+		backend = &jsonBackend{out: out}
+	case logFormatLogfmt:
+		backend = &logfmtBackend{out: out}
+	default:
+		return fmt.Errorf("unknown log format %q", logFormat)
+	}
 
-	backendLeveled := logging.AddModuleLevel(backendFormatter)
+	backendLeveled := logging.AddModuleLevel(backend)
 	logLevelCode, err := logging.LogLevel(logLevel)
 	if err != nil {
 		return err
@@ -82,25 +198,319 @@ func main() {
 	v, err := InitConfig()
 	if err != nil {
 		log.Criticalf("%s", err)
-		return
+		os.Exit(exitConfig)
 	}
 
-	if err := InitLogger(v.GetString("log.level")); err != nil {
-		log.Criticalf("%s", err)
-		return
+	// SIGTERM and SIGINT cancel the run as configured by run.cancelMode
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	err = NewRootCommand(v).ExecuteContext(ctx)
+	stop()
+	// A failed command exits with the code of its error category (see exitCode)
+	if err != nil {
+		os.Exit(exitCode(err))
 	}
+}
 
-	// Print program config with debugging purposes
-	PrintConfig(v)
+// BuildClientConfig builds the configuration of the client from v.
+func BuildClientConfig(v *viper.Viper) (common.ClientConfig, error) {
+	codec, err := protocol.CodecByName(v.GetString("protocol.codec"))
+	if err != nil {
+		return common.ClientConfig{}, err
+	}
+	comma, err := ParseDelimiter(v.GetString("csv.comma"))
+	if err != nil {
+		return common.ClientConfig{}, err
+	}
+	maxErrors, err := common.ParseErrorThreshold(v.GetString("bets.maxErrors"))
+	if err != nil {
+		return common.ClientConfig{}, err
+	}
+	anonymizers, err := ParseAnonymizers(v)
+	if err != nil {
+		return common.ClientConfig{}, err
+	}
+
+	// The bets file may also be an http(s) URL
+	betsPath := v.GetString("bets.path")
+	if betsPath == "" {
+		betsPath = "./bets.csv"
+	}
+
+	return common.ClientConfig{
+		ServerAddress:   v.GetString("server.address"),
+		WebSocketURL:    v.GetString("server.websocket"),
+		ID:              v.GetString("id"),
+		BetsFilePath:    betsPath,
+		BatchLimit:      v.GetInt32("batch.maxAmount"),
+		OpenRetryPeriod: v.GetDuration("bets.openRetryPeriod"),
+		GzipInput:       v.GetBool("bets.gzip"),
+		InputFormat:     common.InputFormat(v.GetString("bets.format")),
+		InputEncoding:   v.GetString("bets.encoding"),
+		DryRun:          v.GetBool("bets.dryRun"),
+		Tolerant:        v.GetBool("bets.tolerant"),
+		RejectsPath:     v.GetString("bets.rejectsPath"),
+		MaxErrors:       maxErrors,
+		RateLimit:       v.GetFloat64("bets.rateLimit"),
+		Pipeline:        v.GetBool("bets.pipeline"),
+		Connections:     v.GetInt("server.connections"),
+		BandwidthLimit:  v.GetInt64("server.bandwidthLimit"),
+		Duplicates:      common.DuplicateMode(v.GetString("bets.duplicates")),
+		Generate:        v.GetInt64("bets.generate"),
+		Anonymize:       anonymizers,
+		CheckpointPath:  v.GetString("bets.checkpoint"),
+		Window:          v.GetInt("protocol.window"),
+		AckTimeout:      v.GetDuration("protocol.ackTimeout"),
+		WinnersTimeout:  v.GetDuration("protocol.winnersTimeout"),
+		WriteTimeout:    v.GetDuration("protocol.writeTimeout"),
+		MaxRetransmits:  v.GetInt("bets.maxRetransmits"),
+		DeadLetterPath:  v.GetString("bets.deadLetterPath"),
+		Resync:          common.ResyncMode(v.GetString("protocol.resync")),
+		MaxReconnects:   v.GetInt("server.maxReconnects"),
+		ConnectRetries:  v.GetInt("server.connectRetries"),
+		ConnectBackoff:  v.GetDuration("server.connectBackoff"),
+		ConnectTimeout:  v.GetDuration("server.connectTimeout"),
+		MaxDuration:     v.GetDuration("run.maxDuration"),
+		CancelMode:      common.CancelMode(v.GetString("run.cancelMode")),
+		DrainTimeout:    v.GetDuration("run.drainTimeout"),
+		MemoryBudget:    v.GetInt64("run.memoryBudget"),
+		JournalPath:     v.GetString("journal.path"),
+		JournalRetry:    v.GetDuration("journal.retry"),
+		AckMode:         common.AckMode(v.GetString("protocol.ackMode")),
+		WinnersDeadline: v.GetDuration("protocol.winnersDeadline"),
+		WinnersCache:    v.GetString("winners.cache"),
+		ProgressPeriod:  v.GetDuration("log.progress"),
+		RateLogPeriod:   v.GetDuration("log.rate"),
+		ResultsPath:     v.GetString("results.path"),
+		OTLPEndpoint:    v.GetString("otlp.endpoint"),
+		AuditTrail:      v.GetString("bundle.path") != "",
+		StallTimeout:    v.GetDuration("http.stallTimeout"),
+		Telemetry:       v.GetBool("protocol.telemetry"),
+		CapturePath:     v.GetString("capture.path"),
+		DrawID:          v.GetString("winners.draw"),
+		MaxFrameSize:    v.GetInt("protocol.maxFrameSize"),
+		Codec:           codec,
+		Shard: common.Shard{
+			StartLine: v.GetInt("shard.startLine"),
+			EndLine:   v.GetInt("shard.endLine"),
+			Index:     v.GetInt("shard.index"),
+			Count:     v.GetInt("shard.count"),
+		},
+		CSV: common.CSVOptions{
+			Comma:           comma,
+			LazyQuotes:      v.GetBool("csv.lazyQuotes"),
+			FieldsPerRecord: v.GetInt("csv.fieldsPerRecord"),
+		},
+		SQL: common.SQLOptions{
+			Driver: v.GetString("sql.driver"),
+			Query:  v.GetString("sql.query"),
+		},
+		TCP: common.TCPOptions{
+			KeepAlive:   v.GetDuration("tcp.keepAlive"),
+			Delay:       v.IsSet("tcp.noDelay") && !v.GetBool("tcp.noDelay"),
+			ReadBuffer:  v.GetInt("tcp.readBuffer"),
+			WriteBuffer: v.GetInt("tcp.writeBuffer"),
+		},
+		TLS: common.TLSOptions{
+			Enabled:            v.GetBool("tls.enabled"),
+			CAFile:             v.GetString("tls.ca"),
+			CertFile:           v.GetString("tls.cert"),
+			KeyFile:            v.GetString("tls.key"),
+			ServerName:         v.GetString("tls.serverName"),
+			InsecureSkipVerify: v.GetBool("tls.insecureSkipVerify"),
+		},
+	}, nil
+}
 
-	clientConfig := common.ClientConfig{
-		ServerAddress: v.GetString("server.address"),
-		ID:            v.GetString("id"),
-		BetsFilePath:  "./bets.csv",
-		BatchLimit:    v.GetInt32("batch.maxAmount"),
+// ReloadOnSighup reads the configuration again on every SIGHUP, from the
+// config file at configPath (see ReadConfigFile) and the environment, and
+// applies the settings a running client can change: the log level, the rate
+// limits and the batch limit. The returned function stops listening for SIGHUP.
+func ReloadOnSighup(client *common.Client, logLevel string, configPath string) func() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-hup:
+			}
+			v, err := InitConfig()
+			if err == nil {
+				err = ReadConfigFile(v, configPath)
+			}
+			if err != nil {
+				log.Errorf("action: reload | result: fail | error: %v", err)
+				continue
+			}
+			if level := v.GetString("log.level"); level != logLevel {
+				code, err := logging.LogLevel(level)
+				if err != nil {
+					log.Errorf("action: reload | result: fail | error: %v", err)
+					continue
+				}
+				logging.SetLevel(code, "")
+				log.Infof("action: reload | result: success | log_level: %s -> %s", logLevel, level)
+				logLevel = level
+			}
+			_ = client.Reload(common.ReloadableConfig{
+				BatchLimit:     v.GetInt32("batch.maxAmount"),
+				RateLimit:      v.GetFloat64("bets.rateLimit"),
+				BandwidthLimit: v.GetInt64("server.bandwidthLimit"),
+			})
+		}
+	}()
+	return func() {
+		signal.Stop(hup)
+		close(done)
 	}
+}
+
+// ToggleDebugOnSignal switches the log level to DEBUG on every SIGUSR1, or
+// back to logLevel if it already is DEBUG, for the rest of the process.
+func ToggleDebugOnSignal(logLevel string) {
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	go func() {
+		for range usr1 {
+			level := "DEBUG"
+			if logging.GetLevel("") == logging.DEBUG {
+				level = logLevel
+			}
+			if err := common.SetLogLevel(level); err != nil {
+				log.Errorf("action: log_level | result: fail | error: %v", err)
+			}
+		}
+	}()
+}
+
+// Exit codes of the client, so scripts can tell the outcome of a run apart
+// without reading the logs.
+const (
+	exitFailure    = 1 // failures without a more specific code
+	exitTimeout    = 2 // run.maxDuration elapsed
+	exitConfig     = 3 // invalid configuration or command line
+	exitInput      = 4 // unreadable or invalid bets input
+	exitConnection = 5 // the server could not be reached or the connection was lost
+	exitProtocol   = 6 // malformed or inconsistent server responses
+	exitPartial    = 7 // the server rejected some batches
+	exitCancelled  = 8 // SIGTERM or SIGINT
+)
 
-	client := common.NewClient(clientConfig)
+// errConfig marks the configuration errors found once the run started,
+// e.g. by RunAgencies.
+var errConfig = errors.New("invalid configuration")
 
-	client.SendBets()
+// exitCode returns the exit code of a run that failed with err, according
+// to its category (see common.Error).
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, errConfig):
+		return exitConfig
+	case errors.Is(err, common.ErrTimeout):
+		return exitTimeout
+	case errors.Is(err, common.ErrCancelled):
+		return exitCancelled
+	case errors.Is(err, common.ErrInput):
+		return exitInput
+	case errors.Is(err, common.ErrConnection):
+		return exitConnection
+	case errors.Is(err, common.ErrProtocol):
+		return exitProtocol
+	case errors.Is(err, common.ErrServerRejected):
+		return exitPartial
+	default:
+		return exitFailure
+	}
+}
+
+// ParseDelimiter converts the csv.comma setting to a rune. An empty value
+// keeps the default delimiter; otherwise it must be a single character.
+func ParseDelimiter(s string) (rune, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if utf8.RuneCountInString(s) != 1 {
+		return 0, fmt.Errorf("csv delimiter must be a single character, got %q", s)
+	}
+	r, _ := utf8.DecodeRuneInString(s)
+	return r, nil
+}
+
+// ParseAnonymizers builds the per field anonymizers from the anonymize
+// section, where each bet field names a built-in anonymizer (see
+// common.AnonymizerByName).
+func ParseAnonymizers(v *viper.Viper) (map[string]common.Anonymizer, error) {
+	anonymizers := map[string]common.Anonymizer{}
+	for _, field := range []string{protocol.FirstNameKey, protocol.LastNameKey, protocol.DocumentKey} {
+		name := v.GetString("anonymize." + strings.ToLower(field))
+		anonymizer, err := common.AnonymizerByName(name, v.GetString("anonymize.salt"))
+		if err != nil {
+			return nil, fmt.Errorf("anonymize.%s: %w", strings.ToLower(field), err)
+		}
+		if anonymizer != nil {
+			anonymizers[field] = anonymizer
+		}
+	}
+	return anonymizers, nil
+}
+
+// ServeResultWindow keeps the process (and thus the HTTP listener) alive for
+// window so orchestration scripts can fetch /result. Cancelling ctx ends it early.
+func ServeResultWindow(ctx context.Context, window time.Duration) {
+	if window <= 0 {
+		return
+	}
+	log.Infof("action: serve_result | result: in_progress | window: %v", window)
+	select {
+	case <-ctx.Done():
+	case <-time.After(window):
+	}
+	log.Infof("action: serve_result | result: success")
+}
+
+// QueryWinners asks the server for the winners of the agency without
+// uploading any bet, or the winners cache unless refresh is set, and logs
+// their documents.
+func QueryWinners(ctx context.Context, client *common.Client, refresh bool) error {
+	winners, err := client.QueryWinners(ctx, refresh)
+	if err != nil {
+		log.Errorf("action: consulta_ganadores | result: fail | error: %v", err)
+		return err
+	}
+	log.Infof("action: ganadores | result: success | cant_ganadores: %d | documentos: %s",
+		len(winners), common.PIIList(winners))
+	return nil
+}
+
+// ExportWinners writes the winners of the last run to path in the given
+// format (see common.Client.ExportWinners) and logs the outcome. An empty
+// path disables the export.
+func ExportWinners(client *common.Client, path string, format string) {
+	if path == "" {
+		return
+	}
+	if err := client.ExportWinners(path, format); err != nil {
+		log.Errorf("action: export_ganadores | result: fail | path: %s | error: %v", path, err)
+		return
+	}
+	log.Infof("action: export_ganadores | result: success | path: %s", path)
+}
+
+// VerifyBundle checks the audit bundle at path with the configured key and
+// logs the outcome.
+func VerifyBundle(path string, key string) error {
+	manifest, err := common.VerifyAuditBundle(path, key)
+	if err != nil {
+		log.Errorf("action: verify_bundle | result: fail | path: %s | error: %v", path, err)
+		return err
+	}
+	fingerprint := manifest.InputFingerprint
+	if fingerprint == "" {
+		fingerprint = "none (" + manifest.FingerprintNote + ")"
+	}
+	log.Infof("action: verify_bundle | result: success | path: %s | trace_id: %s | agency: %s | input_sha256: %s",
+		path, manifest.TraceID, manifest.AgencyID, fingerprint)
+	return nil
 }
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// ReplayOptions sets the pace of Replay.
+// - Speed: scales the gaps between the frames of a capture file, so 2
+// replays them twice as fast; 0 ignores the captured timing.
+// - Interval: gap between frames when the captured timing is not used, or
+// the input is a raw frame stream, which has no timestamps.
+// - Wait: how long the responses of the server are read once every frame
+// was sent.
+// - ConnectTimeout: bounds the dial to the server (0 = no limit).
+type ReplayOptions struct {
+	Speed          float64
+	Interval       time.Duration
+	Wait           time.Duration
+	ConnectTimeout time.Duration
+}
+
+// Replay sends the client frames of in, a capture file or a raw frame
+// stream as accepted by the decode command, to the server at address over
+// one connection, at the pace set by options. Frames the server sent in a
+// capture file are skipped. The frames are sent as they were captured, so
+// the server sees the same agency, batches and correlations. Meanwhile the
+// responses of the server are read and counted, until it closes the
+// connection or options.Wait elapses after the last frame.
+func Replay(ctx context.Context, address string, in io.Reader, codec protocol.Codec, options ReplayOptions) error {
+	next, err := replayFrames(bufio.NewReader(in))
+	if err != nil {
+		return fmt.Errorf("%w: %v", common.ErrInput, err)
+	}
+	dialer := net.Dialer{Timeout: options.ConnectTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		log.Errorf("action: replay | result: fail | server_address: %s | error: %v", address, err)
+		return fmt.Errorf("%w: %v", common.ErrConnection, err)
+	}
+	defer conn.Close()
+	log.Infof("action: replay | result: in_progress | server_address: %s", address)
+
+	received := make(map[protocol.OpCode]int)
+	var receivedMu sync.Mutex
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			frame, err := protocol.ReadFrame(conn)
+			if err != nil {
+				return
+			}
+			receivedMu.Lock()
+			received[frame.OpCode]++
+			receivedMu.Unlock()
+			msg, err := frame.Decode(codec)
+			if err != nil {
+				log.Debugf("action: replay_receive | result: fail | opcode: %v | length: %d | error: %v",
+					frame.OpCode, len(frame.Body), err)
+				continue
+			}
+			log.Debugf("action: replay_receive | result: success | opcode: %v | length: %d | msg: %v",
+				frame.OpCode, len(frame.Body), msg)
+		}
+	}()
+
+	start := time.Now()
+	var sent, sentBytes int
+	var last time.Time
+	for {
+		frame, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Errorf("action: replay | result: fail | frames_sent: %d | error: %v", sent, err)
+			return fmt.Errorf("%w: frame %d: %v", common.ErrInput, sent+1, err)
+		}
+		gap := options.Interval
+		if options.Speed > 0 && !frame.Time.IsZero() {
+			gap = 0
+			if !last.IsZero() {
+				gap = time.Duration(float64(frame.Time.Sub(last)) / options.Speed)
+			}
+			last = frame.Time
+		}
+		if sent > 0 && gap > 0 {
+			timer := time.NewTimer(gap)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				log.Errorf("action: replay | result: fail | frames_sent: %d | error: %v", sent, ctx.Err())
+				return fmt.Errorf("%w: %v", common.ErrCancelled, ctx.Err())
+			case <-timer.C:
+			}
+		}
+		n, err := conn.Write(frame.Bytes())
+		sentBytes += n
+		if err != nil {
+			log.Errorf("action: replay | result: fail | frames_sent: %d | error: %v", sent, err)
+			return fmt.Errorf("%w: %v", common.ErrConnection, err)
+		}
+		sent++
+		log.Debugf("action: replay_send | result: success | opcode: %v | length: %d", frame.OpCode, len(frame.Body))
+	}
+
+	conn.SetReadDeadline(time.Now().Add(options.Wait))
+	select {
+	case <-done:
+	case <-ctx.Done():
+		conn.Close()
+		<-done
+	}
+	receivedMu.Lock()
+	defer receivedMu.Unlock()
+	log.Infof("action: replay | result: success | frames_sent: %d | bytes_sent: %d | duration: %v | received: %s",
+		sent, sentBytes, time.Since(start).Round(time.Millisecond), formatOpCodeCounts(received))
+	return nil
+}
+
+// replayFrame is a client frame to replay, with the time it was captured
+// at, if known.
+type replayFrame struct {
+	*protocol.Frame
+	Time time.Time
+}
+
+// replayFrames returns a function returning the client frames of in one at
+// a time, and io.EOF once they ran out.
+func replayFrames(in *bufio.Reader) (func() (replayFrame, error), error) {
+	if !protocol.IsCapture(in) {
+		return func() (replayFrame, error) {
+			frame, err := protocol.ReadFrame(in)
+			if err != nil {
+				return replayFrame{}, err
+			}
+			return replayFrame{Frame: frame}, nil
+		}, nil
+	}
+	capture, err := protocol.NewCaptureReader(in)
+	if err != nil {
+		return nil, err
+	}
+	return func() (replayFrame, error) {
+		for {
+			frame, err := capture.Next()
+			if err != nil {
+				return replayFrame{}, err
+			}
+			if frame.Direction == protocol.ClientToServer {
+				return replayFrame{Frame: frame.Frame, Time: frame.Time}, nil
+			}
+		}
+	}, nil
+}
+
+// formatOpCodeCounts renders counts as opcode=count pairs sorted by opcode,
+// or none if empty.
+func formatOpCodeCounts(counts map[protocol.OpCode]int) string {
+	if len(counts) == 0 {
+		return "none"
+	}
+	pairs := make([]string, 0, len(counts))
+	for op, n := range counts {
+		pairs = append(pairs, fmt.Sprintf("%v=%d", op, n))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
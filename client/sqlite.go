@@ -0,0 +1,11 @@
+//go:build sqlite
+// +build sqlite
+
+package main
+
+// The SQLite driver backs the "sqlite" bets format. It needs cgo, so it is
+// only linked when building with the sqlite tag:
+//
+//	go get github.com/mattn/go-sqlite3 && go mod vendor
+//	go build -tags sqlite ./client
+import _ "github.com/mattn/go-sqlite3"
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// defaultTuneAckTimeout is used when TuneOptions.AckTimeout is unset.
+const defaultTuneAckTimeout = 10 * time.Second
+
+// tuneTolerance is the fraction of the best throughput within which Tune
+// prefers the BatchLimit with the lowest ack latency.
+const tuneTolerance = 0.05
+
+// TuneOptions sets the trials run by Tune.
+// - Limits: BatchLimit values tried, in order.
+// - Bets: synthetic bets uploaded by each trial.
+// - Codec, MaxFrameSize: encoding of the batches, as configured for uploads.
+// - Window: batches in flight before waiting for an ack (0 = no limit).
+// - ConnectTimeout: bounds the dial of each trial (0 = no limit).
+// - AckTimeout: how long a trial waits for the acks once every batch was sent (0 = 10s).
+type TuneOptions struct {
+	Limits         []int32
+	Bets           int64
+	Codec          protocol.Codec
+	MaxFrameSize   int
+	Window         int
+	ConnectTimeout time.Duration
+	AckTimeout     time.Duration
+}
+
+// TuneResult is the outcome of the trial of one BatchLimit: the batches
+// sent, how long the upload took from the first write to the last ack, and
+// the percentiles of the time each batch waited for its ack.
+type TuneResult struct {
+	BatchLimit int32
+	Bets       int64
+	Batches    int
+	Duration   time.Duration
+	LatencyP50 time.Duration
+	LatencyP99 time.Duration
+	Err        error
+}
+
+// Throughput returns the bets stored per second.
+func (r TuneResult) Throughput() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bets) / r.Duration.Seconds()
+}
+
+// Tune uploads the same Bets random valid bets of agency id once per
+// BatchLimit of options.Limits, each time on a new connection to address,
+// and returns the result of every trial. FINISHED is never sent, so the
+// draw is not triggered, but the server stores the bets like any others:
+// tune against a test server. A trial that fails does not stop the others.
+func Tune(ctx context.Context, id string, address string, options TuneOptions) ([]TuneResult, error) {
+	bets, err := tuneBets(id, options.Bets)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]TuneResult, 0, len(options.Limits))
+	for _, limit := range options.Limits {
+		if ctx.Err() != nil {
+			return results, fmt.Errorf("%w: %v", common.ErrCancelled, ctx.Err())
+		}
+		result := tuneTrial(ctx, address, bets, limit, options)
+		if result.Err != nil {
+			log.Errorf("action: tune | result: fail | batch_limit: %d | error: %v", limit, result.Err)
+		} else {
+			log.Infof("action: tune | result: success | batch_limit: %d | batches: %d | duration: %v | bets_per_sec: %.0f | ack_p50: %v | ack_p99: %v",
+				limit, result.Batches, result.Duration.Round(time.Millisecond), result.Throughput(), result.LatencyP50.Round(time.Microsecond), result.LatencyP99.Round(time.Microsecond))
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// tuneBets generates n random valid bets of agency id.
+func tuneBets(id string, n int64) ([]*protocol.Bet, error) {
+	var generated bytes.Buffer
+	if err := common.WriteGeneratedBets(&generated, n); err != nil {
+		return nil, err
+	}
+	records, err := csv.NewReader(&generated).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	bets := make([]*protocol.Bet, len(records))
+	for i, record := range records {
+		bet, err := protocol.NewBet(id, record[0], record[1], record[2], record[3], record[4])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", common.ErrInput, err)
+		}
+		bets[i] = bet
+	}
+	return bets, nil
+}
+
+// tuneAcks tracks the batches of a trial: when each one was written and
+// how long it waited for its ack, acks arriving in batch order.
+type tuneAcks struct {
+	mu        sync.Mutex
+	sentAt    []time.Time
+	latencies []time.Duration
+	lastAck   time.Time
+	failed    int
+	err       error         // why the reader stopped, set before done is closed
+	acked     chan struct{} // signalled on every ack
+	done      chan struct{} // closed once the reader stopped
+	window    chan struct{} // a slot per batch in flight, nil if unbounded
+}
+
+// tuneTrial uploads bets in batches of limit bets and waits for their acks.
+func tuneTrial(ctx context.Context, address string, bets []*protocol.Bet, limit int32, options TuneOptions) TuneResult {
+	result := TuneResult{BatchLimit: limit, Bets: int64(len(bets))}
+	dialer := net.Dialer{Timeout: options.ConnectTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		result.Err = fmt.Errorf("%w: %v", common.ErrConnection, err)
+		return result
+	}
+	defer conn.Close()
+
+	acks := &tuneAcks{acked: make(chan struct{}, 1), done: make(chan struct{})}
+	if options.Window > 0 {
+		acks.window = make(chan struct{}, options.Window)
+	}
+	go acks.read(conn, options.Codec)
+
+	var batch bytes.Buffer
+	var betsCounter int32
+	for _, bet := range bets {
+		if betsCounter == limit {
+			// The bet flushes the batch: wait for a slot in the window.
+			if err := acks.acquire(ctx); err != nil {
+				result.Err = err
+				return result
+			}
+			acks.sent()
+		}
+		fields := protocol.GetFields(bet)
+		err := protocol.AddBetWithFlush(fields, &batch, conn, &betsCounter, limit, options.MaxFrameSize, options.Codec, nil)
+		protocol.PutFields(fields)
+		if err != nil {
+			result.Err = fmt.Errorf("%w: %v", common.ErrConnection, err)
+			return result
+		}
+	}
+	if betsCounter > 0 {
+		if err := acks.acquire(ctx); err != nil {
+			result.Err = err
+			return result
+		}
+		acks.sent()
+		if err := protocol.FlushBatch(&batch, conn, betsCounter, options.MaxFrameSize, options.Codec, nil); err != nil {
+			result.Err = fmt.Errorf("%w: %v", common.ErrConnection, err)
+			return result
+		}
+	}
+
+	ackTimeout := options.AckTimeout
+	if ackTimeout <= 0 {
+		ackTimeout = defaultTuneAckTimeout
+	}
+	conn.SetReadDeadline(time.Now().Add(ackTimeout))
+	if err := acks.wait(ctx); err != nil {
+		result.Err = err
+		return result
+	}
+
+	acks.mu.Lock()
+	defer acks.mu.Unlock()
+	result.Batches = len(acks.sentAt)
+	result.Duration = acks.lastAck.Sub(acks.sentAt[0])
+	sort.Slice(acks.latencies, func(i, j int) bool { return acks.latencies[i] < acks.latencies[j] })
+	result.LatencyP50 = percentile(acks.latencies, 50)
+	result.LatencyP99 = percentile(acks.latencies, 99)
+	if acks.failed > 0 {
+		result.Err = fmt.Errorf("%w: %d of %d batches rejected", common.ErrServerRejected, acks.failed, result.Batches)
+	}
+	return result
+}
+
+// read reads the responses of the server until the connection is closed,
+// matching each ack with the oldest batch waiting for it.
+func (a *tuneAcks) read(conn net.Conn, codec protocol.Codec) {
+	defer close(a.done)
+	reader := bufio.NewReader(conn)
+	for {
+		msg, err := protocol.ReadMessageCodec(reader, codec)
+		if err != nil {
+			a.mu.Lock()
+			a.err = err
+			a.mu.Unlock()
+			return
+		}
+		var success bool
+		switch msg.GetOpCode() {
+		case protocol.BetsRecvSuccessOpCode:
+			success = true
+		case protocol.BetsRecvFailOpCode:
+			success = false
+		case protocol.AckOpCode:
+			success = msg.(*protocol.Ack).Success()
+		default:
+			continue
+		}
+		a.mu.Lock()
+		now := time.Now()
+		if i := len(a.latencies); i < len(a.sentAt) {
+			a.latencies = append(a.latencies, now.Sub(a.sentAt[i]))
+		}
+		a.lastAck = now
+		if !success {
+			a.failed++
+		}
+		a.mu.Unlock()
+		if a.window != nil {
+			<-a.window
+		}
+		select {
+		case a.acked <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// acquire takes a slot of the window for the next batch, waiting while the
+// window is full.
+func (a *tuneAcks) acquire(ctx context.Context) error {
+	if a.window == nil {
+		return nil
+	}
+	select {
+	case a.window <- struct{}{}:
+		return nil
+	case <-a.done:
+		return a.readError()
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %v", common.ErrCancelled, ctx.Err())
+	}
+}
+
+// sent records that a batch is about to be written, before its ack can
+// arrive.
+func (a *tuneAcks) sent() {
+	a.mu.Lock()
+	a.sentAt = append(a.sentAt, time.Now())
+	a.mu.Unlock()
+}
+
+// wait blocks until every batch written was acknowledged.
+func (a *tuneAcks) wait(ctx context.Context) error {
+	for {
+		a.mu.Lock()
+		pending := len(a.sentAt) - len(a.latencies)
+		a.mu.Unlock()
+		if pending == 0 {
+			return nil
+		}
+		select {
+		case <-a.acked:
+		case <-a.done:
+			return a.readError()
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %v", common.ErrCancelled, ctx.Err())
+		}
+	}
+}
+
+// readError wraps the error that stopped the reader before every batch was
+// acknowledged.
+func (a *tuneAcks) readError() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var netErr net.Error
+	if errors.As(a.err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w: %d of %d batches not acknowledged", common.ErrTimeout, len(a.sentAt)-len(a.latencies), len(a.sentAt))
+	}
+	if a.err == nil || errors.Is(a.err, io.EOF) {
+		return fmt.Errorf("%w: server closed the connection before every batch was acknowledged", common.ErrConnection)
+	}
+	return fmt.Errorf("%w: %v", common.ErrConnection, a.err)
+}
+
+// percentile returns the p-th percentile of sorted, or 0 if it is empty.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[(len(sorted)-1)*p/100]
+}
+
+// RecommendBatchLimit returns the BatchLimit of the successful trial with
+// the highest throughput or, among the ones within tuneTolerance of it, the
+// lowest ack latency. It reports false if every trial failed.
+func RecommendBatchLimit(results []TuneResult) (int32, bool) {
+	best := -1.0
+	for _, result := range results {
+		if result.Err == nil && result.Throughput() > best {
+			best = result.Throughput()
+		}
+	}
+	var recommended *TuneResult
+	for i, result := range results {
+		if result.Err != nil || result.Throughput() < best*(1-tuneTolerance) {
+			continue
+		}
+		if recommended == nil || result.LatencyP99 < recommended.LatencyP99 {
+			recommended = &results[i]
+		}
+	}
+	if recommended == nil {
+		return 0, false
+	}
+	return recommended.BatchLimit, true
+}
+
+// WriteTuneReport writes a table of results to out, followed by the
+// recommended batch.maxAmount, if any.
+func WriteTuneReport(out io.Writer, results []TuneResult) error {
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "BATCH LIMIT\tBATCHES\tDURATION\tBETS/S\tACK P50\tACK P99\tERROR")
+	for _, result := range results {
+		failure := ""
+		if result.Err != nil {
+			failure = result.Err.Error()
+		}
+		fmt.Fprintf(table, "%d\t%d\t%v\t%.0f\t%v\t%v\t%s\n", result.BatchLimit, result.Batches,
+			result.Duration.Round(time.Millisecond), result.Throughput(), result.LatencyP50.Round(time.Microsecond), result.LatencyP99.Round(time.Microsecond), failure)
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+	if limit, ok := RecommendBatchLimit(results); ok {
+		_, err := fmt.Fprintf(out, "\nrecommended batch.maxAmount: %d\n", limit)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package protocol
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"unicode/utf8"
+)
+
+// MaxFieldLength bounds the encoded length of every bet field.
+const MaxFieldLength = 255
+
+// Keys of the [string map] that encodes a bet on the wire.
+const (
+	AgencyKey    = "AGENCIA"
+	FirstNameKey = "NOMBRE"
+	LastNameKey  = "APELLIDO"
+	DocumentKey  = "DOCUMENTO"
+	BirthdateKey = "NACIMIENTO"
+	NumberKey    = "NUMERO"
+)
+
+// ValidationError reports a bet or batch that cannot be serialized.
+// Field is the protocol key of the offending field, empty for batch errors.
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("invalid batch: %s", e.Reason)
+	}
+	return fmt.Sprintf("invalid bet field %s: %s", e.Field, e.Reason)
+}
+
+// Bet is a single lottery bet as carried by a NewBets batch.
+// Build it with NewBet so its fields are validated.
+type Bet struct {
+	Agency    string
+	FirstName string
+	LastName  string
+	Document  string
+	Birthdate string
+	Number    string
+}
+
+// NewBet builds a Bet and validates that every field is present (the
+// server rejects empty strings), valid UTF-8 and at most MaxFieldLength
+// bytes long. The first offending field is reported as a *ValidationError.
+func NewBet(agency, firstName, lastName, document, birthdate, number string) (*Bet, error) {
+	bet := &Bet{
+		Agency:    agency,
+		FirstName: firstName,
+		LastName:  lastName,
+		Document:  document,
+		Birthdate: birthdate,
+		Number:    number,
+	}
+	if err := bet.Validate(); err != nil {
+		return nil, err
+	}
+	return bet, nil
+}
+
+// Validate checks the encoding constraints described in NewBet.
+func (b *Bet) Validate() error {
+	fields := []struct{ key, value string }{
+		{AgencyKey, b.Agency},
+		{FirstNameKey, b.FirstName},
+		{LastNameKey, b.LastName},
+		{DocumentKey, b.Document},
+		{BirthdateKey, b.Birthdate},
+		{NumberKey, b.Number},
+	}
+	for _, f := range fields {
+		switch {
+		case f.value == "":
+			return &ValidationError{Field: f.key, Reason: "missing value"}
+		case !utf8.ValidString(f.value):
+			return &ValidationError{Field: f.key, Reason: "not valid UTF-8"}
+		case len(f.value) > MaxFieldLength:
+			return &ValidationError{Field: f.key, Reason: fmt.Sprintf("%d bytes exceeds the %d bytes limit", len(f.value), MaxFieldLength)}
+		}
+	}
+	return nil
+}
+
+// Fields returns the bet as the protocol [string map].
+func (b *Bet) Fields() map[string]string {
+	fields := make(map[string]string, 6)
+	b.fillFields(fields)
+	return fields
+}
+
+func (b *Bet) fillFields(fields map[string]string) {
+	fields[AgencyKey] = b.Agency
+	fields[FirstNameKey] = b.FirstName
+	fields[LastNameKey] = b.LastName
+	fields[DocumentKey] = b.Document
+	fields[BirthdateKey] = b.Birthdate
+	fields[NumberKey] = b.Number
+}
+
+// fieldsPool recycles the field maps of GetFields. Every map holds the same
+// six keys, so refilling one does not allocate.
+var fieldsPool = sync.Pool{
+	New: func() interface{} { return make(map[string]string, 6) },
+}
+
+// GetFields returns the fields of b, as Fields does, in a map taken from a
+// pool, for bets that are encoded right away. Return it with PutFields once
+// encoded; it must not be modified nor kept.
+func GetFields(b *Bet) map[string]string {
+	fields := fieldsPool.Get().(map[string]string)
+	b.fillFields(fields)
+	return fields
+}
+
+// PutFields returns fields, obtained from GetFields, to the pool.
+func PutFields(fields map[string]string) {
+	fieldsPool.Put(fields)
+}
+
+// BetsBatch is a logical NewBets message. Build it with NewBetsBatch.
+// Codec selects the bets encoding (nil means BinaryCodec); Counters, if
+// set, records the frames written.
+type BetsBatch struct {
+	Bets         []*Bet
+	MaxFrameSize int
+	Codec        Codec
+	Counters     *Counters
+}
+
+// NewBetsBatch builds a batch from bets, framed with DefaultMaxFrameSize.
+// It fails if the batch is empty or any bet is invalid; the error names
+// the position of the offending bet.
+func NewBetsBatch(bets ...*Bet) (*BetsBatch, error) {
+	if len(bets) == 0 {
+		return nil, &ValidationError{Reason: "no bets"}
+	}
+	for i, bet := range bets {
+		if bet == nil {
+			return nil, &ValidationError{Reason: fmt.Sprintf("bet %d is nil", i)}
+		}
+		if err := bet.Validate(); err != nil {
+			return nil, fmt.Errorf("bet %d: %w", i, err)
+		}
+	}
+	return &BetsBatch{Bets: bets, MaxFrameSize: DefaultMaxFrameSize}, nil
+}
+
+func (msg *BetsBatch) GetOpCode() OpCode { return NewBetsOpCode }
+
+func (msg *BetsBatch) String() string {
+	return fmt.Sprintf("NewBets{bets: %d}", len(msg.Bets))
+}
+
+// GetLength computes the logical body length: the bet counter plus every
+// encoded bet, regardless of how it is split into frames.
+func (msg *BetsBatch) GetLength() int32 {
+	codec := codecOrDefault(msg.Codec)
+	buff := GetBuffer()
+	defer PutBuffer(buff)
+	for _, bet := range msg.Bets {
+		fields := GetFields(bet)
+		_ = codec.AppendBet(buff, fields)
+		PutFields(fields)
+	}
+	return int32(4 + buff.Len())
+}
+
+// WriteTo serializes the batch as a NewBets frame plus the Continuation
+// frames needed to respect MaxFrameSize. It returns the bytes written.
+func (msg *BetsBatch) WriteTo(out io.Writer) (int64, error) {
+	codec := codecOrDefault(msg.Codec)
+	body := GetBuffer()
+	defer PutBuffer(body)
+	for _, bet := range msg.Bets {
+		fields := GetFields(bet)
+		err := codec.AppendBet(body, fields)
+		PutFields(fields)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return writeBatch(body.Bytes(), out, int32(len(msg.Bets)), msg.MaxFrameSize, codec, msg.Counters)
+}
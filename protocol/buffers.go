@@ -0,0 +1,53 @@
+package protocol
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooledBufferSize bounds the capacity of the buffers put back in
+// bufferPool, so the buffer of one huge batch is not kept forever.
+const maxPooledBufferSize = 1 << 20
+
+// bufferPool recycles the scratch buffers bets, batch bodies and frames are
+// serialized into, which would otherwise be allocated once per bet.
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// GetBuffer returns an empty buffer from the pool. Return it with PutBuffer
+// once its contents are no longer referenced.
+func GetBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// PutBuffer empties buff and returns it to the pool. Buffers that grew
+// beyond maxPooledBufferSize are dropped instead.
+func PutBuffer(buff *bytes.Buffer) {
+	if buff.Cap() > maxPooledBufferSize {
+		return
+	}
+	buff.Reset()
+	bufferPool.Put(buff)
+}
+
+// zeros is the source of the bytes extend appends.
+var zeros [256]byte
+
+// extend lengthens buff by n zero bytes and returns them, for values to be
+// encoded in place instead of being assembled elsewhere and copied in.
+// buff grows once before the bytes are written, so the returned slice is
+// the end of its contents until buff is written to again.
+func extend(buff *bytes.Buffer, n int) []byte {
+	buff.Grow(n)
+	start := buff.Len()
+	for left := n; left > 0; {
+		chunk := zeros[:]
+		if left < len(chunk) {
+			chunk = chunk[:left]
+		}
+		buff.Write(chunk)
+		left -= len(chunk)
+	}
+	return buff.Bytes()[start:]
+}
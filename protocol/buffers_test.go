@@ -0,0 +1,44 @@
+package protocol
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestExtend(t *testing.T) {
+	tests := []struct {
+		name   string
+		buffer func() *bytes.Buffer
+		n      int
+		want   string // contents of the buffer before the extension
+	}{
+		{"empty", func() *bytes.Buffer { return new(bytes.Buffer) }, 4, ""},
+		{"with contents", func() *bytes.Buffer { return bytes.NewBufferString("abc") }, 4, "abc"},
+		{"partially read", func() *bytes.Buffer {
+			buff := bytes.NewBufferString("abcdef")
+			buff.Next(2)
+			return buff
+		}, 4, "cdef"},
+		{"longer than zeros", func() *bytes.Buffer { return bytes.NewBufferString("abc") }, 3*len(zeros) + 1, "abc"},
+		{"nothing", func() *bytes.Buffer { return bytes.NewBufferString("abc") }, 0, "abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buff := tt.buffer()
+			b := extend(buff, tt.n)
+			if len(b) != tt.n {
+				t.Fatalf("extend returned %d bytes; want %d", len(b), tt.n)
+			}
+			if strings.Count(string(b), "\x00") != tt.n {
+				t.Fatalf("extend returned %q; want zeros", b)
+			}
+			for i := range b {
+				b[i] = 'x'
+			}
+			if want := tt.want + strings.Repeat("x", tt.n); buff.String() != want {
+				t.Fatalf("buffer = %q; want %q", buff.String(), want)
+			}
+		})
+	}
+}
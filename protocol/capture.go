@@ -0,0 +1,177 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Direction tells which peer sent a captured frame.
+type Direction byte
+
+const (
+	ClientToServer Direction = 0
+	ServerToClient Direction = 1
+)
+
+func (d Direction) String() string {
+	switch d {
+	case ClientToServer:
+		return "c->s"
+	case ServerToClient:
+		return "s->c"
+	}
+	return fmt.Sprintf("Direction(%d)", byte(d))
+}
+
+// captureMagic opens every capture file and identifies its format version.
+var captureMagic = []byte("TP0CAP1\n")
+
+// captureRecordHeaderSize is the size of [direction:u8][time:i64 LE].
+const captureRecordHeaderSize = 1 + 8
+
+// CaptureWriter records frames to a capture file, for offline analysis of
+// protocol incidents (see CaptureReader). The file starts with a magic
+// string, followed by one record per frame:
+//
+//	[direction:u8][time:i64 LE, Unix nanoseconds][frame, as on the wire]
+//
+// Each record is written with a single Write call. It is safe for
+// concurrent use. Once a write fails, later frames are dropped and Err
+// returns the error.
+type CaptureWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+	err error
+}
+
+// NewCaptureWriter writes the magic of a capture file to out and returns a
+// writer recording frames after it.
+func NewCaptureWriter(out io.Writer) (*CaptureWriter, error) {
+	if _, err := out.Write(captureMagic); err != nil {
+		return nil, err
+	}
+	return &CaptureWriter{out: out}, nil
+}
+
+// WriteFrame records frame, a whole frame as on the wire, sent in the given
+// direction at the given time.
+func (w *CaptureWriter) WriteFrame(dir Direction, at time.Time, frame []byte) error {
+	record := make([]byte, captureRecordHeaderSize, captureRecordHeaderSize+len(frame))
+	record[0] = byte(dir)
+	binary.LittleEndian.PutUint64(record[1:], uint64(at.UnixNano()))
+	record = append(record, frame...)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	_, w.err = w.out.Write(record)
+	return w.err
+}
+
+// Err returns the error of the first failed write, if any.
+func (w *CaptureWriter) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Stream returns a writer splitting the bytes sent in the given direction
+// of one connection into frames, each recorded when its last byte is
+// written. The bytes of a frame not complete yet are kept until then. A
+// body length above MaxInboundBodyLength means the bytes are not framed,
+// so nothing more of the stream is recorded. Writes never fail, so the
+// writer can tee a connection without affecting it; see Err.
+func (w *CaptureWriter) Stream(dir Direction) io.Writer {
+	return &captureStream{capture: w, dir: dir}
+}
+
+type captureStream struct {
+	capture *CaptureWriter
+	dir     Direction
+	pending []byte
+	broken  bool
+}
+
+func (s *captureStream) Write(p []byte) (int, error) {
+	if s.broken {
+		return len(p), nil
+	}
+	s.pending = append(s.pending, p...)
+	now := time.Now()
+	for len(s.pending) >= frameHeaderSize {
+		length := int32(binary.LittleEndian.Uint32(s.pending[1:]))
+		if length < 0 || length > MaxInboundBodyLength {
+			s.broken = true
+			s.pending = nil
+			break
+		}
+		size := frameHeaderSize + int(length)
+		if len(s.pending) < size {
+			break
+		}
+		_ = s.capture.WriteFrame(s.dir, now, s.pending[:size])
+		s.pending = append(s.pending[:0], s.pending[size:]...)
+	}
+	return len(p), nil
+}
+
+// CapturedFrame is a frame read from a capture file, along with the peer
+// that sent it and when.
+type CapturedFrame struct {
+	Direction Direction
+	Time      time.Time
+	*Frame
+}
+
+// IsCapture reports whether in starts with the magic of a capture file,
+// without consuming it.
+func IsCapture(in *bufio.Reader) bool {
+	magic, err := in.Peek(len(captureMagic))
+	return err == nil && bytes.Equal(magic, captureMagic)
+}
+
+// CaptureReader reads the frames recorded by a CaptureWriter.
+type CaptureReader struct {
+	in io.Reader
+}
+
+// NewCaptureReader checks the magic of the capture file read from in.
+func NewCaptureReader(in io.Reader) (*CaptureReader, error) {
+	magic := make([]byte, len(captureMagic))
+	if _, err := io.ReadFull(in, magic); err != nil {
+		return nil, fmt.Errorf("not a capture file: %w", err)
+	}
+	if !bytes.Equal(magic, captureMagic) {
+		return nil, errors.New("not a capture file")
+	}
+	return &CaptureReader{in: in}, nil
+}
+
+// Next returns the next frame of the capture, or io.EOF once the capture
+// ended. A capture cut short in the middle of a record, e.g. because the
+// process capturing it was killed, fails with io.ErrUnexpectedEOF.
+func (r *CaptureReader) Next() (*CapturedFrame, error) {
+	var header [captureRecordHeaderSize]byte
+	if _, err := io.ReadFull(r.in, header[:]); err != nil {
+		return nil, err
+	}
+	frame, err := ReadFrame(r.in)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &CapturedFrame{
+		Direction: Direction(header[0]),
+		Time:      time.Unix(0, int64(binary.LittleEndian.Uint64(header[1:]))),
+		Frame:     frame,
+	}, nil
+}
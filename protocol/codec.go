@@ -0,0 +1,103 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Codec encodes and decodes message bodies. Every codec shares the
+// [opcode:u8][length:i32 LE] framing and the [nBets:i32 LE] counter that
+// opens a NewBets frame; only the bets, Finished, Winners and Ack bodies
+// depend on the codec. Both peers must be configured with the same codec.
+type Codec interface {
+	// Name identifies the codec in configuration and logs.
+	Name() string
+	// AppendBet appends the encoding of bet to buff.
+	AppendBet(buff *bytes.Buffer, bet map[string]string) error
+	// BetSize returns the size of the encoded bet at the start of body.
+	BetSize(body []byte) (int, error)
+	// DecodeBet parses the bet at the start of body, returning its size.
+	DecodeBet(body []byte) (map[string]string, int, error)
+	// AppendFinished appends the body of a Finished message to buff.
+	AppendFinished(buff *bytes.Buffer, agencyId int32) error
+	// DecodeFinished parses a Finished body.
+	DecodeFinished(body []byte) (int32, error)
+	// DecodeWinners parses a Winners body.
+	DecodeWinners(body []byte) ([]string, error)
+	// DecodeAck parses an Ack body into msg.
+	DecodeAck(body []byte, msg *Ack) error
+}
+
+// BinaryCodec is the original length-prefixed little-endian encoding
+// described in each message type. It is the default codec.
+var BinaryCodec Codec = binaryCodec{}
+
+// MsgpackCodec encodes bodies as MessagePack values:
+//   - bet: map of str → str
+//   - Finished: int (agency ID)
+//   - Winners: array of str
+//   - Ack: array [correlationId:int, status:int, detail:map str → str]
+var MsgpackCodec Codec = msgpackCodec{}
+
+// CodecByName returns the codec registered under name ("binary" or
+// "msgpack"). An empty name selects BinaryCodec.
+func CodecByName(name string) (Codec, error) {
+	switch name {
+	case "", BinaryCodec.Name():
+		return BinaryCodec, nil
+	case MsgpackCodec.Name():
+		return MsgpackCodec, nil
+	default:
+		return nil, fmt.Errorf("unknown codec %q", name)
+	}
+}
+
+// codecOrDefault returns codec, or BinaryCodec if it is nil.
+func codecOrDefault(codec Codec) Codec {
+	if codec == nil {
+		return BinaryCodec
+	}
+	return codec
+}
+
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string { return "binary" }
+
+func (binaryCodec) AppendBet(buff *bytes.Buffer, bet map[string]string) error {
+	return writeStringMap(buff, bet)
+}
+
+func (binaryCodec) BetSize(body []byte) (int, error) {
+	return betSize(body), nil
+}
+
+func (binaryCodec) DecodeBet(body []byte) (map[string]string, int, error) {
+	remaining := int32(len(body))
+	bet, err := readStringMap(bytes.NewReader(body), &remaining, NewBetsOpCode)
+	if err != nil {
+		return nil, 0, err
+	}
+	return bet, len(body) - int(remaining), nil
+}
+
+func (binaryCodec) AppendFinished(buff *bytes.Buffer, agencyId int32) error {
+	writeInt32(buff, agencyId)
+	return nil
+}
+
+func (binaryCodec) DecodeFinished(body []byte) (int32, error) {
+	if len(body) != 4 {
+		return 0, &ProtocolError{Msg: "invalid body length", Opcode: FinishedOpCode}
+	}
+	return int32(binary.LittleEndian.Uint32(body)), nil
+}
+
+func (binaryCodec) DecodeWinners(body []byte) ([]string, error) {
+	return readWinners(bytes.NewReader(body), int32(len(body)))
+}
+
+func (binaryCodec) DecodeAck(body []byte, msg *Ack) error {
+	return readAck(bytes.NewReader(body), int32(len(body)), msg)
+}
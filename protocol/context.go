@@ -0,0 +1,100 @@
+package protocol
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// DeadlineReader is a reader whose blocking reads can be interrupted with a
+// deadline, such as a net.Conn.
+type DeadlineReader interface {
+	io.Reader
+	SetReadDeadline(t time.Time) error
+}
+
+// ConnReader buffers the reads of a connection while keeping access to it,
+// so ReadMessageContext can apply deadlines to the underlying connection.
+// Use a single ConnReader per connection: buffered bytes are not shared.
+type ConnReader struct {
+	conn     DeadlineReader
+	reader   *bufio.Reader
+	codec    Codec
+	counters *Counters
+	aligned  bool
+}
+
+// NewConnReader wraps conn in a buffered ConnReader decoding BinaryCodec bodies.
+func NewConnReader(conn DeadlineReader) *ConnReader {
+	return NewConnReaderCodec(conn, BinaryCodec, nil)
+}
+
+// NewConnReaderCodec wraps conn in a buffered ConnReader decoding bodies
+// with codec and recording the frames read in counters, if not nil.
+func NewConnReaderCodec(conn DeadlineReader, codec Codec, counters *Counters) *ConnReader {
+	return &ConnReader{conn: conn, reader: bufio.NewReader(conn), codec: codec, counters: counters, aligned: true}
+}
+
+// Aligned reports whether the stream is still on a frame boundary: false
+// once a read failed after consuming part of a frame.
+func (r *ConnReader) Aligned() bool {
+	return r.aligned
+}
+
+// read reads one message, recording whether it left the stream aligned.
+// Nothing is consumed until the first byte of the frame arrives, so a read
+// failing before that keeps the stream aligned.
+func (r *ConnReader) read() (Readable, error) {
+	if _, err := r.reader.Peek(1); err != nil {
+		return nil, err
+	}
+	msg, err := readMessage(r.reader, r.codec, r.counters)
+	var protoErr *ProtocolError
+	r.aligned = err == nil || (errors.As(err, &protoErr) && protoErr.Resynced)
+	return msg, err
+}
+
+// ReadMessageContext reads one framed server response like ReadMessageCodec,
+// but honours ctx: its deadline (if any) is applied as the read deadline,
+// and cancelling ctx interrupts a blocked read. In both cases ctx.Err() is
+// returned and, if part of a frame was consumed already, the stream is
+// misaligned (see Aligned). The read deadline is cleared before returning.
+func ReadMessageContext(ctx context.Context, conn *ConnReader) (Readable, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	deadline, hasDeadline := ctx.Deadline()
+	if err := conn.conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	readDone := make(chan struct{})
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		select {
+		case <-ctx.Done():
+			// A deadline in the past makes the pending read fail immediately.
+			_ = conn.conn.SetReadDeadline(time.Unix(1, 0))
+		case <-readDone:
+		}
+	}()
+
+	msg, err := conn.read()
+	close(readDone)
+	<-watcherDone
+	_ = conn.conn.SetReadDeadline(time.Time{})
+	if err != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// The read deadline may expire before the timer of ctx fires.
+		if hasDeadline && !time.Now().Before(deadline) {
+			return nil, context.DeadlineExceeded
+		}
+	}
+	return msg, err
+}
@@ -0,0 +1,147 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// deadlineConn is a DeadlineReader that never receives data: reads block
+// until the read deadline passes and then fail like a socket would.
+type deadlineConn struct {
+	deadline time.Time
+}
+
+func (c *deadlineConn) Read(p []byte) (int, error) {
+	time.Sleep(time.Until(c.deadline))
+	return 0, os.ErrDeadlineExceeded
+}
+
+func (c *deadlineConn) SetReadDeadline(t time.Time) error {
+	if !t.IsZero() {
+		c.deadline = t
+	}
+	return nil
+}
+
+// unfiredContext has a deadline but is never done, like a context whose
+// timer has not fired yet when the read deadline expires.
+type unfiredContext struct {
+	context.Context
+	deadline time.Time
+}
+
+func (c unfiredContext) Deadline() (time.Time, bool) { return c.deadline, true }
+
+func TestReadMessageContext(t *testing.T) {
+	tests := []struct {
+		name string
+		// read starts a read with ReadMessageContext and, if needed, feeds
+		// or interrupts it.
+		read    func() (Readable, error)
+		want    Readable
+		wantErr error
+	}{
+		{
+			name: "message",
+			read: func() (Readable, error) {
+				client, server := net.Pipe()
+				defer client.Close()
+				go server.Write(testSummaryFrame(3, 2, 1))
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				return ReadMessageContext(ctx, NewConnReader(client))
+			},
+			want: &Summary{Batches: 3, Bets: 2, Failed: 1},
+		},
+		{
+			name: "timeout",
+			read: func() (Readable, error) {
+				client, _ := net.Pipe()
+				defer client.Close()
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+				defer cancel()
+				return ReadMessageContext(ctx, NewConnReader(client))
+			},
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			name: "read deadline before the context timer",
+			read: func() (Readable, error) {
+				ctx := unfiredContext{Context: context.Background(), deadline: time.Now().Add(10 * time.Millisecond)}
+				return ReadMessageContext(ctx, NewConnReader(&deadlineConn{}))
+			},
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			name: "cancelled",
+			read: func() (Readable, error) {
+				client, _ := net.Pipe()
+				defer client.Close()
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(10*time.Millisecond, cancel)
+				return ReadMessageContext(ctx, NewConnReader(client))
+			},
+			wantErr: context.Canceled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := tt.read()
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ReadMessageContext error = %v; want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadMessageContext: %v", err)
+			}
+			if !reflect.DeepEqual(msg, tt.want) {
+				t.Fatalf("ReadMessageContext = %#v; want %#v", msg, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnReaderAligned(t *testing.T) {
+	frame := testSummaryFrame(3, 2, 1)
+	tests := []struct {
+		name        string
+		written     []byte
+		wantAligned bool
+	}{
+		{"nothing read", nil, true},
+		{"part of the header", frame[:2], false},
+		{"part of the body", frame[:frameHeaderSize+3], false},
+		{"resynced frame", testFrame(OpCode(99), []byte("skipped")), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			go server.Write(tt.written)
+			reader := NewConnReader(client)
+			for {
+				ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+				_, err := ReadMessageContext(ctx, reader)
+				cancel()
+				if errors.Is(err, context.DeadlineExceeded) {
+					break
+				}
+				var protoErr *ProtocolError
+				if !errors.As(err, &protoErr) {
+					t.Fatalf("ReadMessageContext error = %v; want a timeout", err)
+				}
+			}
+			if reader.Aligned() != tt.wantAligned {
+				t.Fatalf("Aligned = %t; want %t", reader.Aligned(), tt.wantAligned)
+			}
+		})
+	}
+}
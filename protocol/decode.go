@@ -0,0 +1,147 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Frame is a physical frame of either direction of the protocol, with its
+// body not decoded yet. It is meant for tools inspecting captured traffic;
+// peers read server responses with ReadMessageCodec.
+type Frame struct {
+	OpCode OpCode
+	Body   []byte
+}
+
+// ReadFrame reads exactly one frame from reader. Bodies longer than
+// MaxInboundBodyLength are rejected with a ProtocolError, as the stream
+// cannot be trusted past such a length.
+func ReadFrame(reader io.Reader) (*Frame, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, err
+	}
+	frame := &Frame{OpCode: OpCode(header[0])}
+	length := int32(binary.LittleEndian.Uint32(header[1:]))
+	if length < 0 || length > MaxInboundBodyLength {
+		return nil, &ProtocolError{Msg: "implausible body length", Opcode: frame.OpCode}
+	}
+	frame.Body = make([]byte, length)
+	if _, err := io.ReadFull(reader, frame.Body); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// Bytes returns the frame as it is sent on the wire.
+func (f *Frame) Bytes() []byte {
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+len(f.Body))
+	frame[0] = byte(f.OpCode)
+	binary.LittleEndian.PutUint32(frame[1:], uint32(len(f.Body)))
+	return append(frame, f.Body...)
+}
+
+// BetsFrame is a decoded NewBets or Continuation frame: the bets it
+// carries and, for NewBets, the number of bets of the whole logical batch
+// (which may continue in the following Continuation frames).
+type BetsFrame struct {
+	Continuation bool
+	Total        int32
+	Bets         []map[string]string
+	length       int32
+}
+
+func (msg *BetsFrame) GetOpCode() OpCode {
+	if msg.Continuation {
+		return ContinuationOpCode
+	}
+	return NewBetsOpCode
+}
+
+func (msg *BetsFrame) GetLength() int32 { return msg.length }
+
+func (msg *BetsFrame) String() string {
+	if msg.Continuation {
+		return fmt.Sprintf("Continuation{bets: %d}", len(msg.Bets))
+	}
+	return fmt.Sprintf("NewBets{total: %d, bets: %d}", msg.Total, len(msg.Bets))
+}
+
+// FormatBet renders a bet of a BetsFrame as key=value pairs sorted by key.
+func FormatBet(bet map[string]string) string {
+	pairs := make([]string, 0, len(bet))
+	for k, v := range bet {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+// Decode decodes the body of the frame with codec (nil means BinaryCodec)
+// into the message of its opcode: a *BetsFrame, *Finished, *Quiet,
+// *SummaryRequest or *Telemetry for client→server frames, or the Readable
+// of a server→client one. Unknown opcodes and invalid bodies are reported
+// as ProtocolErrors.
+func (f *Frame) Decode(codec Codec) (Message, error) {
+	codec = codecOrDefault(codec)
+	switch f.OpCode {
+	case NewBetsOpCode, ContinuationOpCode:
+		return decodeBetsFrame(f, codec)
+	case FinishedOpCode:
+		agencyId, err := codec.DecodeFinished(f.Body)
+		if err != nil {
+			return nil, bodyError(err, f.OpCode)
+		}
+		return &Finished{AgencyId: agencyId, Codec: codec}, nil
+	case QuietOpCode, SummaryRequestOpCode:
+		if len(f.Body) != 0 {
+			return nil, &ProtocolError{Msg: "invalid body length", Opcode: f.OpCode}
+		}
+		if f.OpCode == QuietOpCode {
+			return &Quiet{}, nil
+		}
+		return &SummaryRequest{}, nil
+	case TelemetryOpCode:
+		return decodeTelemetry(f.Body)
+	}
+	msg := newReadable(f.OpCode)
+	if msg == nil {
+		return nil, &ProtocolError{Msg: "invalid opcode", Opcode: f.OpCode}
+	}
+	if err := msg.decode(f.Body, codec); err != nil {
+		return nil, bodyError(err, f.OpCode)
+	}
+	return msg, nil
+}
+
+// decodeBetsFrame splits the body of a NewBets or Continuation frame into
+// its bets, after the bet counter of NewBets frames.
+func decodeBetsFrame(f *Frame, codec Codec) (*BetsFrame, error) {
+	msg := &BetsFrame{Continuation: f.OpCode == ContinuationOpCode, length: int32(len(f.Body))}
+	body := f.Body
+	if !msg.Continuation {
+		if len(body) < 4 {
+			return nil, &ProtocolError{Msg: "invalid body length", Opcode: f.OpCode}
+		}
+		msg.Total = int32(binary.LittleEndian.Uint32(body))
+		body = body[4:]
+	}
+	for len(body) > 0 {
+		bet, size, err := codec.DecodeBet(body)
+		if err != nil {
+			// The codecs report bet errors as NewBets ones.
+			var protoErr *ProtocolError
+			if errors.As(err, &protoErr) {
+				protoErr.Opcode = f.OpCode
+			}
+			return nil, bodyError(err, f.OpCode)
+		}
+		msg.Bets = append(msg.Bets, bet)
+		body = body[size:]
+	}
+	return msg, nil
+}
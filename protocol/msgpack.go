@@ -0,0 +1,304 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// errMsgpackTruncated reports a MessagePack value cut by the end of the body.
+var errMsgpackTruncated = errors.New("truncated msgpack value")
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) AppendBet(buff *bytes.Buffer, bet map[string]string) error {
+	writeMsgpackStringMap(buff, bet)
+	return nil
+}
+
+func (msgpackCodec) BetSize(body []byte) (int, error) {
+	dec := msgpackDecoder{body: body}
+	if _, err := dec.readStringMap(); err != nil {
+		return 0, err
+	}
+	return dec.pos, nil
+}
+
+func (msgpackCodec) DecodeBet(body []byte) (map[string]string, int, error) {
+	dec := msgpackDecoder{body: body}
+	bet, err := dec.readStringMap()
+	if err != nil {
+		return nil, 0, err
+	}
+	return bet, dec.pos, nil
+}
+
+func (msgpackCodec) AppendFinished(buff *bytes.Buffer, agencyId int32) error {
+	writeMsgpackInt(buff, agencyId)
+	return nil
+}
+
+func (msgpackCodec) DecodeFinished(body []byte) (int32, error) {
+	dec := msgpackDecoder{body: body}
+	agencyId, err := dec.readInt()
+	if err != nil {
+		return 0, err
+	}
+	return int32(agencyId), dec.end()
+}
+
+func (msgpackCodec) DecodeWinners(body []byte) ([]string, error) {
+	dec := msgpackDecoder{body: body}
+	n, err := dec.readArrayLen()
+	if err != nil {
+		return nil, err
+	}
+	// Every winner takes at least one byte, which bounds the capacity
+	// allocated for a forged count
+	capacity := n
+	if left := len(body) - dec.pos; capacity > left {
+		capacity = left
+	}
+	winners := make([]string, 0, capacity)
+	for i := 0; i < n; i++ {
+		doc, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		winners = append(winners, doc)
+	}
+	return winners, dec.end()
+}
+
+func (msgpackCodec) DecodeAck(body []byte, msg *Ack) error {
+	dec := msgpackDecoder{body: body}
+	n, err := dec.readArrayLen()
+	if err != nil {
+		return err
+	}
+	if n != 3 {
+		return fmt.Errorf("ack has %d elements, expected 3", n)
+	}
+	correlationID, err := dec.readInt()
+	if err != nil {
+		return err
+	}
+	status, err := dec.readInt()
+	if err != nil {
+		return err
+	}
+	detail, err := dec.readStringMap()
+	if err != nil {
+		return err
+	}
+	msg.CorrelationID = int32(correlationID)
+	msg.Status = AckStatus(status)
+	msg.Detail = detail
+	return dec.end()
+}
+
+// msgpackLenSize returns the size of the header putMsgpackLen writes.
+func msgpackLenSize(n int, fixMax int, b8 byte) int {
+	switch {
+	case n < fixMax:
+		return 1
+	case b8 != 0 && n <= 0xff:
+		return 2
+	case n <= 0xffff:
+		return 3
+	default:
+		return 5
+	}
+}
+
+// putMsgpackLen encodes at the start of b the header of a str, array or map
+// of n elements using the smallest form: fix (if n < fixMax), then 8 (str
+// only), 16 and 32-bit lengths. It returns the bytes written.
+func putMsgpackLen(b []byte, n int, fix byte, fixMax int, b8 byte, b16 byte) int {
+	switch {
+	case n < fixMax:
+		b[0] = fix | byte(n)
+		return 1
+	case b8 != 0 && n <= 0xff:
+		b[0] = b8
+		b[1] = byte(n)
+		return 2
+	case n <= 0xffff:
+		b[0] = b16
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		return 3
+	default:
+		b[0] = b16 + 1
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		return 5
+	}
+}
+
+func writeMsgpackString(buff *bytes.Buffer, s string) {
+	b := extend(buff, msgpackLenSize(len(s), 32, 0xd9)+len(s))
+	copy(b[putMsgpackLen(b, len(s), 0xa0, 32, 0xd9, 0xda):], s)
+}
+
+// writeMsgpackStringMap encodes m in place at the end of buff (see
+// extend), like writeStringMap.
+func writeMsgpackStringMap(buff *bytes.Buffer, m map[string]string) {
+	b := extend(buff, msgpackLenSize(len(m), 16, 0))
+	putMsgpackLen(b, len(m), 0x80, 16, 0, 0xde)
+	for k, v := range m {
+		writeMsgpackString(buff, k)
+		writeMsgpackString(buff, v)
+	}
+}
+
+func writeMsgpackInt(buff *bytes.Buffer, v int32) {
+	switch {
+	case v >= 0 && v <= 0x7f:
+		buff.WriteByte(byte(v))
+	case v >= -32 && v < 0:
+		buff.WriteByte(byte(v))
+	default:
+		var b [5]byte
+		b[0] = 0xd2
+		binary.BigEndian.PutUint32(b[1:], uint32(v))
+		buff.Write(b[:])
+	}
+}
+
+// msgpackDecoder reads the subset of MessagePack used by MsgpackCodec
+// (ints, strings, arrays and string maps) from an in-memory body.
+type msgpackDecoder struct {
+	body []byte
+	pos  int
+}
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.body)-d.pos < n {
+		return nil, errMsgpackTruncated
+	}
+	b := d.body[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *msgpackDecoder) readUint(size int) (int, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return int(b[0]), nil
+	case 2:
+		return int(binary.BigEndian.Uint16(b)), nil
+	default:
+		return int(binary.BigEndian.Uint32(b)), nil
+	}
+}
+
+// readLen reads a str/array/map header whose fix form is fix with fixMask
+// bits of length, and whose sized forms start at b8 (0 if absent) and b16.
+func (d *msgpackDecoder) readLen(kind string, fix byte, fixMask byte, b8 byte, b16 byte) (int, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, err
+	}
+	switch tag := b[0]; {
+	case tag&^fixMask == fix:
+		return int(tag & fixMask), nil
+	case b8 != 0 && tag == b8:
+		return d.readUint(1)
+	case tag == b16:
+		return d.readUint(2)
+	case tag == b16+1:
+		return d.readUint(4)
+	default:
+		return 0, fmt.Errorf("expected msgpack %s, got 0x%02x", kind, tag)
+	}
+}
+
+func (d *msgpackDecoder) readString() (string, error) {
+	n, err := d.readLen("str", 0xa0, 0x1f, 0xd9, 0xda)
+	if err != nil {
+		return "", err
+	}
+	b, err := d.next(n)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (d *msgpackDecoder) readArrayLen() (int, error) {
+	return d.readLen("array", 0x90, 0x0f, 0, 0xdc)
+}
+
+func (d *msgpackDecoder) readStringMap() (map[string]string, error) {
+	n, err := d.readLen("map", 0x80, 0x0f, 0, 0xde)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string)
+	for i := 0; i < n; i++ {
+		k, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		result[k] = v
+	}
+	return result, nil
+}
+
+// readInt reads any MessagePack int and checks that it fits an int32.
+func (d *msgpackDecoder) readInt() (int64, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, err
+	}
+	var v int64
+	switch tag := b[0]; {
+	case tag <= 0x7f:
+		v = int64(tag)
+	case tag >= 0xe0:
+		v = int64(int8(tag))
+	case tag >= 0xcc && tag <= 0xd3:
+		// uint8..uint64 are 0xcc..0xcf, int8..int64 are 0xd0..0xd3.
+		size := 1 << int((tag-0xcc)%4)
+		raw, err := d.next(size)
+		if err != nil {
+			return 0, err
+		}
+		var u uint64
+		for _, c := range raw {
+			u = u<<8 | uint64(c)
+		}
+		if tag >= 0xd0 {
+			shift := 64 - 8*size
+			v = int64(u<<shift) >> shift
+		} else if u > 1<<63-1 {
+			return 0, errors.New("msgpack int out of range")
+		} else {
+			v = int64(u)
+		}
+	default:
+		return 0, fmt.Errorf("expected msgpack int, got 0x%02x", tag)
+	}
+	if v < -1<<31 || v > 1<<31-1 {
+		return 0, errors.New("msgpack int out of range")
+	}
+	return v, nil
+}
+
+// end fails if the body holds bytes past the decoded value.
+func (d *msgpackDecoder) end() error {
+	if d.pos != len(d.body) {
+		return errors.New("trailing bytes after msgpack value")
+	}
+	return nil
+}
@@ -0,0 +1,709 @@
+// Package protocol implements the framing, message types and batching
+// helpers of the lottery client/server wire protocol. Every frame is
+// [opcode:u8][length:i32 LE][body]. The package does not log and works on
+// plain io.Reader/io.Writer values, so it can be used by the client, a
+// server, load generators or decoders alike.
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// OpCode identifies the message type of a frame (u8 on the wire).
+type OpCode byte
+
+const NewBetsOpCode OpCode = 0
+const BetsRecvSuccessOpCode OpCode = 1
+const BetsRecvFailOpCode OpCode = 2
+const FinishedOpCode OpCode = 3
+const WinnersOpCode OpCode = 4
+const ContinuationOpCode OpCode = 5
+const AckOpCode OpCode = 6
+const QuietOpCode OpCode = 7
+const SummaryRequestOpCode OpCode = 8
+const SummaryOpCode OpCode = 9
+const TelemetryOpCode OpCode = 10
+
+var opCodeNames = map[OpCode]string{
+	NewBetsOpCode:         "NewBets",
+	BetsRecvSuccessOpCode: "BetsRecvSuccess",
+	BetsRecvFailOpCode:    "BetsRecvFail",
+	FinishedOpCode:        "Finished",
+	WinnersOpCode:         "Winners",
+	ContinuationOpCode:    "Continuation",
+	AckOpCode:             "Ack",
+	QuietOpCode:           "Quiet",
+	SummaryRequestOpCode:  "SummaryRequest",
+	SummaryOpCode:         "Summary",
+	TelemetryOpCode:       "Telemetry",
+}
+
+// String renders the opcode name, or OpCode(n) for unknown values, so logs
+// of mixed-version deployments stay readable.
+func (op OpCode) String() string {
+	if name, ok := opCodeNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("OpCode(%d)", byte(op))
+}
+
+// DefaultMaxFrameSize is the default largest physical frame (header included)
+// written by the client. Logical batches larger than the configured maximum
+// are split into a NewBets frame followed by Continuation frames.
+const DefaultMaxFrameSize = 8 * 1024
+
+// MinMaxFrameSize is the smallest accepted maximum frame size: a NewBets
+// header with its bet counter and room for a minimal bet.
+const MinMaxFrameSize = frameHeaderSize + 4 + 64
+
+// frameHeaderSize is the size of [opcode:1][length:i32].
+const frameHeaderSize = 1 + 4
+
+// ProtocolError models a framing/validation error while parsing or writing
+// protocol messages. Opcode, when present, indicates the message context.
+// Resynced reports that the offending frame was fully consumed, so the
+// stream is still aligned on a frame boundary.
+type ProtocolError struct {
+	Msg      string
+	Opcode   OpCode
+	Resynced bool
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("protocol error: %s (opcode=%v)", e.Msg, e.Opcode)
+}
+
+// Message is implemented by all protocol messages and exposes the opcode
+// and the computed body length (for outbound messages).
+type Message interface {
+	GetOpCode() OpCode
+	GetLength() int32
+}
+
+// Writeable is implemented by outbound messages that can serialize themselves
+// to the wire format: [opcode:1][length:i32 LE][body]. WriteTo follows
+// io.WriterTo: it returns the total number of bytes written (header + body)
+// and any I/O error.
+type Writeable interface {
+	Message
+	io.WriterTo
+}
+
+// Finished is a client→server message that indicates the agency finished
+// sending all its bets. Body: [agencyId:i32] with BinaryCodec.
+// Codec selects the body encoding (nil means BinaryCodec); Counters, if
+// set, records the frame written.
+type Finished struct {
+	AgencyId int32
+	Codec    Codec
+	Counters *Counters
+}
+
+func (msg *Finished) GetOpCode() OpCode { return FinishedOpCode }
+
+// GetLength returns the body length under the message codec.
+func (msg *Finished) GetLength() int32 {
+	var body bytes.Buffer
+	_ = codecOrDefault(msg.Codec).AppendFinished(&body, msg.AgencyId)
+	return int32(body.Len())
+}
+
+// WriteTo writes the FINISHED frame with little-endian length and the
+// encoded agencyId, in one write. It returns the total bytes written or an
+// error.
+func (msg *Finished) WriteTo(out io.Writer) (int64, error) {
+	var frame bytes.Buffer
+	frame.Write(make([]byte, frameHeaderSize))
+	if err := codecOrDefault(msg.Codec).AppendFinished(&frame, msg.AgencyId); err != nil {
+		return 0, err
+	}
+	putFrameHeader(frame.Bytes(), msg.GetOpCode(), int32(frame.Len()-frameHeaderSize))
+	if _, err := out.Write(frame.Bytes()); err != nil {
+		return 0, err
+	}
+	msg.Counters.countFrameSent(msg.GetOpCode(), frame.Len())
+	return int64(frame.Len()), nil
+}
+
+func (msg *Finished) String() string {
+	return fmt.Sprintf("Finished{agency: %d}", msg.AgencyId)
+}
+
+// putFrameHeader encodes the header of a frame, [opcode:1][length:i32 LE],
+// at the start of b.
+func putFrameHeader(b []byte, opcode OpCode, length int32) {
+	b[0] = byte(opcode)
+	binary.LittleEndian.PutUint32(b[1:frameHeaderSize], uint32(length))
+}
+
+// writeInt32 writes v as an i32 LE. Unlike binary.Write it does not go
+// through reflection nor allocate, which matters once per string of every
+// bet.
+func writeInt32(buff *bytes.Buffer, v int32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(v))
+	buff.Write(b[:])
+}
+
+// writeString writes a protocol [string]: length (i32 LE) + UTF-8 bytes.
+func writeString(buff *bytes.Buffer, s string) error {
+	putString(extend(buff, 4+len(s)), s)
+	return nil
+}
+
+// putString encodes s as a protocol [string] at the start of b, which must
+// have room for it.
+func putString(b []byte, s string) {
+	binary.LittleEndian.PutUint32(b, uint32(len(s)))
+	copy(b[4:], s)
+}
+
+// writeStringMap writes a protocol [string map]:
+// first the number of pairs (i32 LE) and then each <k, v> as [string][string].
+// Every value is encoded in place at the end of buff (see extend), so its
+// bytes are written once, straight into the batch.
+func writeStringMap(buff *bytes.Buffer, body map[string]string) error {
+	binary.LittleEndian.PutUint32(extend(buff, 4), uint32(len(body)))
+	for k, v := range body {
+		putString(extend(buff, 4+len(k)), k)
+		putString(extend(buff, 4+len(v)), v)
+	}
+	return nil
+}
+
+// AddBetWithFlush serializes a single bet with codec (nil means BinaryCodec)
+// straight into the current batch buffer `to`. If the bet does not fit in
+// the batchLimit, the bets before it are first flushed as with
+// FlushBatch(to, finalOutput, *betsCounter, maxFrameSize, codec, counters)
+// and the bet is kept as the start of a new batch, setting *betsCounter = 1.
+// Either way the bet is encoded once, in place (see extend), and only
+// copied when a flush moves it to the front of `to`.
+// The maxFrameSize limit does not force a flush: FlushBatch splits large
+// batches into continuation frames, so only a single bet that does not fit
+// in one frame is rejected, leaving `to` as it was.
+// On success, it increments *betsCounter and returns nil; any I/O/encoding
+// error is returned.
+func AddBetWithFlush(bet map[string]string, to *bytes.Buffer, finalOutput io.Writer, betsCounter *int32, batchLimit int32, maxFrameSize int, codec Codec, counters *Counters) error {
+	codec = codecOrDefault(codec)
+	start := to.Len()
+	if err := codec.AppendBet(to, bet); err != nil {
+		to.Truncate(start)
+		return err
+	}
+	if size := to.Len() - start; frameHeaderSize+4+size > maxFrameSize {
+		to.Truncate(start)
+		return &ProtocolError{
+			Msg:    fmt.Sprintf("bet of %d bytes does not fit in the max frame size of %d bytes", size, maxFrameSize),
+			Opcode: NewBetsOpCode,
+		}
+	}
+	if *betsCounter+1 <= batchLimit {
+		*betsCounter++
+		return nil
+	}
+	if _, err := writeBatch(to.Bytes()[:start], finalOutput, *betsCounter, maxFrameSize, codec, counters); err != nil {
+		to.Truncate(start)
+		return err
+	}
+	// Move the bet to the front rather than skipping the flushed bytes with
+	// Next, so the next bets reuse their room.
+	b := to.Bytes()
+	to.Truncate(copy(b, b[start:]))
+	*betsCounter = 1
+	return nil
+}
+
+// betSize returns the encoded size of the [string map] at the start of body,
+// walking its length prefixes without decoding the strings.
+func betSize(body []byte) int {
+	nPairs := int(binary.LittleEndian.Uint32(body))
+	size := 4
+	for i := 0; i < 2*nPairs; i++ {
+		size += 4 + int(binary.LittleEndian.Uint32(body[size:]))
+	}
+	return size
+}
+
+// splitAtBet returns the length of the longest prefix of body that holds
+// whole bets encoded with codec and does not exceed limit bytes.
+func splitAtBet(body []byte, limit int, codec Codec) (int, error) {
+	n := 0
+	for n < len(body) {
+		size, err := codec.BetSize(body[n:])
+		if err != nil {
+			return 0, err
+		}
+		if n+size > limit {
+			break
+		}
+		n += size
+	}
+	return n, nil
+}
+
+// FlushBatch frames and writes a logical NewBets message to `out` from the
+// accumulated body in `batch`. The first physical frame is:
+//
+//	[opcode=NewBets:1][length=i32 LE (4 + partLen)][nBets=i32 LE][part]
+//
+// and, if the body does not fit in maxFrameSize, it is followed by
+//
+//	[opcode=Continuation:1][length=i32 LE (partLen)][part]
+//
+// frames until the body is exhausted. Parts are always split at bet
+// boundaries, so the receiver knows the batch is complete once nBets
+// bets were parsed. Bets must be encoded with codec (nil means
+// BinaryCodec). The frames are recorded in counters, if not nil. After a
+// successful write it resets the batch buffer. Any write error is returned.
+func FlushBatch(batch *bytes.Buffer, out io.Writer, betsCounter int32, maxFrameSize int, codec Codec, counters *Counters) error {
+	if _, err := writeBatch(batch.Bytes(), out, betsCounter, maxFrameSize, codecOrDefault(codec), counters); err != nil {
+		return err
+	}
+	batch.Reset()
+	return nil
+}
+
+// writeBatch writes body, the bets of a batch, as the frames described in
+// FlushBatch. Unless out is a buffer already, the frames are assembled in a
+// pooled buffer and written at once when the batch is complete, so an
+// unbuffered out such as a socket gets one write per batch instead of two
+// small writes per frame. On error nothing is written to out, unless the
+// write itself fails. It returns the bytes written to out.
+func writeBatch(body []byte, out io.Writer, betsCounter int32, maxFrameSize int, codec Codec, counters *Counters) (int64, error) {
+	frames, buffered := out.(*bytes.Buffer)
+	if !buffered {
+		frames = GetBuffer()
+		defer PutBuffer(frames)
+	}
+	start := frames.Len()
+	var header [frameHeaderSize + 4]byte
+	var newBetsSize, continuations, continuationsSize int
+	opcode := NewBetsOpCode
+	for first := true; first || len(body) > 0; first = false {
+		limit := maxFrameSize - frameHeaderSize
+		if first {
+			limit -= 4
+		}
+		n, err := splitAtBet(body, limit, codec)
+		if err == nil && n == 0 && len(body) > 0 {
+			err = &ProtocolError{Msg: "bet exceeds frame size", Opcode: opcode}
+		}
+		if err != nil {
+			frames.Truncate(start)
+			return 0, err
+		}
+		headerSize := frameHeaderSize
+		length := int32(n)
+		if first {
+			binary.LittleEndian.PutUint32(header[frameHeaderSize:], uint32(betsCounter))
+			headerSize += 4
+			length += 4
+		}
+		putFrameHeader(header[:], opcode, length)
+		frames.Write(header[:headerSize])
+		frames.Write(body[:n])
+		if first {
+			newBetsSize = frameHeaderSize + int(length)
+		} else {
+			continuations++
+			continuationsSize += frameHeaderSize + int(length)
+		}
+		body = body[n:]
+		opcode = ContinuationOpCode
+	}
+	written := int64(frames.Len() - start)
+	if !buffered {
+		n, err := out.Write(frames.Bytes())
+		if err != nil {
+			return int64(n), err
+		}
+	}
+	counters.countFramesSent(NewBetsOpCode, 1, newBetsSize)
+	if continuations > 0 {
+		counters.countFramesSent(ContinuationOpCode, continuations, continuationsSize)
+	}
+	counters.countBatchFlushed()
+	return written, nil
+}
+
+// MaxInboundBodyLength bounds the body length accepted for a server frame.
+// Longer advertised lengths are considered implausible: the stream cannot be
+// resynchronized by skipping the body, so the connection has to be dropped.
+const MaxInboundBodyLength int32 = 1 << 20
+
+// Readable is implemented by inbound messages that can parse themselves
+// from their complete frame body, decoded with codec.
+type Readable interface {
+	decode(body []byte, codec Codec) error
+	Message
+}
+
+// BetsRecvSuccess is the server→client acknowledgment for a batch processed
+// successfully. Its body length is always 0.
+type BetsRecvSuccess struct{}
+
+func (msg *BetsRecvSuccess) GetOpCode() OpCode { return BetsRecvSuccessOpCode }
+func (msg *BetsRecvSuccess) GetLength() int32  { return 0 }
+
+// decode validates that the body is empty, whatever the codec.
+func (msg *BetsRecvSuccess) decode(body []byte, codec Codec) error {
+	if len(body) != 0 {
+		return &ProtocolError{Msg: "invalid body length", Opcode: BetsRecvSuccessOpCode}
+	}
+	return nil
+}
+
+func (msg *BetsRecvSuccess) String() string { return "BetsRecvSuccess{}" }
+
+// BetsRecvFail is the server→client negative acknowledgment for a batch.
+// Its body length is always 0.
+type BetsRecvFail struct{}
+
+func (msg *BetsRecvFail) GetOpCode() OpCode { return BetsRecvFailOpCode }
+func (msg *BetsRecvFail) GetLength() int32  { return 0 }
+
+// decode validates that the body is empty, whatever the codec.
+func (msg *BetsRecvFail) decode(body []byte, codec Codec) error {
+	if len(body) != 0 {
+		return &ProtocolError{Msg: "invalid body length", Opcode: BetsRecvFailOpCode}
+	}
+	return nil
+}
+
+func (msg *BetsRecvFail) String() string { return "BetsRecvFail{}" }
+
+// Winners is the server→client response listing winner documents for an agency.
+// Body format: [n:i32 LE][n × [string]] where [string] is length-prefixed UTF-8.
+type Winners struct {
+	List []string
+}
+
+func (msg *Winners) GetOpCode() OpCode { return WinnersOpCode }
+
+// GetLength computes the body length: 4 bytes for n plus each string's
+// 4-byte length prefix and its bytes.
+func (msg *Winners) GetLength() int32 {
+	var totalLen int32 = 4
+	for _, doc := range msg.List {
+		totalLen += 4 + int32(len(doc))
+	}
+	return totalLen
+}
+
+func (msg *Winners) String() string {
+	return fmt.Sprintf("Winners{count: %d}", len(msg.List))
+}
+
+// decode parses the Winners body with codec into msg.List.
+func (msg *Winners) decode(body []byte, codec Codec) error {
+	list, err := codec.DecodeWinners(body)
+	if err != nil {
+		return bodyError(err, msg.GetOpCode())
+	}
+	msg.List = list
+	return nil
+}
+
+// readWinners parses a BinaryCodec Winners body defensively, validating
+// remaining counters, string lengths, and consuming exactly the advertised
+// number of bytes.
+func readWinners(reader io.Reader, length int32) ([]string, error) {
+	remaining := length
+	if remaining < 4 {
+		return nil, &ProtocolError{Msg: "invalid body length", Opcode: WinnersOpCode}
+	}
+	var scratch []byte
+	nWinners, err := readInt32(reader, &scratch)
+	if err != nil {
+		return nil, err
+	}
+	if nWinners < 0 {
+		return nil, &ProtocolError{Msg: "invalid body", Opcode: WinnersOpCode}
+	}
+	remaining -= 4
+	// Every winner takes at least its 4-byte length prefix, which bounds the
+	// capacity allocated for a forged count
+	capacity := nWinners
+	if capacity > remaining/4 {
+		capacity = remaining / 4
+	}
+	list := make([]string, 0, capacity)
+	for i := int32(0); i < nWinners; i++ {
+		doc, err := readStringScratch(reader, &remaining, WinnersOpCode, &scratch)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, doc)
+	}
+	if remaining != 0 {
+		return nil, &ProtocolError{Msg: "invalid body length", Opcode: WinnersOpCode}
+	}
+	return list, nil
+}
+
+// AckStatus is the outcome reported by an Ack.
+type AckStatus byte
+
+const (
+	AckSuccess AckStatus = 0
+	AckFail    AckStatus = 1
+)
+
+func (s AckStatus) String() string {
+	switch s {
+	case AckSuccess:
+		return "success"
+	case AckFail:
+		return "fail"
+	default:
+		return fmt.Sprintf("AckStatus(%d)", byte(s))
+	}
+}
+
+// Ack is the generic server→client acknowledgment of a batch. It replaces
+// the BetsRecvSuccess/BetsRecvFail pair (still accepted for backward
+// compatibility). CorrelationID is the 1-based sequence number of the
+// NewBets batch it answers, counted per connection.
+// Body format: [correlationId:i32 LE][status:u8][detail:string map].
+type Ack struct {
+	CorrelationID int32
+	Status        AckStatus
+	Detail        map[string]string
+}
+
+func (msg *Ack) GetOpCode() OpCode { return AckOpCode }
+
+// GetLength computes the body length: correlation ID, status and the
+// encoded detail map.
+func (msg *Ack) GetLength() int32 {
+	totalLen := int32(4 + 1 + 4)
+	for k, v := range msg.Detail {
+		totalLen += 4 + int32(len(k)) + 4 + int32(len(v))
+	}
+	return totalLen
+}
+
+func (msg *Ack) String() string {
+	if len(msg.Detail) == 0 {
+		return fmt.Sprintf("Ack{correlation: %d, status: %v}", msg.CorrelationID, msg.Status)
+	}
+	return fmt.Sprintf("Ack{correlation: %d, status: %v, detail: %v}", msg.CorrelationID, msg.Status, msg.Detail)
+}
+
+// Success reports whether the acknowledged batch was stored.
+func (msg *Ack) Success() bool { return msg.Status == AckSuccess }
+
+// decode parses the Ack body with codec and validates its status.
+func (msg *Ack) decode(body []byte, codec Codec) error {
+	if err := codec.DecodeAck(body, msg); err != nil {
+		return bodyError(err, msg.GetOpCode())
+	}
+	if msg.Status != AckSuccess && msg.Status != AckFail {
+		return &ProtocolError{Msg: "invalid ack status", Opcode: msg.GetOpCode()}
+	}
+	return nil
+}
+
+// readAck parses a BinaryCodec Ack body, validating the detail map against
+// the advertised length.
+func readAck(reader io.Reader, length int32, msg *Ack) error {
+	remaining := length
+	if remaining < 4+1+4 {
+		return &ProtocolError{Msg: "invalid body length", Opcode: msg.GetOpCode()}
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &msg.CorrelationID); err != nil {
+		return err
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &msg.Status); err != nil {
+		return err
+	}
+	remaining -= 4 + 1
+	detail, err := readStringMap(reader, &remaining, msg.GetOpCode())
+	if err != nil {
+		return err
+	}
+	if remaining != 0 {
+		return &ProtocolError{Msg: "invalid body length", Opcode: msg.GetOpCode()}
+	}
+	msg.Detail = detail
+	return nil
+}
+
+// readString reads a protocol [string], decrementing *remaining by the
+// consumed bytes and failing if they exceed it.
+func readString(reader io.Reader, remaining *int32, opcode OpCode) (string, error) {
+	var scratch []byte
+	return readStringScratch(reader, remaining, opcode, &scratch)
+}
+
+// readInt32 reads an i32 LE into *scratch, grown as needed.
+func readInt32(reader io.Reader, scratch *[]byte) (int32, error) {
+	if cap(*scratch) < 4 {
+		*scratch = make([]byte, 4, 64)
+	}
+	b := (*scratch)[:4]
+	if _, err := io.ReadFull(reader, b); err != nil {
+		return 0, err
+	}
+	return int32(binary.LittleEndian.Uint32(b)), nil
+}
+
+// readStringScratch is readString reading the bytes of the string into
+// *scratch, grown as needed, so that reading many strings with the same
+// scratch only allocates the strings themselves.
+func readStringScratch(reader io.Reader, remaining *int32, opcode OpCode, scratch *[]byte) (string, error) {
+	if *remaining < 4 {
+		return "", &ProtocolError{Msg: "invalid body length", Opcode: opcode}
+	}
+	strLen, err := readInt32(reader, scratch)
+	if err != nil {
+		return "", err
+	}
+	if strLen < 0 {
+		return "", &ProtocolError{Msg: "invalid body", Opcode: opcode}
+	}
+	*remaining -= 4
+	if *remaining < strLen {
+		return "", &ProtocolError{Msg: "invalid body length", Opcode: opcode}
+	}
+	if cap(*scratch) < int(strLen) {
+		*scratch = make([]byte, strLen)
+	}
+	buf := (*scratch)[:strLen]
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return "", err
+	}
+	*remaining -= strLen
+	return string(buf), nil
+}
+
+// readStringMap reads a protocol [string map], decrementing *remaining by
+// the consumed bytes and failing if they exceed it.
+func readStringMap(reader io.Reader, remaining *int32, opcode OpCode) (map[string]string, error) {
+	if *remaining < 4 {
+		return nil, &ProtocolError{Msg: "invalid body length", Opcode: opcode}
+	}
+	var nPairs int32
+	if err := binary.Read(reader, binary.LittleEndian, &nPairs); err != nil {
+		return nil, err
+	}
+	if nPairs < 0 {
+		return nil, &ProtocolError{Msg: "invalid body", Opcode: opcode}
+	}
+	*remaining -= 4
+	result := make(map[string]string)
+	for i := int32(0); i < nPairs; i++ {
+		k, err := readString(reader, remaining, opcode)
+		if err != nil {
+			return nil, err
+		}
+		v, err := readString(reader, remaining, opcode)
+		if err != nil {
+			return nil, err
+		}
+		result[k] = v
+	}
+	return result, nil
+}
+
+// bodyError reports a body that could not be decoded as a ProtocolError
+// for opcode, keeping ProtocolErrors raised by the codec itself.
+func bodyError(err error, opcode OpCode) error {
+	if err == nil {
+		return nil
+	}
+	var protoErr *ProtocolError
+	if errors.As(err, &protoErr) {
+		return err
+	}
+	return &ProtocolError{Msg: fmt.Sprintf("invalid body: %v", err), Opcode: opcode}
+}
+
+// newReadable returns an empty inbound message for opcode, or nil if the
+// opcode is not a known server→client message.
+func newReadable(opcode OpCode) Readable {
+	switch opcode {
+	case BetsRecvSuccessOpCode:
+		return &BetsRecvSuccess{}
+	case BetsRecvFailOpCode:
+		return &BetsRecvFail{}
+	case WinnersOpCode:
+		return &Winners{}
+	case AckOpCode:
+		return &Ack{}
+	case SummaryOpCode:
+		return &Summary{}
+	default:
+		return nil
+	}
+}
+
+// ReadMessage reads exactly one framed server response from reader,
+// decoding its body with BinaryCodec. See ReadMessageCodec.
+func ReadMessage(reader *bufio.Reader) (Readable, error) {
+	return ReadMessageCodec(reader, BinaryCodec)
+}
+
+// ReadMessageCodec reads exactly one framed server response from reader.
+// It consumes the opcode, the body length and the whole body, dispatches
+// to the message parser (which decodes the body with codec and validates
+// it), and returns the parsed message. A nil codec means BinaryCodec.
+//
+// On invalid opcode or body, a ProtocolError with Resynced set is
+// returned: the body was fully consumed, so the stream is still
+// aligned on a frame boundary and the caller may keep reading. If the
+// length itself is implausible (negative or above MaxInboundBodyLength),
+// the returned ProtocolError has Resynced unset and the connection should
+// be dropped. On I/O issues, the underlying error is returned.
+func ReadMessageCodec(reader *bufio.Reader, codec Codec) (Readable, error) {
+	return readMessage(reader, codec, nil)
+}
+
+// readMessage is ReadMessageCodec recording the frame read in counters, if
+// not nil.
+func readMessage(reader *bufio.Reader, codec Codec, counters *Counters) (Readable, error) {
+	var err error
+	rawOpcode, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	opcode := OpCode(rawOpcode)
+	var length int32
+	if err := binary.Read(reader, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+	if length < 0 || length > MaxInboundBodyLength {
+		counters.countParseError()
+		return nil, &ProtocolError{Msg: "implausible body length", Opcode: opcode}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+	msg := newReadable(opcode)
+	if msg == nil {
+		err = &ProtocolError{Msg: "invalid opcode", Opcode: opcode}
+	} else {
+		err = bodyError(msg.decode(body, codecOrDefault(codec)), opcode)
+	}
+	var protoErr *ProtocolError
+	if errors.As(err, &protoErr) {
+		protoErr.Resynced = true
+		counters.countParseError()
+		counters.countFrameReceived(opcode, frameHeaderSize+int(length))
+		return nil, protoErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	counters.countFrameReceived(opcode, frameHeaderSize+int(length))
+	if opcode == BetsRecvSuccessOpCode || opcode == BetsRecvFailOpCode || opcode == AckOpCode {
+		counters.countAckReceived()
+	}
+	return msg, nil
+}
@@ -0,0 +1,195 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// benchBets are bets of representative sizes: the shortest valid one, a
+// typical one and one with every field at MaxFieldLength.
+var benchBets = []struct {
+	name string
+	bet  map[string]string
+}{
+	{"small", map[string]string{
+		"AGENCIA":    "1",
+		"NOMBRE":     "Ana",
+		"APELLIDO":   "Paz",
+		"DOCUMENTO":  "1234567",
+		"NACIMIENTO": "2000-01-01",
+		"NUMERO":     "1",
+	}},
+	{"typical", benchBet},
+	{"large", map[string]string{
+		"AGENCIA":    "5",
+		"NOMBRE":     strings.Repeat("n", MaxFieldLength),
+		"APELLIDO":   strings.Repeat("a", MaxFieldLength),
+		"DOCUMENTO":  strings.Repeat("9", MaxFieldLength),
+		"NACIMIENTO": "1999-03-17",
+		"NUMERO":     strings.Repeat("7", MaxFieldLength),
+	}},
+}
+
+var benchBet = map[string]string{
+	"AGENCIA":    "5",
+	"NOMBRE":     "Santiago Lionel",
+	"APELLIDO":   "Lorca",
+	"DOCUMENTO":  "30904465",
+	"NACIMIENTO": "1999-03-17",
+	"NUMERO":     "7574",
+}
+
+// benchBatchBody returns the body of a batch of n copies of bet.
+func benchBatchBody(bet map[string]string, n int, codec Codec) []byte {
+	var body bytes.Buffer
+	for i := 0; i < n; i++ {
+		_ = codec.AppendBet(&body, bet)
+	}
+	return body.Bytes()
+}
+
+func BenchmarkWriteStringMap(b *testing.B) {
+	for _, bench := range benchBets {
+		b.Run(bench.name, func(b *testing.B) {
+			var buff bytes.Buffer
+			_ = writeStringMap(&buff, bench.bet)
+			b.ReportAllocs()
+			b.SetBytes(int64(buff.Len()))
+			for i := 0; i < b.N; i++ {
+				buff.Reset()
+				if err := writeStringMap(&buff, bench.bet); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkAddBetWithFlush(b *testing.B) {
+	for _, codec := range []Codec{BinaryCodec, MsgpackCodec} {
+		for _, bench := range benchBets {
+			b.Run(codec.Name()+"/"+bench.name, func(b *testing.B) {
+				b.ReportAllocs()
+				var batch bytes.Buffer
+				var counter int32
+				for i := 0; i < b.N; i++ {
+					if err := AddBetWithFlush(bench.bet, &batch, io.Discard, &counter, 100, DefaultMaxFrameSize, codec, nil); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkFlushBatch(b *testing.B) {
+	for _, codec := range []Codec{BinaryCodec, MsgpackCodec} {
+		for _, bench := range benchBets {
+			b.Run(codec.Name()+"/"+bench.name, func(b *testing.B) {
+				bets := benchBatchBody(bench.bet, 100, codec)
+				b.ReportAllocs()
+				b.SetBytes(int64(len(bets)))
+				var batch bytes.Buffer
+				for i := 0; i < b.N; i++ {
+					batch.Write(bets)
+					if err := FlushBatch(&batch, io.Discard, 100, DefaultMaxFrameSize, codec, nil); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// benchFrame returns a frame with the given opcode and body.
+func benchFrame(opcode OpCode, body []byte) []byte {
+	return (&Frame{OpCode: opcode, Body: body}).Bytes()
+}
+
+func BenchmarkReadMessage(b *testing.B) {
+	var ack bytes.Buffer
+	writeInt32(&ack, 42)
+	ack.WriteByte(byte(AckSuccess))
+	writeInt32(&ack, 0)
+	var winners bytes.Buffer
+	writeInt32(&winners, 100)
+	for i := 0; i < 100; i++ {
+		_ = writeString(&winners, fmt.Sprint(30000000+i))
+	}
+	benches := []struct {
+		name  string
+		frame []byte
+	}{
+		{"ack", benchFrame(AckOpCode, ack.Bytes())},
+		{"winners", benchFrame(WinnersOpCode, winners.Bytes())},
+	}
+	for _, bench := range benches {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(bench.frame)))
+			in := bytes.NewReader(bench.frame)
+			reader := bufio.NewReader(in)
+			for i := 0; i < b.N; i++ {
+				in.Reset(bench.frame)
+				reader.Reset(in)
+				if _, err := ReadMessage(reader); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFinishedWriteTo(b *testing.B) {
+	b.ReportAllocs()
+	msg := &Finished{AgencyId: 5}
+	for i := 0; i < b.N; i++ {
+		if _, err := msg.WriteTo(io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkWriteBatchTCP measures writing batches of 100 and 1000 bets,
+// split into several frames, to a loopback TCP connection.
+func BenchmarkWriteBatchTCP(b *testing.B) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+	for _, bets := range []int{100, 1000} {
+		var body bytes.Buffer
+		for i := 0; i < bets; i++ {
+			_ = writeStringMap(&body, benchBet)
+		}
+		b.Run(fmt.Sprint(bets), func(b *testing.B) {
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close()
+			b.ReportAllocs()
+			b.SetBytes(int64(body.Len()))
+			for i := 0; i < b.N; i++ {
+				if _, err := writeBatch(body.Bytes(), conn, int32(bets), DefaultMaxFrameSize, BinaryCodec, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testFrame encodes a frame with the given opcode and body.
+func testFrame(opcode OpCode, body []byte) []byte {
+	return (&Frame{OpCode: opcode, Body: body}).Bytes()
+}
+
+func testInt32(v int32) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(v))
+	return b[:]
+}
+
+func testString(s string) []byte {
+	return append(testInt32(int32(len(s))), s...)
+}
+
+func testSummaryFrame(batches, bets, failed int32) []byte {
+	body := append(append(testInt32(batches), testInt32(bets)...), testInt32(failed)...)
+	return testFrame(SummaryOpCode, body)
+}
+
+func TestReadMessageCodecDecodesResponses(t *testing.T) {
+	binaryAck := append(append(testInt32(7), byte(AckFail)), testInt32(1)...)
+	binaryAck = append(append(binaryAck, testString("error")...), testString("bad")...)
+	tests := []struct {
+		name  string
+		codec Codec
+		frame []byte
+		want  Readable
+	}{
+		{"success", nil, testFrame(BetsRecvSuccessOpCode, nil), &BetsRecvSuccess{}},
+		{"binary ack", BinaryCodec, testFrame(AckOpCode, binaryAck),
+			&Ack{CorrelationID: 7, Status: AckFail, Detail: map[string]string{"error": "bad"}}},
+		{"msgpack ack", MsgpackCodec, testFrame(AckOpCode, []byte("\x93\xd2\x00\x00\x00\xc8\x00\x81\xa4bets\xa13")),
+			&Ack{CorrelationID: 200, Status: AckSuccess, Detail: map[string]string{"bets": "3"}}},
+		{"binary winners", BinaryCodec, testFrame(WinnersOpCode, append(testInt32(2), append(testString("30904465"), testString("1234567")...)...)),
+			&Winners{List: []string{"30904465", "1234567"}}},
+		{"msgpack winners", MsgpackCodec, testFrame(WinnersOpCode, []byte("\x91\xa830904465")),
+			&Winners{List: []string{"30904465"}}},
+		{"summary", MsgpackCodec, testSummaryFrame(4, 350, 1), &Summary{Batches: 4, Bets: 350, Failed: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := ReadMessageCodec(bufio.NewReader(bytes.NewReader(tt.frame)), tt.codec)
+			if err != nil {
+				t.Fatalf("ReadMessageCodec: %v", err)
+			}
+			if !reflect.DeepEqual(msg, tt.want) {
+				t.Fatalf("ReadMessageCodec = %#v; want %#v", msg, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadMessageCodecResync(t *testing.T) {
+	tests := []struct {
+		name  string
+		codec Codec
+		frame []byte
+	}{
+		{"unknown opcode", nil, testFrame(OpCode(99), []byte("skipped"))},
+		{"client opcode", nil, testFrame(FinishedOpCode, testInt32(1))},
+		{"truncated ack", BinaryCodec, testFrame(AckOpCode, testInt32(7))},
+		{"ack with trailing bytes", BinaryCodec, testFrame(AckOpCode, append(append(testInt32(7), byte(AckSuccess)), append(testInt32(0), 0)...))},
+		{"winners count too high", BinaryCodec, testFrame(WinnersOpCode, append(testInt32(2), testString("30904465")...))},
+		{"binary ack read as msgpack", MsgpackCodec, testFrame(AckOpCode, append(append(testInt32(7), byte(AckSuccess)), testInt32(0)...))},
+		{"summary too short", nil, testFrame(SummaryOpCode, testInt32(1))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := append(append([]byte{}, tt.frame...), testSummaryFrame(3, 2, 1)...)
+			reader := bufio.NewReader(bytes.NewReader(stream))
+			_, err := ReadMessageCodec(reader, tt.codec)
+			var protoErr *ProtocolError
+			if !errors.As(err, &protoErr) || !protoErr.Resynced {
+				t.Fatalf("ReadMessageCodec error = %v; want a resynced ProtocolError", err)
+			}
+			msg, err := ReadMessageCodec(reader, tt.codec)
+			if err != nil {
+				t.Fatalf("next ReadMessageCodec: %v", err)
+			}
+			if want := (&Summary{Batches: 3, Bets: 2, Failed: 1}); !reflect.DeepEqual(msg, want) {
+				t.Fatalf("next message = %#v; want %#v", msg, want)
+			}
+		})
+	}
+}
+
+func TestReadMessageCodecImplausibleLength(t *testing.T) {
+	for _, length := range []int32{-1, MaxInboundBodyLength + 1} {
+		header := append([]byte{byte(AckOpCode)}, testInt32(length)...)
+		_, err := ReadMessageCodec(bufio.NewReader(bytes.NewReader(header)), nil)
+		var protoErr *ProtocolError
+		if !errors.As(err, &protoErr) || protoErr.Resynced {
+			t.Fatalf("length %d: error = %v; want a ProtocolError without Resynced", length, err)
+		}
+	}
+}
+
+// readFrames reads every frame of stream.
+func readFrames(t *testing.T, stream []byte) []*Frame {
+	t.Helper()
+	var frames []*Frame
+	reader := bytes.NewReader(stream)
+	for reader.Len() > 0 {
+		frame, err := ReadFrame(reader)
+		if err != nil {
+			t.Fatalf("ReadFrame: %v", err)
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+func TestFrameDecodeBatch(t *testing.T) {
+	bets := []map[string]string{benchBets[0].bet, benchBet, benchBets[2].bet, benchBet}
+	for _, codec := range []Codec{BinaryCodec, MsgpackCodec} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			var batch, stream bytes.Buffer
+			for _, bet := range bets {
+				if err := codec.AppendBet(&batch, bet); err != nil {
+					t.Fatalf("AppendBet: %v", err)
+				}
+			}
+			// Small enough that the large bet travels alone.
+			if err := FlushBatch(&batch, &stream, int32(len(bets)), 1200, codec, nil); err != nil {
+				t.Fatalf("FlushBatch: %v", err)
+			}
+			frames := readFrames(t, stream.Bytes())
+			if len(frames) < 2 {
+				t.Fatalf("%d frames; want the batch split in several", len(frames))
+			}
+			var decoded []map[string]string
+			for i, frame := range frames {
+				msg, err := frame.Decode(codec)
+				if err != nil {
+					t.Fatalf("frame %d: Decode: %v", i, err)
+				}
+				betsFrame, ok := msg.(*BetsFrame)
+				if !ok {
+					t.Fatalf("frame %d: decoded as %T; want *BetsFrame", i, msg)
+				}
+				if betsFrame.Continuation != (i > 0) {
+					t.Fatalf("frame %d: Continuation = %t", i, betsFrame.Continuation)
+				}
+				if i == 0 && betsFrame.Total != int32(len(bets)) {
+					t.Fatalf("Total = %d; want %d", betsFrame.Total, len(bets))
+				}
+				if betsFrame.GetLength() != int32(len(frame.Body)) {
+					t.Fatalf("frame %d: GetLength = %d; want %d", i, betsFrame.GetLength(), len(frame.Body))
+				}
+				decoded = append(decoded, betsFrame.Bets...)
+			}
+			if !reflect.DeepEqual(decoded, bets) {
+				t.Fatalf("decoded bets = %v; want %v", decoded, bets)
+			}
+		})
+	}
+}
+
+func TestFrameDecodeClientMessages(t *testing.T) {
+	for _, codec := range []Codec{BinaryCodec, MsgpackCodec} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			var stream bytes.Buffer
+			messages := []Writeable{
+				&Finished{AgencyId: 65536, Codec: codec},
+				&Quiet{},
+				&SummaryRequest{},
+				&Telemetry{Stats: map[string]string{"bets": "3000", "version": "1.2"}},
+			}
+			for _, msg := range messages {
+				if _, err := msg.WriteTo(&stream); err != nil {
+					t.Fatalf("WriteTo: %v", err)
+				}
+			}
+			frames := readFrames(t, stream.Bytes())
+			if len(frames) != len(messages) {
+				t.Fatalf("%d frames; want %d", len(frames), len(messages))
+			}
+			for i, frame := range frames {
+				msg, err := frame.Decode(codec)
+				if err != nil {
+					t.Fatalf("%v: Decode: %v", frame.OpCode, err)
+				}
+				if !reflect.DeepEqual(msg, messages[i]) {
+					t.Fatalf("%v: Decode = %#v; want %#v", frame.OpCode, msg, messages[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFrameDecodeServerMessages(t *testing.T) {
+	frame := &Frame{OpCode: SummaryOpCode, Body: testSummaryFrame(4, 350, 1)[frameHeaderSize:]}
+	msg, err := frame.Decode(nil)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if want := (&Summary{Batches: 4, Bets: 350, Failed: 1}); !reflect.DeepEqual(msg, want) {
+		t.Fatalf("Decode = %#v; want %#v", msg, want)
+	}
+}
+
+func TestFrameDecodeRejectsInvalidFrames(t *testing.T) {
+	var bet bytes.Buffer
+	_ = BinaryCodec.AppendBet(&bet, benchBet)
+	tests := []struct {
+		name  string
+		frame *Frame
+	}{
+		{"unknown opcode", &Frame{OpCode: OpCode(99)}},
+		{"new bets without counter", &Frame{OpCode: NewBetsOpCode, Body: []byte{1, 0}}},
+		{"truncated bet", &Frame{OpCode: NewBetsOpCode, Body: append(testInt32(1), bet.Bytes()[:bet.Len()-1]...)}},
+		{"truncated continuation", &Frame{OpCode: ContinuationOpCode, Body: bet.Bytes()[:10]}},
+		{"quiet with a body", &Frame{OpCode: QuietOpCode, Body: []byte{0}}},
+		{"summary request with a body", &Frame{OpCode: SummaryRequestOpCode, Body: []byte{0}}},
+		{"finished too short", &Frame{OpCode: FinishedOpCode, Body: []byte{1, 0}}},
+		{"telemetry with trailing bytes", &Frame{OpCode: TelemetryOpCode, Body: append(testInt32(0), 0)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.frame.Decode(BinaryCodec)
+			var protoErr *ProtocolError
+			if !errors.As(err, &protoErr) {
+				t.Fatalf("Decode error = %v; want a ProtocolError", err)
+			}
+			if protoErr.Opcode != tt.frame.OpCode {
+				t.Fatalf("ProtocolError opcode = %v; want %v", protoErr.Opcode, tt.frame.OpCode)
+			}
+		})
+	}
+}
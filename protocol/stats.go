@@ -0,0 +1,117 @@
+package protocol
+
+import "sync/atomic"
+
+// OpcodeStats holds the traffic counters of a single opcode.
+type OpcodeStats struct {
+	FramesSent     uint64 `json:"frames_sent"`
+	BytesSent      uint64 `json:"bytes_sent"`
+	FramesReceived uint64 `json:"frames_received"`
+	BytesReceived  uint64 `json:"bytes_received"`
+}
+
+// ProtocolStats is a point-in-time snapshot of Counters.
+// - PerOpcode: traffic per opcode, only for opcodes seen at least once.
+// - BatchesFlushed: logical NewBets batches written (continuations excluded).
+// - AcksReceived: Ack, BetsRecvSuccess and BetsRecvFail frames parsed.
+// - ParseErrors: inbound frames rejected with a ProtocolError.
+type ProtocolStats struct {
+	PerOpcode      map[OpCode]OpcodeStats `json:"per_opcode"`
+	BatchesFlushed uint64                 `json:"batches_flushed"`
+	AcksReceived   uint64                 `json:"acks_received"`
+	ParseErrors    uint64                 `json:"parse_errors"`
+}
+
+// Counters accumulates the traffic of the frames written and read with it,
+// for instance those of a client run. Its methods are safe for concurrent
+// use, and a nil *Counters records nothing. The zero value is ready to use.
+type Counters struct {
+	framesSent     [256]uint64
+	bytesSent      [256]uint64
+	framesReceived [256]uint64
+	bytesReceived  [256]uint64
+	batchesFlushed uint64
+	acksReceived   uint64
+	parseErrors    uint64
+}
+
+// countFrameSent records an outbound frame of size bytes (header included).
+func (c *Counters) countFrameSent(opcode OpCode, size int) {
+	c.countFramesSent(opcode, 1, size)
+}
+
+// countFramesSent records n outbound frames of size bytes in total.
+func (c *Counters) countFramesSent(opcode OpCode, n int, size int) {
+	if c == nil {
+		return
+	}
+	atomic.AddUint64(&c.framesSent[opcode], uint64(n))
+	atomic.AddUint64(&c.bytesSent[opcode], uint64(size))
+}
+
+// countFrameReceived records an inbound frame of size bytes (header included).
+func (c *Counters) countFrameReceived(opcode OpCode, size int) {
+	if c == nil {
+		return
+	}
+	atomic.AddUint64(&c.framesReceived[opcode], 1)
+	atomic.AddUint64(&c.bytesReceived[opcode], uint64(size))
+}
+
+// countBatchFlushed records a logical NewBets batch written.
+func (c *Counters) countBatchFlushed() {
+	if c != nil {
+		atomic.AddUint64(&c.batchesFlushed, 1)
+	}
+}
+
+// countAckReceived records an acknowledgement parsed.
+func (c *Counters) countAckReceived() {
+	if c != nil {
+		atomic.AddUint64(&c.acksReceived, 1)
+	}
+}
+
+// countParseError records an inbound frame rejected with a ProtocolError.
+func (c *Counters) countParseError() {
+	if c != nil {
+		atomic.AddUint64(&c.parseErrors, 1)
+	}
+}
+
+// Reset sets every counter back to zero.
+func (c *Counters) Reset() {
+	for opcode := 0; opcode < 256; opcode++ {
+		atomic.StoreUint64(&c.framesSent[opcode], 0)
+		atomic.StoreUint64(&c.bytesSent[opcode], 0)
+		atomic.StoreUint64(&c.framesReceived[opcode], 0)
+		atomic.StoreUint64(&c.bytesReceived[opcode], 0)
+	}
+	atomic.StoreUint64(&c.batchesFlushed, 0)
+	atomic.StoreUint64(&c.acksReceived, 0)
+	atomic.StoreUint64(&c.parseErrors, 0)
+}
+
+// Stats returns a snapshot of the counters. Counters are read individually,
+// so a snapshot taken while traffic is flowing may be slightly inconsistent
+// across fields.
+func (c *Counters) Stats() ProtocolStats {
+	stats := ProtocolStats{
+		PerOpcode:      map[OpCode]OpcodeStats{},
+		BatchesFlushed: atomic.LoadUint64(&c.batchesFlushed),
+		AcksReceived:   atomic.LoadUint64(&c.acksReceived),
+		ParseErrors:    atomic.LoadUint64(&c.parseErrors),
+	}
+	for opcode := 0; opcode < 256; opcode++ {
+		opStats := OpcodeStats{
+			FramesSent:     atomic.LoadUint64(&c.framesSent[opcode]),
+			BytesSent:      atomic.LoadUint64(&c.bytesSent[opcode]),
+			FramesReceived: atomic.LoadUint64(&c.framesReceived[opcode]),
+			BytesReceived:  atomic.LoadUint64(&c.bytesReceived[opcode]),
+		}
+		if opStats != (OpcodeStats{}) {
+			stats.PerOpcode[OpCode(opcode)] = opStats
+		}
+	}
+	return stats
+}
@@ -0,0 +1,68 @@
+package protocol
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestCounters(t *testing.T) {
+	bet, err := NewBet("5", "Ana", "Gomez", "30123412", "1990-05-17", "7574")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var counters, other Counters
+	var out, batch bytes.Buffer
+	fields := GetFields(bet)
+	var betsCounter int32
+	for i := 0; i < 3; i++ {
+		if err := AddBetWithFlush(fields, &batch, &out, &betsCounter, 2, DefaultMaxFrameSize, nil, &counters); err != nil {
+			t.Fatalf("AddBetWithFlush: %v", err)
+		}
+	}
+	if err := FlushBatch(&batch, &out, betsCounter, DefaultMaxFrameSize, nil, &counters); err != nil {
+		t.Fatalf("FlushBatch: %v", err)
+	}
+	if _, err := (&Finished{AgencyId: 5, Counters: &counters}).WriteTo(&out); err != nil {
+		t.Fatalf("Finished: %v", err)
+	}
+	if _, err := (&Quiet{}).WriteTo(&out); err != nil {
+		t.Fatalf("Quiet: %v", err)
+	}
+
+	ack := testFrame(AckOpCode, append(append(testInt32(1), byte(AckSuccess)), testInt32(0)...))
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		server.Write(append(ack, testFrame(OpCode(99), nil)...))
+		server.Close()
+	}()
+	reader := NewConnReaderCodec(client, nil, &counters)
+	for i := 0; i < 2; i++ {
+		reader.read()
+	}
+
+	finishedSize := uint64(frameHeaderSize + 4)
+	want := ProtocolStats{
+		PerOpcode: map[OpCode]OpcodeStats{
+			NewBetsOpCode:  {FramesSent: 2, BytesSent: uint64(out.Len()) - finishedSize - frameHeaderSize},
+			FinishedOpCode: {FramesSent: 1, BytesSent: finishedSize},
+			AckOpCode:      {FramesReceived: 1, BytesReceived: uint64(len(ack))},
+			OpCode(99):     {FramesReceived: 1, BytesReceived: frameHeaderSize},
+		},
+		BatchesFlushed: 2,
+		AcksReceived:   1,
+		ParseErrors:    1,
+	}
+	if got := counters.Stats(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Stats = %+v; want %+v", got, want)
+	}
+	if got := other.Stats(); len(got.PerOpcode) != 0 || got.BatchesFlushed != 0 {
+		t.Fatalf("Stats of unused counters = %+v; want none", got)
+	}
+	counters.Reset()
+	if got := counters.Stats(); !reflect.DeepEqual(got, ProtocolStats{PerOpcode: map[OpCode]OpcodeStats{}}) {
+		t.Fatalf("Stats after Reset = %+v; want none", got)
+	}
+}
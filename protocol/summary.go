@@ -0,0 +1,84 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Quiet is a client→server message asking the server to stop acknowledging
+// the NewBets batches sent on the connection; the client asks for a
+// Summary instead. Its body length is always 0. Counters, if set, records
+// the frame written.
+type Quiet struct {
+	Counters *Counters
+}
+
+func (msg *Quiet) GetOpCode() OpCode { return QuietOpCode }
+func (msg *Quiet) GetLength() int32  { return 0 }
+
+// WriteTo writes the QUIET frame.
+func (msg *Quiet) WriteTo(out io.Writer) (int64, error) {
+	return writeEmpty(out, msg.GetOpCode(), msg.Counters)
+}
+
+func (msg *Quiet) String() string { return "Quiet{}" }
+
+// SummaryRequest is a client→server message asking for a Summary of the
+// NewBets batches received on the connection. Its body length is always 0.
+// Counters, if set, records the frame written.
+type SummaryRequest struct {
+	Counters *Counters
+}
+
+func (msg *SummaryRequest) GetOpCode() OpCode { return SummaryRequestOpCode }
+func (msg *SummaryRequest) GetLength() int32  { return 0 }
+
+// WriteTo writes the SUMMARY_REQUEST frame.
+func (msg *SummaryRequest) WriteTo(out io.Writer) (int64, error) {
+	return writeEmpty(out, msg.GetOpCode(), msg.Counters)
+}
+
+func (msg *SummaryRequest) String() string { return "SummaryRequest{}" }
+
+// writeEmpty writes a frame with an empty body, recording it in counters.
+func writeEmpty(out io.Writer, opcode OpCode, counters *Counters) (int64, error) {
+	var frame [frameHeaderSize]byte
+	frame[0] = byte(opcode)
+	if _, err := out.Write(frame[:]); err != nil {
+		return 0, err
+	}
+	counters.countFrameSent(opcode, frameHeaderSize)
+	return frameHeaderSize, nil
+}
+
+// Summary is the server→client answer to a SummaryRequest, counting the
+// NewBets batches received on the connection: Batches (rejected ones
+// included), the Bets stored and the Failed batches.
+// Body format, whatever the codec: [batches:i32 LE][bets:i32 LE][failed:i32 LE].
+type Summary struct {
+	Batches int32
+	Bets    int32
+	Failed  int32
+}
+
+func (msg *Summary) GetOpCode() OpCode { return SummaryOpCode }
+func (msg *Summary) GetLength() int32  { return 3 * 4 }
+
+func (msg *Summary) String() string {
+	return fmt.Sprintf("Summary{batches: %d, bets: %d, failed: %d}", msg.Batches, msg.Bets, msg.Failed)
+}
+
+// decode parses the fixed-size body, which the codec does not affect.
+func (msg *Summary) decode(body []byte, codec Codec) error {
+	if int32(len(body)) != msg.GetLength() {
+		return &ProtocolError{Msg: "invalid body length", Opcode: SummaryOpCode}
+	}
+	msg.Batches = int32(binary.LittleEndian.Uint32(body[0:4]))
+	msg.Bets = int32(binary.LittleEndian.Uint32(body[4:8]))
+	msg.Failed = int32(binary.LittleEndian.Uint32(body[8:12]))
+	if msg.Batches < 0 || msg.Bets < 0 || msg.Failed < 0 || msg.Failed > msg.Batches {
+		return &ProtocolError{Msg: "invalid body", Opcode: SummaryOpCode}
+	}
+	return nil
+}
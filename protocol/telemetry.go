@@ -0,0 +1,73 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Telemetry is an optional client→server message reporting the stats of the
+// upload of an agency (bets sent, retries, client version...), sent before
+// FINISHED so the server can aggregate the upload health of every agency.
+// Servers that do not know it reject it as an invalid opcode without
+// replying, so sending it to them is harmless.
+// Body format, whatever the codec: [string map] of stat name → value.
+// Counters, if set, records the frame written.
+type Telemetry struct {
+	Stats    map[string]string
+	Counters *Counters
+}
+
+func (msg *Telemetry) GetOpCode() OpCode { return TelemetryOpCode }
+
+// GetLength returns the body length.
+func (msg *Telemetry) GetLength() int32 {
+	var body bytes.Buffer
+	_ = writeStringMap(&body, msg.Stats)
+	return int32(body.Len())
+}
+
+// WriteTo writes the TELEMETRY frame.
+func (msg *Telemetry) WriteTo(out io.Writer) (int64, error) {
+	var frame bytes.Buffer
+	frame.WriteByte(byte(msg.GetOpCode()))
+	frame.Write([]byte{0, 0, 0, 0})
+	if err := writeStringMap(&frame, msg.Stats); err != nil {
+		return 0, err
+	}
+	binary.LittleEndian.PutUint32(frame.Bytes()[1:], uint32(frame.Len()-frameHeaderSize))
+	if _, err := out.Write(frame.Bytes()); err != nil {
+		return 0, err
+	}
+	msg.Counters.countFrameSent(msg.GetOpCode(), frame.Len())
+	return int64(frame.Len()), nil
+}
+
+func (msg *Telemetry) String() string {
+	keys := make([]string, 0, len(msg.Stats))
+	for key := range msg.Stats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + ": " + msg.Stats[key]
+	}
+	return fmt.Sprintf("Telemetry{%s}", strings.Join(pairs, ", "))
+}
+
+// decodeTelemetry parses the body of a TELEMETRY frame.
+func decodeTelemetry(body []byte) (*Telemetry, error) {
+	remaining := int32(len(body))
+	stats, err := readStringMap(bytes.NewReader(body), &remaining, TelemetryOpCode)
+	if err != nil {
+		return nil, err
+	}
+	if remaining != 0 {
+		return nil, &ProtocolError{Msg: "invalid body length", Opcode: TelemetryOpCode}
+	}
+	return &Telemetry{Stats: stats}, nil
+}
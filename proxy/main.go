@@ -0,0 +1,178 @@
+// Command proxy is a debugging man-in-the-middle for the lottery protocol:
+// it listens for clients, forwards every connection to the server and
+// prints each frame of both directions, decoded, along with when it was
+// seen. Bytes are forwarded as they are read, so the proxy adds no
+// buffering delay. It only understands plain TCP: TLS connections are
+// forwarded but their frames cannot be decoded.
+//
+// Usage:
+//
+//	proxy -listen :12346 -server server:12345 [-codec msgpack] [-bets] [-capture file]
+//
+// Each frame is printed as one tab separated line: the time, the connection
+// number, the direction, the time since the previous frame of the
+// connection, the opcode, the body length and the decoded message.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+func main() {
+	listen := flag.String("listen", ":12346", "address to accept clients on")
+	server := flag.String("server", "server:12345", "address of the server to forward to")
+	codecName := flag.String("codec", "", "body codec (binary or msgpack)")
+	showBets := flag.Bool("bets", false, "also print the bets of every NewBets and Continuation frame")
+	capturePath := flag.String("capture", "", "also record every frame to this capture file")
+	flag.Parse()
+
+	codec, err := protocol.CodecByName(*codecName)
+	if err != nil {
+		log.Fatalf("action: config | result: fail | error: %v", err)
+	}
+	p := &proxy{server: *server, codec: codec, showBets: *showBets}
+	if *capturePath != "" {
+		file, err := os.Create(*capturePath)
+		if err != nil {
+			log.Fatalf("action: capture | result: fail | path: %s | error: %v", *capturePath, err)
+		}
+		defer file.Close()
+		if p.capture, err = protocol.NewCaptureWriter(file); err != nil {
+			log.Fatalf("action: capture | result: fail | path: %s | error: %v", *capturePath, err)
+		}
+	}
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("action: listen | result: fail | address: %s | error: %v", *listen, err)
+	}
+	log.Printf("action: listen | result: success | address: %s | server: %s", listener.Addr(), *server)
+	for id := 1; ; id++ {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Fatalf("action: accept | result: fail | error: %v", err)
+		}
+		go p.serve(id, conn)
+	}
+}
+
+// proxy forwards the connections of clients to the server, printing their
+// frames to stdout.
+type proxy struct {
+	server   string
+	codec    protocol.Codec
+	showBets bool
+	capture  *protocol.CaptureWriter
+
+	// mu keeps the lines of concurrent connections from interleaving.
+	mu sync.Mutex
+}
+
+// conn is a proxied connection, numbered in order of arrival.
+type conn struct {
+	id       int
+	mu       sync.Mutex
+	lastSeen time.Time
+}
+
+// serve forwards client to the server until both directions are closed.
+func (p *proxy) serve(id int, client net.Conn) {
+	defer client.Close()
+	server, err := net.Dial("tcp", p.server)
+	if err != nil {
+		log.Printf("action: dial | result: fail | conn: %d | server: %s | error: %v", id, p.server, err)
+		return
+	}
+	defer server.Close()
+	log.Printf("action: accept | result: success | conn: %d | client: %s", id, client.RemoteAddr())
+
+	c := &conn{id: id, lastSeen: time.Now()}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		p.forward(c, protocol.ClientToServer, client, server)
+	}()
+	go func() {
+		defer wg.Done()
+		p.forward(c, protocol.ServerToClient, server, client)
+	}()
+	wg.Wait()
+	log.Printf("action: close | result: success | conn: %d", id)
+}
+
+// forward copies src to dst, printing every frame once its last byte was
+// forwarded. Bytes that do not parse as frames are forwarded as they are,
+// without printing them. The write side of dst is closed once src ends,
+// so the peer sees the half close.
+func (p *proxy) forward(c *conn, dir protocol.Direction, src, dst net.Conn) {
+	defer closeWrite(dst)
+	tee := io.TeeReader(src, dst)
+	for {
+		frame, err := protocol.ReadFrame(tee)
+		if err != nil {
+			var protoErr *protocol.ProtocolError
+			if errors.As(err, &protoErr) {
+				log.Printf("action: decode | result: fail | conn: %d | direction: %v | error: %v | forwarding raw bytes",
+					c.id, dir, err)
+				_, err = io.Copy(dst, src)
+			}
+			if err != nil && err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Printf("action: forward | result: fail | conn: %d | direction: %v | error: %v", c.id, dir, err)
+			}
+			return
+		}
+		p.print(c, dir, frame)
+	}
+}
+
+// print writes the line of frame, seen now on c, and its bets if asked to.
+func (p *proxy) print(c *conn, dir protocol.Direction, frame *protocol.Frame) {
+	now := time.Now()
+	c.mu.Lock()
+	since := now.Sub(c.lastSeen)
+	c.lastSeen = now
+	c.mu.Unlock()
+	if p.capture != nil {
+		if err := p.capture.WriteFrame(dir, now, frame.Bytes()); err != nil {
+			log.Printf("action: capture | result: fail | error: %v", err)
+		}
+	}
+
+	var line strings.Builder
+	msg, err := frame.Decode(p.codec)
+	fmt.Fprintf(&line, "%s\t#%d\t%v\t+%v\t%v\t%d\t", now.Format("15:04:05.000000"), c.id, dir,
+		since.Round(time.Microsecond), frame.OpCode, len(frame.Body))
+	if err != nil {
+		fmt.Fprintf(&line, "error: %v\n", err)
+	} else {
+		fmt.Fprintf(&line, "%v\n", msg)
+	}
+	if bets, ok := msg.(*protocol.BetsFrame); ok && p.showBets {
+		for _, bet := range bets.Bets {
+			fmt.Fprintf(&line, "\t%s\n", protocol.FormatBet(bet))
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	os.Stdout.WriteString(line.String())
+}
+
+// closeWrite half closes conn if it supports it, or closes it otherwise.
+func closeWrite(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+		return
+	}
+	conn.Close()
+}
@@ -0,0 +1,182 @@
+import socket
+import struct
+
+from app.protocol import ProtocolError, read_i32, read_string, recv_exactly
+
+
+class BinaryCodec:
+    """Original body encoding: little-endian i32 length-prefixed fields.
+
+    Bet:      [n_pairs:i32 LE == 6] 6 × [key:string][value:string]
+    FINISHED: [agency_id:i32 LE]
+    WINNERS:  [count:i32 LE] count × [string]
+    ACK:      [correlation_id:i32 LE][status:u8][detail:string map]
+    """
+
+    name = "binary"
+
+    def read_bet(self, sock: socket.socket, remaining: int, opcode: int) -> tuple[dict[str, str], int]:
+        """Read one bet map with exactly 6 pairs."""
+        bet: dict[str, str] = {}
+        (n_pairs, remaining) = read_i32(sock, remaining, opcode)
+        if n_pairs != 6:
+            raise ProtocolError("invalid body", opcode)
+        for _ in range(0, n_pairs):
+            (key, remaining) = read_string(sock, remaining, opcode)
+            (value, remaining) = read_string(sock, remaining, opcode)
+            bet[key] = value
+        return (bet, remaining)
+
+    def read_agency_id(self, sock: socket.socket, length: int, opcode: int) -> int:
+        """Read the FINISHED body, which must be exactly 4 bytes."""
+        if length != 4:
+            raise ProtocolError("invalid length", opcode)
+        (agency_id, _) = read_i32(sock, length, opcode)
+        return agency_id
+
+    def encode_winners(self, winners: list[str]) -> bytes:
+        body = bytearray(_i32(len(winners)))
+        for document in winners:
+            body += _binary_string(document)
+        return bytes(body)
+
+    def encode_ack(self, correlation_id: int, status: int, detail: dict[str, str]) -> bytes:
+        body = bytearray(_i32(correlation_id))
+        body.append(status)
+        body += _i32(len(detail))
+        for k, v in detail.items():
+            body += _binary_string(k) + _binary_string(v)
+        return bytes(body)
+
+
+class MsgpackCodec:
+    """MessagePack body encoding (only the subset the protocol needs).
+
+    Bet:      map of str → str with the 6 bet keys
+    FINISHED: int (agency_id)
+    WINNERS:  array of str
+    ACK:      array [correlation_id:int, status:int, detail:map str → str]
+
+    The framing and the [n_bets:i32 LE] counter of NEW_BETS are unchanged.
+    """
+
+    name = "msgpack"
+
+    def read_bet(self, sock: socket.socket, remaining: int, opcode: int) -> tuple[dict[str, str], int]:
+        """Read one bet map with exactly 6 non-empty string pairs."""
+        bet: dict[str, str] = {}
+        (n_pairs, remaining) = self.__read_len(sock, remaining, opcode, "map")
+        if n_pairs != 6:
+            raise ProtocolError("invalid body", opcode)
+        for _ in range(0, n_pairs):
+            (key, remaining) = self.__read_str(sock, remaining, opcode)
+            (value, remaining) = self.__read_str(sock, remaining, opcode)
+            bet[key] = value
+        return (bet, remaining)
+
+    def read_agency_id(self, sock: socket.socket, length: int, opcode: int) -> int:
+        """Read the FINISHED body, a single msgpack int filling the frame."""
+        (tag, remaining) = self.__read(sock, 1, length, opcode)
+        tag = tag[0]
+        if tag <= 0x7F:
+            value = tag
+        elif tag >= 0xE0:
+            value = tag - 0x100
+        elif 0xCC <= tag <= 0xD3:
+            size = 1 << ((tag - 0xCC) % 4)
+            (raw, remaining) = self.__read(sock, size, remaining, opcode)
+            value = int.from_bytes(raw, byteorder="big", signed=tag >= 0xD0)
+        else:
+            raise ProtocolError("invalid body", opcode)
+        if remaining != 0:
+            raise ProtocolError("invalid length", opcode)
+        return value
+
+    def encode_winners(self, winners: list[str]) -> bytes:
+        body = bytearray(_msgpack_len(len(winners), 0x90, 16, None, 0xDC))
+        for document in winners:
+            body += _msgpack_str(document)
+        return bytes(body)
+
+    def encode_ack(self, correlation_id: int, status: int, detail: dict[str, str]) -> bytes:
+        body = bytearray([0x93])
+        body += _msgpack_int(correlation_id)
+        body += _msgpack_int(status)
+        body += _msgpack_len(len(detail), 0x80, 16, None, 0xDE)
+        for k, v in detail.items():
+            body += _msgpack_str(k) + _msgpack_str(v)
+        return bytes(body)
+
+    def __read(self, sock, n: int, remaining: int, opcode: int) -> tuple[bytes, int]:
+        if remaining < n:
+            raise ProtocolError("indicated length doesn't match body length", opcode)
+        return (recv_exactly(sock, n), remaining - n)
+
+    def __read_len(self, sock, remaining: int, opcode: int, kind: str) -> tuple[int, int]:
+        """Read a str or map header and return its length."""
+        fix, fix_mask, b8, b16 = {
+            "str": (0xA0, 0x1F, 0xD9, 0xDA),
+            "map": (0x80, 0x0F, None, 0xDE),
+        }[kind]
+        (tag, remaining) = self.__read(sock, 1, remaining, opcode)
+        tag = tag[0]
+        if tag & ~fix_mask == fix:
+            return (tag & fix_mask, remaining)
+        size = {b8: 1, b16: 2, b16 + 1: 4}.get(tag)
+        if size is None:
+            raise ProtocolError("invalid body", opcode)
+        (raw, remaining) = self.__read(sock, size, remaining, opcode)
+        return (int.from_bytes(raw, byteorder="big"), remaining)
+
+    def __read_str(self, sock, remaining: int, opcode: int) -> tuple[str, int]:
+        """Read a non-empty UTF-8 str."""
+        (n, remaining) = self.__read_len(sock, remaining, opcode, "str")
+        if n <= 0:
+            raise ProtocolError("invalid body", opcode)
+        (raw, remaining) = self.__read(sock, n, remaining, opcode)
+        try:
+            return (raw.decode("utf-8"), remaining)
+        except UnicodeDecodeError as e:
+            raise ProtocolError("invalid body", opcode) from e
+
+
+CODECS = {codec.name: codec for codec in (BinaryCodec(), MsgpackCodec())}
+
+
+def codec_by_name(name: str):
+    """Return the codec registered under `name` (raises ValueError if unknown)."""
+    try:
+        return CODECS[name]
+    except KeyError:
+        raise ValueError(f"unknown codec: {name}")
+
+
+def _i32(value: int) -> bytes:
+    return int(value).to_bytes(4, byteorder="little", signed=True)
+
+
+def _binary_string(s: str) -> bytes:
+    b = s.encode("utf-8")
+    return _i32(len(b)) + b
+
+
+def _msgpack_len(n: int, fix: int, fix_max: int, b8, b16: int) -> bytes:
+    """Header of a str/array/map of n elements in its smallest form."""
+    if n < fix_max:
+        return bytes([fix | n])
+    if b8 is not None and n <= 0xFF:
+        return bytes([b8, n])
+    if n <= 0xFFFF:
+        return bytes([b16]) + struct.pack(">H", n)
+    return bytes([b16 + 1]) + struct.pack(">I", n)
+
+
+def _msgpack_str(s: str) -> bytes:
+    b = s.encode("utf-8")
+    return _msgpack_len(len(b), 0xA0, 32, 0xD9, 0xDA) + b
+
+
+def _msgpack_int(value: int) -> bytes:
+    if 0 <= value <= 0x7F or -32 <= value < 0:
+        return struct.pack(">b", value) if value < 0 else bytes([value])
+    return bytes([0xD2]) + struct.pack(">i", value)
@@ -4,19 +4,67 @@ import socket
 import threading
 
 from app import protocol, service
+from app.codec import codec_by_name
+
+
+def mask_pii(value: str) -> str:
+    """Mask the middle of a document or name for the logs, keeping its first
+    and last two characters for correlation (30***12 for 30123412); values of
+    up to four characters keep only the first one. Matches the client."""
+    if len(value) <= 4:
+        return value[:1] + "***" if value else ""
+    return value[:2] + "***" + value[-2:]
+
+
+class Session:
+    """Per-connection state.
+
+    - `batch_seq`: NEW_BETS batches received, numbered from 1 (rejected ones
+      included), so ACKs can be correlated by the client.
+    - `quiet`: set by QUIET; batches are counted instead of acknowledged.
+    - `bets` / `failed`: bets stored and batches rejected, reported by SUMMARY.
+    """
+
+    def __init__(self):
+        self.batch_seq = 0
+        self.quiet = False
+        self.bets = 0
+        self.failed = 0
 
 
 class Server:
-    def __init__(self, port, listen_backlog, clients_amount):
+    def __init__(
+        self,
+        port,
+        listen_backlog,
+        clients_amount,
+        ack_format="legacy",
+        codec="binary",
+        redact=False,
+    ):
         """Initialize listening socket and concurrency primitives.
 
         - Creates and binds the TCP listening socket.
+        - `_ack_format` selects how batches are acknowledged: "legacy" replies
+          BETS_RECV_SUCCESS/BETS_RECV_FAIL, "ack" replies ACK with the batch
+          correlation ID.
+        - `_codec` encodes and decodes message bodies ("binary" or "msgpack",
+          see `app.codec`); clients must be configured with the same codec.
+        - `_redact` masks the documents of the bettors in the logs (see
+          `mask_pii`).
         - `_stop` is a process-wide shutdown flag (set by SIGTERM).
         - `_finished` is a Barrier with the expected number of clients/agencies;
           it is used to block FINISHED handlers until all are in.
         - `_winners` holds the computed winners grouped by agency.
         - `_raffle_done` is a latch Event set once the raffle is computed.
+        - `_raffle_tried` is a latch Event set once the raffle was attempted,
+          whether it succeeded or not.
         - `_raffle_lock` ensures the raffle is computed exactly once.
+        - `_finished_agencies` holds the agencies whose FINISHED was counted, so
+          a FINISHED repeated on a new connection does not cross the barrier
+          twice.
+        - `_telemetry` holds the last TELEMETRY stats of each agency, guarded
+          by `_raffle_lock`, aggregated once the raffle is done.
         - `_storage_lock` serializes access to storage during batch persistence.
         - `_threads` keeps track of per-connection worker threads.
         """
@@ -27,9 +75,15 @@ class Server:
         self._finished = threading.Barrier(int(clients_amount))
         self._winners: dict[int, list[str]] = {}
         self._raffle_lock = threading.Lock()
+        self._finished_agencies: set[int] = set()
+        self._telemetry: dict[str, dict[str, str]] = {}
         self._storage_lock = threading.Lock()
         self._threads: list[threading.Thread] = []
         self._raffle_done = threading.Event()
+        self._raffle_tried = threading.Event()
+        self._ack_format = ack_format
+        self._codec = codec_by_name(codec)
+        self._redact = redact
 
     def run(self):
         """Main server loop.
@@ -76,21 +130,45 @@ class Server:
         `__process_msg` returns False (connection should close), `_stop` is set,
         EOF is reached, or a socket/protocol error occurs. Always closes the
         client socket on exit.
+
+        NEW_BETS batches are numbered per connection (1-based), including the
+        ones rejected while parsing, so ACKs can be correlated by the client
+        (see `Session`). The number is logged as `seq`, like the client does.
         """
+        session = Session()
         while not self._stop.is_set():
             msg = None
             try:
-                msg = protocol.recv_msg(client_sock)
+                msg = protocol.recv_msg(client_sock, self._codec)
                 addr = client_sock.getpeername()
-                logging.info(
-                    "action: receive_message | result: success | ip: %s | opcode: %i",
-                    addr[0],
-                    msg.opcode,
-                )
-                if not self.__process_msg(msg, client_sock):
+                if msg.opcode == protocol.Opcodes.NEW_BETS:
+                    session.batch_seq += 1
+                    logging.info(
+                        "action: receive_message | result: success | ip: %s | opcode: %s | seq: %d",
+                        addr[0],
+                        protocol.Opcodes.name(msg.opcode),
+                        session.batch_seq,
+                    )
+                else:
+                    logging.info(
+                        "action: receive_message | result: success | ip: %s | opcode: %s",
+                        addr[0],
+                        protocol.Opcodes.name(msg.opcode),
+                    )
+                if not self.__process_msg(msg, client_sock, session):
                     break
             except protocol.ProtocolError as e:
-                logging.error("action: receive_message | result: fail | error: %s", e)
+                if e.opcode == protocol.Opcodes.NEW_BETS:
+                    session.batch_seq += 1
+                    logging.error(
+                        "action: receive_message | result: fail | seq: %d | error: %s",
+                        session.batch_seq,
+                        e,
+                    )
+                    if self._ack_format == "ack" or session.quiet:
+                        self.__reply_batch(client_sock, session, False, str(e))
+                else:
+                    logging.error("action: receive_message | result: fail | error: %s", e)
             except EOFError:
                 break
             except OSError as e:
@@ -98,7 +176,22 @@ class Server:
                 break
         client_sock.close()
 
-    def __process_msg(self, msg, client_sock) -> bool:
+    def __reply_batch(self, client_sock, session, success, error=None):
+        """Acknowledge a NEW_BETS batch using the configured ack format, or
+        only count it if the connection is quiet."""
+        if session.quiet:
+            if not success:
+                session.failed += 1
+        elif self._ack_format == "ack":
+            status = protocol.AckStatus.SUCCESS if success else protocol.AckStatus.FAIL
+            detail = {} if error is None else {"error": error}
+            protocol.Ack(session.batch_seq, status, detail).write_to(client_sock, self._codec)
+        elif success:
+            protocol.BetsRecvSuccess().write_to(client_sock)
+        else:
+            protocol.BetsRecvFail().write_to(client_sock)
+
+    def __process_msg(self, msg, client_sock, session) -> bool:
         """Route a decoded message and apply the server-side semantics.
 
         Returns:
@@ -107,12 +200,20 @@ class Server:
 
         Semantics:
         - NEW_BETS: persist the whole batch under `_storage_lock`. If every bet
-          is stored successfully, reply BETS_RECV_SUCCESS and log
-          'apuesta_recibida | success | cantidad'. On any exception, reply
-          BETS_RECV_FAIL and log 'apuesta_recibida | fail | cantidad'.
+          is stored successfully, reply success (BETS_RECV_SUCCESS or ACK, see
+          `_ack_format`) and log 'apuesta_recibida | success | cantidad'. On any
+          exception, reply failure and log 'apuesta_recibida | fail | cantidad'.
+          Quiet connections get no reply (see `Session`).
+        - QUIET: stop acknowledging the batches of this connection.
+        - SUMMARY_REQUEST: reply SUMMARY with the batches received, the bets
+          stored and the batches rejected on this connection.
+        - TELEMETRY: record and log the upload stats reported by the agency.
         - FINISHED: wait on the `_finished` Barrier. The last thread crossing
           the barrier triggers the raffle (under `_raffle_lock`) if not done.
-          Once the raffle is done, send the agency's winners.
+          Once the raffle is done, send the agency's winners. An agency that
+          repeats FINISHED (after reconnecting) only waits for the raffle to
+          be attempted. A raffle that failed is retried by the next FINISHED;
+          meanwhile the connection is closed without winners.
         """
         if msg.opcode == protocol.Opcodes.NEW_BETS:
             try:
@@ -121,11 +222,11 @@ class Server:
                     for bet in msg.bets:
                         logging.info(
                             "action: apuesta_almacenada | result: success | dni: %s | numero: %s",
-                            bet.document,
+                            mask_pii(bet.document) if self._redact else bet.document,
                             bet.number,
                         )
             except Exception as e:
-                protocol.BetsRecvFail().write_to(client_sock)
+                self.__reply_batch(client_sock, session, False, str(e))
                 logging.error(
                     "action: apuesta_recibida | result: fail | cantidad: %d", msg.amount
                 )
@@ -134,13 +235,55 @@ class Server:
                 "action: apuesta_recibida | result: success | cantidad: %d",
                 msg.amount,
             )
-            protocol.BetsRecvSuccess().write_to(client_sock)
+            session.bets += msg.amount
+            self.__reply_batch(client_sock, session, True)
+            return True
+        if msg.opcode == protocol.Opcodes.QUIET:
+            session.quiet = True
+            return True
+        if msg.opcode == protocol.Opcodes.SUMMARY_REQUEST:
+            protocol.Summary(session.batch_seq, session.bets, session.failed).write_to(
+                client_sock
+            )
+            logging.info(
+                "action: enviar_resumen | result: success | batches: %d | bets: %d | failed: %d",
+                session.batch_seq,
+                session.bets,
+                session.failed,
+            )
+            return True
+        if msg.opcode == protocol.Opcodes.TELEMETRY:
+            agency = msg.stats.get("agency", "")
+            with self._raffle_lock:
+                self._telemetry[agency] = msg.stats
+            logging.info(
+                "action: telemetria | result: success | agencia: %s | %s",
+                agency,
+                " | ".join(
+                    f"{k}: {v}"
+                    for k, v in sorted(msg.stats.items())
+                    if k != "agency"
+                ),
+            )
             return True
         if msg.opcode == protocol.Opcodes.FINISHED:
-            self._finished.wait()
+            with self._raffle_lock:
+                repeated = msg.agency_id in self._finished_agencies
+                self._finished_agencies.add(msg.agency_id)
+            if repeated:
+                # The agency reconnected after its FINISHED was counted.
+                self._raffle_tried.wait()
+            else:
+                self._finished.wait()
             with self._raffle_lock:
                 if not self._raffle_done.is_set():
                     self.__raffle()
+            if not self._raffle_done.is_set():
+                logging.error(
+                    "action: enviar_ganadores | result: fail | agencia: %d | error: raffle not done",
+                    msg.agency_id,
+                )
+                return False
             self.__send_winners(msg.agency_id, client_sock)
             return False
 
@@ -149,15 +292,35 @@ class Server:
 
         Calls `service.compute_winners()` (pure domain logic), stores the result
         into `_winners`, logs success, and sets `_raffle_done` so any waiting
-        FINISHED handlers can proceed.
+        FINISHED handlers can proceed. `_raffle_tried` is set either way.
         """
         try:
             self._winners = service.compute_winners()
             logging.info("action: sorteo | result: success")
+            self.__log_telemetry()
             self._raffle_done.set()
         except Exception as e:
             logging.error("action: sorteo | result: fail | error: %s", e)
+        finally:
+            self._raffle_tried.set()
+
+    def __log_telemetry(self):
+        """Log the upload stats of every agency that reported TELEMETRY added
+        up, if any did. Called under `_raffle_lock`."""
+        if not self._telemetry:
             return
+        totals: dict[str, int] = {}
+        for stats in self._telemetry.values():
+            for key, value in stats.items():
+                if value.lstrip("-").isdigit():
+                    totals[key] = totals.get(key, 0) + int(value)
+        logging.info(
+            "action: telemetria_agencias | result: success | agencias: %d | %s",
+            len(self._telemetry),
+            " | ".join(
+                f"{k}: {v}" for k, v in sorted(totals.items()) if k != "agency"
+            ),
+        )
 
     def __send_winners(self, agency_id, sock):
         """Serialize and send a WINNERS response for a given agency.
@@ -166,7 +329,7 @@ class Server:
         and logs success or a protocol error if framing fails.
         """
         try:
-            protocol.Winners(self._winners.get(agency_id, [])).write_to(sock)
+            protocol.Winners(self._winners.get(agency_id, [])).write_to(sock, self._codec)
             logging.info(
                 "action: enviar_ganadores | result: success | agencia: %d", agency_id
             )
@@ -20,6 +20,27 @@ class Opcodes:
     BETS_RECV_FAIL = 2
     FINISHED = 3
     WINNERS = 4
+    CONTINUATION = 5
+    ACK = 6
+    QUIET = 7
+    SUMMARY_REQUEST = 8
+    SUMMARY = 9
+    TELEMETRY = 10
+
+    @classmethod
+    def name(cls, opcode: int) -> str:
+        """Render an opcode as its name, or OPCODE(n) if unknown."""
+        for name, value in vars(cls).items():
+            if name.isupper() and value == opcode:
+                return name
+        return f"OPCODE({opcode})"
+
+
+class AckStatus:
+    """Status codes carried by ACK (u8)."""
+
+    SUCCESS = 0
+    FAIL = 1
 
 
 class RawBet:
@@ -47,15 +68,18 @@ class NewBets:
 
     Body layout:
       [n_bets:i32 LE]
-      n_bets × {
-        [n_pairs:i32 LE == 6]
-        6 × [key:string][value:string]  // UTF-8 with i32 length prefix
-      }
+      n_bets × bet  // encoded with the connection codec (see app.codec)
+
+    A logical batch may span several physical frames: if the NEW_BETS frame
+    holds fewer than `n_bets` bets, the rest arrive in CONTINUATION frames
+    whose body is just more bet maps. Frames are always split at bet
+    boundaries.
 
     Validates required keys and collects bets as `RawBet` instances.
     """
 
-    def __init__(self):
+    def __init__(self, codec):
+        self.codec = codec
         self.bets: list[RawBet] = []
         self.opcode: int = Opcodes.NEW_BETS
         self.required = (
@@ -68,21 +92,9 @@ class NewBets:
         )
         self.amount: int = 0
 
-    def __read_pair(self, sock: socket.socket, remaining: int) -> tuple[str, str, int]:
-        """Read a single <key, value> pair, both as protocol [string]."""
-        (key, remaining) = read_string(sock, remaining, self.opcode)
-        (value, remaining) = read_string(sock, remaining, self.opcode)
-        return (key, value, remaining)
-
     def __read_bet(self, sock: socket.socket, remaining: int) -> int:
-        """Read one bet map, enforce 6 pairs and required keys, append RawBet."""
-        curr_bet: dict[str, str] = {}
-        (n_pairs, remaining) = read_i32(sock, remaining, self.opcode)
-        if n_pairs != 6:
-            raise ProtocolError("invalid body", self.opcode)
-        for _ in range(0, n_pairs):
-            (k, v, remaining) = self.__read_pair(sock, remaining)
-            curr_bet[k] = v
+        """Read one bet map with the codec, enforce required keys, append RawBet."""
+        (curr_bet, remaining) = self.codec.read_bet(sock, remaining, self.opcode)
         if [k for k in self.required if k not in curr_bet]:
             raise ProtocolError("invalid body", self.opcode)
         self.bets.append(
@@ -97,43 +109,131 @@ class NewBets:
         )
         return remaining
 
+    def __read_frame_bets(self, sock, remaining: int) -> int:
+        """Consume bet maps until the frame body is exhausted.
+
+        Raises ProtocolError if the frame carries more bets than announced
+        or if its length doesn't match the parsed body.
+        """
+        if remaining == 0:
+            raise ProtocolError("empty batch frame", self.opcode)
+        while remaining > 0:
+            if len(self.bets) >= self.amount:
+                raise ProtocolError(
+                    "indicated length doesn't match body length", self.opcode
+                )
+            remaining = self.__read_bet(sock, remaining)
+        return remaining
+
     def read_from(self, sock, length: int):
-        """Parse the complete NEW_BETS body and enforce exact-length consumption.
+        """Parse the complete logical NEW_BETS batch and enforce exact-length consumption.
 
-        Reads the `n_bets` counter and then consumes each bet map. If, after
-        parsing, `remaining != 0`, raises ProtocolError. On parse failure, drains
-        the remaining bytes (to keep the stream synchronized) and re-raises.
+        Reads the `n_bets` counter and then consumes each bet map, following
+        CONTINUATION frames until `n_bets` bets were read. If a frame's body
+        doesn't match its length, raises ProtocolError. On parse failure,
+        drains the bytes of the current frame not read yet (to keep the
+        stream synchronized) and re-raises.
         """
-        remaining = length
+        frame = _CountingReader(sock)
+        frame_length = remaining = length
         try:
-            n_bets, remaining = read_i32(sock, remaining, self.opcode)
+            n_bets, remaining = read_i32(frame, remaining, self.opcode)
+            if n_bets < 0:
+                raise ProtocolError("invalid body", self.opcode)
             self.amount = n_bets
-            for _ in range(n_bets):
-                remaining = self.__read_bet(sock, remaining)
+            if n_bets > 0:
+                remaining = self.__read_frame_bets(frame, remaining)
+            while len(self.bets) < n_bets:
+                opcode = read_u8(sock)
+                (frame_length, _) = read_i32(sock, 4, self.opcode)
+                frame.received = 0
+                remaining = frame_length
+                if opcode != Opcodes.CONTINUATION:
+                    raise ProtocolError("expected continuation frame", opcode)
+                remaining = self.__read_frame_bets(frame, remaining)
             if remaining != 0:
                 raise ProtocolError(
                     "indicated length doesn't match body length", self.opcode
                 )
         except ProtocolError:
-            if remaining > 0:
-                _ = recv_exactly(sock, remaining)
+            if frame_length - frame.received > 0:
+                _ = recv_exactly(sock, frame_length - frame.received)
             raise
 
 
+class Empty:
+    """Inbound message with an empty body (QUIET, SUMMARY_REQUEST)."""
+
+    def __init__(self, opcode: int):
+        self.opcode = opcode
+
+    def read_from(self, sock: socket.socket, length: int):
+        """Validate that the body is empty."""
+        if length != 0:
+            _ = recv_exactly(sock, length)
+            raise ProtocolError("invalid length", self.opcode)
+
+
 class Finished:
-    """Inbound FINISHED message. Body is a single agency_id (i32 LE)."""
+    """Inbound FINISHED message. Body is a single agency_id (i32 LE with the
+    binary codec)."""
 
-    def __init__(self):
+    def __init__(self, codec):
+        self.codec = codec
         self.opcode = Opcodes.FINISHED
         self.agency_id = None
-        self._length = 4
 
     def read_from(self, sock: socket.socket, length: int):
-        """Validate fixed body length (4) and read agency_id."""
-        if length != self._length:
-            raise ProtocolError("invalid length", self.opcode)
-        (agency_id, _) = read_i32(sock, length, self.opcode)
-        self.agency_id = agency_id
+        """Read agency_id with the codec, which validates the body length."""
+        self.agency_id = self.codec.read_agency_id(sock, length, self.opcode)
+
+
+class Telemetry:
+    """Inbound TELEMETRY message: the stats of the upload of an agency (bets
+    sent, retries, client version...), sent by the client before FINISHED.
+
+    Body layout, whatever the codec:
+      [n:i32 LE] n × [key:string][value:string]
+    """
+
+    def __init__(self):
+        self.opcode = Opcodes.TELEMETRY
+        self.stats: dict[str, str] = {}
+
+    def read_from(self, sock: socket.socket, length: int):
+        """Read the stats map and enforce exact-length consumption. On parse
+        failure, drains the rest of the body and re-raises."""
+        remaining = length
+        try:
+            n_pairs, remaining = read_i32(sock, remaining, self.opcode)
+            if n_pairs < 0:
+                raise ProtocolError("invalid body", self.opcode)
+            for _ in range(n_pairs):
+                key, remaining = read_string(sock, remaining, self.opcode)
+                value, remaining = read_string(sock, remaining, self.opcode)
+                self.stats[key] = value
+            if remaining != 0:
+                raise ProtocolError(
+                    "indicated length doesn't match body length", self.opcode
+                )
+        except ProtocolError:
+            if remaining > 0:
+                _ = recv_exactly(sock, remaining)
+            raise
+
+
+class _CountingReader:
+    """Socket wrapper counting the bytes received since `received` was reset,
+    so that a frame that fails to parse midway can be skipped exactly."""
+
+    def __init__(self, sock: socket.socket):
+        self.sock = sock
+        self.received = 0
+
+    def recv_into(self, buffer, nbytes: int) -> int:
+        n = self.sock.recv_into(buffer, nbytes)
+        self.received += n
+        return n
 
 
 def recv_exactly(sock: socket.socket, n: int) -> bytes:
@@ -197,24 +297,37 @@ def read_string(sock: socket.socket, remaining: int, opcode: int) -> (str, int):
     return (s, remaining)
 
 
-def recv_msg(sock: socket.socket):
+def recv_msg(sock: socket.socket, codec):
     """Read a single framed message and dispatch by opcode.
 
     Reads opcode (u8) and length (i32 LE), validates length, then dispatches
-    to the appropriate message class. Raises ProtocolError on invalid opcode.
+    to the appropriate message class, which decodes the body with `codec`.
+    Raises ProtocolError on invalid opcode.
     """
     opcode = read_u8(sock)
     (length, _) = read_i32(sock, 4, -1)
     if length < 0:
         raise ProtocolError("invalid length")
     if opcode == Opcodes.NEW_BETS:
-        msg = NewBets()
+        msg = NewBets(codec)
         msg.read_from(sock, length)
         return msg
     if opcode == Opcodes.FINISHED:
-        msg = Finished()
+        msg = Finished(codec)
+        msg.read_from(sock, length)
+        return msg
+    if opcode in (Opcodes.QUIET, Opcodes.SUMMARY_REQUEST):
+        msg = Empty(opcode)
+        msg.read_from(sock, length)
+        return msg
+    if opcode == Opcodes.TELEMETRY:
+        msg = Telemetry()
         msg.read_from(sock, length)
         return msg
+    if opcode == Opcodes.CONTINUATION:
+        # Leftover of a batch whose first frame was rejected: skip it whole.
+        _ = recv_exactly(sock, length)
+        raise ProtocolError("unexpected continuation frame", opcode)
     raise ProtocolError(f"invalid opcode: {opcode}")
 
 
@@ -262,10 +375,56 @@ class BetsRecvFail:
         write_i32(sock, 0)
 
 
+class Ack:
+    """Outbound ACK response, the generic replacement of BETS_RECV_*.
+
+    Body layout with the binary codec:
+      [correlation_id:i32 LE]  // 1-based NEW_BETS sequence on the connection
+      [status:u8]
+      [detail:string map]      // [n:i32 LE] n × [key:string][value:string]
+    """
+
+    def __init__(self, correlation_id: int, status: int, detail: dict[str, str]):
+        self.opcode = Opcodes.ACK
+        self.correlation_id = correlation_id
+        self.status = status
+        self.detail = detail
+
+    def write_to(self, sock: socket.socket, codec):
+        """Encode the body with `codec`, then frame and send the ack."""
+        body = codec.encode_ack(self.correlation_id, self.status, self.detail)
+        write_u8(sock, self.opcode)
+        write_i32(sock, len(body))
+        sock.sendall(body)
+
+
+class Summary:
+    """Outbound SUMMARY response to SUMMARY_REQUEST.
+
+    Body layout, whatever the codec:
+      [batches:i32 LE]  // NEW_BETS received on the connection
+      [bets:i32 LE]     // bets stored
+      [failed:i32 LE]   // batches rejected
+    """
+
+    def __init__(self, batches: int, bets: int, failed: int):
+        self.opcode = Opcodes.SUMMARY
+        self.batches = batches
+        self.bets = bets
+        self.failed = failed
+
+    def write_to(self, sock: socket.socket):
+        """Frame and send the summary."""
+        write_u8(sock, self.opcode)
+        write_i32(sock, 12)
+        for value in (self.batches, self.bets, self.failed):
+            write_i32(sock, value)
+
+
 class Winners:
     """Outbound WINNERS response.
 
-    Body layout:
+    Body layout with the binary codec:
       [count:i32 LE]
       count × [string]  // each is i32 length + UTF-8
     """
@@ -274,13 +433,9 @@ class Winners:
         self.opcode = Opcodes.WINNERS
         self.list = winners
 
-    def write_to(self, sock: socket.socket):
-        """Frame and send the winners list using sendall() for each chunk."""
-        body_length = 4
-        for document in self.list:
-            body_length += 4 + len(document)
+    def write_to(self, sock: socket.socket, codec):
+        """Encode the body with `codec`, then frame and send the winners list."""
+        body = codec.encode_winners(self.list)
         write_u8(sock, self.opcode)
-        write_i32(sock, body_length)
-        write_i32(sock, len(self.list))
-        for document in self.list:
-            write_string(sock, document)
+        write_i32(sock, len(body))
+        sock.sendall(body)
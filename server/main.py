@@ -36,6 +36,17 @@ def initialize_config():
             "LOGGING_LEVEL", config["DEFAULT"]["LOGGING_LEVEL"]
         )
         config_params["clients_amount"] = os.getenv("CLIENTS_AMOUNT")
+        config_params["ack_format"] = os.getenv(
+            "ACK_FORMAT", config["DEFAULT"].get("ACK_FORMAT", "legacy")
+        )
+        config_params["codec"] = os.getenv(
+            "CODEC", config["DEFAULT"].get("CODEC", "binary")
+        )
+        config_params["logging_redact"] = (
+            os.getenv("LOGGING_REDACT", config["DEFAULT"].get("LOGGING_REDACT", "false"))
+            .lower()
+            == "true"
+        )
     except KeyError as e:
         raise KeyError("Key was not found. Error: {} .Aborting server".format(e))
     except ValueError as e:
@@ -52,6 +63,9 @@ def main():
     port = config_params["port"]
     listen_backlog = config_params["listen_backlog"]
     clients_amount = config_params["clients_amount"]
+    ack_format = config_params["ack_format"]
+    codec = config_params["codec"]
+    logging_redact = config_params["logging_redact"]
 
     initialize_log(logging_level)
 
@@ -59,11 +73,14 @@ def main():
     # of the component
     logging.debug(
         f"action: config | result: success | port: {port} | "
-        f"listen_backlog: {listen_backlog} | logging_level: {logging_level}"
+        f"listen_backlog: {listen_backlog} | logging_level: {logging_level} | "
+        f"ack_format: {ack_format} | codec: {codec} | logging_redact: {logging_redact}"
     )
 
     # Initialize server and start server loop
-    server = Server(port, listen_backlog, clients_amount)
+    server = Server(
+        port, listen_backlog, clients_amount, ack_format, codec, logging_redact
+    )
     server.run()
 
 
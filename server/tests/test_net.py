@@ -0,0 +1,68 @@
+from app import net
+from app.protocol import Opcodes
+from types import SimpleNamespace
+from unittest import mock
+import threading
+import unittest
+
+from tests.test_protocol import SentBytes, frame, i32, binary_string
+
+
+def finished(agency_id):
+    return SimpleNamespace(opcode=Opcodes.FINISHED, agency_id=agency_id)
+
+
+class TestFinished(unittest.TestCase):
+
+    def setUp(self):
+        self.server = net.Server(0, 1, 1)
+
+    def tearDown(self):
+        self.server._server_socket.close()
+
+    def _process(self, msg):
+        """Run the handler of msg on a thread, failing if it blocks."""
+        sock = SentBytes()
+        result = []
+        thread = threading.Thread(
+            target=lambda: result.append(
+                self.server._Server__process_msg(msg, sock, net.Session())
+            ),
+            daemon=True,
+        )
+        thread.start()
+        thread.join(1)
+        self.assertFalse(thread.is_alive(), 'FINISHED handler blocked')
+        return result[0], sock.data
+
+    def test_finished_sends_winners(self):
+        with mock.patch.object(net.service, 'compute_winners', return_value={1: ['30123412']}):
+            keep, sent = self._process(finished(1))
+        self.assertFalse(keep)
+        self.assertEqual(frame(Opcodes.WINNERS, i32(1) + binary_string('30123412')), sent)
+
+    def test_failed_raffle_sends_no_winners(self):
+        with mock.patch.object(net.service, 'compute_winners', side_effect=OSError('disk')):
+            keep, sent = self._process(finished(1))
+        self.assertFalse(keep)
+        self.assertEqual(b'', sent)
+
+    def test_repeated_finished_retries_a_failed_raffle(self):
+        with mock.patch.object(net.service, 'compute_winners', side_effect=OSError('disk')):
+            self._process(finished(1))
+        with mock.patch.object(net.service, 'compute_winners', return_value={1: ['30123412']}):
+            keep, sent = self._process(finished(1))
+        self.assertFalse(keep)
+        self.assertEqual(frame(Opcodes.WINNERS, i32(1) + binary_string('30123412')), sent)
+
+    def test_repeated_finished_after_the_raffle(self):
+        winners = {1: ['30123412']}
+        with mock.patch.object(net.service, 'compute_winners', return_value=winners) as compute:
+            self._process(finished(1))
+            keep, sent = self._process(finished(1))
+        self.assertEqual(1, compute.call_count)
+        self.assertEqual(frame(Opcodes.WINNERS, i32(1) + binary_string('30123412')), sent)
+
+
+if __name__ == '__main__':
+    unittest.main()
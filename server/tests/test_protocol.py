@@ -0,0 +1,194 @@
+from app.codec import BinaryCodec, MsgpackCodec
+from app.protocol import *
+import socket
+import unittest
+
+BET = {
+    'AGENCIA': '1',
+    'NOMBRE': 'first',
+    'APELLIDO': 'last',
+    'DOCUMENTO': '10000000',
+    'NACIMIENTO': '2000-12-20',
+    'NUMERO': '7500',
+}
+
+
+def i32(value):
+    return int(value).to_bytes(4, byteorder='little', signed=True)
+
+
+def binary_string(s):
+    b = s.encode('utf-8')
+    return i32(len(b)) + b
+
+
+def binary_bet(bet):
+    body = i32(len(bet))
+    for k, v in bet.items():
+        body += binary_string(k) + binary_string(v)
+    return body
+
+
+def msgpack_bet(bet):
+    body = bytes([0x80 | len(bet)])
+    for k, v in bet.items():
+        for s in (k, v):
+            b = s.encode('utf-8')
+            body += bytes([0xA0 | len(b)]) + b
+    return body
+
+
+def frame(opcode, body):
+    return bytes([opcode]) + i32(len(body)) + body
+
+
+class SentBytes:
+    """Socket stand-in recording what is sent."""
+
+    def __init__(self):
+        self.data = b''
+
+    def sendall(self, data):
+        self.data += data
+
+
+class TestProtocol(unittest.TestCase):
+
+    def setUp(self):
+        self.reader, self.writer = socket.socketpair()
+        # A parser reading past its frame fails instead of hanging.
+        self.reader.settimeout(1)
+
+    def tearDown(self):
+        self.reader.close()
+        self.writer.close()
+
+    def _recv(self, data, codec=BinaryCodec()):
+        self.writer.sendall(data)
+        return recv_msg(self.reader, codec)
+
+    def _assert_next_is_finished(self, codec=BinaryCodec(), body=i32(3)):
+        """The stream is still in sync: the next frame parses."""
+        self.writer.sendall(frame(Opcodes.FINISHED, body))
+        self.assertEqual(3, recv_msg(self.reader, codec).agency_id)
+
+    def test_new_bets_single_frame(self):
+        msg = self._recv(frame(Opcodes.NEW_BETS, i32(2) + binary_bet(BET) * 2))
+        self.assertEqual(Opcodes.NEW_BETS, msg.opcode)
+        self.assertEqual(2, len(msg.bets))
+        self.assertEqual('10000000', msg.bets[1].document)
+        self.assertEqual('7500', msg.bets[1].number)
+
+    def test_new_bets_follows_continuation_frames(self):
+        data = frame(Opcodes.NEW_BETS, i32(4) + binary_bet(BET))
+        data += frame(Opcodes.CONTINUATION, binary_bet(BET) * 2)
+        data += frame(Opcodes.CONTINUATION, binary_bet(dict(BET, DOCUMENTO='20000000')))
+        msg = self._recv(data)
+        self.assertEqual(4, len(msg.bets))
+        self.assertEqual('20000000', msg.bets[3].document)
+        self._assert_next_is_finished()
+
+    def test_new_bets_with_other_frame_instead_of_continuation_fails(self):
+        data = frame(Opcodes.NEW_BETS, i32(2) + binary_bet(BET))
+        data += frame(Opcodes.FINISHED, i32(3))
+        with self.assertRaises(ProtocolError):
+            self._recv(data)
+
+    def test_continuation_with_more_bets_than_announced_fails_and_resyncs(self):
+        data = frame(Opcodes.NEW_BETS, i32(2) + binary_bet(BET))
+        data += frame(Opcodes.CONTINUATION, binary_bet(BET) * 2)
+        with self.assertRaises(ProtocolError):
+            self._recv(data)
+        self._assert_next_is_finished()
+
+    def test_empty_continuation_fails(self):
+        data = frame(Opcodes.NEW_BETS, i32(2) + binary_bet(BET))
+        data += frame(Opcodes.CONTINUATION, b'')
+        with self.assertRaises(ProtocolError):
+            self._recv(data)
+
+    def test_stray_continuation_is_skipped(self):
+        with self.assertRaises(ProtocolError) as ctx:
+            self._recv(frame(Opcodes.CONTINUATION, binary_bet(BET)))
+        self.assertEqual(Opcodes.CONTINUATION, ctx.exception.opcode)
+        self._assert_next_is_finished()
+
+    def test_new_bets_missing_key_fails_and_resyncs(self):
+        bet = dict(BET)
+        del bet['NUMERO']
+        bet['OTRO'] = '1'
+        with self.assertRaises(ProtocolError):
+            self._recv(frame(Opcodes.NEW_BETS, i32(1) + binary_bet(bet)))
+        self._assert_next_is_finished()
+
+    def test_new_bets_msgpack(self):
+        codec = MsgpackCodec()
+        data = frame(Opcodes.NEW_BETS, i32(2) + msgpack_bet(BET))
+        data += frame(Opcodes.CONTINUATION, msgpack_bet(dict(BET, NOMBRE='otro')))
+        msg = self._recv(data, codec)
+        self.assertEqual(2, len(msg.bets))
+        self.assertEqual('first', msg.bets[0].first_name)
+        self.assertEqual('otro', msg.bets[1].first_name)
+
+    def test_new_bets_msgpack_rejects_binary_body(self):
+        with self.assertRaises(ProtocolError):
+            self._recv(frame(Opcodes.NEW_BETS, i32(1) + binary_bet(BET)), MsgpackCodec())
+        self._assert_next_is_finished(MsgpackCodec(), b'\x03')
+
+    def test_finished_msgpack(self):
+        for body, agency_id in ((b'\x05', 5), (b'\xcc\xc8', 200), (b'\xd2\x00\x01\x00\x00', 65536)):
+            self.writer.sendall(frame(Opcodes.FINISHED, body))
+            self.assertEqual(agency_id, recv_msg(self.reader, MsgpackCodec()).agency_id)
+
+    def test_finished_msgpack_with_trailing_bytes_fails(self):
+        with self.assertRaises(ProtocolError):
+            self._recv(frame(Opcodes.FINISHED, b'\x05\x00'), MsgpackCodec())
+
+    def test_telemetry(self):
+        body = i32(2) + binary_string('bets') + binary_string('3000')
+        body += binary_string('version') + binary_string('1.2')
+        msg = self._recv(frame(Opcodes.TELEMETRY, body))
+        self.assertEqual(Opcodes.TELEMETRY, msg.opcode)
+        self.assertEqual({'bets': '3000', 'version': '1.2'}, msg.stats)
+
+    def test_telemetry_with_trailing_bytes_fails_and_resyncs(self):
+        body = i32(1) + binary_string('bets') + binary_string('3000') + b'\x00'
+        with self.assertRaises(ProtocolError):
+            self._recv(frame(Opcodes.TELEMETRY, body))
+        self._assert_next_is_finished()
+
+    def test_telemetry_truncated_pair_fails_and_resyncs(self):
+        with self.assertRaises(ProtocolError):
+            self._recv(frame(Opcodes.TELEMETRY, i32(2) + binary_string('bets') + binary_string('3000')))
+        self._assert_next_is_finished()
+
+    def test_summary_request_must_be_empty(self):
+        self.assertEqual(Opcodes.SUMMARY_REQUEST, self._recv(frame(Opcodes.SUMMARY_REQUEST, b'')).opcode)
+        with self.assertRaises(ProtocolError):
+            self._recv(frame(Opcodes.SUMMARY_REQUEST, b'\x00'))
+        self._assert_next_is_finished()
+
+    def test_summary_write(self):
+        sock = SentBytes()
+        Summary(4, 350, 1).write_to(sock)
+        self.assertEqual(frame(Opcodes.SUMMARY, i32(4) + i32(350) + i32(1)), sock.data)
+
+    def test_ack_binary(self):
+        sock = SentBytes()
+        Ack(7, AckStatus.FAIL, {'error': 'bad'}).write_to(sock, BinaryCodec())
+        body = i32(7) + bytes([AckStatus.FAIL]) + i32(1) + binary_string('error') + binary_string('bad')
+        self.assertEqual(frame(Opcodes.ACK, body), sock.data)
+
+    def test_ack_msgpack(self):
+        sock = SentBytes()
+        Ack(200, AckStatus.SUCCESS, {'bets': '3'}).write_to(sock, MsgpackCodec())
+        body = b'\x93' + b'\xd2\x00\x00\x00\xc8' + b'\x00' + b'\x81' + b'\xa4bets' + b'\xa13'
+        self.assertEqual(frame(Opcodes.ACK, body), sock.data)
+
+    def test_winners_msgpack(self):
+        sock = SentBytes()
+        Winners(['30904465']).write_to(sock, MsgpackCodec())
+        self.assertEqual(frame(Opcodes.WINNERS, b'\x91\xa830904465'), sock.data)
+
+if __name__ == '__main__':
+    unittest.main()